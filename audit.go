@@ -0,0 +1,86 @@
+package traefikoidc
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// AuditEventType identifies the kind of authentication event reported to the audit hook.
+type AuditEventType string
+
+const (
+	// AuditLoginSuccess is reported when a callback completes and the session is authenticated.
+	AuditLoginSuccess AuditEventType = "login_success"
+
+	// AuditLoginFailure is reported when a callback fails (provider error, state or nonce
+	// mismatch, failed token exchange or verification, disallowed email).
+	AuditLoginFailure AuditEventType = "login_failure"
+
+	// AuditLogout is reported when a user logs out.
+	AuditLogout AuditEventType = "logout"
+
+	// AuditTokenRefresh is reported when tokens are refreshed successfully.
+	AuditTokenRefresh AuditEventType = "token_refresh"
+
+	// AuditTokenRefreshFailure is reported when a token refresh fails.
+	AuditTokenRefreshFailure AuditEventType = "token_refresh_failure"
+
+	// AuditAccessDenied is reported when an authenticated user is denied access by the
+	// domain, role or group restrictions.
+	AuditAccessDenied AuditEventType = "access_denied"
+)
+
+// AuditEvent describes an authentication event for compliance audit trails.
+// It never carries raw tokens.
+type AuditEvent struct {
+	// Type is the kind of event.
+	Type AuditEventType
+
+	// Timestamp is when the event occurred.
+	Timestamp time.Time
+
+	// Email is the user's email address, when known.
+	Email string
+
+	// RemoteIP is the IP address the request was received from.
+	RemoteIP string
+
+	// Reason is a short human readable explanation, mainly for failures and denials.
+	Reason string
+}
+
+// audit reports an authentication event to the configured audit hook, if any.
+//
+// Parameters:
+//   - req: The request the event relates to (used for the remote IP).
+//   - eventType: The kind of event.
+//   - email: The user's email address, or "" if unknown.
+//   - reason: A short explanation of the event.
+func (t *TraefikOidc) audit(req *http.Request, eventType AuditEventType, email, reason string) {
+	if t.auditHook == nil {
+		return
+	}
+	t.auditHook(AuditEvent{
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Email:     email,
+		RemoteIP:  remoteIP(req),
+		Reason:    reason,
+	})
+}
+
+// remoteIP returns the IP address part of the request's remote address.
+//
+// Parameters:
+//   - req: The incoming HTTP request.
+//
+// Returns:
+//   - The remote IP, or the raw remote address if it has no port.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -50,16 +50,31 @@ const DefaultMaxSize = 500
 // It initializes the internal maps and list, sets the default maximum size,
 // and starts the automatic cleanup goroutine.
 func NewCache() *Cache {
-	c := &Cache{
-		items:               make(map[string]CacheItem, DefaultMaxSize),
+	c := newCache(DefaultMaxSize)
+	go c.startAutoCleanup()
+	return c
+}
+
+// newCache creates an empty cache holding at most maxSize items, without starting the
+// automatic cleanup goroutine.
+func newCache(maxSize int) *Cache {
+	return &Cache{
+		items:               make(map[string]CacheItem, maxSize),
 		order:               list.New(),
-		elems:               make(map[string]*list.Element, DefaultMaxSize),
-		maxSize:             DefaultMaxSize,
+		elems:               make(map[string]*list.Element, maxSize),
+		maxSize:             maxSize,
 		autoCleanupInterval: 5 * time.Minute,
 		stopCleanup:         make(chan struct{}),
 	}
-	go c.startAutoCleanup()
-	return c
+}
+
+// SetMaxSize changes the maximum number of items held by the cache. Items beyond the
+// new limit are evicted on subsequent Set calls, least recently used first.
+func (c *Cache) SetMaxSize(size int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.maxSize = size
 }
 
 // Set adds or updates an item in the cache with the specified key, value, and expiration duration.
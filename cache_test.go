@@ -1,7 +1,10 @@
 package traefikoidc
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -288,7 +291,7 @@ func TestTokenCache(t *testing.T) {
 		}
 	})
 
-	t.Run("Token Prefix", func(t *testing.T) {
+	t.Run("Hashed Token Key", func(t *testing.T) {
 		tc := NewTokenCache()
 		token := "test-token"
 		claims := map[string]interface{}{"sub": "1234567890"}
@@ -297,10 +300,137 @@ func TestTokenCache(t *testing.T) {
 		// Set token
 		tc.Set(token, claims, expiration)
 
-		// Verify internal storage uses prefix
-		_, found := tc.cache.Get("t-" + token)
-		if !found {
-			t.Error("Expected to find prefixed token in underlying cache")
+		// Verify internal storage uses the prefixed hash, never the raw token
+		if _, found := tc.cache.Get("t-" + token); found {
+			t.Error("Expected raw token not to be used as a cache key")
+		}
+		key := tokenCacheKey(token)
+		if !strings.HasPrefix(key, "t-") || strings.Contains(key, token) {
+			t.Errorf("Unexpected token cache key %q", key)
+		}
+		if _, found := tc.cache.Get(key); !found {
+			t.Error("Expected to find hashed token key in underlying cache")
 		}
 	})
 }
+
+// TestCacheConcurrentAccess hammers a cache from many goroutines at once. Run it with
+// -race to check the cache for data races.
+func TestCacheConcurrentAccess(t *testing.T) {
+	c := NewCache()
+	defer c.Close()
+	c.SetMaxSize(64)
+
+	const goroutines = 16
+	const iterations = 500
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				key := fmt.Sprintf("key-%d", (g*iterations+i)%128)
+				switch i % 4 {
+				case 0:
+					c.Set(key, i, time.Duration(i%3)*time.Millisecond+time.Millisecond)
+				case 1:
+					c.Get(key)
+				case 2:
+					c.Delete(key)
+				default:
+					c.Cleanup()
+				}
+			}
+		}(g)
+	}
+
+	tc := NewTokenCache()
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				token := fmt.Sprintf("token-%d", i%32)
+				tc.Set(token, map[string]interface{}{"sub": token}, time.Second)
+				if claims, ok := tc.Get(token); ok && claims["sub"] != token {
+					t.Errorf("Expected claims for %s, got %v", token, claims)
+				}
+				if i%8 == 0 {
+					tc.Delete(token)
+					tc.Cleanup()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if len(c.items) > 64 {
+		t.Errorf("Expected at most 64 items, got %d", len(c.items))
+	}
+	if len(c.items) != len(c.elems) || len(c.items) != c.order.Len() {
+		t.Errorf("Expected consistent cache index, got %d items, %d elements, %d list entries",
+			len(c.items), len(c.elems), c.order.Len())
+	}
+}
+
+func TestShardedCache(t *testing.T) {
+	c := NewShardedCache(8)
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("key-%d", i), i, time.Minute)
+	}
+	for i := 0; i < 100; i++ {
+		if value, found := c.Get(fmt.Sprintf("key-%d", i)); !found || value != i {
+			t.Errorf("Expected key-%d to hold %d, got %v (found %v)", i, i, value, found)
+		}
+	}
+
+	// Items are spread over the shards
+	used := 0
+	for _, shard := range c.shards {
+		if len(shard.items) > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Errorf("Expected items in several shards, got %d", used)
+	}
+
+	c.Delete("key-0")
+	if _, found := c.Get("key-0"); found {
+		t.Error("Expected key-0 to be deleted")
+	}
+
+	// Cleanup reaches every shard
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("short-%d", i), i, time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	c.Cleanup()
+	if n := c.Len(); n != 99 {
+		t.Errorf("Expected 99 items after cleanup, got %d", n)
+	}
+
+	// The size limit is split between the shards
+	limited := NewShardedCache(8)
+	defer limited.Close()
+	limited.SetMaxSize(16)
+	for i := 0; i < 200; i++ {
+		limited.Set(fmt.Sprintf("fill-%d", i), i, time.Minute)
+	}
+	for _, shard := range limited.shards {
+		if len(shard.items) > 2 {
+			t.Errorf("Expected at most 2 items per shard, got %d", len(shard.items))
+		}
+	}
+
+	// An unset shard count uses the default
+	defaulted := NewShardedCache(0)
+	defer defaulted.Close()
+	if len(defaulted.shards) != DefaultCacheShards {
+		t.Errorf("Expected %d shards, got %d", DefaultCacheShards, len(defaulted.shards))
+	}
+}
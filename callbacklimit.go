@@ -0,0 +1,112 @@
+package traefikoidc
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultCallbackRateLimit is the default number of callback requests a single client IP may
+// make per minute.
+const DefaultCallbackRateLimit = 30
+
+// callbackLimiterSize is the maximum number of client IPs tracked by the callback limiter;
+// the least recently seen clients are forgotten first.
+const callbackLimiterSize = 10000
+
+// callbackLimiterTTL is how long the bucket of an idle client IP is kept.
+const callbackLimiterTTL = 10 * time.Minute
+
+// callbackLimiter throttles callback requests per client IP with a token bucket, so that a
+// client hammering the callback with bogus codes cannot exhaust the token endpoint quota.
+type callbackLimiter struct {
+	mutex     sync.Mutex
+	limiters  *Cache
+	perMinute int
+}
+
+// newCallbackLimiter creates a limiter allowing each client IP perMinute callback requests
+// per minute, with bursts of up to the same number.
+//
+// Parameters:
+//   - perMinute: The number of callback requests allowed per client IP and minute.
+//
+// Returns:
+//   - The callback limiter.
+func newCallbackLimiter(perMinute int) *callbackLimiter {
+	limiters := NewCache()
+	limiters.SetMaxSize(callbackLimiterSize)
+	return &callbackLimiter{limiters: limiters, perMinute: perMinute}
+}
+
+// allow reports whether a callback request from the given client IP is within its limit,
+// consuming one token from the client's bucket if so.
+//
+// Parameters:
+//   - ip: The client IP address.
+//
+// Returns:
+//   - true if the request may proceed.
+func (l *callbackLimiter) allow(ip string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var limiter *rate.Limiter
+	if value, ok := l.limiters.Get(ip); ok {
+		limiter, _ = value.(*rate.Limiter)
+	}
+	if limiter == nil {
+		limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(l.perMinute)), l.perMinute)
+	}
+	// Re-setting keeps the bucket of an active client from expiring
+	l.limiters.Set(ip, limiter, callbackLimiterTTL)
+	return limiter.Allow()
+}
+
+// clientIP returns the IP address of the client that sent the request. When trusted proxies
+// are configured and the request came through one of them, the right-most X-Forwarded-For
+// address that is not a trusted proxy is used; otherwise the peer address.
+//
+// Parameters:
+//   - req: The incoming HTTP request.
+//
+// Returns:
+//   - The client IP address.
+func (t *TraefikOidc) clientIP(req *http.Request) string {
+	peer := remoteIP(req)
+	if len(t.trustedProxies) == 0 || !t.trustsForwardedHeaders(req) {
+		return peer
+	}
+	hops := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		if !t.isTrustedProxy(ip) {
+			return hop
+		}
+	}
+	return peer
+}
+
+// isTrustedProxy reports whether the address belongs to one of the trusted proxy ranges.
+//
+// Parameters:
+//   - ip: The address to check.
+//
+// Returns:
+//   - true if the address is a trusted proxy.
+func (t *TraefikOidc) isTrustedProxy(ip net.IP) bool {
+	for _, network := range t.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package traefikoidc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resolveClaimPath looks up a claim by a dotted path such as "resource_access.myclient.roles",
+// for providers like Keycloak that nest roles inside objects. Each segment selects a key of
+// an object; arrays met along the path are searched element by element. A claim whose name
+// is the whole path, dots included, takes precedence.
+//
+// Parameters:
+//   - claims: The token claims.
+//   - path: The dotted claim path.
+//
+// Returns:
+//   - The string values found at the path, with arrays flattened and numbers and booleans in
+//     their text form, or nil if the path does not resolve.
+func resolveClaimPath(claims map[string]interface{}, path string) []string {
+	if value, ok := claims[path]; ok {
+		return claimStrings(value)
+	}
+	values := []interface{}{claims}
+	for _, key := range strings.Split(path, ".") {
+		var next []interface{}
+		for _, value := range values {
+			next = append(next, claimChildren(value, key)...)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		values = next
+	}
+	var result []string
+	for _, value := range values {
+		result = append(result, claimStrings(value)...)
+	}
+	return result
+}
+
+// claimChildren returns the values stored under key in a claim object, or in each object of
+// a claim array.
+func claimChildren(value interface{}, key string) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if child, ok := v[key]; ok {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		var children []interface{}
+		for _, element := range v {
+			children = append(children, claimChildren(element, key)...)
+		}
+		return children
+	}
+	return nil
+}
+
+// claimStrings normalizes a claim value to a list of strings. Objects are skipped.
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case float64, bool:
+		return []string{fmt.Sprint(v)}
+	case []interface{}:
+		var result []string
+		for _, element := range v {
+			result = append(result, claimStrings(element)...)
+		}
+		return result
+	}
+	return nil
+}
+
+// validClaimPath reports whether a dotted claim path is non-empty and has no empty segments.
+func validClaimPath(path string) bool {
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package traefikoidc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CompressionCodec abstracts the algorithm used to compress tokens before they are
+// stored in session cookies. Each codec is identified by a one-byte marker that is
+// persisted next to the compressed token, so tokens can always be decompressed with
+// the codec that produced them, even after the configured codec changes.
+type CompressionCodec interface {
+	// ID returns the one-byte marker stored alongside compressed tokens.
+	// It must be unique across all registered codecs and must not be zero.
+	ID() byte
+
+	// Name returns the configuration name of the codec (e.g. "gzip").
+	Name() string
+
+	// Compress compresses the provided data.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress reverses Compress.
+	Decompress(data []byte) ([]byte, error)
+}
+
+const (
+	// codecIDGzip marks tokens compressed with gzip. Tokens stored before codec
+	// markers were introduced carry no marker and are treated as gzip.
+	codecIDGzip byte = 1
+
+	// codecIDDeflate marks tokens compressed with raw DEFLATE.
+	codecIDDeflate byte = 2
+
+	// DefaultCompressionCodec is the codec used when none is configured.
+	DefaultCompressionCodec = "gzip"
+)
+
+var (
+	compressionCodecsMu sync.RWMutex
+	compressionCodecs   = map[string]CompressionCodec{
+		"gzip":    gzipCodec{},
+		"deflate": deflateCodec{},
+	}
+)
+
+// RegisterCompressionCodec makes an additional compression codec (for example zstd or
+// brotli) selectable by name. The Traefik plugin runtime only ships the standard
+// library, so codecs backed by third-party packages can only be registered when the
+// middleware is embedded in a compiled binary.
+//
+// Parameters:
+//   - codec: The codec to register.
+//
+// Returns:
+//   - An error if the codec's ID is zero or its name or ID is already registered.
+func RegisterCompressionCodec(codec CompressionCodec) error {
+	if codec.ID() == 0 {
+		return fmt.Errorf("compression codec %q must not use the reserved ID 0", codec.Name())
+	}
+
+	compressionCodecsMu.Lock()
+	defer compressionCodecsMu.Unlock()
+
+	for name, existing := range compressionCodecs {
+		if name == codec.Name() {
+			return fmt.Errorf("compression codec %q is already registered", name)
+		}
+		if existing.ID() == codec.ID() {
+			return fmt.Errorf("compression codec ID %d is already used by %q", codec.ID(), name)
+		}
+	}
+	compressionCodecs[codec.Name()] = codec
+	return nil
+}
+
+// getCompressionCodec looks up a registered compression codec by name.
+// An empty name selects the default codec.
+//
+// Parameters:
+//   - name: The configuration name of the codec.
+//
+// Returns:
+//   - The matching CompressionCodec.
+//   - An error if no codec with that name is registered.
+func getCompressionCodec(name string) (CompressionCodec, error) {
+	if name == "" {
+		name = DefaultCompressionCodec
+	}
+
+	compressionCodecsMu.RLock()
+	defer compressionCodecsMu.RUnlock()
+
+	codec, ok := compressionCodecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression codec: %s", name)
+	}
+	return codec, nil
+}
+
+// compressionCodecByID looks up a registered compression codec by its one-byte marker.
+// A zero marker (tokens written before markers existed) resolves to gzip.
+//
+// Parameters:
+//   - id: The codec marker read from the session.
+//
+// Returns:
+//   - The matching CompressionCodec and true, or nil and false if the marker is unknown.
+func compressionCodecByID(id byte) (CompressionCodec, bool) {
+	if id == 0 {
+		id = codecIDGzip
+	}
+
+	compressionCodecsMu.RLock()
+	defer compressionCodecsMu.RUnlock()
+
+	for _, codec := range compressionCodecs {
+		if codec.ID() == id {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// gzipCodec implements CompressionCodec using gzip. It is the default codec.
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte     { return codecIDGzip }
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	// Refuse input that does not start with the gzip magic bytes, so a stored value that
+	// merely looks like base64 is never mistaken for a compressed token.
+	if !hasGzipMagic(data) {
+		return nil, errNotGzip
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// gzipMagic is the two-byte header that starts every gzip stream (RFC 1952).
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// errNotGzip is returned by gzipCodec.Decompress for data without the gzip magic bytes.
+var errNotGzip = errors.New("data is not gzip compressed")
+
+// hasGzipMagic reports whether data starts with the gzip magic bytes.
+func hasGzipMagic(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// deflateCodec implements CompressionCodec using raw DEFLATE, which avoids the
+// gzip header and trailer and therefore produces slightly smaller cookies.
+type deflateCodec struct{}
+
+func (deflateCodec) ID() byte     { return codecIDDeflate }
+func (deflateCodec) Name() string { return "deflate" }
+
+func (deflateCodec) Compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	fw, err := flate.NewWriter(&b, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (deflateCodec) Decompress(data []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer fr.Close()
+	return io.ReadAll(fr)
+}
@@ -0,0 +1,81 @@
+package traefikoidc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultMaxProviderConcurrency is the default limit on requests to the provider
+// (discovery, JWKS, token, UserInfo, revocation) in flight at the same time.
+const DefaultMaxProviderConcurrency = 20
+
+// providerQueueTimeout is how long a provider request waits for a free slot before failing.
+const providerQueueTimeout = 5 * time.Second
+
+// ErrProviderBusy is returned for provider requests that could not get a free slot within
+// the queue timeout because the concurrency limit was reached.
+var ErrProviderBusy = errors.New("too many concurrent requests to the provider")
+
+// limitedTransport is an http.RoundTripper that allows at most a fixed number of requests in
+// flight, counting each request until its response body is closed. Further requests queue
+// for up to providerQueueTimeout, so that a burst of cold-start logins or a key rotation does
+// not flood the provider or exhaust the connection pool.
+type limitedTransport struct {
+	base  http.RoundTripper
+	slots chan struct{}
+}
+
+// newLimitedTransport wraps a transport with a concurrency limit.
+//
+// Parameters:
+//   - base: The transport performing the requests; nil uses http.DefaultTransport.
+//   - limit: The maximum number of requests in flight.
+//
+// Returns:
+//   - The limiting transport.
+func newLimitedTransport(base http.RoundTripper, limit int) *limitedTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &limitedTransport{base: base, slots: make(chan struct{}, limit)}
+}
+
+// RoundTrip waits for a free slot, then performs the request with the base transport. The
+// slot is released when the response body is closed, or immediately if the request fails.
+func (l *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timer := time.NewTimer(providerQueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: %s waited %v for a free slot", ErrProviderBusy, req.URL.Host, providerQueueTimeout)
+	}
+
+	resp, err := l.base.RoundTrip(req)
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	resp.Body = &slotReleasingBody{ReadCloser: resp.Body, release: func() { <-l.slots }}
+	return resp, nil
+}
+
+// slotReleasingBody releases a limitedTransport slot when the response body is closed.
+type slotReleasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+// Close closes the body and releases the request's slot.
+func (b *slotReleasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
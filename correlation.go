@@ -0,0 +1,75 @@
+package traefikoidc
+
+import (
+	"net/http"
+)
+
+// correlationIDBytes is the number of random bytes of a correlation ID, hex encoded into a
+// short string that is easy to grep for.
+const correlationIDBytes = 6
+
+// newCorrelationID generates the correlation ID of a new auth flow and emits it as the
+// correlation ID response header, if configured. The ID is stored in the session so that the
+// callback and later token refreshes of the session are logged under the same ID.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//
+// Returns:
+//   - The correlation ID, or an empty string if correlation IDs are disabled or could not be
+//     generated.
+func (t *TraefikOidc) newCorrelationID(rw http.ResponseWriter) string {
+	if !t.logCorrelationIDs && t.correlationIDHeader == "" {
+		return ""
+	}
+	id, err := generateSecureRandomString(t.random, correlationIDBytes)
+	if err != nil {
+		t.logger.Errorf("Failed to generate correlation ID: %v", err)
+		return ""
+	}
+	if t.correlationIDHeader != "" {
+		rw.Header().Set(t.correlationIDHeader, id)
+	}
+	return id
+}
+
+// correlatedLogger returns the logger for an auth flow: with logCorrelationIds enabled, its
+// messages are tagged with the flow's correlation ID.
+//
+// Parameters:
+//   - id: The correlation ID of the flow, empty if it has none.
+//
+// Returns:
+//   - The logger to use for the flow.
+func (t *TraefikOidc) correlatedLogger(id string) *Logger {
+	if !t.logCorrelationIDs || id == "" {
+		return t.logger
+	}
+	return t.logger.WithCorrelationID(id)
+}
+
+// flowLogger returns the logger for the auth flow the session belongs to.
+//
+// Parameters:
+//   - session: The user's session data.
+//
+// Returns:
+//   - The logger to use for the request.
+func (t *TraefikOidc) flowLogger(session *SessionData) *Logger {
+	return t.correlatedLogger(session.GetCorrelationID())
+}
+
+// emitCorrelationID sets the correlation ID response header to the session's correlation ID,
+// if a header is configured and the session has one.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - session: The user's session data.
+func (t *TraefikOidc) emitCorrelationID(rw http.ResponseWriter, session *SessionData) {
+	if t.correlationIDHeader == "" {
+		return
+	}
+	if id := session.GetCorrelationID(); id != "" {
+		rw.Header().Set(t.correlationIDHeader, id)
+	}
+}
@@ -0,0 +1,83 @@
+package traefikoidc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// buildDevToken prepares dev mode: it checks the environment guard and encodes the static
+// claims as an unsigned token, so that the claim-forwarding and authorization code paths
+// read them exactly as they would read the claims of a provider-issued token.
+//
+// Parameters:
+//   - claims: The static claims every request is authenticated with.
+//
+// Returns:
+//   - The unsigned token carrying the claims.
+//   - An error if the environment guard is not set or the claims cannot be encoded.
+func buildDevToken(claims map[string]interface{}) (string, error) {
+	if os.Getenv(DevModeEnvVar) != "true" {
+		return "", fmt.Errorf("devMode refuses to activate unless the %s environment variable is set to true", DevModeEnvVar)
+	}
+	if len(claims) == 0 {
+		return "", fmt.Errorf("devMode requires devClaims")
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode dev token header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode devClaims: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + ".", nil
+}
+
+// serveDevMode authenticates the request with the configured static claims instead of a
+// provider round-trip. Nothing is written to the session cookies; every request is
+// authenticated afresh, and the usual domain, role and group checks still apply.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - req: The incoming HTTP request.
+func (t *TraefikOidc) serveDevMode(rw http.ResponseWriter, req *http.Request) {
+	if t.determineExcludedURL(req.URL.Path) {
+		t.next.ServeHTTP(rw, req)
+		return
+	}
+
+	claims, err := extractClaims(t.devToken)
+	if err != nil {
+		t.logger.Errorf("Failed to decode dev mode claims: %v", err)
+		http.Error(rw, "Invalid dev mode claims", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := t.sessionManager.GetSession(req)
+	if err != nil {
+		t.logger.Errorf("Failed to get session in dev mode: %v", err)
+		http.Error(rw, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	userID, email := t.userIdentity(claims)
+	if err := session.SetAuthenticated(true); err != nil {
+		t.logger.Errorf("Failed to mark dev mode session as authenticated: %v", err)
+		http.Error(rw, "Session error", http.StatusInternalServerError)
+		return
+	}
+	session.SetEmail(email)
+	session.SetUserID(userID)
+	if err := session.SetAccessToken(t.devToken); err != nil {
+		t.logger.Errorf("Failed to set dev mode token: %v", err)
+		http.Error(rw, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	t.processAuthorizedRequest(rw, req, session, t.buildRedirectURL(req))
+}
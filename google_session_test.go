@@ -1,6 +1,7 @@
 package traefikoidc
 
 import (
+	"context"
 	"fmt"
 	"net/http/httptest"
 	"strings"
@@ -13,7 +14,7 @@ type MockTokenVerifier struct {
 	VerifyFunc func(token string) error
 }
 
-func (m *MockTokenVerifier) VerifyToken(token string) error {
+func (m *MockTokenVerifier) VerifyToken(ctx context.Context, token string) error {
 	if m.VerifyFunc != nil {
 		return m.VerifyFunc(token)
 	}
@@ -86,7 +87,7 @@ func TestGoogleOIDCRefreshTokenHandling(t *testing.T) {
 
 		// Create a mock token exchanger that simulates Google's behavior
 		mockTokenExchanger := &MockTokenExchanger{
-			RefreshTokenFunc: func(refreshToken string) (*TokenResponse, error) {
+			RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 				// Check that the refresh token is passed correctly
 				if refreshToken != "valid-refresh-token" {
 					t.Errorf("Incorrect refresh token passed: %s", refreshToken)
@@ -1,18 +1,23 @@
 package traefikoidc
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // generateNonce creates a cryptographically secure random string suitable for use as an OIDC nonce.
@@ -20,12 +25,15 @@ import (
 // the ID token with the specific authentication request.
 // It generates 32 random bytes and encodes them using base64 URL encoding.
 //
+// Parameters:
+//   - random: The source of randomness; nil uses crypto/rand.
+//
 // Returns:
 //   - A base64 URL encoded random string (nonce).
 //   - An error if the random byte generation fails.
-func generateNonce() (string, error) {
+func generateNonce(random io.Reader) (string, error) {
 	nonceBytes := make([]byte, 32)
-	_, err := rand.Read(nonceBytes)
+	err := readRandom(random, nonceBytes)
 	if err != nil {
 		return "", fmt.Errorf("could not generate nonce: %w", err)
 	}
@@ -36,13 +44,16 @@ func generateNonce() (string, error) {
 // According to RFC 7636, the verifier should be a high-entropy string between 43 and 128 characters long.
 // This function generates 32 random bytes, resulting in a 43-character base64 URL encoded string.
 //
+// Parameters:
+//   - random: The source of randomness; nil uses crypto/rand.
+//
 // Returns:
 //   - A base64 URL encoded random string (code verifier).
 //   - An error if the random byte generation fails.
-func generateCodeVerifier() (string, error) {
+func generateCodeVerifier(random io.Reader) (string, error) {
 	// Using 32 bytes (256 bits) will produce a 43 character base64url string
 	verifierBytes := make([]byte, 32)
-	_, err := rand.Read(verifierBytes)
+	err := readRandom(random, verifierBytes)
 	if err != nil {
 		return "", fmt.Errorf("could not generate code verifier: %w", err)
 	}
@@ -88,6 +99,110 @@ type TokenResponse struct {
 	TokenType string `json:"token_type"`
 }
 
+// expiresAt returns the absolute expiry of the access token from its expires_in lifetime.
+//
+// Returns:
+//   - The expiry time, or the zero time if the provider did not report a lifetime.
+func (r *TokenResponse) expiresAt() time.Time {
+	if r.ExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+}
+
+// ErrTokenEndpointRedirect is returned by token requests when the token endpoint answers with
+// a redirect while redirects are disallowed. A correctly configured token endpoint never
+// redirects; following one can turn the POST into a GET and drop the request body.
+var ErrTokenEndpointRedirect = errors.New("token endpoint redirected the request")
+
+// OAuthError is returned when the token endpoint answers with a non-200 status.
+// It carries the HTTP status and, when the body is a standard OAuth 2.0 error response,
+// the error code and description.
+type OAuthError struct {
+	// StatusCode is the HTTP status returned by the token endpoint
+	StatusCode int
+
+	// Code is the OAuth 2.0 "error" value, e.g. "invalid_grant" (empty if not provided)
+	Code string
+
+	// Description is the OAuth 2.0 "error_description" value (empty if not provided)
+	Description string
+
+	// Body is the raw response body
+	Body string
+}
+
+// Error implements the error interface.
+func (e *OAuthError) Error() string {
+	return fmt.Sprintf("token endpoint returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// newOAuthError builds an OAuthError from a token endpoint response.
+//
+// Parameters:
+//   - statusCode: The HTTP status code of the response.
+//   - body: The raw response body.
+//
+// Returns:
+//   - The OAuthError, with Code and Description filled in if the body is an OAuth error response.
+func newOAuthError(statusCode int, body []byte) *OAuthError {
+	oauthErr := &OAuthError{StatusCode: statusCode, Body: string(body)}
+	var payload struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		oauthErr.Code = payload.Error
+		oauthErr.Description = payload.ErrorDescription
+	}
+	return oauthErr
+}
+
+// isProviderUnavailable reports whether a token request failed because the provider could not
+// be reached or was temporarily unable to answer (network errors, timeouts, 5xx and 429), as
+// opposed to the provider rejecting the request.
+//
+// Parameters:
+//   - err: The error returned by the token request.
+//
+// Returns:
+//   - true if the failure is transient and not a rejection by the provider.
+func isProviderUnavailable(err error) bool {
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		return oauthErr.StatusCode >= http.StatusInternalServerError || oauthErr.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// reservedTokenParams lists the token request parameters set by exchangeTokens itself,
+// which configured extra parameters are not allowed to override.
+var reservedTokenParams = map[string]bool{
+	"grant_type":    true,
+	"client_id":     true,
+	"client_secret": true,
+	"code":          true,
+	"refresh_token": true,
+	"code_verifier": true,
+	"redirect_uri":  true,
+}
+
+// addResourceParams adds the configured RFC 8707 resource indicators and the audience
+// parameter to an authorization or token request, so the provider issues an access token
+// whose audience matches the target API.
+//
+// Parameters:
+//   - params: The request parameters to extend.
+func (t *TraefikOidc) addResourceParams(params url.Values) {
+	for _, resource := range t.resources {
+		params.Add("resource", resource)
+	}
+	if t.audience != "" {
+		params.Set("audience", t.audience)
+	}
+}
+
 // exchangeTokens performs the OAuth 2.0 token exchange with the OIDC provider's token endpoint.
 // It handles both the "authorization_code" grant type (exchanging an authorization code for tokens)
 // and the "refresh_token" grant type (using a refresh token to obtain new tokens).
@@ -121,6 +236,21 @@ func (t *TraefikOidc) exchangeTokens(ctx context.Context, grantType string, code
 		}
 	} else if grantType == "refresh_token" {
 		data.Set("refresh_token", codeOrToken)
+
+		// Ask for the originally requested scopes so the refreshed token is not narrowed
+		if t.sendScopeOnRefresh {
+			data.Set("scope", t.requestScope())
+		}
+	}
+
+	t.addResourceParams(data)
+
+	// Merge configured provider-specific parameters, never overriding reserved ones
+	for name, value := range t.tokenRequestParams {
+		if reservedTokenParams[name] {
+			continue
+		}
+		data.Set(name, value)
 	}
 
 	// Create a cookie jar for this request to handle redirects with cookies
@@ -129,7 +259,10 @@ func (t *TraefikOidc) exchangeTokens(ctx context.Context, grantType string, code
 		Transport: t.httpClient.Transport,
 		Timeout:   t.httpClient.Timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Always follow redirects for OIDC endpoints
+			if t.noTokenRedirects {
+				return fmt.Errorf("%w from %s to %s; check the configured token endpoint", ErrTokenEndpointRedirect, via[0].URL, req.URL)
+			}
+			// Otherwise follow redirects for OIDC endpoints
 			if len(via) >= 50 {
 				return fmt.Errorf("stopped after 50 redirects")
 			}
@@ -138,11 +271,15 @@ func (t *TraefikOidc) exchangeTokens(ctx context.Context, grantType string, code
 		Jar: jar,
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", t.tokenURL, strings.NewReader(data.Encode()))
+	body, contentType, err := t.encodeTokenRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.tokenURL, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create token request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Type", contentType)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -152,7 +289,7 @@ func (t *TraefikOidc) exchangeTokens(ctx context.Context, grantType string, code
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newOAuthError(resp.StatusCode, bodyBytes)
 	}
 
 	var tokenResponse TokenResponse
@@ -163,18 +300,48 @@ func (t *TraefikOidc) exchangeTokens(ctx context.Context, grantType string, code
 	return &tokenResponse, nil
 }
 
+// encodeTokenRequest encodes the parameters of a token request with the configured encoding.
+// With JSON encoding, single-valued parameters become strings and repeated parameters (such
+// as several resource indicators) become arrays of strings.
+//
+// Parameters:
+//   - data: The token request parameters.
+//
+// Returns:
+//   - The request body.
+//   - The Content-Type of the body.
+//   - An error if the parameters cannot be encoded.
+func (t *TraefikOidc) encodeTokenRequest(data url.Values) (io.Reader, string, error) {
+	if !t.tokenRequestJSON {
+		return strings.NewReader(data.Encode()), "application/x-www-form-urlencoded", nil
+	}
+	params := make(map[string]interface{}, len(data))
+	for name, values := range data {
+		if len(values) == 1 {
+			params[name] = values[0]
+		} else {
+			params[name] = values
+		}
+	}
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode token request: %w", err)
+	}
+	return bytes.NewReader(encoded), "application/json", nil
+}
+
 // getNewTokenWithRefreshToken uses a refresh token to obtain a new set of tokens (ID, access, refresh)
 // from the OIDC provider's token endpoint. It wraps the exchangeTokens function with the
 // "refresh_token" grant type.
 //
 // Parameters:
+//   - ctx: The context of the request that triggered the refresh; cancelling it aborts the call.
 //   - refreshToken: The refresh token previously obtained during authentication or a prior refresh.
 //
 // Returns:
 //   - A TokenResponse containing the newly obtained tokens.
 //   - An error if the refresh operation fails.
-func (t *TraefikOidc) getNewTokenWithRefreshToken(refreshToken string) (*TokenResponse, error) {
-	ctx := context.Background()
+func (t *TraefikOidc) getNewTokenWithRefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 	tokenResponse, err := t.exchangeTokens(ctx, "refresh_token", refreshToken, "", "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to refresh token: %w", err)
@@ -218,33 +385,81 @@ func extractClaims(tokenString string) (map[string]interface{}, error) {
 // It stores token claims to avoid repeated validation of the
 // same token, improving performance for frequently used tokens.
 type TokenCache struct {
-	// cache is the underlying cache implementation
-	cache *Cache
+	// cache is the underlying cache implementation, sharded to reduce lock contention
+	cache *ShardedCache
 }
 
-// NewTokenCache creates and initializes a new TokenCache.
-// It internally creates a new generic Cache instance for storage.
+// NewTokenCache creates and initializes a new TokenCache with the default number of shards.
 func NewTokenCache() *TokenCache {
+	return NewShardedTokenCache(DefaultCacheShards)
+}
+
+// NewShardedTokenCache creates and initializes a new TokenCache whose storage is split into
+// the given number of independently locked shards.
+//
+// Parameters:
+//   - shards: The number of cache shards; values below 1 use DefaultCacheShards.
+func NewShardedTokenCache(shards int) *TokenCache {
 	return &TokenCache{
-		cache: NewCache(),
+		cache: NewShardedCache(shards),
 	}
 }
 
+// tokenCacheKey derives the cache key for a token from its SHA-256 digest, so that raw
+// tokens (bearer credentials) are never kept as keys in the cache map. The key is
+// prefixed to avoid potential collisions with other cache types.
+//
+// Parameters:
+//   - token: The raw token string.
+//
+// Returns:
+//   - The prefixed, hex-encoded SHA-256 digest of the token.
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "t-" + hex.EncodeToString(sum[:])
+}
+
+// tokenCacheTTL computes how long the claims of a verified token may be cached: until the
+// token's own 'exp', capped at maxTTL, so that a token is never trusted from the cache after
+// it has expired.
+//
+// Parameters:
+//   - claims: The verified token claims.
+//   - maxTTL: The configured maximum lifetime of a cache entry (0 or less for no cap).
+//
+// Returns:
+//   - The cache TTL, or 0 if the token has no 'exp' claim or has already expired and must
+//     not be cached.
+func tokenCacheTTL(claims map[string]interface{}, maxTTL time.Duration) time.Duration {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		// Without an expiry there is no safe bound for the cache entry
+		return 0
+	}
+	ttl := time.Until(time.Unix(int64(exp), 0))
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
 // Set stores the claims associated with a specific token string in the cache.
-// It prefixes the token string to avoid potential collisions with other cache types
-// and sets the provided expiration duration.
+// The entry is keyed by the token's hash (see tokenCacheKey) and expires after the
+// provided duration.
 //
 // Parameters:
-//   - token: The raw token string (used as the key).
+//   - token: The raw token string (hashed to form the key).
 //   - claims: The map of claims associated with the token.
 //   - expiration: The duration for which the cache entry should be valid.
 func (tc *TokenCache) Set(token string, claims map[string]interface{}, expiration time.Duration) {
-	token = "t-" + token
-	tc.cache.Set(token, claims, expiration)
+	tc.cache.Set(tokenCacheKey(token), claims, expiration)
 }
 
 // Get retrieves the cached claims for a given token string.
-// It prefixes the token string before querying the underlying cache.
+// It hashes the token string before querying the underlying cache.
 //
 // Parameters:
 //   - token: The raw token string to look up.
@@ -253,8 +468,7 @@ func (tc *TokenCache) Set(token string, claims map[string]interface{}, expiratio
 //   - The cached claims map if found and valid.
 //   - A boolean indicating whether the token was found in the cache (true if found, false otherwise).
 func (tc *TokenCache) Get(token string) (map[string]interface{}, bool) {
-	token = "t-" + token
-	value, found := tc.cache.Get(token)
+	value, found := tc.cache.Get(tokenCacheKey(token))
 	if !found {
 		return nil, false
 	}
@@ -263,13 +477,12 @@ func (tc *TokenCache) Get(token string) (map[string]interface{}, bool) {
 }
 
 // Delete removes the cached entry for a specific token string.
-// It prefixes the token string before calling the underlying cache's Delete method.
+// It hashes the token string before calling the underlying cache's Delete method.
 //
 // Parameters:
 //   - token: The raw token string to remove from the cache.
 func (tc *TokenCache) Delete(token string) {
-	token = "t-" + token
-	tc.cache.Delete(token)
+	tc.cache.Delete(tokenCacheKey(token))
 }
 
 // Cleanup triggers the cleanup process for the underlying generic cache,
@@ -283,6 +496,7 @@ func (tc *TokenCache) Cleanup() {
 // PKCE code verifier based on the middleware's configuration (t.enablePKCE).
 //
 // Parameters:
+//   - ctx: The context of the callback request; cancelling it aborts the call.
 //   - code: The authorization code received from the OIDC provider.
 //   - redirectURL: The redirect URI used in the initial authorization request.
 //   - codeVerifier: The PKCE code verifier stored in the session (if PKCE is enabled).
@@ -290,9 +504,7 @@ func (tc *TokenCache) Cleanup() {
 // Returns:
 //   - A TokenResponse containing the obtained tokens.
 //   - An error if the code exchange fails.
-func (t *TraefikOidc) exchangeCodeForToken(code string, redirectURL string, codeVerifier string) (*TokenResponse, error) {
-	ctx := context.Background()
-
+func (t *TraefikOidc) exchangeCodeForToken(ctx context.Context, code string, redirectURL string, codeVerifier string) (*TokenResponse, error) {
 	// Only include code verifier if PKCE is enabled
 	effectiveCodeVerifier := ""
 	if t.enablePKCE && codeVerifier != "" {
@@ -323,26 +535,401 @@ func createStringMap(keys []string) map[string]struct{} {
 	return result
 }
 
+// normalizeScopes prepares the configured scopes for an authorization request: blank entries
+// are dropped, duplicates are removed (keeping the first occurrence) and "openid" is added
+// at the front when missing, since without it the provider returns no ID token.
+//
+// Parameters:
+//   - scopes: The configured scopes.
+//
+// Returns:
+//   - The normalized list of scopes.
+//   - true if "openid" had to be added.
+func normalizeScopes(scopes []string) ([]string, bool) {
+	seen := make(map[string]struct{}, len(scopes)+1)
+	result := make([]string, 0, len(scopes)+1)
+	result = append(result, "openid")
+	seen["openid"] = struct{}{}
+
+	addedOpenID := true
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "openid" {
+			addedOpenID = false
+		}
+		if scope == "" {
+			continue
+		}
+		if _, ok := seen[scope]; ok {
+			continue
+		}
+		seen[scope] = struct{}{}
+		result = append(result, scope)
+	}
+	return result, addedOpenID
+}
+
+// authTooOld reports whether maxAuthAgeSeconds is configured and the authenticated session's
+// last authentication at the provider is older than allowed. Sessions without a recorded
+// authentication time are treated as too old.
+//
+// Parameters:
+//   - session: The user's session data.
+//
+// Returns:
+//   - true if the user must authenticate again.
+func (t *TraefikOidc) authTooOld(session *SessionData) bool {
+	if t.maxAuthAge <= 0 || !session.GetAuthenticated() {
+		return false
+	}
+	authTime := session.GetAuthTime()
+	return authTime.IsZero() || time.Since(authTime) > t.maxAuthAge+ClockSkewTolerance
+}
+
+// setURLQueryParam sets a query parameter on a URL, returning the URL unchanged if it cannot
+// be parsed.
+//
+// Parameters:
+//   - rawURL: The URL to modify.
+//   - key: The query parameter name.
+//   - value: The query parameter value.
+//
+// Returns:
+//   - The URL with the parameter set.
+func setURLQueryParam(rawURL, key, value string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// parseTrustedProxies parses the trustedProxies entries into networks. A single IP address
+// is treated as a network containing only that address.
+//
+// Parameters:
+//   - entries: IP addresses or CIDR ranges.
+//
+// Returns:
+//   - The parsed networks.
+//   - An error naming the first entry that is neither an IP address nor a CIDR range.
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("trustedProxies entry %q is not an IP address or CIDR range", entry)
+		}
+		bits := 8 * net.IPv6len
+		if v4 := ip.To4(); v4 != nil {
+			ip, bits = v4, 8*net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// subjectMismatch reports whether the subject of a token differs from the subject the session
+// was established for, which means the token belongs to a different user. Sessions that
+// predate subject tracking are not checked.
+//
+// Parameters:
+//   - session: The user's session data.
+//   - claims: The claims of the token obtained for the session.
+//
+// Returns:
+//   - true if the session recorded a subject and the token's 'sub' claim differs from it.
+func subjectMismatch(session *SessionData, claims map[string]interface{}) bool {
+	stored := session.GetSubject()
+	if stored == "" {
+		return false
+	}
+	sub, _ := claims["sub"].(string)
+	return sub != stored
+}
+
+// claimsStale reports whether the session's claims are due to be refreshed from the provider
+// under claimsRefreshIntervalSeconds. Only sessions with a refresh token can be refreshed;
+// sessions that never recorded a claims update are refreshed once.
+//
+// Parameters:
+//   - session: The authenticated session.
+//
+// Returns:
+//   - true if the session's token should be refreshed to pick up updated claims.
+func (t *TraefikOidc) claimsStale(session *SessionData) bool {
+	if t.claimsRefreshInterval <= 0 || session.GetRefreshToken() == "" {
+		return false
+	}
+	updatedAt := session.GetClaimsUpdatedAt()
+	return updatedAt.IsZero() || time.Since(updatedAt) >= t.claimsRefreshInterval
+}
+
+// trustsForwardedHeaders reports whether the request's X-Forwarded-* headers may be honored,
+// that is whether the immediate peer is one of the trusted proxies.
+//
+// Parameters:
+//   - req: The incoming HTTP request.
+//
+// Returns:
+//   - true if no trusted proxies are configured or the peer address is in one of them.
+func (t *TraefikOidc) trustsForwardedHeaders(req *http.Request) bool {
+	if len(t.trustedProxies) == 0 {
+		return true
+	}
+	ip := net.ParseIP(remoteIP(req))
+	if ip == nil {
+		return false
+	}
+	return t.isTrustedProxy(ip)
+}
+
+// rejectRedirectHost answers with 400 when a redirect URI would be built from a request host
+// outside allowedRedirectHosts, so that login starts and callbacks never use one. Requests
+// that do not build a redirect URI, such as those of authenticated sessions, are not checked.
+//
+// Parameters:
+//   - rw: The HTTP response writer receiving the error.
+//   - req: The request starting a login or completing one.
+//
+// Returns:
+//   - true if the request was rejected.
+func (t *TraefikOidc) rejectRedirectHost(rw http.ResponseWriter, req *http.Request) bool {
+	if t.publicCallbackURL != "" || t.isAllowedRedirectHost(t.determineHost(req)) {
+		return false
+	}
+	t.logger.Errorf("Rejecting login for host %q not in allowedRedirectHosts", t.determineHost(req))
+	t.sendErrorResponse(rw, req, "Host not allowed", http.StatusBadRequest)
+	return true
+}
+
+// isAllowedRedirectHost checks a request host against the configured redirect host allowlist.
+// Entries match the host exactly (case-insensitively); entries without a port also match the
+// host on any port, and "*.example.com" entries match any subdomain of example.com but not
+// example.com itself.
+//
+// Parameters:
+//   - host: The request host, possibly including a port.
+//
+// Returns:
+//   - true if no allowlist is configured or the host matches an entry.
+func (t *TraefikOidc) isAllowedRedirectHost(host string) bool {
+	if len(t.allowedRedirectHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	for _, allowed := range t.allowedRedirectHosts {
+		allowed = strings.ToLower(allowed)
+		if allowed == host || allowed == hostname {
+			return true
+		}
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(hostname, allowed[1:]) && len(hostname) > len(allowed)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// isCORSPreflight reports whether the request is a CORS preflight: an OPTIONS request
+// carrying both an Origin and an Access-Control-Request-Method header.
+//
+// Parameters:
+//   - req: The incoming HTTP request.
+//
+// Returns:
+//   - true if the request is a CORS preflight.
+func isCORSPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsOriginAllowed reports whether the origin is listed in corsAllowedOrigins. Origins are
+// compared case-insensitively; an empty list allows none.
+//
+// Parameters:
+//   - origin: The value of the request's Origin header.
+//
+// Returns:
+//   - true if the origin may make credentialed cross-origin requests.
+func (t *TraefikOidc) corsOriginAllowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	_, ok := t.corsAllowedOrigins[strings.ToLower(origin)]
+	return ok
+}
+
+// setCORSHeaders allows the given origin to make credentialed cross-origin requests. Callers
+// must only pass origins accepted by corsOriginAllowed.
+//
+// Parameters:
+//   - rw: The HTTP response writer whose headers are modified.
+//   - origin: The value of the request's Origin header.
+func setCORSHeaders(rw http.ResponseWriter, origin string) {
+	rw.Header().Set("Access-Control-Allow-Origin", origin)
+	rw.Header().Set("Access-Control-Allow-Credentials", "true")
+	rw.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	rw.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	rw.Header().Add("Vary", "Origin")
+}
+
+// setNoStoreHeaders marks a response as session-specific and uncacheable by setting
+// "Cache-Control: no-store" and adding "Cookie" to the Vary header. It is applied to
+// redirects, unauthorized responses and callback responses so that a shared cache or CDN
+// never serves one user's redirect, login state or error page to another.
+//
+// Parameters:
+//   - rw: The HTTP response writer whose headers are modified.
+func setNoStoreHeaders(rw http.ResponseWriter) {
+	header := rw.Header()
+	header.Set("Cache-Control", "no-store")
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Cookie") {
+				return
+			}
+		}
+	}
+	header.Add("Vary", "Cookie")
+}
+
+// parseFormLimited parses the request's form, reading at most the configured maximum number
+// of body bytes so that an oversized POST cannot exhaust memory. Endpoints of the middleware
+// that read request bodies must use it instead of calling ParseForm directly.
+//
+// Parameters:
+//   - rw: The HTTP response writer, used by http.MaxBytesReader to close oversized requests.
+//   - req: The incoming HTTP request.
+//
+// Returns:
+//   - An error if the body exceeds the limit or the form cannot be parsed; callers answer
+//     with 400 Bad Request.
+func (t *TraefikOidc) parseFormLimited(rw http.ResponseWriter, req *http.Request) error {
+	limit := t.maxRequestBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxRequestBodyBytes
+	}
+	if req.Body != nil {
+		req.Body = http.MaxBytesReader(rw, req.Body, limit)
+	}
+	if err := req.ParseForm(); err != nil {
+		return fmt.Errorf("failed to parse request form: %w", err)
+	}
+	return nil
+}
+
+// redactedURL formats a URL for logging with the values of token-bearing query parameters
+// (authorization codes and implicit flow tokens) replaced.
+//
+// Parameters:
+//   - u: The URL to format.
+//
+// Returns:
+//   - The URL string with sensitive query values redacted.
+func redactedURL(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, name := range []string{"code", "id_token", "access_token", "refresh_token"} {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	clean := *u
+	clean.RawQuery = query.Encode()
+	return clean.String()
+}
+
+// isTruthyParam reports whether a query parameter value enables an option, accepting the
+// usual boolean spellings and "on", which browsers send for checked checkboxes.
+//
+// Parameters:
+//   - value: The raw parameter value.
+//
+// Returns:
+//   - true if the value enables the option.
+func isTruthyParam(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
+
+// maxProviderErrorLength limits the length of provider error texts shown to users and logged.
+const maxProviderErrorLength = 256
+
+// sanitizeProviderError prepares an error or error_description parameter received from the
+// provider for logging and display: non-printable characters (including newlines, which could
+// forge log lines) are dropped and the text is truncated to maxProviderErrorLength characters.
+// The result is still untrusted text and must be escaped when rendered.
+//
+// Parameters:
+//   - value: The raw parameter value.
+//
+// Returns:
+//   - The sanitized text.
+func sanitizeProviderError(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsPrint(r) {
+			return r
+		}
+		return -1
+	}, value)
+	if runes := []rune(value); len(runes) > maxProviderErrorLength {
+		value = string(runes[:maxProviderErrorLength])
+	}
+	return value
+}
+
 // handleLogout processes requests to the configured logout path.
 // It performs the following steps:
 //  1. Retrieves the current user session.
 //  2. Gets the access token (ID token hint) from the session.
 //  3. Clears all authentication-related data from the session cookies.
 //  4. Determines the final post-logout redirect URI.
-//  5. If an OIDC end_session_endpoint is configured and an ID token hint is available,
-//     it builds the OIDC logout URL and redirects the user agent to the provider for logout.
+//  5. If an OIDC end_session_endpoint is configured and an ID token hint is available (or
+//     not needed by the configured logout parameters), it builds the logout URL and
+//     redirects the user agent to the provider for logout.
 //  6. Otherwise, it redirects the user agent directly to the post-logout redirect URI.
 //
+// When logoutConfirmation is enabled, the steps above only run for a POST carrying the
+// session's CSRF token; see confirmLogout.
+//
 // It handles potential errors during session retrieval or clearing.
 func (t *TraefikOidc) handleLogout(rw http.ResponseWriter, req *http.Request) {
+	setNoStoreHeaders(rw)
+
 	session, err := t.sessionManager.GetSession(req)
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrSessionExpired) {
 		t.logger.Errorf("Error getting session: %v", err)
 		http.Error(rw, "Session error", http.StatusInternalServerError)
 		return
 	}
 
+	if t.logoutConfirmation && !t.confirmLogout(rw, req, session) {
+		return
+	}
+
 	accessToken := session.GetAccessToken()
+	email := session.GetEmail()
 
 	if err := session.Clear(req, rw); err != nil {
 		t.logger.Errorf("Error clearing session: %v", err)
@@ -350,6 +937,8 @@ func (t *TraefikOidc) handleLogout(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	t.audit(req, AuditLogout, email, "")
+
 	host := t.determineHost(req)
 	scheme := t.determineScheme(req)
 	baseURL := fmt.Sprintf("%s://%s", scheme, host)
@@ -361,8 +950,8 @@ func (t *TraefikOidc) handleLogout(rw http.ResponseWriter, req *http.Request) {
 		postLogoutRedirectURI = fmt.Sprintf("%s%s", baseURL, postLogoutRedirectURI)
 	}
 
-	if t.endSessionURL != "" && accessToken != "" {
-		logoutURL, err := BuildLogoutURL(t.endSessionURL, accessToken, postLogoutRedirectURI)
+	if t.endSessionURL != "" && (accessToken != "" || t.logoutParams.OmitIDTokenHint) {
+		logoutURL, err := BuildSignedLogoutURL(t.endSessionURL, accessToken, postLogoutRedirectURI, t.clientID, t.issuerURL, t.logoutParams, t.logoutSigner)
 		if err != nil {
 			t.logger.Errorf("Failed to build logout URL: %v", err)
 			http.Error(rw, "Logout error", http.StatusInternalServerError)
@@ -375,6 +964,54 @@ func (t *TraefikOidc) handleLogout(rw http.ResponseWriter, req *http.Request) {
 	http.Redirect(rw, req, postLogoutRedirectURI, http.StatusFound)
 }
 
+// confirmLogout guards the logout path when logoutConfirmation is enabled, so that other
+// sites cannot force a logout with a link or image. Requests other than POST are answered
+// with a confirmation page whose form carries the session's CSRF token, issuing one if the
+// session has none. A POST must present that token, otherwise it is rejected with 403.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - req: The request to the logout path.
+//   - session: The user's session.
+//
+// Returns:
+//   - true if the request is a confirmed logout; false if a response has already been written.
+func (t *TraefikOidc) confirmLogout(rw http.ResponseWriter, req *http.Request, session *SessionData) bool {
+	if req.Method != http.MethodPost {
+		csrfToken := session.GetCSRF()
+		if csrfToken == "" {
+			var err error
+			csrfToken, err = generateSecureRandomString(t.random, 32)
+			if err != nil {
+				t.logger.Errorf("Failed to generate logout CSRF token: %v", err)
+				http.Error(rw, "Logout error", http.StatusInternalServerError)
+				return false
+			}
+			session.SetCSRF(csrfToken)
+			if err := session.Save(req, rw); err != nil {
+				t.logger.Errorf("Failed to save session: %v", err)
+				http.Error(rw, "Session error", http.StatusInternalServerError)
+				return false
+			}
+		}
+		t.sendLogoutConfirmationPage(rw, csrfToken)
+		return false
+	}
+
+	if err := t.parseFormLimited(rw, req); err != nil {
+		t.logger.Infof("Rejecting logout request: %v", err)
+		http.Error(rw, "Bad Request", http.StatusBadRequest)
+		return false
+	}
+	expected := session.GetCSRF()
+	if expected == "" || subtle.ConstantTimeCompare([]byte(req.PostForm.Get("csrf")), []byte(expected)) != 1 {
+		t.logger.Infof("Rejecting logout request without a valid CSRF token")
+		http.Error(rw, "Invalid logout request", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 // BuildLogoutURL constructs the URL for redirecting the user agent to the OIDC provider's
 // end_session_endpoint, including the required id_token_hint and optional
 // post_logout_redirect_uri parameters as query arguments.
@@ -388,17 +1025,113 @@ func (t *TraefikOidc) handleLogout(rw http.ResponseWriter, req *http.Request) {
 //   - The fully constructed logout URL string.
 //   - An error if the provided endSessionURL is invalid.
 func BuildLogoutURL(endSessionURL, idToken, postLogoutRedirectURI string) (string, error) {
+	return BuildLogoutURLWithParams(endSessionURL, idToken, postLogoutRedirectURI, "", LogoutParams{})
+}
+
+// BuildLogoutURLWithParams constructs the logout URL like BuildLogoutURL, using the parameter
+// names described by params so that providers with non-standard logout endpoints (such as
+// Auth0's returnTo or Cognito's logout_uri) can be targeted.
+//
+// Parameters:
+//   - endSessionURL: The URL of the provider's logout endpoint.
+//   - idToken: The ID token previously issued to the user (used as the ID token hint).
+//   - postLogoutRedirectURI: The optional URI where the provider should redirect the user agent after logout.
+//   - clientID: The client ID, sent when params.IncludeClientID is set.
+//   - params: The logout parameter mapping; zero values use the standard names.
+//
+// Returns:
+//   - The fully constructed logout URL string.
+//   - An error if the provided endSessionURL is invalid.
+func BuildLogoutURLWithParams(endSessionURL, idToken, postLogoutRedirectURI, clientID string, params LogoutParams) (string, error) {
 	u, err := url.Parse(endSessionURL)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse end session URL: %w", err)
 	}
 
+	paramName := func(name, standard string) string {
+		if name == "" {
+			return standard
+		}
+		return name
+	}
+
 	q := u.Query()
-	q.Set("id_token_hint", idToken)
+	if !params.OmitIDTokenHint {
+		q.Set(paramName(params.IDTokenHintParam, "id_token_hint"), idToken)
+	}
 	if postLogoutRedirectURI != "" {
-		q.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+		q.Set(paramName(params.PostLogoutRedirectParam, "post_logout_redirect_uri"), postLogoutRedirectURI)
+	}
+	if params.IncludeClientID {
+		q.Set(paramName(params.ClientIDParam, "client_id"), clientID)
 	}
 	u.RawQuery = q.Encode()
 
 	return u.String(), nil
 }
+
+// BuildSignedLogoutURL constructs the logout URL for providers that require RP-initiated
+// logout requests to be signed. The logout parameters are carried in a request object, a
+// JWT signed with the client's key and passed in the 'request' parameter next to client_id.
+// Without a signer it falls back to the plain URL built by BuildLogoutURLWithParams.
+//
+// Parameters:
+//   - endSessionURL: The base URL of the provider's end_session_endpoint.
+//   - idToken: The ID token hint.
+//   - postLogoutRedirectURI: The URI the provider should redirect to after logout (optional).
+//   - clientID: The client ID, used as the request object's issuer.
+//   - audience: The provider's issuer URL, used as the request object's audience.
+//   - params: The logout parameter mapping; zero values use the standard names.
+//   - signer: The client's request signer; nil builds an unsigned URL.
+//
+// Returns:
+//   - The fully constructed logout URL string.
+//   - An error if the endSessionURL is invalid or the request object cannot be signed.
+func BuildSignedLogoutURL(endSessionURL, idToken, postLogoutRedirectURI, clientID, audience string, params LogoutParams, signer *RequestSigner) (string, error) {
+	if signer == nil {
+		return BuildLogoutURLWithParams(endSessionURL, idToken, postLogoutRedirectURI, clientID, params)
+	}
+
+	// Build the plain URL first and move its logout parameters into the request object
+	plainURL, err := BuildLogoutURLWithParams(endSessionURL, idToken, postLogoutRedirectURI, clientID, params)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(plainURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse end session URL: %w", err)
+	}
+	base, err := url.Parse(endSessionURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse end session URL: %w", err)
+	}
+
+	jti, err := generateSecureRandomString(nil, 16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate request object ID: %w", err)
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":       clientID,
+		"aud":       audience,
+		"iat":       now.Unix(),
+		"exp":       now.Add(5 * time.Minute).Unix(),
+		"jti":       jti,
+		"client_id": clientID,
+	}
+	q := base.Query()
+	for name, values := range u.Query() {
+		if _, inBase := q[name]; !inBase && len(values) > 0 {
+			claims[name] = values[0]
+		}
+	}
+	requestObject, err := signer.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+
+	q.Set("client_id", clientID)
+	q.Set("request", requestObject)
+	base.RawQuery = q.Encode()
+	return base.String(), nil
+}
@@ -1,8 +1,16 @@
 package traefikoidc
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -61,7 +69,328 @@ func TestTokenCacheMemoryUsage(t *testing.T) {
 	}
 
 	// Verify cache size stayed within limits
-	if len(tc.cache.items) > tc.cache.maxSize {
-		t.Errorf("Cache exceeded max size: %d", len(tc.cache.items))
+	for _, shard := range tc.cache.shards {
+		if len(shard.items) > shard.maxSize {
+			t.Errorf("Cache shard exceeded max size: %d", len(shard.items))
+		}
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		name        string
+		scopes      []string
+		expected    []string
+		addedOpenID bool
+	}{
+		{
+			name:        "Defaults unchanged",
+			scopes:      []string{"openid", "profile", "email"},
+			expected:    []string{"openid", "profile", "email"},
+			addedOpenID: false,
+		},
+		{
+			name:        "Missing openid is added",
+			scopes:      []string{"profile", "email"},
+			expected:    []string{"openid", "profile", "email"},
+			addedOpenID: true,
+		},
+		{
+			name:        "Duplicates and blanks removed",
+			scopes:      []string{"email", "openid", "email", " ", "profile", "openid"},
+			expected:    []string{"openid", "email", "profile"},
+			addedOpenID: false,
+		},
+		{
+			name:        "Empty",
+			scopes:      nil,
+			expected:    []string{"openid"},
+			addedOpenID: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, added := normalizeScopes(tc.scopes)
+			if !stringSliceEqual(got, tc.expected) {
+				t.Errorf("Expected scopes %v, got %v", tc.expected, got)
+			}
+			if added != tc.addedOpenID {
+				t.Errorf("Expected addedOpenID=%v, got %v", tc.addedOpenID, added)
+			}
+		})
+	}
+}
+
+func TestIsAllowedRedirectHost(t *testing.T) {
+	tOidc := &TraefikOidc{
+		allowedRedirectHosts: []string{"app.example.com", "*.tenants.example.com", "localhost:8443"},
+	}
+
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{host: "app.example.com", allowed: true},
+		{host: "APP.example.com:443", allowed: true},
+		{host: "acme.tenants.example.com", allowed: true},
+		{host: "a.b.tenants.example.com", allowed: true},
+		{host: "tenants.example.com", allowed: false},
+		{host: "eviltenants.example.com", allowed: false},
+		{host: "localhost:8443", allowed: true},
+		{host: "localhost:9000", allowed: false},
+		{host: "attacker.com", allowed: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.host, func(t *testing.T) {
+			if got := tOidc.isAllowedRedirectHost(tc.host); got != tc.allowed {
+				t.Errorf("isAllowedRedirectHost(%q) = %v, want %v", tc.host, got, tc.allowed)
+			}
+		})
+	}
+
+	if !(&TraefikOidc{}).isAllowedRedirectHost("anything.example.org") {
+		t.Error("Expected any host to be allowed without an allowlist")
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.10", "::1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tOidc := &TraefikOidc{trustedProxies: trustedProxies}
+
+	tests := []struct {
+		remoteAddr string
+		scheme     string
+		host       string
+	}{
+		{remoteAddr: "10.1.2.3:4567", scheme: "https", host: "public.example.com"},
+		{remoteAddr: "192.168.1.10:4567", scheme: "https", host: "public.example.com"},
+		{remoteAddr: "[::1]:4567", scheme: "https", host: "public.example.com"},
+		{remoteAddr: "192.168.1.11:4567", scheme: "http", host: "internal.example.com"},
+		{remoteAddr: "203.0.113.7:4567", scheme: "http", host: "internal.example.com"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.remoteAddr, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://internal.example.com/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "public.example.com")
+			if got := tOidc.determineScheme(req); got != tc.scheme {
+				t.Errorf("Expected scheme %q, got %q", tc.scheme, got)
+			}
+			if got := tOidc.determineHost(req); got != tc.host {
+				t.Errorf("Expected host %q, got %q", tc.host, got)
+			}
+		})
+	}
+
+	// Without trusted proxies the headers are honored from any peer
+	req := httptest.NewRequest("GET", "http://internal.example.com/", nil)
+	req.Header.Set("X-Forwarded-Host", "public.example.com")
+	if got := (&TraefikOidc{}).determineHost(req); got != "public.example.com" {
+		t.Errorf("Expected forwarded host without trusted proxies, got %q", got)
+	}
+
+	if _, err := parseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Error("Expected an invalid entry to be rejected")
+	}
+}
+
+// TestParseFormLimited verifies that request bodies above the configured limit are rejected
+// while smaller forms are parsed normally.
+func TestParseFormLimited(t *testing.T) {
+	tOidc := &TraefikOidc{maxRequestBodyBytes: 64}
+
+	tests := []struct {
+		name        string
+		body        string
+		expectError bool
+	}{
+		{name: "Small form", body: "logout_token=abc", expectError: false},
+		{name: "Oversized form", body: "logout_token=" + strings.Repeat("a", 100), expectError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/logout", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			err := tOidc.parseFormLimited(httptest.NewRecorder(), req)
+			if tc.expectError && err == nil {
+				t.Error("Expected an error for an oversized body")
+			}
+			if !tc.expectError {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if got := req.PostForm.Get("logout_token"); got != "abc" {
+					t.Errorf("Expected logout_token %q, got %q", "abc", got)
+				}
+			}
+		})
+	}
+}
+
+// TestIsProviderUnavailable verifies which token request failures count as provider outages.
+func TestIsProviderUnavailable(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		unavailable bool
+	}{
+		{name: "Server error", err: &OAuthError{StatusCode: 503}, unavailable: true},
+		{name: "Rate limited", err: fmt.Errorf("failed to refresh token: %w", &OAuthError{StatusCode: 429}), unavailable: true},
+		{name: "Invalid grant", err: newOAuthError(400, []byte(`{"error":"invalid_grant"}`)), unavailable: false},
+		{name: "Network error", err: fmt.Errorf("failed to exchange tokens: %w", &url.Error{Op: "Post", URL: "https://idp", Err: fmt.Errorf("dial tcp: connection refused")}), unavailable: true},
+		{name: "Other error", err: fmt.Errorf("failed to decode token response"), unavailable: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isProviderUnavailable(tc.err); got != tc.unavailable {
+				t.Errorf("Expected unavailable=%v, got %v", tc.unavailable, got)
+			}
+		})
+	}
+
+	if oauthErr := newOAuthError(400, []byte(`{"error":"invalid_grant","error_description":"expired"}`)); oauthErr.Code != "invalid_grant" || oauthErr.Description != "expired" {
+		t.Errorf("Expected parsed error code and description, got %q and %q", oauthErr.Code, oauthErr.Description)
+	}
+}
+
+// TestTokenCacheTTL verifies that cached claims never outlive the token's expiry and respect
+// the configured maximum.
+func TestTokenCacheTTL(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		maxTTL time.Duration
+		min    time.Duration
+		max    time.Duration
+	}{
+		{name: "Until expiry", claims: map[string]interface{}{"exp": float64(now.Add(time.Hour).Unix())}, maxTTL: 0, min: 59 * time.Minute, max: time.Hour},
+		{name: "Capped by maximum", claims: map[string]interface{}{"exp": float64(now.Add(time.Hour).Unix())}, maxTTL: 5 * time.Minute, min: 5 * time.Minute, max: 5 * time.Minute},
+		{name: "Expiry before maximum", claims: map[string]interface{}{"exp": float64(now.Add(2 * time.Minute).Unix())}, maxTTL: 5 * time.Minute, min: time.Minute, max: 2 * time.Minute},
+		{name: "Already expired", claims: map[string]interface{}{"exp": float64(now.Add(-time.Minute).Unix())}, maxTTL: 5 * time.Minute, min: 0, max: 0},
+		{name: "No expiry", claims: map[string]interface{}{"sub": "user"}, maxTTL: 5 * time.Minute, min: 0, max: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ttl := tokenCacheTTL(tc.claims, tc.maxTTL)
+			if ttl < tc.min || ttl > tc.max {
+				t.Errorf("Expected TTL between %v and %v, got %v", tc.min, tc.max, ttl)
+			}
+		})
+	}
+}
+
+func TestLimitedTransport(t *testing.T) {
+	release := make(chan struct{})
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		for {
+			seen := atomic.LoadInt32(&maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&inFlight, -1)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newLimitedTransport(nil, 2)}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Errorf("Request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&inFlight); got != 2 {
+		t.Errorf("Expected 2 requests in flight while the others queue, got %d", got)
+	}
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&maxInFlight); got != 2 {
+		t.Errorf("Expected at most 2 concurrent requests, got %d", got)
+	}
+
+	// A queued request gives up when its context is done
+	limited := newLimitedTransport(nil, 1)
+	limited.slots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := limited.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the queued request to time out with its context, got %v", err)
+	}
+}
+
+func TestResolveClaimPath(t *testing.T) {
+	claims := map[string]interface{}{
+		"realm_access": map[string]interface{}{"roles": []interface{}{"offline_access", "admin"}},
+		"resource_access": map[string]interface{}{
+			"my-client": map[string]interface{}{"roles": []interface{}{"editor"}},
+		},
+		"memberships": []interface{}{
+			map[string]interface{}{"group": "engineering"},
+			map[string]interface{}{"group": "oncall", "level": float64(2)},
+		},
+		"tier":            "gold",
+		"verified":        true,
+		"flat.dotted.key": []interface{}{"literal"},
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"realm_access.roles", []string{"offline_access", "admin"}},
+		{"resource_access.my-client.roles", []string{"editor"}},
+		{"memberships.group", []string{"engineering", "oncall"}},
+		{"memberships.level", []string{"2"}},
+		{"tier", []string{"gold"}},
+		{"verified", []string{"true"}},
+		{"flat.dotted.key", []string{"literal"}},
+		{"resource_access.other-client.roles", nil},
+		{"tier.name", nil},
+		{"realm_access", nil},
+	}
+	for _, tc := range tests {
+		if got := resolveClaimPath(claims, tc.path); !stringSliceEqual(got, tc.want) {
+			t.Errorf("resolveClaimPath(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	ts.tOidc.roleClaimPaths = []string{"realm_access.roles", "resource_access.my-client.roles"}
+	token, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+		"roles":           []interface{}{"user"},
+		"realm_access":    claims["realm_access"],
+		"resource_access": claims["resource_access"],
+	})
+	_, roles, err := ts.tOidc.extractGroupsAndRoles(token)
+	if err != nil {
+		t.Fatalf("extractGroupsAndRoles failed: %v", err)
+	}
+	if want := []string{"user", "offline_access", "admin", "editor"}; !stringSliceEqual(roles, want) {
+		t.Errorf("Expected roles %v, got %v", want, roles)
 	}
 }
@@ -0,0 +1,41 @@
+package traefikoidc
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// trackSessionActivity records the request as activity of the session, restarting its idle
+// timeout, and, when sessionExpiresHeader is configured, tells the client in that response
+// header how many seconds remain before the session expires: the earlier of the idle and the
+// absolute timeout. Frontends can use it to warn users before they are logged out; any
+// request to a protected route extends an idle session.
+//
+// Parameters:
+//   - rw: The response writer receiving the session cookie and the header.
+//   - req: The authorized request.
+//   - session: The user's session data.
+func (t *TraefikOidc) trackSessionActivity(rw http.ResponseWriter, req *http.Request, session *SessionData) {
+	if session.MarkActive() {
+		if err := session.SaveMain(req, rw); err != nil {
+			t.logger.Errorf("Failed to save session activity: %v", err)
+		}
+	}
+	if t.sessionExpiresHeader == "" {
+		return
+	}
+
+	expiresAt := session.ExpiresAt()
+	if idleExpiresAt := session.IdleExpiresAt(); !idleExpiresAt.IsZero() && (expiresAt.IsZero() || idleExpiresAt.Before(expiresAt)) {
+		expiresAt = idleExpiresAt
+	}
+	if expiresAt.IsZero() {
+		return
+	}
+	remaining := time.Until(expiresAt) / time.Second
+	if remaining < 0 {
+		remaining = 0
+	}
+	rw.Header().Set(t.sessionExpiresHeader, strconv.FormatInt(int64(remaining), 10))
+}
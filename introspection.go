@@ -0,0 +1,139 @@
+package traefikoidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRetryInterval is how often local verification retries fetching the JWKS while tokens
+// are verified through introspection.
+const jwksRetryInterval = 30 * time.Second
+
+// maxIntrospectionBytes limits the size of introspection responses read from the provider.
+const maxIntrospectionBytes = 1 << 20
+
+// introspectionFallback tracks whether tokens are verified through the provider's
+// introspection endpoint because the JWKS could not be fetched.
+type introspectionFallback struct {
+	mutex       sync.Mutex
+	active      bool
+	lastAttempt time.Time
+}
+
+// shouldTryJWKS reports whether the JWKS should be used for the next verification: always
+// outside fallback mode, and at most once per jwksRetryInterval while in it.
+//
+// Returns:
+//   - true if the JWKS should be fetched.
+func (f *introspectionFallback) shouldTryJWKS() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.active || time.Since(f.lastAttempt) >= jwksRetryInterval {
+		f.lastAttempt = time.Now()
+		return true
+	}
+	return false
+}
+
+// setActive switches fallback mode on or off.
+//
+// Parameters:
+//   - active: true while the JWKS cannot be fetched.
+//
+// Returns:
+//   - true if the mode changed.
+func (f *introspectionFallback) setActive(active bool) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	changed := f.active != active
+	f.active = active
+	return changed
+}
+
+// getJWKSOrFallback fetches the JWKS for local signature verification. With
+// introspectionFallback enabled and an introspection endpoint known, a failed fetch switches
+// to introspection mode; while in it, the JWKS is only retried every jwksRetryInterval, and a
+// successful retry switches back to local verification.
+//
+// Returns:
+//   - The JWKS, or nil if the token must be verified through introspection instead.
+//   - An error if the JWKS cannot be fetched and no fallback is possible.
+func (t *TraefikOidc) getJWKSOrFallback() (*JWKSet, error) {
+	fallback := t.introspectionFallback
+	if fallback == nil || t.introspectionURL == "" {
+		jwks, err := t.jwkCache.GetJWKS(context.Background(), t.jwksURL, t.httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get JWKS: %w", err)
+		}
+		return jwks, nil
+	}
+
+	if !fallback.shouldTryJWKS() {
+		return nil, nil
+	}
+	jwks, err := t.jwkCache.GetJWKS(context.Background(), t.jwksURL, t.httpClient)
+	if err != nil {
+		if fallback.setActive(true) {
+			t.logger.Errorf("JWKS unavailable (%v), falling back to token introspection at %s", err, t.introspectionURL)
+		}
+		return nil, nil
+	}
+	if fallback.setActive(false) {
+		t.logger.Infof("JWKS available again, switching back to local signature verification")
+	}
+	return jwks, nil
+}
+
+// introspectToken asks the provider's introspection endpoint (RFC 7662) whether a token is
+// active, in place of the local signature check while the JWKS is unavailable.
+//
+// Parameters:
+//   - ctx: Cancels the request, e.g. when the client disconnects.
+//   - token: The raw token to introspect.
+//
+// Returns:
+//   - nil if the provider reports the token as active.
+//   - An error if the request fails or the token is not active.
+func (t *TraefikOidc) introspectToken(ctx context.Context, token string) error {
+	// The session token is the ID token. id_token is not a registered RFC 7662 hint, but a
+	// server that does not know it must still search all of its token types
+	data := url.Values{
+		"token":           {token},
+		"token_type_hint": {"id_token"},
+		"client_id":       {t.clientID},
+		"client_secret":   {t.clientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.introspectionURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create introspection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send introspection request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("introspection endpoint returned status %d", resp.StatusCode)
+	}
+	var result struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxIntrospectionBytes)).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+	if !result.Active {
+		return fmt.Errorf("token is not active according to the introspection endpoint")
+	}
+	return nil
+}
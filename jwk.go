@@ -35,9 +35,13 @@ type JWKSet struct {
 type JWKCache struct {
 	jwks      *JWKSet
 	expiresAt time.Time
-	mutex     sync.RWMutex
+	// refreshedAt is when RefreshJWKS last fetched the JWKS.
+	refreshedAt time.Time
+	mutex       sync.RWMutex
 	// CacheLifetime is configurable to determine how long the JWKS is cached.
 	CacheLifetime time.Duration
+	// MinRefreshInterval limits how often RefreshJWKS fetches the JWKS ahead of its expiry.
+	MinRefreshInterval time.Duration
 }
 
 type JWKCacheInterface interface {
@@ -45,6 +49,15 @@ type JWKCacheInterface interface {
 	Cleanup()
 }
 
+// JWKSRefresher is implemented by JWK caches that can fetch the JWKS again before the cached
+// set expires, so that tokens signed with a newly rotated key can be verified.
+type JWKSRefresher interface {
+	RefreshJWKS(ctx context.Context, jwksURL string, httpClient *http.Client) (*JWKSet, error)
+}
+
+// DefaultJWKSMinRefreshInterval is the default minimum time between forced JWKS refetches.
+const DefaultJWKSMinRefreshInterval = 30 * time.Second
+
 // GetJWKS retrieves the JSON Web Key Set (JWKS) from the cache or fetches it from the provider.
 // It first checks if a valid, non-expired JWKS is present in the cache. If so, it returns the cached version.
 // Otherwise, it attempts to fetch the JWKS from the specified jwksURL using the provided httpClient.
@@ -79,14 +92,72 @@ func (c *JWKCache) GetJWKS(ctx context.Context, jwksURL string, httpClient *http
 		return nil, err
 	}
 
+	c.store(jwks)
+	return jwks, nil
+}
+
+// RefreshJWKS fetches the JWKS from the provider even though the cached set has not expired,
+// for tokens whose key ID is missing from the cached set after a key rotation. To avoid a
+// stampede of fetches when many such tokens arrive, it fetches at most once per
+// MinRefreshInterval (DefaultJWKSMinRefreshInterval if unset) and otherwise returns the
+// cached set.
+//
+// Parameters:
+//   - ctx: Context for the HTTP request.
+//   - jwksURL: The URL of the OIDC provider's JWKS endpoint.
+//   - httpClient: The HTTP client to use for fetching the JWKS.
+//
+// Returns:
+//   - A pointer to the refreshed (or recently fetched) JWKSet.
+//   - An error if fetching fails or the response cannot be decoded.
+func (c *JWKCache) RefreshJWKS(ctx context.Context, jwksURL string, httpClient *http.Client) (*JWKSet, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	interval := c.MinRefreshInterval
+	if interval == 0 {
+		interval = DefaultJWKSMinRefreshInterval
+	}
+	if c.jwks != nil && time.Since(c.refreshedAt) < interval {
+		return c.jwks, nil
+	}
+
+	c.refreshedAt = time.Now()
+	jwks, err := fetchJWKS(ctx, jwksURL, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	c.store(jwks)
+	return jwks, nil
+}
+
+// store caches a freshly fetched JWKS for CacheLifetime (1 hour if unset).
+// Note: This function assumes the write lock is already held.
+func (c *JWKCache) store(jwks *JWKSet) {
 	c.jwks = jwks
 	lifetime := c.CacheLifetime
 	if lifetime == 0 {
 		lifetime = 1 * time.Hour
 	}
 	c.expiresAt = time.Now().Add(lifetime)
+}
 
-	return jwks, nil
+// findJWK returns the key with the given key ID from a key set.
+//
+// Parameters:
+//   - jwks: The key set to search.
+//   - kid: The key ID from the token header.
+//
+// Returns:
+//   - The matching key, or nil if the set has no key with that ID.
+func findJWK(jwks *JWKSet, kid string) *JWK {
+	for i := range jwks.Keys {
+		if jwks.Keys[i].Kid == kid {
+			return &jwks.Keys[i]
+		}
+	}
+	return nil
 }
 
 // Cleanup removes the cached JWKS if it has expired.
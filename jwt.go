@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -99,6 +100,17 @@ func parseJWT(tokenString string) (*JWT, error) {
 	return jwt, nil
 }
 
+// supportedSigningAlgorithms lists the JWS algorithms verifySignature can check.
+// "none" is deliberately absent.
+var supportedSigningAlgorithms = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+}
+
+// defaultAllowedSigningAlgorithms is the signing algorithm allowlist used when none is configured.
+var defaultAllowedSigningAlgorithms = []string{"RS256", "ES256"}
+
 // Verify performs standard claim validation on the JWT according to RFC 7519.
 // It checks the following:
 // - Algorithm ('alg') is supported.
@@ -118,17 +130,27 @@ func parseJWT(tokenString string) (*JWT, error) {
 //   - nil if all standard claims are valid.
 //   - An error describing the first validation failure encountered.
 func (j *JWT) Verify(issuerURL, clientID string) error {
+	return j.VerifyIssuers([]string{issuerURL}, clientID)
+}
+
+// VerifyIssuers performs the same claim validation as Verify, but accepts a token whose
+// 'iss' claim matches any of the given issuers, for providers that issue tokens from
+// region-specific issuers or while migrating to a new issuer URL.
+//
+// Parameters:
+//   - issuers: The acceptable issuer URLs.
+//   - clientID: The expected audience value (the client ID of this application).
+//
+// Returns:
+//   - nil if all standard claims are valid.
+//   - An error describing the first validation failure encountered.
+func (j *JWT) VerifyIssuers(issuers []string, clientID string) error {
 	// Validate algorithm to prevent algorithm switching attacks
 	alg, ok := j.Header["alg"].(string)
 	if !ok {
 		return fmt.Errorf("missing 'alg' header")
 	}
-	supportedAlgs := map[string]bool{
-		"RS256": true, "RS384": true, "RS512": true,
-		"PS256": true, "PS384": true, "PS512": true,
-		"ES256": true, "ES384": true, "ES512": true,
-	}
-	if !supportedAlgs[alg] {
+	if !supportedSigningAlgorithms[alg] {
 		return fmt.Errorf("unsupported algorithm: %s", alg)
 	}
 
@@ -138,7 +160,7 @@ func (j *JWT) Verify(issuerURL, clientID string) error {
 	if !ok {
 		return fmt.Errorf("missing 'iss' claim")
 	}
-	if err := verifyIssuer(iss, issuerURL); err != nil {
+	if err := verifyIssuer(iss, issuers...); err != nil {
 		return err
 	}
 
@@ -239,20 +261,22 @@ func verifyAudience(tokenAudience interface{}, expectedAudience string) error {
 	return nil
 }
 
-// verifyIssuer checks if the token's 'iss' claim matches the expected issuer URL.
+// verifyIssuer checks if the token's 'iss' claim matches one of the expected issuer URLs.
 //
 // Parameters:
 //   - tokenIssuer: The 'iss' claim value from the token.
-//   - expectedIssuer: The expected issuer URL configured for the OIDC provider.
+//   - expectedIssuers: The acceptable issuer URLs configured for the OIDC provider.
 //
 // Returns:
-//   - nil if the issuers match.
-//   - An error if the issuers do not match.
-func verifyIssuer(tokenIssuer, expectedIssuer string) error {
-	if tokenIssuer != expectedIssuer {
-		return fmt.Errorf("invalid issuer (token: %s, expected: %s)", tokenIssuer, expectedIssuer)
+//   - nil if the token's issuer is one of the expected issuers.
+//   - An error if it matches none of them.
+func verifyIssuer(tokenIssuer string, expectedIssuers ...string) error {
+	for _, expected := range expectedIssuers {
+		if tokenIssuer == expected {
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("invalid issuer (token: %s, expected: %s)", tokenIssuer, strings.Join(expectedIssuers, ", "))
 }
 
 // verifyTimeConstraint checks time-based claims ('exp', 'iat', 'nbf') against the current time,
@@ -387,3 +411,37 @@ func verifySignature(tokenString string, publicKeyPEM []byte, alg string) error
 		return fmt.Errorf("unsupported public key type: %T", pubKey)
 	}
 }
+
+// verifyAccessTokenHash checks an ID token's at_hash claim against the access token returned
+// with it, as OpenID Connect Core 3.2.2.9 requires for the implicit flow: at_hash is the
+// base64url encoded left half of the access token's hash, using the hash of the ID token's
+// signing algorithm.
+//
+// Parameters:
+//   - accessToken: The access token returned next to the ID token.
+//   - atHash: The ID token's at_hash claim.
+//   - alg: The ID token's signing algorithm.
+//
+// Returns:
+//   - An error if the algorithm is unsupported or the hash does not match.
+func verifyAccessTokenHash(accessToken, atHash, alg string) error {
+	var hashFunc crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hashFunc = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hashFunc = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hashFunc = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm for at_hash: %s", alg)
+	}
+	h := hashFunc.New()
+	h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+	expected := base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(atHash)) != 1 {
+		return fmt.Errorf("at_hash does not match the access token")
+	}
+	return nil
+}
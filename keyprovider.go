@@ -0,0 +1,196 @@
+package traefikoidc
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// DefaultKeyRefreshInterval is how often a session key provider is consulted for rotated keys.
+const DefaultKeyRefreshInterval = time.Minute
+
+// FileKeyProvider returns a session key provider that reads the keys from a file, one key per
+// line, newest first. Blank lines and surrounding whitespace are ignored. The file is read on
+// every call, so a rotated Kubernetes secret mounted as a file is picked up without a restart.
+//
+// Parameters:
+//   - path: The path of the key file.
+//   - logger: Logger for read failures.
+//
+// Returns:
+//   - The key provider. It returns nil if the file cannot be read, which keeps the current keys.
+func FileKeyProvider(path string, logger *Logger) func() [][]byte {
+	return func() [][]byte {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.Errorf("Failed to read session key file %s: %v", path, err)
+			return nil
+		}
+		var keys [][]byte
+		for _, line := range strings.Split(string(data), "\n") {
+			if key := strings.TrimSpace(line); key != "" {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return keys
+	}
+}
+
+// SetKeyProvider makes the manager take its session keys from provider instead of the fixed
+// key it was created with, and pick up rotated keys without a restart. The provider returns
+// the keys newest first: the first key signs new cookies and server-side stored tokens, and
+// every key is accepted when reading them, so sessions written with a previous key stay valid
+// for as long as that key is still returned. The provider is consulted at most once per
+// interval; when the keys change, the cookie store is rebuilt with the new key set.
+//
+// Parameters:
+//   - provider: Returns the current session keys, newest first.
+//   - interval: How often to check for rotated keys; 0 uses DefaultKeyRefreshInterval.
+//
+// Returns:
+//   - An error if the provider does not return a usable initial key set.
+func (sm *SessionManager) SetKeyProvider(provider func() [][]byte, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultKeyRefreshInterval
+	}
+	if err := sm.applyKeys(provider()); err != nil {
+		return err
+	}
+
+	sm.storeMutex.Lock()
+	defer sm.storeMutex.Unlock()
+	sm.keyProvider = provider
+	sm.keyRefreshInterval = interval
+	sm.keysCheckedAt = time.Now()
+	return nil
+}
+
+// refreshKeys consults the key provider, if one is set and the refresh interval has passed,
+// and switches to the returned keys if they changed. An unusable key set is logged and the
+// current keys are kept.
+func (sm *SessionManager) refreshKeys() {
+	sm.storeMutex.RLock()
+	due := sm.keyProvider != nil && time.Since(sm.keysCheckedAt) >= sm.keyRefreshInterval
+	sm.storeMutex.RUnlock()
+	if !due {
+		return
+	}
+
+	sm.storeMutex.Lock()
+	if time.Since(sm.keysCheckedAt) < sm.keyRefreshInterval {
+		sm.storeMutex.Unlock()
+		return
+	}
+	sm.keysCheckedAt = time.Now()
+	provider := sm.keyProvider
+	sm.storeMutex.Unlock()
+
+	if err := sm.applyKeys(provider()); err != nil {
+		sm.logger.Errorf("Ignoring rotated session keys: %v", err)
+	}
+}
+
+// applyKeys switches the manager to the given session keys, rebuilding the cookie store and
+// the stored token codecs. Nothing changes if the keys are the ones already in use.
+//
+// Parameters:
+//   - keys: The session keys, newest first.
+//
+// Returns:
+//   - An error if no key is given or a key is too short.
+func (sm *SessionManager) applyKeys(keys [][]byte) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no session keys provided")
+	}
+	for i, key := range keys {
+		if len(key) < minEncryptionKeyLength {
+			return fmt.Errorf("session key %d must be at least %d bytes long", i+1, minEncryptionKeyLength)
+		}
+	}
+
+	sm.storeMutex.Lock()
+	defer sm.storeMutex.Unlock()
+	if keysEqual(sm.keys, keys) {
+		return nil
+	}
+
+	if current, ok := sm.store.(*sessions.CookieStore); ok {
+		pairs := make([][]byte, 0, 2*len(keys))
+		for _, key := range keys {
+			pairs = append(pairs, key, nil)
+		}
+		store := sessions.NewCookieStore(pairs...)
+		if current.Options != nil {
+			options := *current.Options
+			store.Options = &options
+		}
+		if sm.serializer != nil {
+			for _, codec := range store.Codecs {
+				if sc, ok := codec.(*securecookie.SecureCookie); ok {
+					sc.SetSerializer(sm.serializer)
+				}
+			}
+		}
+		sm.store = store
+	}
+	sm.tokenCodecs = newTokenCodecs(keys)
+	if sm.keys != nil {
+		sm.logger.Infof("Session keys rotated (%d keys in use)", len(keys))
+	}
+	sm.keys = keys
+	return nil
+}
+
+// getStore returns the session store in use.
+func (sm *SessionManager) getStore() sessions.Store {
+	sm.storeMutex.RLock()
+	defer sm.storeMutex.RUnlock()
+	return sm.store
+}
+
+// getTokenCodecs returns the codecs for server-side stored tokens, newest key first.
+func (sm *SessionManager) getTokenCodecs() []securecookie.Codec {
+	sm.storeMutex.RLock()
+	defer sm.storeMutex.RUnlock()
+	return sm.tokenCodecs
+}
+
+// newTokenCodecs creates the codecs for server-side stored tokens from the session keys.
+//
+// Parameters:
+//   - keys: The session keys, newest first.
+//
+// Returns:
+//   - One codec per key.
+func newTokenCodecs(keys [][]byte) []securecookie.Codec {
+	pairs := make([][]byte, 0, 2*len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key, nil)
+	}
+	codecs := securecookie.CodecsFromPairs(pairs...)
+	for _, codec := range codecs {
+		// Stored tokens are not bound by cookie size limits.
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxLength(0)
+		}
+	}
+	return codecs
+}
+
+// keysEqual reports whether two key sets hold the same keys in the same order.
+func keysEqual(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
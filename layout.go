@@ -0,0 +1,327 @@
+package traefikoidc
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// packedCookieName is the cookie holding the whole session with the single-cookie layout.
+const packedCookieName = "_oidc_raczylo_s"
+
+// packedLayoutVersion is the first byte of a packed session, identifying its field layout.
+// Version 2 added the access token expiry and version 3 the provider access token; older
+// sessions remain readable.
+const packedLayoutVersion = 3
+
+// packedFieldCount maps each readable packed layout version to its number of fields.
+var packedFieldCount = map[byte]int{1: 3, 2: 4, 3: 5}
+
+// SetSessionLayout selects how sessions are laid out in cookies. With SessionLayoutSplit (the
+// default) the main session, the access token and the refresh token each get their own cookie,
+// chunked as needed. With SessionLayoutSingle the main session values and both tokens are
+// packed into a single cookie using a compact length-prefixed binary layout, with the tokens
+// kept as raw compressed bytes instead of base64; sessions that do not fit within
+// maxCookieSize fall back to the split layout. Sessions written with either layout stay
+// readable after switching. The setting only applies to the built-in cookie store.
+//
+// Parameters:
+//   - layout: SessionLayoutSplit, SessionLayoutSingle, or "" for the default.
+//
+// Returns:
+//   - An error if the layout is unknown.
+func (sm *SessionManager) SetSessionLayout(layout string) error {
+	switch layout {
+	case "", SessionLayoutSplit:
+		sm.singleCookie = false
+	case SessionLayoutSingle:
+		sm.singleCookie = true
+	default:
+		return fmt.Errorf("unknown session layout: %s", layout)
+	}
+	return nil
+}
+
+// packSession encodes the main session values and the tokens into the packed layout:
+// a version byte followed by five length-prefixed fields (main values serialized with the
+// cookie encoding, the access and refresh tokens, the access token expiry, then the provider
+// access token). A token field
+// is empty when there is no token, otherwise it holds the compression codec marker followed by
+// the compressed token. The expiry field is empty when none is recorded, otherwise it holds the
+// expiry in Unix seconds as a uvarint.
+//
+// Returns:
+//   - The packed session.
+//   - false if the session cannot be packed: the store is not cookie based, a token is
+//     chunked or stored uncompressed, or the packed session exceeds maxCookieSize.
+func (sd *SessionData) packSession() ([]byte, bool) {
+	if _, ok := sd.manager.getStore().(*sessions.CookieStore); !ok {
+		return nil, false
+	}
+	if len(sd.accessTokenChunks) > 0 || len(sd.refreshTokenChunks) > 0 || len(sd.providerTokenChunks) > 0 || len(sd.claimsChunks) > 0 {
+		return nil, false
+	}
+
+	sd.manager.storeMutex.RLock()
+	serializer := sd.manager.serializer
+	sd.manager.storeMutex.RUnlock()
+	if serializer == nil {
+		serializer = securecookie.GobEncoder{}
+	}
+	main, err := serializer.Serialize(sd.mainSession.Values)
+	if err != nil {
+		sd.manager.logger.Errorf("Failed to serialize session for the single-cookie layout: %v", err)
+		return nil, false
+	}
+	access, ok := packToken(sd.accessSession)
+	if !ok {
+		return nil, false
+	}
+	refresh, ok := packToken(sd.refreshSession)
+	if !ok {
+		return nil, false
+	}
+	provider, ok := packToken(sd.providerSession)
+	if !ok {
+		return nil, false
+	}
+
+	var expiry []byte
+	if expiresAt := sd.GetAccessTokenExpiry(); !expiresAt.IsZero() {
+		expiry = binary.AppendUvarint(nil, uint64(expiresAt.Unix()))
+	}
+
+	packed := make([]byte, 0, 1+5*binary.MaxVarintLen32+len(main)+len(access)+len(refresh)+len(expiry)+len(provider))
+	packed = append(packed, packedLayoutVersion)
+	for _, field := range [][]byte{main, access, refresh, expiry, provider} {
+		packed = binary.AppendUvarint(packed, uint64(len(field)))
+		packed = append(packed, field...)
+	}
+	if len(packed) > maxCookieSize {
+		return nil, false
+	}
+	return packed, true
+}
+
+// packToken returns the packed field of the token held in a primary token session.
+//
+// Parameters:
+//   - session: The primary access, refresh or provider access token session.
+//
+// Returns:
+//   - The codec marker followed by the compressed token, or nil if there is no token.
+//   - false if the token is chunked or not stored compressed.
+func packToken(session *sessions.Session) ([]byte, bool) {
+	token, _ := session.Values["token"].(string)
+	if token == "" {
+		return nil, chunkCount(session) < 0
+	}
+	if compressed, _ := session.Values["compressed"].(bool); !compressed {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, false
+	}
+	codecID, ok := session.Values["codec"].(byte)
+	if !ok {
+		id, _ := sessionInt(session.Values["codec"])
+		codecID = byte(id)
+	}
+	return append([]byte{codecID}, data...), true
+}
+
+// unpackSession restores a session written with the single-cookie layout into the main
+// and token sessions.
+//
+// Parameters:
+//   - packed: The packed session.
+//
+// Returns:
+//   - An error if the packed session is malformed.
+func (sd *SessionData) unpackSession(packed []byte) error {
+	if len(packed) == 0 || packedFieldCount[packed[0]] == 0 {
+		return errors.New("unknown packed session layout")
+	}
+	fields := make([][]byte, packedFieldCount[packed[0]])
+	packed = packed[1:]
+	for i := range fields {
+		length, n := binary.Uvarint(packed)
+		if n <= 0 || uint64(len(packed)-n) < length {
+			return errors.New("truncated packed session")
+		}
+		fields[i] = packed[n : n+int(length)]
+		packed = packed[n+int(length):]
+	}
+
+	sd.manager.storeMutex.RLock()
+	serializer := sd.manager.serializer
+	sd.manager.storeMutex.RUnlock()
+	if serializer == nil {
+		serializer = securecookie.GobEncoder{}
+	}
+	values := make(map[interface{}]interface{})
+	if err := serializer.Deserialize(fields[0], &values); err != nil {
+		return fmt.Errorf("failed to deserialize packed session: %w", err)
+	}
+
+	sd.mainSession.Values = values
+	unpackToken(sd.accessSession, fields[1])
+	unpackToken(sd.refreshSession, fields[2])
+	if len(fields) > 3 && len(fields[3]) > 0 {
+		expiresAt, n := binary.Uvarint(fields[3])
+		if n <= 0 {
+			return errors.New("malformed access token expiry in packed session")
+		}
+		sd.accessSession.Values["expires_at"] = int64(expiresAt)
+	}
+	var provider []byte
+	if len(fields) > 4 {
+		provider = fields[4]
+	}
+	unpackToken(sd.providerSession, provider)
+	return nil
+}
+
+// unpackToken stores a packed token field in a primary token session.
+//
+// Parameters:
+//   - session: The primary access, refresh or provider access token session.
+//   - field: The codec marker followed by the compressed token, or empty for no token.
+func unpackToken(session *sessions.Session, field []byte) {
+	session.Values = make(map[interface{}]interface{})
+	if len(field) == 0 {
+		return
+	}
+	session.Values["codec"] = field[0]
+	session.Values["token"] = base64.StdEncoding.EncodeToString(field[1:])
+	session.Values["compressed"] = true
+}
+
+// loadPackedSession reads the single-cookie session, if the request carries one, in place of
+// the split session cookies. Like the split cookies it is decoded once per request, so later
+// loads of the same request see the changes made to the session since. An undecodable
+// cookie is logged and ignored.
+//
+// Parameters:
+//   - r: The incoming HTTP request.
+func (sd *SessionData) loadPackedSession(r *http.Request) {
+	session, err := sessions.GetRegistry(r).Get(packedStore{sd.manager}, packedCookieName)
+	if err != nil {
+		sd.manager.logger.Infof("Discarding undecodable session cookie %s: %v", packedCookieName, err)
+		return
+	}
+	if session.IsNew {
+		return
+	}
+	if unpacked, _ := session.Values["unpacked"].(bool); !unpacked {
+		packed, _ := session.Values["packed"].([]byte)
+		if err := sd.unpackSession(packed); err != nil {
+			sd.manager.logger.Infof("Discarding session cookie %s: %v", packedCookieName, err)
+			session.IsNew = true
+			return
+		}
+		session.Values["unpacked"] = true
+	}
+	sd.packedLoaded = true
+}
+
+// packedStore decodes the single cookie of the single-cookie layout for the per-request
+// session registry. The cookie is written by SessionData.Save, never through the store.
+type packedStore struct {
+	manager *SessionManager
+}
+
+// Get returns the packed session cached for the request, decoding it on first use.
+func (s packedStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New decodes the packed session from the request cookie into the "packed" value. The
+// session is new if the request carries no such cookie.
+func (s packedStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.IsNew = true
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var packed []byte
+	if err := securecookie.DecodeMulti(name, cookie.Value, &packed, s.manager.getTokenCodecs()...); err != nil {
+		return session, err
+	}
+	session.Values["packed"] = packed
+	session.IsNew = false
+	return session, nil
+}
+
+// Save is not supported; packed sessions are written by SessionData.Save.
+func (s packedStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	return errors.New("packed sessions are saved with SessionData.Save")
+}
+
+// encodePackedCookie encodes the session as the value of the single cookie.
+//
+// Returns:
+//   - The encoded cookie value.
+//   - false if the session cannot be packed.
+//   - An error if the packed session cannot be encoded.
+func (sd *SessionData) encodePackedCookie() (string, bool, error) {
+	packed, ok := sd.packSession()
+	if !ok {
+		return "", false, nil
+	}
+	encoded, err := securecookie.EncodeMulti(packedCookieName, packed, sd.manager.getTokenCodecs()...)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to encode packed session: %w", err)
+	}
+	return encoded, true, nil
+}
+
+// savePacked writes the session as a single cookie and expires the split session cookies
+// and chunks the client still holds.
+//
+// Parameters:
+//   - r: The HTTP request (required by the underlying session store).
+//   - w: The HTTP response writer to which the Set-Cookie headers will be added.
+//   - encoded: The encoded single cookie value.
+//   - options: The cookie options of the single cookie.
+//
+// Returns:
+//   - An error if expiring leftover chunk cookies fails.
+func (sd *SessionData) savePacked(r *http.Request, w http.ResponseWriter, encoded string, options *sessions.Options) error {
+	http.SetCookie(w, sessions.NewCookie(packedCookieName, encoded, options))
+
+	expiredOptions := *options
+	expiredOptions.MaxAge = -1
+	for _, session := range []*sessions.Session{sd.mainSession, sd.accessSession, sd.refreshSession, sd.providerSession} {
+		if !session.IsNew {
+			http.SetCookie(w, sessions.NewCookie(session.Name(), "", &expiredOptions))
+			session.IsNew = true
+		}
+	}
+	if err := sd.saveExpiredChunks(r, w, options); err != nil {
+		return err
+	}
+
+	sd.mainDirty, sd.accessDirty, sd.refreshDirty, sd.providerDirty = false, false, false, false
+	sd.packedLoaded = true
+	return nil
+}
+
+// expirePackedCookie expires the single cookie after the session moved to the split layout.
+//
+// Parameters:
+//   - w: The HTTP response writer to which the Set-Cookie header will be added.
+//   - options: The cookie options of the split session cookies.
+func (sd *SessionData) expirePackedCookie(w http.ResponseWriter, options *sessions.Options) {
+	expiredOptions := *options
+	expiredOptions.MaxAge = -1
+	http.SetCookie(w, sessions.NewCookie(packedCookieName, "", &expiredOptions))
+	sd.packedLoaded = false
+}
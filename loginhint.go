@@ -0,0 +1,68 @@
+package traefikoidc
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// maxLoginHintTokenLength bounds the login_hint_token forwarded to the authorization endpoint,
+// keeping the authorization URL within the limits browsers and providers accept.
+const maxLoginHintTokenLength = 4096
+
+// loginHintToken returns the login_hint_token to send with a new authorization request, taken
+// from the configured request header or from a claim of the session's previous ID token. A
+// hint that is missing or longer than maxLoginHintTokenLength is not sent.
+//
+// Parameters:
+//   - req: The request that triggered the login.
+//   - session: The user's session, read before it is cleared for the new login.
+//
+// Returns:
+//   - The login_hint_token, or an empty string if none applies.
+func (t *TraefikOidc) loginHintToken(req *http.Request, session *SessionData) string {
+	var hint string
+	switch {
+	case t.loginHintTokenHeader != "":
+		hint = req.Header.Get(t.loginHintTokenHeader)
+	case t.loginHintTokenClaim != "":
+		token := session.GetAccessToken()
+		if token == "" {
+			return ""
+		}
+		// The token comes from the encrypted session cookie and was verified when stored; it
+		// may have expired since, which does not matter for a hint
+		jwt, err := parseJWT(token)
+		if err != nil {
+			return ""
+		}
+		if values := resolveClaimPath(jwt.Claims, t.loginHintTokenClaim); len(values) == 1 {
+			hint = values[0]
+		}
+	}
+	if len(hint) > maxLoginHintTokenLength {
+		t.logger.Infof("Not sending a login_hint_token of %d bytes, limit is %d", len(hint), maxLoginHintTokenLength)
+		return ""
+	}
+	return hint
+}
+
+// setLoginHintToken adds the login_hint_token to an authorization URL. Per the specification
+// it is never sent together with login_hint, so any login_hint is removed.
+//
+// Parameters:
+//   - authURL: The authorization URL.
+//   - hint: The login_hint_token.
+//
+// Returns:
+//   - The authorization URL with the login_hint_token.
+func setLoginHintToken(authURL, hint string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return authURL
+	}
+	query := u.Query()
+	query.Del("login_hint")
+	query.Set("login_hint_token", hint)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -3,22 +3,35 @@ package traefikoidc
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	htmltemplate "html/template"
 	"io"
 	"math"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
-	"github.com/google/uuid"
 	"golang.org/x/time/rate"
 )
 
+// DefaultProviderDialTimeout is the default time allowed for connecting to the provider.
+const DefaultProviderDialTimeout = 15 * time.Second
+
+// DefaultProviderTLSHandshakeTimeout is the default time allowed for the TLS handshake with
+// the provider.
+const DefaultProviderTLSHandshakeTimeout = 5 * time.Second
+
 // createDefaultHTTPClient creates a new http.Client with settings optimized for OIDC communication.
 // It configures the transport with specific timeouts (dial, keepalive, TLS handshake, idle connection),
 // connection limits (max idle, max per host), enables HTTP/2, and sets a default request timeout.
@@ -28,16 +41,8 @@ import (
 //   - A pointer to the configured http.Client.
 func createDefaultHTTPClient() *http.Client {
 	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			dialer := &net.Dialer{
-				Timeout:   15 * time.Second, // Reduced timeout
-				KeepAlive: 15 * time.Second, // Reduced keepalive
-			}
-			return dialer.DialContext(ctx, network, addr)
-		},
+		Proxy:                 http.ProxyFromEnvironment,
 		ForceAttemptHTTP2:     true,
-		TLSHandshakeTimeout:   5 * time.Second, // Reduced from 10s
 		ExpectContinueTimeout: 0,
 		MaxIdleConns:          30,               // Reduced from 100
 		MaxIdleConnsPerHost:   10,               // Reduced from 100
@@ -45,6 +50,7 @@ func createDefaultHTTPClient() *http.Client {
 		DisableKeepAlives:     false,            // Enable connection reuse
 		MaxConnsPerHost:       50,               // Limit max connections
 	}
+	setProviderTimeouts(transport, DefaultProviderDialTimeout, DefaultProviderTLSHandshakeTimeout)
 
 	return &http.Client{
 		Timeout:   time.Second * 15, // Reduced timeout
@@ -59,25 +65,86 @@ func createDefaultHTTPClient() *http.Client {
 	}
 }
 
+// setProviderTimeouts sets the connect and TLS handshake timeouts of a provider transport.
+// They bound each phase separately from the client's overall request timeout, so that an
+// unreachable provider address fails on dial instead of consuming the whole request budget.
+//
+// Parameters:
+//   - transport: The transport used for provider requests.
+//   - dialTimeout: The time allowed for establishing a connection.
+//   - tlsHandshakeTimeout: The time allowed for the TLS handshake.
+func setProviderTimeouts(transport *http.Transport, dialTimeout, tlsHandshakeTimeout time.Duration) {
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 15 * time.Second, // Reduced keepalive
+	}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = tlsHandshakeTimeout
+}
+
+// buildProviderTLSConfig builds the TLS configuration used to talk to the provider from PEM
+// files. The CA bundle is added to the system roots so that providers behind a private CA
+// can be verified, and the client certificate enables mTLS-protected token endpoints.
+//
+// Parameters:
+//   - caFile: Path to a PEM bundle of additional trusted CAs, or "" for system roots only.
+//   - certFile: Path to the PEM client certificate, or "" for none.
+//   - keyFile: Path to the PEM client private key, or "" for none.
+//
+// Returns:
+//   - The TLS configuration.
+//   - An error if a file cannot be read or parsed.
+func buildProviderTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read provider CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("provider CA file %s contains no valid certificates", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load provider client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
 const (
-	ConstSessionTimeout      = 86400          // Session timeout in seconds
-	defaultBlacklistDuration = 24 * time.Hour // Default duration to blacklist a JTI
+	ConstSessionTimeout      = 86400            // Session timeout in seconds
+	defaultBlacklistDuration = 24 * time.Hour   // Default duration to blacklist a JTI
+	maxRefreshBackoff        = 5 * time.Minute  // Upper bound for the delay between failed refresh attempts
+	fragmentParam            = "_oidc_fragment" // Query parameter carrying the URL fragment captured in the browser
+	maxFragmentLength        = 2048             // Longest URL fragment that is preserved across login
 )
 
 // TokenVerifier interface for token verification
 type TokenVerifier interface {
-	VerifyToken(token string) error
+	VerifyToken(ctx context.Context, token string) error
 }
 
 // JWTVerifier interface for JWT verification
 type JWTVerifier interface {
-	VerifyJWTSignatureAndClaims(jwt *JWT, token string) error
+	VerifyJWTSignatureAndClaims(ctx context.Context, jwt *JWT, token string) error
 }
 
 // TokenExchanger defines methods for OIDC token operations
 type TokenExchanger interface {
 	ExchangeCodeForToken(ctx context.Context, grantType string, codeOrToken string, redirectURL string, codeVerifier string) (*TokenResponse, error)
-	GetNewTokenWithRefreshToken(refreshToken string) (*TokenResponse, error)
+	GetNewTokenWithRefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error)
 	RevokeTokenWithProvider(token, tokenType string) error
 }
 
@@ -97,6 +164,7 @@ type TraefikOidc struct {
 	clientSecret               string
 	authURL                    string
 	tokenURL                   string
+	userInfoURL                string
 	scopes                     []string
 	limiter                    *rate.Limiter
 	forceHTTPS                 bool
@@ -120,6 +188,69 @@ type TraefikOidc struct {
 	tokenExchanger        TokenExchanger                // Added field for mocking
 	refreshGracePeriod    time.Duration                 // Configurable grace period for proactive refresh
 	headerTemplates       map[string]*template.Template // Parsed templates for custom headers
+	accessTokenHeader     string                        // Outbound header receiving the forwarded token (empty disables)
+	forwardIDToken        bool                          // The access token header carries the ID token instead of the provider's access token
+	unauthenticatedMode   string                        // How unauthenticated requests are answered (redirect, json-401, custom)
+	unauthenticatedPage   *htmltemplate.Template        // Parsed template served in custom unauthenticated mode
+	silentRenewPath       string                        // Endpoint starting a prompt=none renewal (empty disables)
+	refreshBackoff        time.Duration                 // Base delay after a failed refresh, doubled per consecutive failure (0 disables)
+	maxRefreshFailures    int                           // Consecutive refresh failures before forcing re-login (0 disables)
+	resources             []string                      // RFC 8707 resource indicators sent with auth and token requests
+	audience              string                        // Audience parameter sent with auth and token requests
+	tokenRequestParams    map[string]string             // Extra form fields added to token endpoint requests
+	allowedSigningAlgs    map[string]struct{}           // Accepted JWS algorithms (empty uses defaultAllowedSigningAlgorithms)
+	preserveFragment      bool                          // Capture the URL fragment in the browser before the login redirect
+	refreshOnlyForwarded  bool                          // Skip proactive refresh when the access token is not forwarded upstream
+	auditHook             func(event AuditEvent)        // Receives authentication audit events (nil disables)
+	userIDClaim           string                        // Claim used as the user identifier (default "email")
+	maxRequestBodyBytes   int64                         // Body size limit for the middleware's own endpoints
+	validateCertBinding   bool                          // Enforce RFC 8705 cnf.x5t#S256 certificate binding
+	clientCertHeader      string                        // Header carrying the client certificate from a TLS-terminating proxy
+	outageGracePeriod     time.Duration                 // How long an expired token is served while the provider is unavailable
+	tokenCacheMaxTTL      time.Duration                 // Upper bound for cached token claims (0 means until token expiry)
+	corsPreflight         string                        // How CORS preflight requests are handled (respond, passthrough)
+	corsAllowedOrigins    map[string]struct{}           // Lowercased origins allowed credentialed cross-origin requests
+	devToken              string                        // Unsigned token carrying the dev mode claims; empty unless dev mode is active
+	stateTTL              time.Duration                 // How long an authorization request's state stays valid; 0 disables the check
+	logoutParams          LogoutParams                  // Parameter names of the provider's logout endpoint
+	pendingAuthStore      PendingAuthStore              // Shared store resolving callbacks by state; nil keeps pending logins in the session only
+	sessionExpiredPath    string                        // Post-login destination after an absolute session timeout; empty returns to the requested page
+	restoreHeaders        []string                      // Request headers captured at login start and restored after the post-login redirect
+	allowedIssuers        []string                      // Issuers accepted in addition to the discovered one
+	rememberMeParam       string                        // Query parameter that requests a remember-me session at login start
+	routeRules            []routeRule                   // Per-path authorization rules, longest prefix first
+	allowImplicitFlow     bool                          // Request and accept tokens directly from the authorization endpoint
+	sendScopeOnRefresh    bool                          // Repeat the requested scopes in refresh token requests
+	noTokenRedirects      bool                          // Fail token requests that the token endpoint redirects
+	enableUserInfo        bool                          // Enrich login claims from the UserInfo endpoint
+	userInfoCache         *TokenCache                   // UserInfo claims keyed by access token
+	relaxNonce            bool                          // Accept ID tokens without a nonce claim
+	trustedProxies        []*net.IPNet                  // Peers whose X-Forwarded-* headers are honored (empty trusts any)
+	hostedDomain          string                        // Google Workspace domain required in the hd claim (empty disables)
+	maxAuthAge            time.Duration                 // Maximum age of the user's authentication at the provider (0 disables)
+	requireMFA            bool                          // Grant access only to logins whose amr claim shows MFA
+	mfaMethods            []string                      // amr values accepted as MFA
+	mfaACRValues          string                        // acr_values requesting MFA from the provider (empty omits it)
+	callbackLimiter       *callbackLimiter              // Per-client-IP limit on callback requests; nil disables
+	sessionExpiresHeader  string                        // Response header reporting the seconds until the session expires (empty disables)
+	claimsRefreshInterval time.Duration                 // How often session claims are refreshed from the provider (0 disables)
+	groupClaimPaths       []string                      // Dotted claim paths whose values are added to the user's groups
+	roleClaimPaths        []string                      // Dotted claim paths whose values are added to the user's roles
+	logCorrelationIDs     bool                          // Tag the log lines of each auth flow with its correlation ID
+	correlationIDHeader   string                        // Response header carrying the flow's correlation ID (empty disables)
+	introspectionURL      string                        // Token introspection endpoint, configured or discovered
+	introspectionFallback *introspectionFallback        // Verifies tokens by introspection while the JWKS is unavailable; nil disables
+	onAuthError           AuthErrorHandler              // Renders provider errors returned to the callback; nil uses the default 403 page
+	tokenRequestJSON      bool                          // Send token requests as a JSON object instead of form-encoded
+	postLoginHTMLRedirect bool                          // Navigate to the original page from an HTML page instead of a 302 after login
+	loginHintTokenHeader  string                        // Request header whose value is sent as login_hint_token
+	loginHintTokenClaim   string                        // Claim path of the previous ID token sent as login_hint_token
+	logoutConfirmation    bool                          // Require a confirmed POST with the session's CSRF token to log out
+	random                io.Reader                     // Source of randomness for state, nonce, PKCE verifier and IDs; nil uses crypto/rand
+	logoutSigner          *RequestSigner                // Signs logout requests; nil sends them unsigned
+	publicCallbackURL     string                        // Fixed redirect_uri overriding the request-derived one (empty disables)
+	allowedRedirectHosts  []string                      // Hosts (or *.domain wildcards) the redirect_uri may be built from (empty allows any)
+	templatesUseToken     bool                          // At least one header template references {{.AccessToken}}
 }
 
 // ProviderMetadata holds OIDC provider metadata
@@ -130,6 +261,9 @@ type ProviderMetadata struct {
 	JWKSURL       string `json:"jwks_uri"`
 	RevokeURL     string `json:"revocation_endpoint"`
 	EndSessionURL string `json:"end_session_endpoint"`
+	UserInfoURL   string `json:"userinfo_endpoint"`
+	// IntrospectionURL is the token introspection endpoint (RFC 7662), if the provider has one.
+	IntrospectionURL string `json:"introspection_endpoint"`
 }
 
 // defaultExcludedURLs are the paths that are excluded from authentication
@@ -146,12 +280,13 @@ var defaultExcludedURLs = map[string]struct{}{
 // 6. If verification succeeds and the token has a JTI claim, adds the JTI to the blacklist cache to prevent replay attacks.
 //
 // Parameters:
+//   - ctx: Cancels provider requests made during verification, e.g. introspection.
 //   - token: The raw ID token string to verify.
 //
 // Returns:
 //   - nil if the token is valid according to all checks.
 //   - An error describing the reason for validation failure (e.g., rate limit, blacklisted, parsing error, signature error, claim error).
-func (t *TraefikOidc) VerifyToken(token string) error {
+func (t *TraefikOidc) VerifyToken(ctx context.Context, token string) error {
 	// Check cache first
 	if claims, exists := t.tokenCache.Get(token); exists && len(claims) > 0 {
 		t.logger.Debugf("Token found in cache with valid claims; skipping verification")
@@ -172,7 +307,7 @@ func (t *TraefikOidc) VerifyToken(token string) error {
 	}
 
 	// Verify JWT signature and standard claims
-	if err := t.VerifyJWTSignatureAndClaims(jwt, token); err != nil {
+	if err := t.VerifyJWTSignatureAndClaims(ctx, jwt, token); err != nil {
 		return err
 	}
 
@@ -241,16 +376,18 @@ func (t *TraefikOidc) performPreVerificationChecks(token string) error {
 }
 
 // cacheVerifiedToken adds the claims of a successfully verified token to the token cache.
-// It calculates the remaining duration until the token's 'exp' claim and uses that
-// duration for the cache entry's lifetime.
+// The entry lives until the token's 'exp' claim, capped at the configured maximum token
+// cache TTL (see tokenCacheTTL). Tokens without an 'exp' claim, or already expired, are
+// not cached.
 //
 // Parameters:
 //   - token: The raw token string (used as the cache key).
 //   - claims: The map of claims extracted from the verified token.
 func (t *TraefikOidc) cacheVerifiedToken(token string, claims map[string]interface{}) {
-	expirationTime := time.Unix(int64(claims["exp"].(float64)), 0)
-	now := time.Now()
-	duration := expirationTime.Sub(now)
+	duration := tokenCacheTTL(claims, t.tokenCacheMaxTTL)
+	if duration <= 0 {
+		return
+	}
 	t.tokenCache.Set(token, claims, duration)
 }
 
@@ -259,6 +396,7 @@ func (t *TraefikOidc) cacheVerifiedToken(token string, claims map[string]interfa
 // and then validates the standard JWT claims (iss, aud, exp, iat, nbf, sub, jti replay).
 //
 // Parameters:
+//   - ctx: Cancels the introspection request made while the JWKS is unavailable.
 //   - jwt: A pointer to the parsed JWT struct containing header and claims.
 //   - token: The original raw token string (used for signature verification).
 //
@@ -266,15 +404,9 @@ func (t *TraefikOidc) cacheVerifiedToken(token string, claims map[string]interfa
 //   - nil if both the signature and all standard claims are valid.
 //   - An error describing the validation failure (e.g., failed to get JWKS, missing kid/alg,
 //     no matching key, signature verification failed, standard claim validation failed).
-func (t *TraefikOidc) VerifyJWTSignatureAndClaims(jwt *JWT, token string) error {
+func (t *TraefikOidc) VerifyJWTSignatureAndClaims(ctx context.Context, jwt *JWT, token string) error {
 	t.logger.Debugf("Verifying JWT signature and claims")
 
-	// Get JWKS
-	jwks, err := t.jwkCache.GetJWKS(context.Background(), t.jwksURL, t.httpClient)
-	if err != nil {
-		return fmt.Errorf("failed to get JWKS: %w", err)
-	}
-
 	// Retrieve key ID and algorithm from JWT header
 	kid, ok := jwt.Header["kid"].(string)
 	if !ok {
@@ -285,16 +417,48 @@ func (t *TraefikOidc) VerifyJWTSignatureAndClaims(jwt *JWT, token string) error
 		return fmt.Errorf("missing algorithm in token header")
 	}
 
-	// Find the matching key in JWKS
-	var matchingKey *JWK
-	for _, key := range jwks.Keys {
-		if key.Kid == kid {
-			matchingKey = &key
-			break
+	// Reject unsigned tokens and algorithms outside the allowlist before touching any key
+	if err := t.checkSigningAlgorithm(alg); err != nil {
+		return err
+	}
+
+	// Get JWKS, or have the provider vouch for the token while the JWKS is unavailable
+	jwks, err := t.getJWKSOrFallback()
+	if err != nil {
+		return err
+	}
+	if jwks == nil {
+		t.logger.Debugf("JWKS unavailable, verifying token through introspection")
+		if err := t.introspectToken(ctx, token); err != nil {
+			return fmt.Errorf("token introspection failed: %w", err)
 		}
+		if err := jwt.VerifyIssuers(append([]string{t.issuerURL}, t.allowedIssuers...), t.clientID); err != nil {
+			return fmt.Errorf("standard claim verification failed: %w", err)
+		}
+		return nil
 	}
+
+	// Find the matching key in JWKS, refetching the JWKS once if the provider may have
+	// rotated its keys since it was cached
+	matchingKey := findJWK(jwks, kid)
 	if matchingKey == nil {
-		return fmt.Errorf("no matching public key found for kid: %s", kid)
+		refresher, ok := t.jwkCache.(JWKSRefresher)
+		if !ok {
+			return fmt.Errorf("no matching public key found for kid: %s", kid)
+		}
+		t.logger.Debugf("Key ID %s not found in cached JWKS, refetching it", kid)
+		jwks, err = refresher.RefreshJWKS(context.Background(), t.jwksURL, t.httpClient)
+		if err != nil {
+			return fmt.Errorf("no matching public key found for kid: %s, and refetching the JWKS failed: %w", kid, err)
+		}
+		if matchingKey = findJWK(jwks, kid); matchingKey == nil {
+			return fmt.Errorf("no matching public key found for kid: %s, even after refetching the JWKS from %s", kid, t.jwksURL)
+		}
+	}
+
+	// A key published for one algorithm must not be used to verify another
+	if matchingKey.Alg != "" && matchingKey.Alg != alg {
+		return fmt.Errorf("token algorithm %s does not match key algorithm %s for kid: %s", alg, matchingKey.Alg, kid)
 	}
 
 	// Convert JWK to PEM format
@@ -309,13 +473,35 @@ func (t *TraefikOidc) VerifyJWTSignatureAndClaims(jwt *JWT, token string) error
 	}
 
 	// Verify standard claims
-	if err := jwt.Verify(t.issuerURL, t.clientID); err != nil {
+	if err := jwt.VerifyIssuers(append([]string{t.issuerURL}, t.allowedIssuers...), t.clientID); err != nil {
 		return fmt.Errorf("standard claim verification failed: %w", err)
 	}
 
 	return nil
 }
 
+// checkSigningAlgorithm rejects the "alg" value from a token header if it is "none" or not
+// in the configured allowlist, preventing none-algorithm downgrades and algorithm confusion.
+//
+// Parameters:
+//   - alg: The algorithm read from the token header.
+//
+// Returns:
+//   - nil if the algorithm is allowed, otherwise an error.
+func (t *TraefikOidc) checkSigningAlgorithm(alg string) error {
+	if strings.EqualFold(alg, "none") {
+		return fmt.Errorf("unsigned tokens (alg: none) are not accepted")
+	}
+	allowed := t.allowedSigningAlgs
+	if len(allowed) == 0 {
+		allowed = createStringMap(defaultAllowedSigningAlgorithms)
+	}
+	if _, ok := allowed[alg]; !ok {
+		return fmt.Errorf("signing algorithm %s is not allowed", alg)
+	}
+	return nil
+}
+
 // New is the constructor for the TraefikOidc middleware plugin.
 // It is called by Traefik during plugin initialization. It performs the following steps:
 //  1. Creates a default configuration if none is provided.
@@ -361,12 +547,39 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 			return nil, fmt.Errorf("encryption key must be at least %d bytes long", minEncryptionKeyLength)
 		}
 	}
-	// Setup HTTP client
+	// Setup HTTP client for provider-bound requests. Traffic to the upstream service goes
+	// through the next handler and never uses this client.
 	var httpClient *http.Client
 	if config.HTTPClient != nil {
 		httpClient = config.HTTPClient
 	} else {
 		httpClient = createDefaultHTTPClient()
+		if config.ProviderTransport != nil {
+			httpClient.Transport = config.ProviderTransport
+		} else {
+			transport := httpClient.Transport.(*http.Transport)
+			dialTimeout, tlsHandshakeTimeout := DefaultProviderDialTimeout, DefaultProviderTLSHandshakeTimeout
+			if config.ProviderDialTimeoutSeconds > 0 {
+				dialTimeout = time.Duration(config.ProviderDialTimeoutSeconds) * time.Second
+			}
+			if config.ProviderTLSHandshakeTimeoutSeconds > 0 {
+				tlsHandshakeTimeout = time.Duration(config.ProviderTLSHandshakeTimeoutSeconds) * time.Second
+			}
+			setProviderTimeouts(transport, dialTimeout, tlsHandshakeTimeout)
+			if config.ProviderCAFile != "" || config.ProviderClientCertFile != "" {
+				tlsConfig, err := buildProviderTLSConfig(config.ProviderCAFile, config.ProviderClientCertFile, config.ProviderClientKeyFile)
+				if err != nil {
+					return nil, err
+				}
+				transport.TLSClientConfig = tlsConfig
+			}
+		}
+	}
+	if config.MaxProviderConcurrency > 0 {
+		// Copy the client so that a caller-supplied one is left untouched
+		limited := *httpClient
+		limited.Transport = newLimitedTransport(httpClient.Transport, config.MaxProviderConcurrency)
+		httpClient = &limited
 	}
 	t := &TraefikOidc{
 		next:         next,
@@ -384,16 +597,22 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 			}
 			return config.PostLogoutRedirectURI
 		}(),
-		tokenBlacklist:        NewCache(), // Use generic cache for blacklist
-		jwkCache:              &JWKCache{},
-		metadataCache:         NewMetadataCache(),
-		clientID:              config.ClientID,
-		clientSecret:          config.ClientSecret,
-		forceHTTPS:            config.ForceHTTPS,
-		enablePKCE:            config.EnablePKCE,
-		scopes:                config.Scopes,
+		tokenBlacklist: NewCache(), // Use generic cache for blacklist
+		jwkCache:       &JWKCache{MinRefreshInterval: time.Duration(config.JWKSRefetchIntervalSeconds) * time.Second},
+		metadataCache:  NewMetadataCache(),
+		clientID:       config.ClientID,
+		clientSecret:   config.ClientSecret,
+		forceHTTPS:     config.ForceHTTPS,
+		enablePKCE:     config.EnablePKCE,
+		scopes: func() []string {
+			scopes, addedOpenID := normalizeScopes(config.Scopes)
+			if addedOpenID {
+				logger.Infof("Scope 'openid' missing from configured scopes; adding it so the provider issues an ID token")
+			}
+			return scopes
+		}(),
 		limiter:               rate.NewLimiter(rate.Every(time.Second), config.RateLimit),
-		tokenCache:            NewTokenCache(),
+		tokenCache:            NewShardedTokenCache(config.TokenCacheShards),
 		httpClient:            httpClient,
 		excludedURLs:          createStringMap(config.ExcludedURLs),
 		allowedUserDomains:    createStringMap(config.AllowedUserDomains),
@@ -409,6 +628,47 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 	}
 
 	t.sessionManager, _ = NewSessionManager(config.SessionEncryptionKey, config.ForceHTTPS, t.logger)
+	if err := t.sessionManager.SetCompressionCodec(config.CompressionCodec); err != nil {
+		logger.Errorf("Invalid compression codec, falling back to %s: %v", DefaultCompressionCodec, err)
+	}
+	t.sessionManager.SetCompressionThreshold(config.CompressionThreshold)
+	t.sessionManager.SetDisableChunking(config.DisableChunking)
+	t.sessionManager.SetCompressClaims(config.CompressClaims)
+	t.sessionManager.SetSingleSessionPerUser(config.SingleSessionPerUser)
+	t.sessionManager.SetCookieBudget(config.MaxCookieBytes, config.EnforceCookieBudget)
+	mainSameSite, tokenSameSite, err := config.cookieSameSite()
+	if err != nil {
+		logger.Errorf("Invalid cookie SameSite mode, falling back to lax: %v", err)
+	}
+	t.sessionManager.SetSameSite(mainSameSite, tokenSameSite)
+	t.sessionManager.SetCookiePath(config.CookiePath)
+	if err := t.sessionManager.SetTokenStorage(config.TokenStorage); err != nil {
+		logger.Errorf("Invalid token storage, falling back to %s: %v", TokenStorageCookie, err)
+	}
+	if err := t.sessionManager.SetCookieEncoding(config.CookieEncoding); err != nil {
+		logger.Errorf("Invalid cookie encoding, falling back to %s: %v", CookieEncodingGob, err)
+	}
+	if err := t.sessionManager.SetSessionLayout(config.SessionLayout); err != nil {
+		logger.Errorf("Invalid session layout, falling back to %s: %v", SessionLayoutSplit, err)
+	}
+	t.sessionManager.SetCookieStatsHook(config.CookieStatsHook)
+	t.sessionManager.SetTokenTooLargeHook(config.OnTokenTooLarge)
+	keyProvider := config.SessionKeyProvider
+	if keyProvider == nil && config.SessionEncryptionKeyFile != "" {
+		keyProvider = FileKeyProvider(config.SessionEncryptionKeyFile, logger)
+	}
+	if keyProvider != nil {
+		if err := t.sessionManager.SetKeyProvider(keyProvider, DefaultKeyRefreshInterval); err != nil {
+			return nil, fmt.Errorf("invalid session keys: %w", err)
+		}
+	}
+	if config.SessionTimeoutJitterPercent < 0 || config.SessionTimeoutJitterPercent > 50 {
+		logger.Errorf("Invalid sessionTimeoutJitterPercent %d, disabling session timeout jitter", config.SessionTimeoutJitterPercent)
+	} else {
+		t.sessionManager.SetSessionTimeoutJitter(float64(config.SessionTimeoutJitterPercent) / 100)
+	}
+	t.sessionManager.SetLoginTimeout(time.Duration(config.StateTTLSeconds) * time.Second)
+	t.sessionManager.SetIdleTimeout(time.Duration(config.IdleTimeoutSeconds) * time.Second)
 	t.extractClaimsFunc = extractClaims
 	// t.exchangeCodeForTokenFunc = t.exchangeCodeForToken // Removed, using interface now
 	t.initiateAuthenticationFunc = func(rw http.ResponseWriter, req *http.Request, session *SessionData, redirectURL string) {
@@ -434,9 +694,168 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 			continue
 		}
 		t.headerTemplates[header.Name] = tmpl
+		if strings.Contains(header.Value, ".AccessToken") {
+			t.templatesUseToken = true
+		}
 		logger.Debugf("Parsed template for header %s: %s", header.Name, header.Value)
 	}
 
+	t.accessTokenHeader = http.CanonicalHeaderKey(config.AccessTokenHeader)
+	switch config.ForwardedToken {
+	case "", ForwardedTokenAccess:
+	case ForwardedTokenID:
+		t.forwardIDToken = true
+	default:
+		logger.Errorf("Unknown forwarded token %q, falling back to the access token", config.ForwardedToken)
+	}
+	t.silentRenewPath = config.SilentRenewPath
+	t.refreshBackoff = time.Duration(config.RefreshBackoffSeconds) * time.Second
+	t.maxRefreshFailures = config.MaxRefreshFailures
+	t.resources = config.Resources
+	t.audience = config.Audience
+	t.tokenRequestParams = config.TokenRequestParams
+	t.preserveFragment = config.PreserveFragment
+	t.refreshOnlyForwarded = config.RefreshOnlyWhenForwarded
+	t.publicCallbackURL = config.PublicCallbackURL
+	t.auditHook = config.AuditHook
+	t.userIDClaim = config.UserIDClaim
+	t.validateCertBinding = config.ValidateCertificateBinding
+	t.clientCertHeader = config.ClientCertHeader
+	t.maxRequestBodyBytes = config.MaxRequestBodyBytes
+	t.outageGracePeriod = time.Duration(config.OutageGracePeriodSeconds) * time.Second
+	t.tokenCacheMaxTTL = time.Duration(config.TokenCacheMaxTTLSeconds) * time.Second
+	t.stateTTL = time.Duration(config.StateTTLSeconds) * time.Second
+	t.logoutParams = config.LogoutParams
+	t.pendingAuthStore = config.PendingAuthStore
+	t.sessionExpiredPath = config.SessionExpiredRedirectPath
+	t.restoreHeaders = config.RestoreHeaders
+	t.allowedIssuers = config.AllowedIssuers
+	t.routeRules = compileRouteRules(config.RouteRules)
+	t.allowImplicitFlow = config.AllowImplicitFlow
+	t.sendScopeOnRefresh = config.SendScopeOnRefresh
+	t.noTokenRedirects = config.DisallowTokenEndpointRedirects
+	t.enableUserInfo = config.FetchUserInfo
+	t.userInfoCache = NewTokenCache()
+	t.relaxNonce = config.AllowMissingNonce
+	t.hostedDomain = config.GoogleHostedDomain
+	t.maxAuthAge = time.Duration(config.MaxAuthAgeSeconds) * time.Second
+	t.requireMFA = config.RequireMFA
+	t.mfaMethods = config.MFAMethods
+	if len(t.mfaMethods) == 0 {
+		t.mfaMethods = DefaultMFAMethods
+	}
+	t.mfaACRValues = config.MFAACRValues
+	if config.CallbackRateLimit > 0 {
+		t.callbackLimiter = newCallbackLimiter(config.CallbackRateLimit)
+	}
+	t.sessionExpiresHeader = http.CanonicalHeaderKey(config.SessionExpiresHeader)
+	t.claimsRefreshInterval = time.Duration(config.ClaimsRefreshIntervalSeconds) * time.Second
+	t.groupClaimPaths = config.GroupClaimPaths
+	t.roleClaimPaths = config.RoleClaimPaths
+	t.logCorrelationIDs = config.LogCorrelationIDs
+	t.correlationIDHeader = http.CanonicalHeaderKey(config.CorrelationIDHeader)
+	t.introspectionURL = config.IntrospectionURL
+	if config.IntrospectionFallback {
+		t.introspectionFallback = &introspectionFallback{}
+	}
+	t.onAuthError = config.OnAuthError
+	t.tokenRequestJSON = config.TokenRequestEncoding == TokenRequestEncodingJSON
+	t.random = rand.Reader
+	t.postLoginHTMLRedirect = config.PostLoginHTMLRedirect
+	if config.LoginHintTokenHeader != "" {
+		t.loginHintTokenHeader = http.CanonicalHeaderKey(config.LoginHintTokenHeader)
+	}
+	t.loginHintTokenClaim = config.LoginHintTokenClaim
+	t.logoutConfirmation = config.LogoutConfirmation
+	trustedProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	t.trustedProxies = trustedProxies
+	if t.relaxNonce {
+		logger.Infof("Nonce enforcement relaxed (allowMissingNonce: true): ID tokens without a nonce claim are accepted. Leave it disabled unless the provider cannot return the nonce.")
+	}
+	if t.allowImplicitFlow {
+		logger.Infof("Implicit flow enabled: tokens are accepted from the callback URL. Prefer the authorization code flow where the provider supports it.")
+	}
+	if config.SignLogoutRequests {
+		signer, err := NewRequestSigner(config.ClientSigningKey, config.ClientSigningKeyID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clientSigningKey: %w", err)
+		}
+		t.logoutSigner = signer
+	}
+	if config.RememberMeParam != "" {
+		t.rememberMeParam = config.RememberMeParam
+		rememberMeTimeout := time.Duration(config.RememberMeTimeoutSeconds) * time.Second
+		if rememberMeTimeout <= 0 {
+			rememberMeTimeout = DefaultRememberMeTimeoutSeconds * time.Second
+		}
+		t.sessionManager.SetRememberMeTimeout(rememberMeTimeout)
+	}
+	t.corsAllowedOrigins = make(map[string]struct{}, len(config.CORSAllowedOrigins))
+	for _, origin := range config.CORSAllowedOrigins {
+		t.corsAllowedOrigins[strings.ToLower(origin)] = struct{}{}
+	}
+	t.corsPreflight = CORSPreflightPassthrough
+	if config.CORSPreflight == CORSPreflightRespond || (config.CORSPreflight == "" && len(t.corsAllowedOrigins) > 0) {
+		t.corsPreflight = CORSPreflightRespond
+	} else if config.CORSPreflight != "" && config.CORSPreflight != CORSPreflightPassthrough {
+		logger.Errorf("Unknown CORS preflight mode %q, falling back to %s", config.CORSPreflight, CORSPreflightPassthrough)
+	}
+	if t.maxRequestBodyBytes <= 0 {
+		t.maxRequestBodyBytes = DefaultMaxRequestBodyBytes
+	}
+	t.allowedRedirectHosts = config.AllowedRedirectHosts
+	t.allowedSigningAlgs = createStringMap(defaultAllowedSigningAlgorithms)
+	if len(config.AllowedSigningAlgorithms) > 0 {
+		t.allowedSigningAlgs = make(map[string]struct{})
+		for _, alg := range config.AllowedSigningAlgorithms {
+			if !supportedSigningAlgorithms[alg] {
+				logger.Errorf("Ignoring unsupported signing algorithm %q", alg)
+				continue
+			}
+			t.allowedSigningAlgs[alg] = struct{}{}
+		}
+		if len(t.allowedSigningAlgs) == 0 {
+			logger.Errorf("No supported signing algorithms configured, falling back to %v", defaultAllowedSigningAlgorithms)
+			t.allowedSigningAlgs = createStringMap(defaultAllowedSigningAlgorithms)
+		}
+	}
+	for name := range config.TokenRequestParams {
+		if reservedTokenParams[name] {
+			logger.Errorf("Ignoring token request parameter %q: reserved parameters cannot be overridden", name)
+		}
+	}
+
+	// Configure how unauthenticated requests are answered
+	t.unauthenticatedMode = UnauthenticatedModeRedirect
+	switch config.UnauthenticatedMode {
+	case "", UnauthenticatedModeRedirect:
+	case UnauthenticatedModeJSON:
+		t.unauthenticatedMode = UnauthenticatedModeJSON
+	case UnauthenticatedModeCustom:
+		tmpl, err := htmltemplate.New("unauthenticated").Parse(config.UnauthenticatedTemplate)
+		if err != nil || config.UnauthenticatedTemplate == "" {
+			logger.Errorf("Invalid unauthenticated page template, falling back to redirect mode: %v", err)
+		} else {
+			t.unauthenticatedMode = UnauthenticatedModeCustom
+			t.unauthenticatedPage = tmpl
+		}
+	default:
+		logger.Errorf("Unknown unauthenticated mode %q, falling back to redirect mode", config.UnauthenticatedMode)
+	}
+
+	if config.DevMode {
+		devToken, err := buildDevToken(config.DevClaims)
+		if err != nil {
+			return nil, err
+		}
+		logger.Errorf("DEV MODE ACTIVE: every request is authenticated with static claims and the provider is never contacted. Do not use in production.")
+		t.devToken = devToken
+		return t, nil
+	}
+
 	go t.initializeMetadata(config.ProviderURL)
 
 	return t, nil
@@ -491,6 +910,10 @@ func (t *TraefikOidc) updateMetadataEndpoints(metadata *ProviderMetadata) {
 	t.issuerURL = metadata.Issuer
 	t.revocationURL = metadata.RevokeURL
 	t.endSessionURL = metadata.EndSessionURL
+	t.userInfoURL = metadata.UserInfoURL
+	if t.introspectionURL == "" {
+		t.introspectionURL = metadata.IntrospectionURL
+	}
 }
 
 // startMetadataRefresh starts a background goroutine that periodically attempts to refresh
@@ -614,6 +1037,11 @@ func fetchMetadata(wellKnownURL string, httpClient *http.Client) (*ProviderMetad
 // ServeHTTP is the main entry point for incoming requests to the middleware.
 // It orchestrates the OIDC authentication flow.
 func (t *TraefikOidc) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if t.devToken != "" {
+		t.serveDevMode(rw, req)
+		return
+	}
+
 	// --- Initialization Check ---
 	select {
 	case <-t.initComplete:
@@ -638,6 +1066,23 @@ func (t *TraefikOidc) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		t.next.ServeHTTP(rw, req)
 		return
 	}
+	if isCORSPreflight(req) {
+		// Preflights carry no cookies; redirecting them to the provider fails the CORS request
+		if t.corsPreflight == CORSPreflightPassthrough {
+			t.logger.Debugf("Forwarding CORS preflight for %s without authentication", req.URL.Path)
+			t.next.ServeHTTP(rw, req)
+			return
+		}
+		origin := req.Header.Get("Origin")
+		if !t.corsOriginAllowed(origin) {
+			t.logger.Debugf("Rejecting CORS preflight from origin %q that is not in corsAllowedOrigins", origin)
+			http.Error(rw, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		setCORSHeaders(rw, origin)
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
 	acceptHeader := req.Header.Get("Accept")
 	if strings.Contains(acceptHeader, "text/event-stream") {
 		t.logger.Debugf("Request accepts text/event-stream (%s), bypassing OIDC", acceptHeader)
@@ -646,8 +1091,19 @@ func (t *TraefikOidc) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	// --- Session Retrieval ---
-	session, err := t.sessionManager.GetSession(req)
+	session, err := t.sessionManager.GetSessionContext(req.Context(), req)
 	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			// Client went away or the deadline passed; nothing useful can be sent back
+			t.logger.Debugf("Request context done while loading session: %v", err)
+			return
+		}
+		if errors.Is(err, ErrSessionExpired) {
+			// Not a failure: the user simply has to log in again
+			t.logger.Infof("Session of user %s reached the absolute or idle session timeout, initiating re-authentication", session.GetUserID())
+			t.defaultInitiateAuthentication(rw, t.sessionExpiredLoginRequest(req), session, t.buildRedirectURL(req))
+			return
+		}
 		// Log the specific session error
 		t.logger.Errorf("Error getting session: %v. Initiating authentication.", err)
 		// Attempt to get a new session to store CSRF etc.
@@ -663,40 +1119,88 @@ func (t *TraefikOidc) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			http.Error(rw, "Critical session error", http.StatusInternalServerError)
 			return
 		}
-		scheme := t.determineScheme(req)
-		host := t.determineHost(req)
-		redirectURL := buildFullURL(scheme, host, t.redirURLPath)
-		t.defaultInitiateAuthentication(rw, req, session, redirectURL)
+		t.defaultInitiateAuthentication(rw, req, session, t.buildRedirectURL(req))
 		return
 	}
 
+	logger := t.flowLogger(session)
+	t.emitCorrelationID(rw, session)
+
 	// --- URL Handling (Callback, Logout) ---
-	scheme := t.determineScheme(req)
-	host := t.determineHost(req)
-	redirectURL := buildFullURL(scheme, host, t.redirURLPath) // Used for callback and re-auth
+	redirectURL := t.buildRedirectURL(req) // Used for callback and re-auth
 
 	if req.URL.Path == t.logoutURLPath {
 		t.handleLogout(rw, req)
 		return
 	}
+	if t.silentRenewPath != "" && req.URL.Path == t.silentRenewPath {
+		t.handleSilentRenew(rw, req, session, redirectURL)
+		return
+	}
 	if req.URL.Path == t.redirURLPath {
 		t.handleCallback(rw, req, redirectURL)
 		return
 	}
 
+	if t.sessionManager.IsInvalidated(session) {
+		logger.Infof("Session of user %s was invalidated, forcing re-authentication", session.GetUserID())
+		t.audit(req, AuditLogout, session.GetEmail(), "session invalidated")
+		t.handleExpiredToken(rw, req, session, redirectURL)
+		return
+	}
+
+	if t.authTooOld(session) {
+		logger.Infof("Authentication of user %s is older than maxAuthAgeSeconds, forcing re-authentication", session.GetUserID())
+		t.defaultInitiateAuthentication(rw, req, session, redirectURL)
+		return
+	}
+
+	if t.needsMFAStepUp(session) {
+		if session.GetMFAStepUp() {
+			// The step-up login still did not use MFA; sending the user back would loop
+			logger.Infof("User %s did not complete multi-factor authentication, denying access", session.GetUserID())
+			t.audit(req, AuditAccessDenied, session.GetEmail(), "multi-factor authentication required")
+			t.sendErrorResponse(rw, req, "Access denied: Multi-factor authentication required", http.StatusForbidden)
+			return
+		}
+		logger.Infof("Login of user %s did not use multi-factor authentication, requesting step-up", session.GetUserID())
+		t.defaultInitiateAuthentication(rw, req, session, redirectURL)
+		return
+	}
+
 	// --- Authentication & Refresh Logic ---
-	authenticated, needsRefresh, expired := t.isUserAuthenticated(session)
+	authenticated, needsRefresh, expired := t.isUserAuthenticated(req.Context(), session)
 
 	if expired {
-		t.logger.Debug("Session token is definitively expired or invalid, initiating re-auth")
+		if t.canLoginSilently(session) {
+			logger.Infof("Session of user %s has no refresh token, attempting a silent login", session.GetUserID())
+			t.initiateAuthentication(rw, req, session, redirectURL, true)
+			return
+		}
+		logger.Debug("Session token is definitively expired or invalid, initiating re-auth")
 		// handleExpiredToken clears the session and initiates auth
 		t.handleExpiredToken(rw, req, session, redirectURL)
 		return
 	}
 
+	// Refresh sessions whose claims are older than the claims refresh interval, so that
+	// changes made at the provider (revoked roles) take effect within the interval
+	claimsRefresh := authenticated && !needsRefresh && t.claimsStale(session)
+	if claimsRefresh {
+		logger.Debugf("Claims of user %s are older than claimsRefreshIntervalSeconds, refreshing them", session.GetUserID())
+		needsRefresh = true
+	}
+
 	// If authenticated and token doesn't need proactive refresh, proceed directly
 	if authenticated && !needsRefresh {
-		t.logger.Debug("User authenticated and token valid, proceeding to process authorized request")
+		logger.Debug("User authenticated and token valid, proceeding to process authorized request")
+		t.processAuthorizedRequest(rw, req, session, redirectURL)
+		return
+	}
+
+	// Defer proactive refresh while the still-valid token is never sent upstream
+	if authenticated && !claimsRefresh && t.refreshOnlyForwarded && !t.forwardsAccessToken() {
+		logger.Debug("Access token is not forwarded upstream, deferring proactive refresh")
 		t.processAuthorizedRequest(rw, req, session, redirectURL)
 		return
 	}
@@ -709,31 +1213,88 @@ func (t *TraefikOidc) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	shouldAttemptRefresh := needsRefresh && refreshTokenPresent
 
 	if shouldAttemptRefresh {
-		if needsRefresh && authenticated {
-			t.logger.Debug("Session token needs proactive refresh, attempting refresh")
-		} else if needsRefresh && !authenticated {
-			t.logger.Debug("Access token invalid/expired, but refresh token found. Attempting refresh.")
+		if err := req.Context().Err(); err != nil {
+			logger.Debugf("Request context done before token refresh, skipping refresh: %v", err)
+			return
+		}
+
+		failures, nextAttempt := session.GetRefreshFailures()
+		inBackoff := failures > 0 && time.Now().Before(nextAttempt)
+		if inBackoff && authenticated {
+			// The current token is still valid; don't hit the token endpoint again yet
+			logger.Debugf("Skipping proactive refresh during backoff (failures=%d, next attempt at %v)", failures, nextAttempt)
+			t.processAuthorizedRequest(rw, req, session, redirectURL)
+			return
 		}
 
-		refreshed := t.refreshToken(rw, req, session)
+		refreshed := false
+		if inBackoff {
+			logger.Debugf("Skipping token refresh during backoff (failures=%d, next attempt at %v)", failures, nextAttempt)
+		} else {
+			if needsRefresh && authenticated {
+				logger.Debug("Session token needs proactive refresh, attempting refresh")
+			} else if needsRefresh && !authenticated {
+				logger.Debug("Access token invalid/expired, but refresh token found. Attempting refresh.")
+			}
+			refreshed = t.refreshToken(rw, req, session)
+			if refreshed {
+				t.audit(req, AuditTokenRefresh, session.GetEmail(), "")
+			} else {
+				t.audit(req, AuditTokenRefreshFailure, session.GetEmail(), "token refresh failed")
+			}
+		}
 		if refreshed {
 			// Refresh succeeded, proceed to authorization checks
-			t.logger.Debug("Token refresh successful, proceeding to process authorized request")
+			logger.Debug("Token refresh successful, proceeding to process authorized request")
 			t.processAuthorizedRequest(rw, req, session, redirectURL)
 			return
 		}
 
 		// Refresh failed
-		t.logger.Infof("Token refresh failed (authenticated=%v, needsRefresh=%v, refreshTokenPresent=%v)", authenticated, needsRefresh, refreshTokenPresent)
+		logger.Infof("Token refresh failed (authenticated=%v, needsRefresh=%v, refreshTokenPresent=%v)", authenticated, needsRefresh, refreshTokenPresent)
+		if session.subjectChanged {
+			// The provider answered for a different user; the current token must not be kept
+			t.handleExpiredToken(rw, req, session, redirectURL)
+			return
+		}
+		if !inBackoff {
+			failures = t.recordRefreshFailure(session, failures)
+		}
+		if !authenticated && t.withinOutageGracePeriod(session) {
+			logger.Infof("Provider unavailable during token refresh, serving the expired token within the outage grace period")
+			if err := session.Save(req, rw); err != nil {
+				logger.Errorf("Failed to save refresh backoff state: %v", err)
+			}
+			t.processAuthorizedRequest(rw, req, session, redirectURL)
+			return
+		}
+		if t.maxRefreshFailures > 0 && failures >= t.maxRefreshFailures {
+			logger.Infof("Token refresh failed %d consecutive times, clearing session and forcing re-login", failures)
+			t.defaultInitiateAuthentication(rw, req, session, redirectURL)
+			return
+		}
+		if authenticated {
+			// The current token is still valid; keep serving it until the backoff expires
+			if err := session.Save(req, rw); err != nil {
+				logger.Errorf("Failed to save refresh backoff state: %v", err)
+			}
+			t.processAuthorizedRequest(rw, req, session, redirectURL)
+			return
+		}
+
 		// Handle refresh failure (401 for API, re-auth for browser)
 		acceptHeader := req.Header.Get("Accept")
 		if strings.Contains(acceptHeader, "application/json") {
-			t.logger.Debug("Client accepts JSON, sending 401 Unauthorized on refresh failure")
+			if err := session.Save(req, rw); err != nil {
+				logger.Errorf("Failed to save refresh backoff state: %v", err)
+			}
+			logger.Debug("Client accepts JSON, sending 401 Unauthorized on refresh failure")
+			setNoStoreHeaders(rw)
 			rw.Header().Set("Content-Type", "application/json")
 			rw.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(rw).Encode(map[string]string{"error": "unauthorized", "message": "Token refresh failed"})
 		} else {
-			t.logger.Debug("Client does not prefer JSON, handling refresh failure by initiating re-auth")
+			logger.Debug("Client does not prefer JSON, handling refresh failure by initiating re-auth")
 			// Use defaultInitiateAuthentication which clears the session properly
 			t.defaultInitiateAuthentication(rw, req, session, redirectURL)
 		}
@@ -746,76 +1307,346 @@ func (t *TraefikOidc) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	// - AND EITHER token doesn't need refresh (!needsRefresh, e.g., first visit)
 	// - OR refresh token is missing (!refreshTokenPresent)
 	// - OR refresh was attempted but failed (handled above)
-	t.logger.Debugf("Initiating full OIDC authentication flow (authenticated=%v, needsRefresh=%v, refreshTokenPresent=%v)", authenticated, needsRefresh, refreshTokenPresent)
+	logger.Debugf("Initiating full OIDC authentication flow (authenticated=%v, needsRefresh=%v, refreshTokenPresent=%v)", authenticated, needsRefresh, refreshTokenPresent)
 	t.defaultInitiateAuthentication(rw, req, session, redirectURL)
 }
 
-// processAuthorizedRequest handles the final steps for an authenticated and authorized request.
-// It performs domain/role/group checks, sets headers, and forwards the request.
-func (t *TraefikOidc) processAuthorizedRequest(rw http.ResponseWriter, req *http.Request, session *SessionData, redirectURL string) {
-	email := session.GetEmail()
-	if email == "" {
-		t.logger.Error("CRITICAL: No email found in session during final processing, initiating re-auth")
-		// This case should ideally not happen if checks are done correctly before calling this,
-		// but as a safeguard, initiate re-authentication.
-		t.defaultInitiateAuthentication(rw, req, session, redirectURL)
-		return
-	}
+// sessionExpiredLoginRequest returns the request a new login is started for when the session
+// reached the absolute timeout. Users are returned to the page they requested unless a
+// sessionExpiredRedirectPath is configured.
+//
+// Parameters:
+//   - req: The incoming HTTP request.
+//
+// Returns:
+//   - The request whose URL is stored as the post-login destination.
+func (t *TraefikOidc) sessionExpiredLoginRequest(req *http.Request) *http.Request {
+	if t.sessionExpiredPath == "" {
+		return req
+	}
+	loginReq := req.Clone(req.Context())
+	loginReq.URL.Path = t.sessionExpiredPath
+	loginReq.URL.RawPath = ""
+	loginReq.URL.RawQuery = ""
+	return loginReq
+}
 
-	if !t.isAllowedDomain(email) {
-		t.logger.Infof("User with email %s is not from an allowed domain", email)
-		errorMsg := fmt.Sprintf("Access denied: Your email domain is not allowed. To log out, visit: %s", t.logoutURLPath)
-		t.sendErrorResponse(rw, req, errorMsg, http.StatusForbidden)
-		return
+// withinOutageGracePeriod reports whether a session whose token has expired may keep being
+// served because the last refresh failed due to a provider outage and the token expired less
+// than the configured outage grace period ago.
+//
+// Parameters:
+//   - session: The session whose refresh failed.
+//
+// Returns:
+//   - true if the expired token may still be used.
+func (t *TraefikOidc) withinOutageGracePeriod(session *SessionData) bool {
+	if t.outageGracePeriod <= 0 || !session.GetProviderUnavailable() {
+		return false
 	}
-
-	groups, roles, err := t.extractGroupsAndRoles(session.GetAccessToken())
+	claims, err := t.extractClaimsFunc(session.GetAccessToken())
 	if err != nil {
-		t.logger.Errorf("Failed to extract groups and roles: %v", err)
-		// Continue without group/role headers if extraction fails
-	} else {
-		if len(groups) > 0 {
-			req.Header.Set("X-User-Groups", strings.Join(groups, ","))
-		}
-		if len(roles) > 0 {
-			req.Header.Set("X-User-Roles", strings.Join(roles, ","))
-		}
+		return false
 	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return false
+	}
+	return time.Now().Before(time.Unix(int64(exp), 0).Add(t.outageGracePeriod))
+}
 
-	// Check allowed roles and groups
-	if len(t.allowedRolesAndGroups) > 0 {
-		allowed := false
-		for _, roleOrGroup := range append(groups, roles...) {
-			if _, ok := t.allowedRolesAndGroups[roleOrGroup]; ok {
-				allowed = true
-				break
-			}
+// ValidateToken verifies a token exactly like the tokens of a login (JWKS signature, issuer,
+// audience, expiry and the other standard claims, blacklist and replay checks) and returns its
+// claims, without reading or writing any session. It is intended for custom handlers and
+// composed middleware that accept bearer tokens, such as webhook endpoints. Verified tokens are
+// cached like those of the main flow, so repeated calls with the same token are cheap.
+//
+// Parameters:
+//   - ctx: Bounds the wait for the provider metadata to be initialized.
+//   - token: The raw token to validate.
+//
+// Returns:
+//   - The token claims. The map is a copy and may be modified by the caller.
+//   - An error if the provider is not initialized, ctx is done, or the token is invalid.
+func (t *TraefikOidc) ValidateToken(ctx context.Context, token string) (map[string]interface{}, error) {
+	select {
+	case <-t.initComplete:
+		if t.issuerURL == "" {
+			return nil, fmt.Errorf("OIDC provider metadata initialization failed")
 		}
-		if !allowed {
-			t.logger.Infof("User with email %s does not have any allowed roles or groups", email)
-			errorMsg := fmt.Sprintf("Access denied: You do not have any of the allowed roles or groups. To log out, visit: %s", t.logoutURLPath)
-			t.sendErrorResponse(rw, req, errorMsg, http.StatusForbidden)
-			return
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for OIDC initialization: %w", ctx.Err())
+	}
+
+	if err := t.verifyToken(ctx, token); err != nil {
+		return nil, err
+	}
+
+	claims, ok := t.tokenCache.Get(token)
+	if !ok {
+		var err error
+		if claims, err = extractClaims(token); err != nil {
+			return nil, fmt.Errorf("failed to extract claims: %w", err)
 		}
 	}
+	result := make(map[string]interface{}, len(claims))
+	for k, v := range claims {
+		result[k] = v
+	}
+	return result, nil
+}
 
-	// Set user information in headers
-	req.Header.Set("X-Forwarded-User", email)
+// InvalidateUser terminates all current sessions of the given user, forcing re-authentication
+// on their next request. See SessionManager.InvalidateUser for the limitations.
+//
+// Parameters:
+//   - userID: The user identifier (the configured user ID claim, email by default).
+func (t *TraefikOidc) InvalidateUser(userID string) {
+	t.sessionManager.InvalidateUser(userID)
+}
 
-	// Set OIDC-specific headers
-	req.Header.Set("X-Auth-Request-Redirect", req.URL.RequestURI())
-	req.Header.Set("X-Auth-Request-User", email)
-	if idToken := session.GetAccessToken(); idToken != "" {
-		req.Header.Set("X-Auth-Request-Token", idToken)
+// forwardsAccessToken reports whether authorized requests carry the session's access token
+// upstream, either through the configured access token header or a header template that
+// references {{.AccessToken}}.
+//
+// Returns:
+//   - true if the access token is forwarded to the backend.
+func (t *TraefikOidc) forwardsAccessToken() bool {
+	return t.accessTokenHeader != "" || t.templatesUseToken
+}
+
+// storeProviderAccessToken keeps the provider's access token in the session when it is
+// forwarded upstream through the access token header; otherwise, including when the header
+// carries the ID token, it is not stored, so that sessions carry no extra cookie.
+//
+// Parameters:
+//   - session: The session being updated after a login or refresh.
+//   - token: The access token returned by the provider's token endpoint.
+//
+// Returns:
+//   - An error if the token cannot be stored.
+func (t *TraefikOidc) storeProviderAccessToken(session *SessionData, token string) error {
+	if t.accessTokenHeader == "" || t.forwardIDToken {
+		return nil
 	}
+	return session.SetProviderAccessToken(token)
+}
 
-	// Execute and set templated headers if configured
-	if len(t.headerTemplates) > 0 {
-		accessToken := session.GetAccessToken()
-		refreshToken := session.GetRefreshToken()
-		claims, err := t.extractClaimsFunc(accessToken)
+// recordRefreshFailure increments the session's consecutive refresh failure count and schedules
+// the next allowed refresh attempt. The delay starts at the configured refresh backoff and doubles
+// with each consecutive failure, capped at maxRefreshBackoff. The caller is responsible for saving
+// the session.
+//
+// Parameters:
+//   - session: The session whose refresh failed.
+//   - failures: The number of consecutive failures recorded before this one.
+//
+// Returns:
+//   - The updated consecutive failure count.
+func (t *TraefikOidc) recordRefreshFailure(session *SessionData, failures int) int {
+	failures++
+	if t.refreshBackoff <= 0 {
+		session.SetRefreshFailures(failures, time.Now())
+		return failures
+	}
+
+	delay := t.refreshBackoff
+	for i := 1; i < failures && delay < maxRefreshBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRefreshBackoff {
+		delay = maxRefreshBackoff
+	}
+	t.logger.Debugf("Refresh failure %d recorded, next attempt in %v", failures, delay)
+	session.SetRefreshFailures(failures, time.Now().Add(delay))
+	return failures
+}
+
+// injectAccessTokenHeader sets the configured outbound header to the provider's access token,
+// so that the upstream can call further APIs on the user's behalf, or to the verified ID token
+// when forwardedToken is "id", for upstreams parsing the identity claims themselves. The token
+// is reassembled from its chunks via GetProviderAccessToken or GetIDToken. When the header is
+// Authorization the value is sent as a bearer credential; any other header receives the raw
+// token. The caller removes a value sent by the client beforehand, so a header already present
+// here was set by a templated header and is not overwritten.
+//
+// Parameters:
+//   - req: The request being forwarded to the upstream.
+//   - session: The authenticated user's session data.
+func (t *TraefikOidc) injectAccessTokenHeader(req *http.Request, session *SessionData) {
+	if t.accessTokenHeader == "" {
+		return
+	}
+	if req.Header.Get(t.accessTokenHeader) != "" {
+		t.logger.Debugf("Header %s set by a header template, not injecting access token", t.accessTokenHeader)
+		return
+	}
+
+	accessToken := session.GetProviderAccessToken()
+	if t.forwardIDToken {
+		accessToken = session.GetIDToken()
+	}
+	if accessToken == "" {
+		return
+	}
+
+	if t.accessTokenHeader == "Authorization" {
+		req.Header.Set(t.accessTokenHeader, "Bearer "+accessToken)
+	} else {
+		req.Header.Set(t.accessTokenHeader, accessToken)
+	}
+}
+
+// captureHeaders collects the allowlisted headers of the request that starts a login, so
+// they can be restored on the first request after the post-login redirect.
+//
+// Parameters:
+//   - req: The request that triggered the login.
+//
+// Returns:
+//   - The present allowlisted headers keyed by canonical name.
+func (t *TraefikOidc) captureHeaders(req *http.Request) map[string]string {
+	captured := make(map[string]string)
+	for _, name := range t.restoreHeaders {
+		if value := req.Header.Get(name); value != "" {
+			captured[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+	return captured
+}
+
+// restoreIncomingHeaders applies the headers captured when the login started to the first
+// request after login, when it targets the page the user was originally trying to reach, so
+// that content negotiation and app-specific context survive the authentication round-trip.
+// The captured headers are discarded after the first authorized request either way.
+//
+// Parameters:
+//   - rw: The HTTP response writer, used to save the session.
+//   - req: The request being forwarded to the upstream.
+//   - session: The authenticated user's session data.
+func (t *TraefikOidc) restoreIncomingHeaders(rw http.ResponseWriter, req *http.Request, session *SessionData) {
+	path, headers := session.GetIncomingHeaders()
+	if headers == nil {
+		return
+	}
+	if path == req.URL.RequestURI() {
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		t.logger.Debugf("Restored %d request headers captured before login", len(headers))
+	}
+	session.SetIncomingHeaders("", nil)
+	if err := session.SaveMain(req, rw); err != nil {
+		t.logger.Errorf("Failed to save session after restoring request headers: %v", err)
+	}
+}
+
+// processAuthorizedRequest handles the final steps for an authenticated and authorized request.
+// It performs domain/role/group checks, sets headers, and forwards the request.
+func (t *TraefikOidc) processAuthorizedRequest(rw http.ResponseWriter, req *http.Request, session *SessionData, redirectURL string) {
+	logger := t.flowLogger(session)
+	email := session.GetEmail()
+	userID := session.GetUserID()
+	if userID == "" {
+		logger.Error("CRITICAL: No user ID found in session during final processing, initiating re-auth")
+		// This case should ideally not happen if checks are done correctly before calling this,
+		// but as a safeguard, initiate re-authentication.
+		t.defaultInitiateAuthentication(rw, req, session, redirectURL)
+		return
+	}
+
+	if !t.isAllowedDomain(email) {
+		logger.Infof("User with email %s is not from an allowed domain", email)
+		errorMsg := fmt.Sprintf("Access denied: Your email domain is not allowed. To log out, visit: %s", t.logoutURLPath)
+		t.audit(req, AuditAccessDenied, email, "email domain not allowed")
+		t.sendErrorResponse(rw, req, errorMsg, http.StatusForbidden)
+		return
+	}
+
+	if t.validateCertBinding {
+		claims, err := t.getTokenClaims(session.GetAccessToken())
+		if err == nil {
+			err = t.verifyCertificateBinding(req, claims)
+		}
 		if err != nil {
-			t.logger.Errorf("Failed to extract claims for template headers: %v", err)
+			logger.Infof("Certificate binding check failed for user %s: %v", email, err)
+			t.audit(req, AuditAccessDenied, email, "certificate binding mismatch")
+			t.sendErrorResponse(rw, req, "Access denied: Token is not bound to the presented client certificate", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	groups, roles, err := t.extractGroupsAndRoles(session.GetAccessToken())
+	if err != nil {
+		logger.Errorf("Failed to extract groups and roles: %v", err)
+		// Continue without group/role headers if extraction fails
+	} else {
+		if len(groups) > 0 {
+			req.Header.Set("X-User-Groups", strings.Join(groups, ","))
+		}
+		if len(roles) > 0 {
+			req.Header.Set("X-User-Roles", strings.Join(roles, ","))
+		}
+	}
+
+	// Check allowed roles and groups
+	if len(t.allowedRolesAndGroups) > 0 {
+		allowed := false
+		for _, roleOrGroup := range append(groups, roles...) {
+			if _, ok := t.allowedRolesAndGroups[roleOrGroup]; ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			logger.Infof("User with email %s does not have any allowed roles or groups", email)
+			errorMsg := fmt.Sprintf("Access denied: You do not have any of the allowed roles or groups. To log out, visit: %s", t.logoutURLPath)
+			t.audit(req, AuditAccessDenied, email, "no allowed role or group")
+			t.sendErrorResponse(rw, req, errorMsg, http.StatusForbidden)
+			return
+		}
+	}
+
+	// Check the authorization rule for the requested route
+	if len(t.routeRules) > 0 {
+		claims, err := t.sessionClaims(session)
+		if err == nil {
+			err = t.authorizeRoute(req.URL.Path, claims, append(groups, roles...))
+		}
+		if err != nil {
+			logger.Infof("User with email %s is not authorized for %s: %v", email, req.URL.Path, err)
+			errorMsg := fmt.Sprintf("Access denied: You are not authorized to access this page. To log out, visit: %s", t.logoutURLPath)
+			t.audit(req, AuditAccessDenied, email, "route rule not met")
+			t.sendErrorResponse(rw, req, errorMsg, http.StatusForbidden)
+			return
+		}
+	}
+
+	// Restore headers of the request that started the login before setting our own
+	t.restoreIncomingHeaders(rw, req, session)
+
+	// Set user information in headers
+	req.Header.Set("X-Forwarded-User", userID)
+
+	// Set OIDC-specific headers
+	req.Header.Set("X-Auth-Request-Redirect", req.URL.RequestURI())
+	req.Header.Set("X-Auth-Request-User", userID)
+	if idToken := session.GetAccessToken(); idToken != "" {
+		req.Header.Set("X-Auth-Request-Token", idToken)
+	}
+
+	// Drop a client-supplied access token header; only this middleware may set it
+	if t.accessTokenHeader != "" {
+		req.Header.Del(t.accessTokenHeader)
+	}
+
+	// Execute and set templated headers if configured
+	if len(t.headerTemplates) > 0 {
+		accessToken := session.GetAccessToken()
+		refreshToken := session.GetRefreshToken()
+		claims, err := t.sessionClaims(session)
+		if err != nil {
+			logger.Errorf("Failed to extract claims for template headers: %v", err)
 		} else {
 			// Create template data context with available tokens and claims
 			// Fields must be exported (uppercase) to be accessible in templates
@@ -836,39 +1667,41 @@ func (t *TraefikOidc) processAuthorizedRequest(rw http.ResponseWriter, req *http
 			for headerName, tmpl := range t.headerTemplates {
 				var buf bytes.Buffer
 				if err := tmpl.Execute(&buf, templateData); err != nil {
-					t.logger.Errorf("Failed to execute template for header %s: %v", headerName, err)
+					logger.Errorf("Failed to execute template for header %s: %v", headerName, err)
 					continue
 				}
 				headerValue := buf.String()
 				req.Header.Set(headerName, headerValue)
-				t.logger.Debugf("Set templated header %s = %s", headerName, headerValue)
+				logger.Debugf("Set templated header %s = %s", headerName, headerValue)
 			}
 		}
 	}
 
+	// Forward the access token to the upstream if configured
+	t.injectAccessTokenHeader(req, session)
+
 	// Set security headers
 	rw.Header().Set("X-Frame-Options", "DENY")
 	rw.Header().Set("X-Content-Type-Options", "nosniff")
 	rw.Header().Set("X-XSS-Protection", "1; mode=block")
 	rw.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
 
-	// Set CORS headers
-	origin := req.Header.Get("Origin")
-	if origin != "" {
-		rw.Header().Set("Access-Control-Allow-Origin", origin)
-		rw.Header().Set("Access-Control-Allow-Credentials", "true")
-		rw.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		rw.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	// Restart the idle timeout and report the remaining session lifetime
+	t.trackSessionActivity(rw, req, session)
+
+	// Set CORS headers for allowed origins only
+	if origin := req.Header.Get("Origin"); origin != "" && t.corsOriginAllowed(origin) {
+		setCORSHeaders(rw, origin)
 
 		// Handle preflight requests
-		if req.Method == "OPTIONS" {
+		if req.Method == http.MethodOptions {
 			rw.WriteHeader(http.StatusOK)
 			return
 		}
 	}
 
 	// Process the request
-	t.logger.Debugf("Request authorized for user %s, forwarding to next handler", email)
+	logger.Debugf("Request authorized for user %s, forwarding to next handler", email)
 	t.next.ServeHTTP(rw, req)
 }
 
@@ -884,17 +1717,23 @@ func (t *TraefikOidc) processAuthorizedRequest(rw http.ResponseWriter, req *http
 //   - session: The user's session data containing the expired token information.
 //   - redirectURL: The callback URL to be used in the new authentication flow.
 func (t *TraefikOidc) handleExpiredToken(rw http.ResponseWriter, req *http.Request, session *SessionData, redirectURL string) {
-	t.logger.Debug("Handling expired token: Clearing session and initiating re-authentication.")
+	logger := t.flowLogger(session)
+	logger.Debug("Handling expired token: Clearing session and initiating re-authentication.")
 	// Clear authentication data but preserve CSRF state if possible (though Clear might remove it)
 	session.SetAuthenticated(false)
 	session.SetAccessToken("")
+	session.SetAccessTokenExpiry(time.Time{})
 	session.SetRefreshToken("")
+	if session.GetProviderAccessToken() != "" {
+		session.SetProviderAccessToken("")
+	}
 	session.SetEmail("")
+	session.SetUserID("")
 
 	// Save the cleared session state (this sends expired cookies)
 	// Pass rw to ensure expiring cookies are sent
 	if err := session.Save(req, rw); err != nil {
-		t.logger.Errorf("Failed to save cleared session during expired token handling: %v", err)
+		logger.Errorf("Failed to save cleared session during expired token handling: %v", err)
 		// Still attempt to initiate authentication, but log the error
 	}
 
@@ -924,6 +1763,22 @@ func (t *TraefikOidc) handleExpiredToken(rw http.ResponseWriter, req *http.Reque
 //   - req: The incoming HTTP request to the callback URL.
 //   - redirectURL: The fully qualified callback URL (used in the token exchange request).
 func (t *TraefikOidc) handleCallback(rw http.ResponseWriter, req *http.Request, redirectURL string) {
+	setNoStoreHeaders(rw)
+	if t.rejectRedirectHost(rw, req) {
+		return
+	}
+
+	// Throttle clients hammering the callback before any session or token endpoint work
+	if t.callbackLimiter != nil {
+		if ip := t.clientIP(req); !t.callbackLimiter.allow(ip) {
+			t.logger.Infof("Too many callback requests from %s, rejecting", ip)
+			t.audit(req, AuditLoginFailure, "", "callback rate limit exceeded")
+			rw.Header().Set("Retry-After", "60")
+			http.Error(rw, "Too many login attempts, please try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	session, err := t.sessionManager.GetSession(req)
 	if err != nil {
 		t.logger.Errorf("Session error during callback: %v", err)
@@ -931,102 +1786,254 @@ func (t *TraefikOidc) handleCallback(rw http.ResponseWriter, req *http.Request,
 		return
 	}
 
-	t.logger.Debugf("Handling callback, URL: %s", req.URL.String())
+	t.logger.Debugf("Handling callback, URL: %s", redactedURL(req.URL))
+
+	// Implicit flow responses arrive in the URL fragment; have the browser resend them as query
+	if t.allowImplicitFlow && req.URL.RawQuery == "" {
+		t.sendImplicitCallbackPage(rw)
+		return
+	}
+
+	// Resolve logins started on another replica through the shared pending authorization store
+	t.restorePendingAuth(req, req.URL.Query().Get("state"), session)
+	logger := t.flowLogger(session)
+
+	silentRenew := session.GetSilentRenew()
+
+	// failLogin reports a failed login to the audit hook and answers with an error page
+	failLogin := func(message string, code int) {
+		t.audit(req, AuditLoginFailure, "", message)
+		t.sendErrorResponse(rw, req, message, code)
+	}
 
 	// Check for errors in the callback
 	if req.URL.Query().Get("error") != "" {
-		errorDescription := req.URL.Query().Get("error_description")
-		if errorDescription == "" {
-			errorDescription = req.URL.Query().Get("error") // Use error code if description is empty
+		errorCode := sanitizeProviderError(req.URL.Query().Get("error"))
+		errorDescription := sanitizeProviderError(req.URL.Query().Get("error_description"))
+		if silentRenew {
+			// login_required / interaction_required tell the client a visible login is needed
+			logger.Debugf("Silent renew rejected by provider: %s - %s", errorCode, errorDescription)
+			session.SetSilentRenew(false)
+			session.SetCSRF("")
+			session.SetNonce("")
+			session.SetCodeVerifier("")
+			if err := session.SaveMain(req, rw); err != nil {
+				logger.Errorf("Failed to save session after silent renew failure: %v", err)
+			}
+			t.audit(req, AuditLoginFailure, session.GetEmail(), "silent renew: "+errorCode)
+			t.sendSilentRenewResult(rw, req, errorCode)
+			return
+		}
+		if session.GetSilentLogin() {
+			logger.Infof("Silent login rejected by provider (%s), falling back to an interactive login", errorCode)
+			t.fallBackToInteractiveLogin(rw, req, session, false)
+			return
+		}
+		logger.Errorf("Authentication error from provider during callback: %s - %s", errorCode, errorDescription)
+		t.audit(req, AuditLoginFailure, "", "provider error: "+errorCode)
+		if t.onAuthError != nil {
+			t.onAuthError(errorCode, errorDescription, rw, req)
+			return
 		}
-		t.logger.Errorf("Authentication error from provider during callback: %s - %s", req.URL.Query().Get("error"), errorDescription)
-		t.sendErrorResponse(rw, req, fmt.Sprintf("Authentication error from provider: %s", errorDescription), http.StatusBadRequest)
+		message := errorDescription
+		if message == "" {
+			message = errorCode // Use error code if description is empty
+		}
+		t.sendErrorResponse(rw, req, "Authentication error from provider: "+message, http.StatusForbidden)
 		return
 	}
 
 	// Validate CSRF state
 	state := req.URL.Query().Get("state")
 	if state == "" {
-		t.logger.Error("No state in callback")
-		t.sendErrorResponse(rw, req, "State parameter missing in callback", http.StatusBadRequest)
+		logger.Error("No state in callback")
+		failLogin("State parameter missing in callback", http.StatusBadRequest)
 		return
 	}
 
 	csrfToken := session.GetCSRF()
 	if csrfToken == "" {
-		t.logger.Error("CSRF token missing in session during callback")
-		t.sendErrorResponse(rw, req, "CSRF token missing in session", http.StatusBadRequest)
+		if session.IsCSRFConsumed(state) {
+			logger.Error("State parameter was already used by an earlier callback")
+			failLogin("State parameter has already been used", http.StatusBadRequest)
+			return
+		}
+		logger.Error("CSRF token missing in session during callback")
+		failLogin("CSRF token missing in session", http.StatusBadRequest)
 		return
 	}
 
 	if state != csrfToken {
-		t.logger.Error("State parameter does not match CSRF token in session during callback")
-		t.sendErrorResponse(rw, req, "Invalid state parameter (CSRF mismatch)", http.StatusBadRequest)
+		logger.Error("State parameter does not match CSRF token in session during callback")
+		failLogin("Invalid state parameter (CSRF mismatch)", http.StatusBadRequest)
 		return
 	}
 
-	// Exchange code for tokens
-	code := req.URL.Query().Get("code")
-	if code == "" {
-		t.logger.Error("No code in callback")
-		t.sendErrorResponse(rw, req, "No authorization code received in callback", http.StatusBadRequest)
+	// The state is valid exactly once; persist its consumption before anything else can fail
+	issuedAt := session.GetCSRFIssuedAt()
+	session.ConsumeCSRF()
+	if err := session.SaveMain(req, rw); err != nil {
+		logger.Errorf("Failed to save consumed state: %v", err)
+		http.Error(rw, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+	if t.stateTTL > 0 && !issuedAt.IsZero() && time.Since(issuedAt) > t.stateTTL {
+		logger.Errorf("State parameter issued at %v exceeded its %v lifetime", issuedAt, t.stateTTL)
+		failLogin("Authorization request expired, please log in again", http.StatusBadRequest)
 		return
 	}
 
-	// Get the code verifier from the session for PKCE flow
-	codeVerifier := session.GetCodeVerifier()
+	var tokenResponse *TokenResponse
+	code := req.URL.Query().Get("code")
+	implicit := false
+	if idToken := req.URL.Query().Get("id_token"); t.allowImplicitFlow && code == "" && idToken != "" {
+		// Implicit flow: the tokens were returned directly and are verified below like any other
+		logger.Debug("Accepting tokens returned by the implicit flow")
+		implicit = true
+		session.SetRedirectURI("")
+		tokenResponse = &TokenResponse{IDToken: idToken, AccessToken: req.URL.Query().Get("access_token")}
+	} else {
+		// Exchange code for tokens
+		if code == "" {
+			logger.Error("No code in callback")
+			failLogin("No authorization code received in callback", http.StatusBadRequest)
+			return
+		}
 
-	tokenResponse, err := t.tokenExchanger.ExchangeCodeForToken(req.Context(), "authorization_code", code, redirectURL, codeVerifier)
-	if err != nil {
-		t.logger.Errorf("Failed to exchange code for token during callback: %v", err)
-		t.sendErrorResponse(rw, req, "Authentication failed: Could not exchange code for token", http.StatusInternalServerError)
-		return
+		// Get the code verifier from the session for PKCE flow
+		codeVerifier := session.GetCodeVerifier()
+
+		// The token request must repeat the redirect URI used in the authorization request
+		if storedRedirectURI := session.GetRedirectURI(); storedRedirectURI != "" {
+			redirectURL = storedRedirectURI
+		}
+		session.SetRedirectURI("")
+
+		tokenResponse, err = t.tokenExchanger.ExchangeCodeForToken(req.Context(), "authorization_code", code, redirectURL, codeVerifier)
+		if err != nil {
+			logger.Errorf("Failed to exchange code for token during callback: %v", err)
+			failLogin("Authentication failed: Could not exchange code for token", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	// Verify tokens and claims
-	if err := t.VerifyToken(tokenResponse.IDToken); err != nil {
-		t.logger.Errorf("Failed to verify id_token during callback: %v", err)
-		t.sendErrorResponse(rw, req, "Authentication failed: Could not verify ID token", http.StatusInternalServerError)
+	if err := t.VerifyToken(req.Context(), tokenResponse.IDToken); err != nil {
+		logger.Errorf("Failed to verify id_token during callback: %v", err)
+		failLogin("Authentication failed: Could not verify ID token", http.StatusInternalServerError)
 		return
 	}
 
 	claims, err := t.extractClaimsFunc(tokenResponse.IDToken)
 	if err != nil {
-		t.logger.Errorf("Failed to extract claims during callback: %v", err)
-		t.sendErrorResponse(rw, req, "Authentication failed: Could not extract claims from token", http.StatusInternalServerError)
+		logger.Errorf("Failed to extract claims during callback: %v", err)
+		failLogin("Authentication failed: Could not extract claims from token", http.StatusInternalServerError)
 		return
 	}
 
-	// Verify nonce to prevent replay attacks
+	// Verify nonce to prevent replay attacks. With allowMissingNonce a token without a
+	// nonce is accepted, but a nonce the provider did return must still match.
 	nonceClaim, ok := claims["nonce"].(string)
 	if !ok || nonceClaim == "" {
-		t.logger.Error("Nonce claim missing in id_token during callback")
-		t.sendErrorResponse(rw, req, "Authentication failed: Nonce missing in token", http.StatusInternalServerError)
-		return
+		if !t.relaxNonce {
+			logger.Error("Nonce claim missing in id_token during callback")
+			failLogin("Authentication failed: Nonce missing in token", http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Accepting id_token without a nonce claim: nonce enforcement is disabled")
+	} else {
+		sessionNonce := session.GetNonce()
+		if sessionNonce == "" {
+			logger.Error("Nonce not found in session during callback")
+			failLogin("Authentication failed: Nonce missing in session", http.StatusInternalServerError)
+			return
+		}
+
+		if nonceClaim != sessionNonce {
+			logger.Error("Nonce claim does not match session nonce during callback")
+			failLogin("Authentication failed: Nonce mismatch", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	sessionNonce := session.GetNonce()
-	if sessionNonce == "" {
-		t.logger.Error("Nonce not found in session during callback")
-		t.sendErrorResponse(rw, req, "Authentication failed: Nonce missing in session", http.StatusInternalServerError)
-		return
+	// An implicit flow access token arrives in the URL next to the ID token and could have been
+	// issued to another client; it is only used when the ID token's at_hash binds it
+	if implicit && tokenResponse.AccessToken != "" {
+		atHash, _ := claims["at_hash"].(string)
+		if atHash == "" {
+			logger.Info("Dropping the implicit flow access_token: the ID token carries no at_hash")
+			tokenResponse.AccessToken = ""
+		} else {
+			var alg string
+			if jwt, err := parseJWT(tokenResponse.IDToken); err == nil {
+				alg, _ = jwt.Header["alg"].(string)
+			}
+			if err := verifyAccessTokenHash(tokenResponse.AccessToken, atHash, alg); err != nil {
+				logger.Errorf("Implicit flow access_token rejected during callback: %v", err)
+				failLogin("Authentication failed: Access token does not match ID token", http.StatusInternalServerError)
+				return
+			}
+		}
 	}
 
-	if nonceClaim != sessionNonce {
-		t.logger.Error("Nonce claim does not match session nonce during callback")
-		t.sendErrorResponse(rw, req, "Authentication failed: Nonce mismatch", http.StatusInternalServerError)
+	// With maxAuthAgeSeconds the provider must report a recent enough authentication
+	var authTime time.Time
+	if value, ok := claims["auth_time"].(float64); ok {
+		authTime = time.Unix(int64(value), 0)
+	}
+	if t.maxAuthAge > 0 && (authTime.IsZero() || time.Since(authTime) > t.maxAuthAge+ClockSkewTolerance) {
+		// Ask the provider once for a fresh login; a forced login that is still too old
+		// fails instead of looping
+		if !session.GetAuthAgeStepUp() {
+			logger.Infof("auth_time %v missing or older than maxAuthAgeSeconds during callback, requesting a fresh login", authTime)
+			t.fallBackToInteractiveLogin(rw, req, session, true)
+			return
+		}
+		logger.Errorf("auth_time %v missing or older than maxAuthAgeSeconds after a forced login during callback", authTime)
+		failLogin("Authentication failed: Authentication is too old", http.StatusUnauthorized)
 		return
 	}
 
-	// Validate user's email domain
-	email, _ := claims["email"].(string)
-	if email == "" {
-		t.logger.Errorf("Email claim missing or empty in token during callback")
-		t.sendErrorResponse(rw, req, "Authentication failed: Email missing in token", http.StatusInternalServerError)
+	// Google Workspace domain restriction, checked on the ID token itself before any
+	// UserInfo claims are merged in
+	if t.hostedDomain != "" {
+		if hd, _ := claims["hd"].(string); !strings.EqualFold(hd, t.hostedDomain) {
+			logger.Errorf("Hosted domain %q does not match googleHostedDomain during callback", hd)
+			email, _ := claims["email"].(string)
+			t.audit(req, AuditLoginFailure, email, "hosted domain not allowed")
+			t.sendErrorResponse(rw, req, "Authentication failed: Hosted domain not allowed", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Enrich the claims with the provider's UserInfo response; a failed fetch keeps the
+	// ID token claims, but a response for a different subject fails the login
+	var userInfo map[string]interface{}
+	if t.enableUserInfo && tokenResponse.AccessToken != "" {
+		userInfo, err = t.fetchUserInfo(req.Context(), tokenResponse.AccessToken)
+		if err != nil {
+			logger.Errorf("Failed to fetch UserInfo during callback: %v", err)
+		} else if claims, err = mergeUserInfoClaims(claims, userInfo); err != nil {
+			logger.Errorf("Rejecting UserInfo response during callback: %v", err)
+			failLogin("Authentication failed: UserInfo subject mismatch", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Validate user's identity and email domain
+	userID, email := t.userIdentity(claims)
+	if userID == "" {
+		logger.Errorf("User ID claim %s missing or empty in token during callback", t.userIDClaimName())
+		if t.userIDClaimName() == DefaultUserIDClaim {
+			failLogin("Authentication failed: Email missing in token", http.StatusInternalServerError)
+		} else {
+			failLogin("Authentication failed: User ID missing in token", http.StatusInternalServerError)
+		}
 		return
 	}
 	if !t.isAllowedDomain(email) {
-		t.logger.Errorf("Disallowed email domain during callback: %s", email)
+		logger.Errorf("Disallowed email domain during callback: %s", email)
+		t.audit(req, AuditLoginFailure, email, "email domain not allowed")
 		t.sendErrorResponse(rw, req, "Authentication failed: Email domain not allowed", http.StatusForbidden)
 		return
 	}
@@ -1034,18 +2041,60 @@ func (t *TraefikOidc) handleCallback(rw http.ResponseWriter, req *http.Request,
 	// Update session with authentication data
 	// Regenerate session ID upon successful authentication
 	if err := session.SetAuthenticated(true); err != nil {
-		t.logger.Errorf("Failed to set authenticated state and regenerate session ID: %v", err)
+		logger.Errorf("Failed to set authenticated state and regenerate session ID: %v", err)
 		http.Error(rw, "Failed to update session", http.StatusInternalServerError)
 		return
 	}
 	session.SetEmail(email)
-	session.SetAccessToken(tokenResponse.IDToken)
-	session.SetRefreshToken(tokenResponse.RefreshToken)
+	session.SetUserID(userID)
+	session.SetUserInfo(userInfo)
+	session.SetAuthTime(authTime)
+	if t.claimsRefreshInterval > 0 {
+		session.SetClaimsUpdatedAt(time.Now())
+	}
+	sub, _ := claims["sub"].(string)
+	session.SetSubject(sub)
+	session.SetAMR(authMethods(claims))
+	if err := t.sessionManager.BindUserSession(session); err != nil {
+		logger.Errorf("Failed to bind session to user %s: %v", userID, err)
+		http.Error(rw, "Failed to update session", http.StatusInternalServerError)
+		return
+	}
+	if err := session.SetAccessToken(tokenResponse.IDToken); err != nil {
+		logger.Errorf("Failed to store access token in session: %v", err)
+		http.Error(rw, "Failed to update session", http.StatusInternalServerError)
+		return
+	}
+	session.SetAccessTokenExpiry(tokenResponse.expiresAt())
+	if err := session.SetRefreshToken(tokenResponse.RefreshToken); err != nil {
+		logger.Errorf("Failed to store refresh token in session: %v", err)
+		http.Error(rw, "Failed to update session", http.StatusInternalServerError)
+		return
+	}
+	if err := t.storeProviderAccessToken(session, tokenResponse.AccessToken); err != nil {
+		logger.Errorf("Failed to store provider access token in session: %v", err)
+		http.Error(rw, "Failed to update session", http.StatusInternalServerError)
+		return
+	}
 
 	// Clear CSRF, Nonce, CodeVerifier after use
 	session.SetCSRF("")
 	session.SetNonce("")
 	session.SetCodeVerifier("")
+	session.SetSilentLogin(false)
+
+	if silentRenew {
+		session.SetSilentRenew(false)
+		if err := session.Save(req, rw); err != nil {
+			logger.Errorf("Failed to save session after silent renew: %v", err)
+			http.Error(rw, "Failed to save session after callback", http.StatusInternalServerError)
+			return
+		}
+		logger.Debug("Silent renew successful")
+		t.audit(req, AuditLoginSuccess, email, "silent renew")
+		t.sendSilentRenewResult(rw, req, "success")
+		return
+	}
 
 	// Retrieve original path *before* saving, as save might clear it if Clear was called concurrently
 	redirectPath := "/"
@@ -1053,15 +2102,29 @@ func (t *TraefikOidc) handleCallback(rw http.ResponseWriter, req *http.Request,
 		redirectPath = incomingPath
 	}
 	session.SetIncomingPath("") // Clear incoming path after retrieving it
+	fragment := session.GetIncomingFragment()
+	session.SetIncomingFragment("")
 
 	if err := session.Save(req, rw); err != nil {
-		t.logger.Errorf("Failed to save session after callback: %v", err)
+		logger.Errorf("Failed to save session after callback: %v", err)
 		http.Error(rw, "Failed to save session after callback", http.StatusInternalServerError)
 		return
 	}
 
+	t.audit(req, AuditLoginSuccess, email, "")
+
 	// Redirect to original path or root
-	t.logger.Debugf("Callback successful, redirecting to %s", redirectPath)
+	logger.Debugf("Callback successful, redirecting to %s%s", redirectPath, fragment)
+	if t.postLoginHTMLRedirect {
+		t.sendPostLoginPage(rw, redirectPath+fragment)
+		return
+	}
+	if fragment != "" {
+		// Set Location directly: http.Redirect would path-clean the fragment
+		rw.Header().Set("Location", redirectPath+fragment)
+		rw.WriteHeader(http.StatusFound)
+		return
+	}
 	http.Redirect(rw, req, redirectPath, http.StatusFound)
 }
 
@@ -1085,8 +2148,8 @@ func (t *TraefikOidc) determineExcludedURL(currentRequest string) bool {
 }
 
 // determineScheme determines the request scheme (http or https).
-// It prioritizes the X-Forwarded-Proto header if present, otherwise checks
-// the TLS property of the request. Defaults to "http".
+// It prioritizes the X-Forwarded-Proto header if present and sent by a trusted proxy,
+// otherwise checks the TLS property of the request. Defaults to "http".
 //
 // Parameters:
 //   - req: The incoming HTTP request.
@@ -1094,7 +2157,7 @@ func (t *TraefikOidc) determineExcludedURL(currentRequest string) bool {
 // Returns:
 //   - "https" or "http".
 func (t *TraefikOidc) determineScheme(req *http.Request) string {
-	if scheme := req.Header.Get("X-Forwarded-Proto"); scheme != "" {
+	if scheme := req.Header.Get("X-Forwarded-Proto"); scheme != "" && t.trustsForwardedHeaders(req) {
 		return scheme
 	}
 	if req.TLS != nil {
@@ -1104,7 +2167,8 @@ func (t *TraefikOidc) determineScheme(req *http.Request) string {
 }
 
 // determineHost determines the request host.
-// It prioritizes the X-Forwarded-Host header if present, otherwise uses the req.Host value.
+// It prioritizes the X-Forwarded-Host header if present and sent by a trusted proxy,
+// otherwise uses the req.Host value.
 //
 // Parameters:
 //   - req: The incoming HTTP request.
@@ -1112,7 +2176,7 @@ func (t *TraefikOidc) determineScheme(req *http.Request) string {
 // Returns:
 //   - The determined host string (e.g., "example.com:8080").
 func (t *TraefikOidc) determineHost(req *http.Request) string {
-	if host := req.Header.Get("X-Forwarded-Host"); host != "" {
+	if host := req.Header.Get("X-Forwarded-Host"); host != "" && t.trustsForwardedHeaders(req) {
 		return host
 	}
 	return req.Host
@@ -1124,13 +2188,14 @@ func (t *TraefikOidc) determineHost(req *http.Request) string {
 // token is within the configured refreshGracePeriod before its actual expiration.
 //
 // Parameters:
+//   - ctx: Cancels provider requests made during verification, e.g. introspection.
 //   - session: The SessionData object for the current user.
 //
 // Returns:
 //   - authenticated (bool): True if the session is marked authenticated and the token is present and valid (signature/claims ok, not expired beyond grace).
 //   - needsRefresh (bool): True if the token is valid but nearing expiration (within refreshGracePeriod) OR if VerifyJWTSignatureAndClaims failed specifically due to expiration (meaning refresh might be possible).
 //   - expired (bool): True if the session is unauthenticated, the token is missing, or the token verification failed for reasons other than nearing/actual expiration (e.g., invalid signature, invalid claims).
-func (t *TraefikOidc) isUserAuthenticated(session *SessionData) (bool, bool, bool) {
+func (t *TraefikOidc) isUserAuthenticated(ctx context.Context, session *SessionData) (bool, bool, bool) {
 	if !session.GetAuthenticated() {
 		t.logger.Debug("User is not authenticated according to session flag")
 		// Check if there's still a refresh token - if so, refresh might be possible
@@ -1143,51 +2208,63 @@ func (t *TraefikOidc) isUserAuthenticated(session *SessionData) (bool, bool, boo
 
 	accessToken := session.GetAccessToken()
 	if accessToken == "" {
-		t.logger.Debug("Authenticated flag set, but no access token found in session")
-		// If authenticated flag is true but token is missing, treat as expired/invalid session state
-		// Check for refresh token before declaring fully expired
+		// The session claims to be authenticated but its access token cannot be recovered,
+		// typically because the browser evicted some of its chunk cookies. Never forward such a
+		// session: recover the token with a refresh, or start over with a clean login.
 		if session.GetRefreshToken() != "" {
-			t.logger.Debug("Authenticated flag set, access token missing, but refresh token exists. Signaling need for refresh.")
+			t.logger.Infof("Authenticated session of %s has no recoverable access token, refreshing it", session.GetEmail())
 			return false, true, false // Not authenticated (no access token), NeedsRefresh=true, Expired=false
 		}
+		t.logger.Infof("Authenticated session of %s has no recoverable access or refresh token, re-authenticating", session.GetEmail())
 		return false, false, true // No access or refresh token, treat as expired
 	}
 
-	// Verify the token structure and signature first
-	jwt, err := parseJWT(accessToken)
-	if err != nil {
-		t.logger.Errorf("Failed to parse JWT during auth check: %v", err)
-		// Check for refresh token before declaring fully expired
-		if session.GetRefreshToken() != "" {
-			t.logger.Debug("Access token parsing failed, but refresh token exists. Signaling need for refresh.")
-			return false, true, false // Not authenticated (bad access token), NeedsRefresh=true, Expired=false
-		}
-		return false, false, true // Invalid format, no refresh token, treat as expired/invalid
-	}
-	if err := t.VerifyJWTSignatureAndClaims(jwt, accessToken); err != nil {
-		// Check if the error is specifically about expiration
-		if strings.Contains(err.Error(), "token has expired") {
-			t.logger.Debugf("Access token signature/claims valid but token expired, needs refresh")
-			// Token is expired but otherwise valid, signal for refresh
-			// Return authenticated=false because the current token is unusable
-			// NeedsRefresh is true only if a refresh token exists
+	// Reuse the claims of an access token that was already verified; the cache entry
+	// expires together with the token, so only the first request pays for verification.
+	claims, cached := t.tokenCache.Get(accessToken)
+	if !cached || len(claims) == 0 {
+		// Verify the token structure and signature first
+		jwt, err := parseJWT(accessToken)
+		if err != nil {
+			t.logger.Errorf("Failed to parse JWT during auth check: %v", err)
+			// Check for refresh token before declaring fully expired
 			if session.GetRefreshToken() != "" {
-				return false, true, false // Not authenticated (current token unusable), NeedsRefresh=true, Expired=false (because refresh might fix it)
+				t.logger.Debug("Access token parsing failed, but refresh token exists. Signaling need for refresh.")
+				return false, true, false // Not authenticated (bad access token), NeedsRefresh=true, Expired=false
 			}
-			return false, false, true // Expired access token, no refresh token, treat as expired
+			return false, false, true // Invalid format, no refresh token, treat as expired/invalid
 		}
-		// Other verification error (signature, issuer, audience etc.)
-		t.logger.Errorf("Access token verification failed (non-expiration): %v", err)
-		// Check for refresh token before declaring fully expired
-		if session.GetRefreshToken() != "" {
-			t.logger.Debug("Access token verification failed, but refresh token exists. Signaling need for refresh.")
-			return false, true, false // Not authenticated (bad access token), NeedsRefresh=true, Expired=false
+		if err := t.VerifyJWTSignatureAndClaims(ctx, jwt, accessToken); err != nil {
+			// Check if the error is specifically about expiration
+			if strings.Contains(err.Error(), "token has expired") {
+				t.logger.Debugf("Access token signature/claims valid but token expired, needs refresh")
+				// Token is expired but otherwise valid, signal for refresh
+				// Return authenticated=false because the current token is unusable
+				// NeedsRefresh is true only if a refresh token exists
+				if session.GetRefreshToken() != "" {
+					return false, true, false // Not authenticated (current token unusable), NeedsRefresh=true, Expired=false (because refresh might fix it)
+				}
+				return false, false, true // Expired access token, no refresh token, treat as expired
+			}
+			// Other verification error (signature, issuer, audience etc.)
+			t.logger.Errorf("Access token verification failed (non-expiration): %v", err)
+			// Check for refresh token before declaring fully expired
+			if session.GetRefreshToken() != "" {
+				t.logger.Debug("Access token verification failed, but refresh token exists. Signaling need for refresh.")
+				return false, true, false // Not authenticated (bad access token), NeedsRefresh=true, Expired=false
+			}
+			return false, false, true // Token is invalid for other reasons, no refresh token, treat as expired/invalid session
 		}
-		return false, false, true // Token is invalid for other reasons, no refresh token, treat as expired/invalid session
+
+		// Claims already parsed within VerifyJWTSignatureAndClaims if it didn't error early
+		claims = jwt.Claims
+		t.cacheVerifiedToken(accessToken, claims)
 	}
 
-	// Claims already parsed within VerifyJWTSignatureAndClaims if it didn't error early
-	claims := jwt.Claims
+	if subjectMismatch(session, claims) {
+		t.logger.Infof("Session token subject changed from %s, resetting the session", session.GetSubject())
+		return false, false, true // A token of a different user is never accepted, treat as expired
+	}
 
 	expClaim, ok := claims["exp"].(float64)
 	if !ok {
@@ -1205,11 +2282,17 @@ func (t *TraefikOidc) isUserAuthenticated(session *SessionData) (bool, bool, boo
 	// Expiration check is now handled within VerifyJWTSignatureAndClaims logic above
 	// We only get here if the token is valid and not expired
 
+	// The provider's access token may expire before the ID token; refresh for whichever comes first
+	expiresAt := time.Unix(expTime, 0)
+	if accessExpiry := session.GetAccessTokenExpiry(); !accessExpiry.IsZero() && accessExpiry.Before(expiresAt) {
+		expiresAt = accessExpiry
+	}
+
 	// Check if token is nearing expiration (needs refresh proactively)
 	// Check if token is nearing expiration using the configured grace period
-	if time.Unix(expTime, 0).Before(time.Now().Add(t.refreshGracePeriod)) {
+	if expiresAt.Before(time.Now().Add(t.refreshGracePeriod)) {
 		// Recalculate remaining seconds for logging clarity if needed, using the configured duration
-		remainingSeconds := int64(time.Until(time.Unix(expTime, 0)).Seconds())
+		remainingSeconds := int64(time.Until(expiresAt).Seconds())
 		t.logger.Debugf("Access token nearing expiration (expires in %d seconds, grace period %s), scheduling proactive refresh", remainingSeconds, t.refreshGracePeriod)
 		// Token is still valid, but we should refresh it soon
 		// NeedsRefresh is true only if a refresh token exists
@@ -1238,72 +2321,452 @@ func (t *TraefikOidc) isUserAuthenticated(session *SessionData) (bool, bool, boo
 //   - session: The user's SessionData object (potentially new or cleared).
 //   - redirectURL: The pre-calculated callback URL (redirect_uri) for this middleware instance.
 func (t *TraefikOidc) defaultInitiateAuthentication(rw http.ResponseWriter, req *http.Request, session *SessionData, redirectURL string) {
-	t.logger.Debugf("Initiating new OIDC authentication flow for request: %s", req.URL.RequestURI())
-	// Generate CSRF token and nonce
-	csrfToken := uuid.NewString()
-	nonce, err := generateNonce()
+	t.initiateAuthentication(rw, req, session, redirectURL, false)
+}
+
+// initiateAuthentication starts an OIDC authentication flow as described for
+// defaultInitiateAuthentication. A silent flow asks the provider not to interact with the user
+// (prompt=none) and marks the session so that the callback falls back to an interactive login
+// if the provider cannot authenticate the user silently. Logins that must be interactive, such
+// as MFA step-ups and too old authentications, are never silent.
+//
+// Parameters:
+//   - rw: The HTTP response writer used to send the redirect response.
+//   - req: The original incoming HTTP request that requires authentication.
+//   - session: The user's SessionData object (potentially new or cleared).
+//   - redirectURL: The pre-calculated callback URL (redirect_uri) for this middleware instance.
+//   - silent: true to request a prompt=none login.
+func (t *TraefikOidc) initiateAuthentication(rw http.ResponseWriter, req *http.Request, session *SessionData, redirectURL string, silent bool) {
+	if t.rejectRedirectHost(rw, req) {
+		return
+	}
+	correlationID := t.newCorrelationID(rw)
+	logger := t.correlatedLogger(correlationID)
+	logger.Debugf("Initiating new OIDC authentication flow for request: %s", req.URL.RequestURI())
+	csrfToken, nonce, codeVerifier, codeChallenge, ok := t.generateAuthRequestState(rw)
+	if !ok {
+		return
+	}
+
+	// A session whose authentication is too old, or did not use a required second factor,
+	// must not be silently re-authenticated
+	stepUp := t.needsMFAStepUp(session)
+	authAgeStepUp := t.authTooOld(session)
+	forceLogin := authAgeStepUp || stepUp
+	silent = silent && !forceLogin
+	loginHintToken := t.loginHintToken(req, session)
+
+	// Clear any existing session data to avoid stale state causing redirect loops
+	// Pass the response writer to ensure expiring cookies are sent
+	if err := session.Clear(req, rw); err != nil {
+		// Log the error but continue, as clearing is best-effort before re-auth
+		logger.Errorf("Error clearing session before initiating authentication: %v", err)
+	}
+
+	// Set new session values
+	session.SetCorrelationID(correlationID)
+	session.SetCSRF(csrfToken)
+	session.SetNonce(nonce)
+	if t.enablePKCE {
+		session.SetCodeVerifier(codeVerifier)
+	}
+	// Remember the exact redirect URI so the token exchange sends the same value
+	session.SetRedirectURI(redirectURL)
+	session.SetMFAStepUp(stepUp)
+	session.SetAuthAgeStepUp(authAgeStepUp)
+	session.SetSilentLogin(silent)
+
+	// Store the original path the user was trying to access, plus any fragment captured in the browser
+	incomingPath := req.URL.RequestURI()
+	if t.preserveFragment {
+		if fragment, path, ok := extractFragmentParam(req.URL); ok {
+			incomingPath = path
+			session.SetIncomingFragment(fragment)
+			logger.Debugf("Storing incoming fragment: %s", fragment)
+		}
+	}
+	session.SetIncomingPath(incomingPath)
+	logger.Debugf("Storing incoming path: %s", incomingPath)
+	if len(t.restoreHeaders) > 0 {
+		session.SetIncomingHeaders(incomingPath, t.captureHeaders(req))
+	}
+	if t.rememberMeParam != "" {
+		session.SetRememberMe(isTruthyParam(req.URL.Query().Get(t.rememberMeParam)))
+	}
+
+	t.storePendingAuth(rw, req, csrfToken, session)
+
+	// Save the main session (to store CSRF, Nonce, etc.); Clear has already expired the token cookies
+	if err := session.SaveMain(req, rw); err != nil {
+		logger.Errorf("Failed to save session before redirecting to provider: %v", err)
+		http.Error(rw, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	// Build authentication URL and send the user (or client) towards it
+	authURL := t.buildAuthURL(redirectURL, csrfToken, nonce, codeChallenge)
+	if forceLogin {
+		authURL = setURLQueryParam(authURL, "prompt", "login")
+	} else if silent {
+		authURL = setURLQueryParam(authURL, "prompt", "none")
+	}
+	if loginHintToken != "" {
+		authURL = setLoginHintToken(authURL, loginHintToken)
+	}
+	t.sendUnauthenticatedResponse(rw, req, authURL)
+}
+
+// sendUnauthenticatedResponse answers a request that requires authentication according to
+// the configured unauthenticated mode:
+//   - redirect: a 302 redirect to the provider's authorization endpoint (default).
+//   - json-401: a 401 with a JSON body {"error":"unauthenticated","login_url":"..."}, letting
+//     single-page apps start the login themselves.
+//   - custom: a 401 rendering the configured HTML template with {{.LoginURL}} available.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - req: The HTTP request.
+//   - authURL: The fully built authorization URL for the new login attempt.
+func (t *TraefikOidc) sendUnauthenticatedResponse(rw http.ResponseWriter, req *http.Request, authURL string) {
+	setNoStoreHeaders(rw)
+	switch t.unauthenticatedMode {
+	case UnauthenticatedModeJSON:
+		t.logger.Debugf("Returning 401 with login URL: %s", authURL)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(rw).Encode(map[string]string{"error": "unauthenticated", "login_url": authURL})
+	case UnauthenticatedModeCustom:
+		t.logger.Debugf("Serving custom unauthenticated page with login URL: %s", authURL)
+		var buf bytes.Buffer
+		if err := t.unauthenticatedPage.Execute(&buf, struct{ LoginURL string }{LoginURL: authURL}); err != nil {
+			t.logger.Errorf("Failed to render unauthenticated page, redirecting instead: %v", err)
+			http.Redirect(rw, req, authURL, http.StatusFound)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rw.Write(buf.Bytes())
+	default:
+		if t.shouldCaptureFragment(req) {
+			t.sendFragmentCapturePage(rw, authURL)
+			return
+		}
+		t.logger.Debugf("Redirecting user to OIDC provider: %s", authURL)
+		http.Redirect(rw, req, authURL, http.StatusFound)
+	}
+}
+
+// fragmentCapturePage is served instead of the login redirect when fragment preservation is
+// enabled. If the current URL has a fragment, the page reloads itself with the fragment copied
+// into the _oidc_fragment query parameter so the server can store it; otherwise it continues
+// to the provider. Browsers without JavaScript fall back to a meta refresh.
+var fragmentCapturePage = htmltemplate.Must(htmltemplate.New("fragment-capture").Parse(`<!DOCTYPE html>
+<html><head><title>Signing in</title>
+<noscript><meta http-equiv="refresh" content="0;url={{.LoginURL}}"></noscript>
+</head><body><script>
+(function () {
+  var hash = window.location.hash;
+  if (hash && hash.length > 1) {
+    var url = new URL(window.location.href);
+    url.hash = "";
+    url.searchParams.set({{.Param}}, hash);
+    window.location.replace(url.toString());
+  } else {
+    window.location.replace({{.LoginURL}});
+  }
+})();
+</script></body></html>`))
+
+// implicitCallbackPage is served on callbacks without a query string when the implicit flow
+// is allowed. Providers return implicit flow tokens in the URL fragment, which never reaches
+// the server, so the page resends the fragment's parameters as the query string.
+var implicitCallbackPage = htmltemplate.Must(htmltemplate.New("implicit-callback").Parse(`<!DOCTYPE html>
+<html><head><title>Signing in</title></head><body>
+<noscript>JavaScript is required to complete the sign-in.</noscript>
+<script>
+(function () {
+  var hash = window.location.hash;
+  if (hash && hash.length > 1) {
+    window.location.replace(window.location.pathname + "?" + hash.substring(1));
+  } else {
+    document.body.appendChild(document.createTextNode("Missing authentication response."));
+  }
+})();
+</script></body></html>`))
+
+// postLoginPage is served instead of the post-login 302 when postLoginHtmlRedirect is enabled.
+// Navigating from a loaded page rather than a redirect makes browsers with strict cookie
+// handling commit the session cookies set by the callback before the original page is
+// requested. Browsers without JavaScript fall back to a meta refresh.
+var postLoginPage = htmltemplate.Must(htmltemplate.New("post-login").Parse(`<!DOCTYPE html>
+<html><head><title>Signing in</title>
+<noscript><meta http-equiv="refresh" content="0;url={{.}}"></noscript>
+</head><body>
+<p><a href="{{.}}">Continue</a></p>
+<script>
+(function () {
+  window.location.replace({{.}});
+})();
+</script></body></html>`))
+
+// sendPostLoginPage writes the page that navigates to the original destination after a
+// successful callback.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - location: The destination path, including any restored fragment.
+func (t *TraefikOidc) sendPostLoginPage(rw http.ResponseWriter, location string) {
+	setNoStoreHeaders(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+	rw.WriteHeader(http.StatusOK)
+	if err := postLoginPage.Execute(rw, location); err != nil {
+		t.logger.Errorf("Failed to render post-login page: %v", err)
+	}
+}
+
+// logoutConfirmationPage is served for GET requests to the logout path when
+// logoutConfirmation is enabled. Its form posts the session's CSRF token back to the
+// logout path.
+var logoutConfirmationPage = htmltemplate.Must(htmltemplate.New("logout-confirmation").Parse(`<!DOCTYPE html>
+<html><head><title>Log out</title></head><body>
+<form method="POST" action="{{.Action}}">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<p>Do you want to log out?</p>
+<button type="submit">Log out</button>
+</form>
+</body></html>`))
+
+// sendLogoutConfirmationPage writes the page asking the user to confirm logout.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - csrfToken: The session's CSRF token, which the confirming POST must carry.
+func (t *TraefikOidc) sendLogoutConfirmationPage(rw http.ResponseWriter, csrfToken string) {
+	setNoStoreHeaders(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+	rw.Header().Set("X-Frame-Options", "DENY")
+	rw.WriteHeader(http.StatusOK)
+	data := struct{ Action, CSRF string }{Action: t.logoutURLPath, CSRF: csrfToken}
+	if err := logoutConfirmationPage.Execute(rw, data); err != nil {
+		t.logger.Errorf("Failed to render logout confirmation page: %v", err)
+	}
+}
+
+// sendImplicitCallbackPage writes the page that resends an implicit flow response from the
+// URL fragment to the callback as query parameters.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+func (t *TraefikOidc) sendImplicitCallbackPage(rw http.ResponseWriter) {
+	t.logger.Debug("Serving implicit flow callback page to read the response from the URL fragment")
+	setNoStoreHeaders(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Referrer-Policy", "no-referrer")
+	rw.WriteHeader(http.StatusOK)
+	if err := implicitCallbackPage.Execute(rw, nil); err != nil {
+		t.logger.Errorf("Failed to render implicit flow callback page: %v", err)
+	}
+}
+
+// shouldCaptureFragment reports whether a login redirect should be replaced by the fragment
+// capture page: fragment preservation is enabled, the request is a browser page load, and the
+// fragment has not been captured already.
+//
+// Parameters:
+//   - req: The request that requires authentication.
+//
+// Returns:
+//   - true if the fragment capture page should be served.
+func (t *TraefikOidc) shouldCaptureFragment(req *http.Request) bool {
+	if !t.preserveFragment || req.Method != http.MethodGet {
+		return false
+	}
+	if !strings.Contains(req.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	return !req.URL.Query().Has(fragmentParam)
+}
+
+// sendFragmentCapturePage writes the page that captures the URL fragment before continuing
+// to the provider's authorization endpoint.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - authURL: The fully built authorization URL for the new login attempt.
+func (t *TraefikOidc) sendFragmentCapturePage(rw http.ResponseWriter, authURL string) {
+	t.logger.Debug("Serving fragment capture page before redirecting to OIDC provider")
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	if err := fragmentCapturePage.Execute(rw, struct{ LoginURL, Param string }{LoginURL: authURL, Param: fragmentParam}); err != nil {
+		t.logger.Errorf("Failed to render fragment capture page: %v", err)
+	}
+}
+
+// extractFragmentParam reads a fragment captured by the fragment capture page from the
+// request URL and returns the request URI with the capture parameter removed. Fragments that
+// do not start with "#", exceed maxFragmentLength or contain control characters are dropped.
+//
+// Parameters:
+//   - u: The request URL.
+//
+// Returns:
+//   - The captured fragment, including the leading "#", or "" if it was invalid.
+//   - The request URI without the capture parameter.
+//   - true if the capture parameter was present.
+func extractFragmentParam(u *url.URL) (string, string, bool) {
+	query := u.Query()
+	if !query.Has(fragmentParam) {
+		return "", u.RequestURI(), false
+	}
+	fragment := query.Get(fragmentParam)
+	query.Del(fragmentParam)
+
+	stripped := *u
+	stripped.RawQuery = query.Encode()
+	path := stripped.RequestURI()
+
+	if !strings.HasPrefix(fragment, "#") || len(fragment) > maxFragmentLength {
+		return "", path, true
+	}
+	for _, r := range fragment {
+		if r < 0x20 || r == 0x7f {
+			return "", path, true
+		}
+	}
+	return fragment, path, true
+}
+
+// generateAuthRequestState creates the per-request values of a new authorization request:
+// the CSRF token (sent as state), the OIDC nonce and, if PKCE is enabled, the code verifier
+// and its derived challenge. On failure an error response is written to rw.
+//
+// Parameters:
+//   - rw: The HTTP response writer used to report generation failures.
+//
+// Returns:
+//   - The CSRF token, nonce, code verifier and code challenge (the latter two empty without PKCE).
+//   - false if any value could not be generated and an error response was sent.
+func (t *TraefikOidc) generateAuthRequestState(rw http.ResponseWriter) (string, string, string, string, bool) {
+	// Generate a fresh CSRF token and nonce for every authorization attempt
+	csrfToken, err := generateSecureRandomString(t.random, 32)
+	if err != nil {
+		t.logger.Errorf("Failed to generate CSRF token: %v", err)
+		http.Error(rw, "Failed to generate CSRF token", http.StatusInternalServerError)
+		return "", "", "", "", false
+	}
+	nonce, err := generateNonce(t.random)
 	if err != nil {
 		t.logger.Errorf("Failed to generate nonce: %v", err)
 		http.Error(rw, "Failed to generate nonce", http.StatusInternalServerError)
-		return
+		return "", "", "", "", false
 	}
 
 	// Generate PKCE code verifier and challenge if PKCE is enabled
 	var codeVerifier, codeChallenge string
 	if t.enablePKCE {
-		var err error
-		codeVerifier, err = generateCodeVerifier()
+		codeVerifier, err = generateCodeVerifier(t.random)
 		if err != nil {
 			t.logger.Errorf("Failed to generate code verifier: %v", err)
 			http.Error(rw, "Failed to generate code verifier", http.StatusInternalServerError)
-			return
+			return "", "", "", "", false
 		}
 		codeChallenge = deriveCodeChallenge(codeVerifier)
 		t.logger.Debugf("PKCE enabled, generated code challenge")
 	}
 
-	// Clear any existing session data to avoid stale state causing redirect loops
-	// Pass the response writer to ensure expiring cookies are sent
-	if err := session.Clear(req, rw); err != nil {
-		// Log the error but continue, as clearing is best-effort before re-auth
-		t.logger.Errorf("Error clearing session before initiating authentication: %v", err)
+	return csrfToken, nonce, codeVerifier, codeChallenge, true
+}
+
+// handleSilentRenew starts a silent re-authentication (prompt=none), intended to be loaded in a
+// hidden iframe by single-page apps. Unlike defaultInitiateAuthentication it keeps the existing
+// session intact and only stores the state, nonce and PKCE verifier for the new request, marking
+// it as silent so that handleCallback answers with a postMessage page rather than a redirect.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - req: The HTTP request to the silent renew endpoint.
+//   - session: The user's current session data.
+//   - redirectURL: The callback URL (redirect_uri) for the authorization request.
+func (t *TraefikOidc) handleSilentRenew(rw http.ResponseWriter, req *http.Request, session *SessionData, redirectURL string) {
+	setNoStoreHeaders(rw)
+	if t.rejectRedirectHost(rw, req) {
+		return
+	}
+
+	logger := t.flowLogger(session)
+
+	logger.Debug("Initiating silent renew (prompt=none)")
+	csrfToken, nonce, codeVerifier, codeChallenge, ok := t.generateAuthRequestState(rw)
+	if !ok {
+		return
 	}
 
-	// Set new session values
 	session.SetCSRF(csrfToken)
 	session.SetNonce(nonce)
 	if t.enablePKCE {
 		session.SetCodeVerifier(codeVerifier)
 	}
-	// Store the original path the user was trying to access
-	session.SetIncomingPath(req.URL.RequestURI())
-	t.logger.Debugf("Storing incoming path: %s", req.URL.RequestURI())
+	session.SetSilentRenew(true)
+	session.SetRedirectURI(redirectURL)
+	t.storePendingAuth(rw, req, csrfToken, session)
 
-	// Save the session (to store CSRF, Nonce, etc.)
-	if err := session.Save(req, rw); err != nil {
-		t.logger.Errorf("Failed to save session before redirecting to provider: %v", err)
+	if err := session.SaveMain(req, rw); err != nil {
+		logger.Errorf("Failed to save session before silent renew: %v", err)
 		http.Error(rw, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
-	// Build and redirect to authentication URL
-	authURL := t.buildAuthURL(redirectURL, csrfToken, nonce, codeChallenge)
-	t.logger.Debugf("Redirecting user to OIDC provider: %s", authURL)
+	authURL := setURLQueryParam(t.buildAuthURL(redirectURL, csrfToken, nonce, codeChallenge), "prompt", "none")
+	logger.Debugf("Redirecting silent renew to OIDC provider: %s", authURL)
 	http.Redirect(rw, req, authURL, http.StatusFound)
 }
 
+// silentRenewPage is the page returned to the hidden iframe at the end of a silent renew.
+// It posts {type: "oidc-silent-renew", result: ...} to the parent window of the same origin.
+var silentRenewPage = htmltemplate.Must(htmltemplate.New("silent-renew").Parse(`<!DOCTYPE html>
+<html><head><title>Silent renew</title></head><body><script>
+(function () {
+  var message = {type: "oidc-silent-renew", result: {{.Result}}};
+  if (window.parent && window.parent !== window) {
+    window.parent.postMessage(message, {{.Origin}});
+  }
+})();
+</script></body></html>`))
+
+// sendSilentRenewResult writes the postMessage page that reports the outcome of a silent renew
+// to the parent window. The result is "success" when the session was renewed, or the error code
+// returned by the provider (e.g. "login_required", "interaction_required") when a full,
+// visible redirect is needed.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - req: The callback request (used to determine the parent window's origin).
+//   - result: The outcome to report.
+func (t *TraefikOidc) sendSilentRenewResult(rw http.ResponseWriter, req *http.Request, result string) {
+	origin := fmt.Sprintf("%s://%s", t.determineScheme(req), t.determineHost(req))
+	setNoStoreHeaders(rw)
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	if err := silentRenewPage.Execute(rw, struct{ Result, Origin string }{Result: result, Origin: origin}); err != nil {
+		t.logger.Errorf("Failed to render silent renew result page: %v", err)
+	}
+}
+
 // verifyToken is a wrapper method that calls the VerifyToken method of the configured
 // TokenVerifier interface (which defaults to the TraefikOidc instance itself).
 // This primarily exists to facilitate testing and potential future extensions where
 // token verification logic might be delegated differently.
 //
 // Parameters:
+//   - ctx: Cancels provider requests made during verification, e.g. introspection.
 //   - token: The raw token string to verify.
 //
 // Returns:
-//   - The result of calling t.tokenVerifier.VerifyToken(token).
-func (t *TraefikOidc) verifyToken(token string) error {
-	return t.tokenVerifier.VerifyToken(token)
+//   - The result of calling t.tokenVerifier.VerifyToken(ctx, token).
+func (t *TraefikOidc) verifyToken(ctx context.Context, token string) error {
+	return t.tokenVerifier.VerifyToken(ctx, token)
 }
 
 // buildAuthURL constructs the OIDC authorization endpoint URL with all necessary query parameters
@@ -1322,39 +2785,38 @@ func (t *TraefikOidc) verifyToken(token string) error {
 func (t *TraefikOidc) buildAuthURL(redirectURL, state, nonce, codeChallenge string) string {
 	params := url.Values{}
 	params.Set("client_id", t.clientID)
-	params.Set("response_type", "code")
+	if t.allowImplicitFlow {
+		params.Set("response_type", "id_token token")
+	} else {
+		params.Set("response_type", "code")
+	}
 	params.Set("redirect_uri", redirectURL)
 	params.Set("state", state)
 	params.Set("nonce", nonce)
 
 	// Add PKCE parameters only if PKCE is enabled and we have a code challenge
-	if t.enablePKCE && codeChallenge != "" {
+	if t.enablePKCE && codeChallenge != "" && !t.allowImplicitFlow {
 		params.Set("code_challenge", codeChallenge)
 		params.Set("code_challenge_method", "S256")
 	}
 
-	// Handle scopes - ensure offline_access is included for refresh tokens
-	scopes := make([]string, len(t.scopes))
-	copy(scopes, t.scopes)
+	if scope := t.requestScope(); scope != "" {
+		params.Set("scope", scope)
+	}
 
 	// Check if we're dealing with a Google OIDC provider
 	isGoogleProvider := strings.Contains(t.issuerURL, "google") || strings.Contains(t.issuerURL, "accounts.google.com")
 
-	// Add offline_access scope if it's missing
-	hasOfflineAccess := false
-	for _, scope := range scopes {
-		if scope == "offline_access" {
-			hasOfflineAccess = true
-			break
-		}
-	}
+	t.addResourceParams(params)
 
-	if !hasOfflineAccess {
-		scopes = append(scopes, "offline_access")
+	if t.hostedDomain != "" {
+		params.Set("hd", t.hostedDomain)
 	}
-
-	if len(scopes) > 0 {
-		params.Set("scope", strings.Join(scopes, " "))
+	if t.requireMFA && t.mfaACRValues != "" {
+		params.Set("acr_values", t.mfaACRValues)
+	}
+	if t.maxAuthAge > 0 {
+		params.Set("max_age", strconv.FormatInt(int64(t.maxAuthAge.Seconds()), 10))
 	}
 
 	// Add prompt=consent for Google to ensure refresh token is issued
@@ -1368,6 +2830,26 @@ func (t *TraefikOidc) buildAuthURL(redirectURL, state, nonce, codeChallenge stri
 	return t.buildURLWithParams(t.authURL, params)
 }
 
+// requestScope returns the scope parameter of authorization requests: the scopes normalized
+// by New, with offline_access added so a refresh token is issued.
+//
+// Returns:
+//   - The space-separated scopes.
+func (t *TraefikOidc) requestScope() string {
+	scope := strings.Join(t.scopes, " ")
+
+	// Add offline_access scope if it's missing
+	for _, configured := range t.scopes {
+		if configured == "offline_access" {
+			return scope
+		}
+	}
+	if scope == "" {
+		return "offline_access"
+	}
+	return scope + " offline_access"
+}
+
 // buildURLWithParams takes a base URL and query parameters and constructs a full URL string.
 // If the baseURL is relative (doesn't start with http/https), it prepends the scheme and host
 // from the configured issuerURL. It then appends the encoded query parameters.
@@ -1515,17 +2997,19 @@ func (t *TraefikOidc) refreshToken(rw http.ResponseWriter, req *http.Request, se
 	session.refreshMutex.Lock()
 	defer session.refreshMutex.Unlock()
 
-	t.logger.Debug("Attempting to refresh token (mutex acquired)")
+	logger := t.flowLogger(session)
+
+	logger.Debug("Attempting to refresh token (mutex acquired)")
 	initialRefreshToken := session.GetRefreshToken() // Get token *after* acquiring lock
 	if initialRefreshToken == "" {
-		t.logger.Errorf("refreshToken failed: No refresh token found in session (after acquiring lock)")
+		logger.Errorf("refreshToken failed: No refresh token found in session (after acquiring lock)")
 		return false
 	}
 
 	// Detect if we're using Google's OIDC provider
 	isGoogleProvider := strings.Contains(t.issuerURL, "google") || strings.Contains(t.issuerURL, "accounts.google.com")
 	if isGoogleProvider {
-		t.logger.Debug("Google OIDC provider detected for token refresh operation")
+		logger.Debug("Google OIDC provider detected for token refresh operation")
 	}
 
 	// Log the attempt with a truncated token for security
@@ -1533,27 +3017,29 @@ func (t *TraefikOidc) refreshToken(rw http.ResponseWriter, req *http.Request, se
 	if len(initialRefreshToken) > 10 {
 		tokenPrefix = initialRefreshToken[:10]
 	}
-	t.logger.Debugf("Attempting refresh with token starting with %s...", tokenPrefix)
+	logger.Debugf("Attempting refresh with token starting with %s...", tokenPrefix)
 
-	// Attempt to refresh the token
-	newToken, err := t.tokenExchanger.GetNewTokenWithRefreshToken(initialRefreshToken)
+	// Attempt to refresh the token; the request context cancels a slow token endpoint call
+	// when the client disconnects
+	newToken, err := t.tokenExchanger.GetNewTokenWithRefreshToken(req.Context(), initialRefreshToken)
 	if err != nil {
 		// Log detailed error information
-		t.logger.Errorf("refreshToken failed: Error from token refresh operation: %v", err)
+		logger.Errorf("refreshToken failed: Error from token refresh operation: %v", err)
+		session.SetProviderUnavailable(isProviderUnavailable(err))
 
 		// Check for specific error patterns
 		errMsg := err.Error()
 		if strings.Contains(errMsg, "invalid_grant") || strings.Contains(errMsg, "token expired") {
-			t.logger.Errorf("Refresh token appears to be expired or revoked: %v", err)
+			logger.Errorf("Refresh token appears to be expired or revoked: %v", err)
 			// Don't keep trying with an invalid refresh token
 			session.SetRefreshToken("")
 			if err := session.Save(req, rw); err != nil {
-				t.logger.Errorf("Failed to remove invalid refresh token from session: %v", err)
+				logger.Errorf("Failed to remove invalid refresh token from session: %v", err)
 			}
 		} else if strings.Contains(errMsg, "invalid_client") {
-			t.logger.Errorf("Client credentials rejected: %v - check client_id and client_secret configuration", err)
+			logger.Errorf("Client credentials rejected: %v - check client_id and client_secret configuration", err)
 		} else if isGoogleProvider && strings.Contains(errMsg, "invalid_request") {
-			t.logger.Errorf("Google OIDC provider error: %v - check scope configuration includes 'offline_access' and prompt=consent is used during authentication", err)
+			logger.Errorf("Google OIDC provider error: %v - check scope configuration includes 'offline_access' and prompt=consent is used during authentication", err)
 		}
 
 		return false
@@ -1561,17 +3047,17 @@ func (t *TraefikOidc) refreshToken(rw http.ResponseWriter, req *http.Request, se
 
 	// Handle potentially missing tokens in the response
 	if newToken.IDToken == "" {
-		t.logger.Errorf("refreshToken failed: Provider did not return a new ID token")
+		logger.Errorf("refreshToken failed: Provider did not return a new ID token")
 		return false
 	}
 
 	// Verify the new access token (ID token)
-	if err := t.verifyToken(newToken.IDToken); err != nil {
+	if err := t.verifyToken(req.Context(), newToken.IDToken); err != nil {
 		truncatedNewToken := newToken.IDToken
 		if len(newToken.IDToken) > 10 {
 			truncatedNewToken = newToken.IDToken[:10]
 		}
-		t.logger.Errorf("refreshToken failed: Failed to verify newly obtained ID token starting with %s...: %v", truncatedNewToken, err)
+		logger.Errorf("refreshToken failed: Failed to verify newly obtained ID token starting with %s...: %v", truncatedNewToken, err)
 		return false
 	}
 
@@ -1581,61 +3067,101 @@ func (t *TraefikOidc) refreshToken(rw http.ResponseWriter, req *http.Request, se
 	currentRefreshToken := session.GetRefreshToken() // Get token again *after* the potentially long exchange
 	if initialRefreshToken != currentRefreshToken {
 		// Use Infof as Warnf doesn't exist
-		t.logger.Infof("refreshToken aborted: Session refresh token changed concurrently during refresh attempt.")
+		logger.Infof("refreshToken aborted: Session refresh token changed concurrently during refresh attempt.")
 		// Do not save the new tokens, as the session state is likely invalid/cleared.
 		return false // Indicate refresh failure due to concurrency conflict
 	}
 	// --- End Concurrency Check ---
 
 	// Update session with new tokens ONLY if the concurrency check passed
-	t.logger.Debugf("Concurrency check passed. Updating session with new tokens.")
+	logger.Debugf("Concurrency check passed. Updating session with new tokens.")
 
 	// Extract email from the new token and update session
 	claims, err := t.extractClaimsFunc(newToken.IDToken)
 	if err != nil {
-		t.logger.Errorf("refreshToken failed: Failed to extract claims from refreshed token: %v", err)
+		logger.Errorf("refreshToken failed: Failed to extract claims from refreshed token: %v", err)
 		return false // Cannot proceed without claims
 	}
-	email, _ := claims["email"].(string)
-	if email == "" {
-		t.logger.Errorf("refreshToken failed: Email claim missing or empty in refreshed token")
-		return false // Cannot proceed without email
+	if subjectMismatch(session, claims) {
+		logger.Errorf("refreshToken failed: Refreshed token is for subject %v, session belongs to %s", claims["sub"], session.GetSubject())
+		session.subjectChanged = true
+		return false // The session must be reset, not kept with its current token
+	}
+
+	// Refresh the UserInfo claims along with the ID token; a failed fetch keeps the stored ones
+	var userInfo map[string]interface{}
+	if t.enableUserInfo && newToken.AccessToken != "" {
+		userInfo, err = t.fetchUserInfo(req.Context(), newToken.AccessToken)
+		if err != nil {
+			logger.Errorf("Failed to fetch UserInfo during token refresh: %v", err)
+		} else if claims, err = mergeUserInfoClaims(claims, userInfo); err != nil {
+			logger.Errorf("refreshToken failed: Rejecting UserInfo response: %v", err)
+			return false
+		}
+	}
+	userID, email := t.userIdentity(claims)
+	if userID == "" {
+		logger.Errorf("refreshToken failed: User ID claim %s missing or empty in refreshed token", t.userIDClaimName())
+		return false // Cannot proceed without a user identifier
 	}
 	session.SetEmail(email) // Update email in session
+	session.SetUserID(userID)
+	if userInfo != nil {
+		session.SetUserInfo(userInfo)
+	}
+	if t.claimsRefreshInterval > 0 {
+		session.SetClaimsUpdatedAt(time.Now())
+	}
 
 	// Get token expiry information for logging
 	var expiryTime time.Time
 	if expClaim, ok := claims["exp"].(float64); ok {
 		expiryTime = time.Unix(int64(expClaim), 0)
-		t.logger.Debugf("New token expires at: %v (in %v)", expiryTime, time.Until(expiryTime))
+		logger.Debugf("New token expires at: %v (in %v)", expiryTime, time.Until(expiryTime))
 	}
 
 	// Set the new access token
-	session.SetAccessToken(newToken.IDToken)
+	if err := session.SetAccessToken(newToken.IDToken); err != nil {
+		logger.Errorf("refreshToken failed: Failed to store new access token: %v", err)
+		return false
+	}
+	session.SetAccessTokenExpiry(newToken.expiresAt())
 
 	// Handle the refresh token
+	refreshTokenToStore := initialRefreshToken
 	if newToken.RefreshToken != "" {
-		t.logger.Debug("Received new refresh token from provider")
-		session.SetRefreshToken(newToken.RefreshToken)
+		logger.Debug("Received new refresh token from provider")
+		refreshTokenToStore = newToken.RefreshToken
 	} else {
 		// If no new refresh token is returned, keep the existing one
-		t.logger.Debug("Provider did not return a new refresh token, keeping the existing one")
-		session.SetRefreshToken(initialRefreshToken)
+		logger.Debug("Provider did not return a new refresh token, keeping the existing one")
+	}
+	if err := session.SetRefreshToken(refreshTokenToStore); err != nil {
+		logger.Errorf("refreshToken failed: Failed to store refresh token: %v", err)
+		return false
+	}
+	if err := t.storeProviderAccessToken(session, newToken.AccessToken); err != nil {
+		logger.Errorf("refreshToken failed: Failed to store provider access token: %v", err)
+		return false
 	}
 
 	// Ensure authenticated flag is set
 	if err := session.SetAuthenticated(true); err != nil {
-		t.logger.Errorf("refreshToken warning: Failed to set authenticated flag: %v", err)
+		logger.Errorf("refreshToken warning: Failed to set authenticated flag: %v", err)
 		// Continue anyway since we have valid tokens
 	}
 
+	// A successful refresh ends any backoff and outage
+	session.SetRefreshFailures(0, time.Time{})
+	session.SetProviderUnavailable(false)
+
 	// Save the session
 	if err := session.Save(req, rw); err != nil {
-		t.logger.Errorf("refreshToken failed: Failed to save session after successful token refresh: %v", err)
+		logger.Errorf("refreshToken failed: Failed to save session after successful token refresh: %v", err)
 		return false
 	}
 
-	t.logger.Debugf("Token refresh successful and session saved")
+	logger.Debugf("Token refresh successful and session saved")
 	return true
 }
 
@@ -1665,8 +3191,49 @@ func (t *TraefikOidc) isAllowedDomain(email string) bool {
 	return ok
 }
 
+// userIDClaimName returns the claim used as the user identifier, defaulting to "email".
+func (t *TraefikOidc) userIDClaimName() string {
+	if t.userIDClaim == "" {
+		return DefaultUserIDClaim
+	}
+	return t.userIDClaim
+}
+
+// userIdentity extracts the user identifier and email address from validated token claims.
+// The identifier is read from the configured user ID claim; the email is always read from
+// the "email" claim and may be empty when the identifier comes from another claim.
+//
+// Parameters:
+//   - claims: The validated ID token claims.
+//
+// Returns:
+//   - The user identifier, or an empty string if the claim is missing or not a string.
+//   - The email address, or an empty string if not present.
+func (t *TraefikOidc) userIdentity(claims map[string]interface{}) (userID, email string) {
+	email, _ = claims["email"].(string)
+	userID, _ = claims[t.userIDClaimName()].(string)
+	return userID, email
+}
+
+// getTokenClaims returns the claims of the given token, served from the token cache when the
+// token has already been verified and otherwise decoded with the configured extractClaimsFunc.
+//
+// Parameters:
+//   - token: The raw token string.
+//
+// Returns:
+//   - The token's claims.
+//   - An error if the token is not cached and its claims cannot be extracted.
+func (t *TraefikOidc) getTokenClaims(token string) (map[string]interface{}, error) {
+	if claims, ok := t.tokenCache.Get(token); ok && len(claims) > 0 {
+		return claims, nil
+	}
+	return t.extractClaimsFunc(token)
+}
+
 // extractGroupsAndRoles attempts to extract 'groups' and 'roles' claims from a decoded ID token.
-// It expects these claims, if present, to be arrays of strings.
+// It expects these claims, if present, to be arrays of strings. The values found at the
+// configured groupClaimPaths and roleClaimPaths are added to them.
 // It uses the configured extractClaimsFunc (which defaults to the package-level extractClaims)
 // to get the claims map from the token string.
 //
@@ -1678,7 +3245,7 @@ func (t *TraefikOidc) isAllowedDomain(email string) bool {
 //   - A slice of strings containing the roles found in the 'roles' claim.
 //   - An error if claim extraction fails or if the 'groups' or 'roles' claims are present but not arrays of strings.
 func (t *TraefikOidc) extractGroupsAndRoles(idToken string) ([]string, []string, error) {
-	claims, err := t.extractClaimsFunc(idToken)
+	claims, err := t.getTokenClaims(idToken)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to extract claims: %w", err)
 	}
@@ -1722,9 +3289,33 @@ func (t *TraefikOidc) extractGroupsAndRoles(idToken string) ([]string, []string,
 		}
 	}
 
+	// Add the values of nested claims, such as Keycloak's realm_access.roles
+	for _, path := range t.groupClaimPaths {
+		groups = append(groups, resolveClaimPath(claims, path)...)
+	}
+	for _, path := range t.roleClaimPaths {
+		roles = append(roles, resolveClaimPath(claims, path)...)
+	}
+
 	return groups, roles, nil
 }
 
+// buildRedirectURL returns the redirect_uri for authorization and token requests: the
+// configured public callback URL if set, otherwise the callback path on the scheme and host
+// the request was received on (honoring X-Forwarded-Proto and X-Forwarded-Host).
+//
+// Parameters:
+//   - req: The incoming HTTP request.
+//
+// Returns:
+//   - The absolute redirect URI.
+func (t *TraefikOidc) buildRedirectURL(req *http.Request) string {
+	if t.publicCallbackURL != "" {
+		return t.publicCallbackURL
+	}
+	return buildFullURL(t.determineScheme(req), t.determineHost(req), t.redirURLPath)
+}
+
 // buildFullURL constructs an absolute URL string from its components.
 // If the provided path already starts with "http://" or "https://", it's returned directly.
 // Otherwise, it combines the scheme, host, and path, ensuring the path starts with a '/'.
@@ -1765,9 +3356,9 @@ func (t *TraefikOidc) ExchangeCodeForToken(ctx context.Context, grantType string
 // It directly calls the internal getNewTokenWithRefreshToken helper method.
 // This allows the TraefikOidc struct to act as its own default TokenExchanger, while
 // still allowing mocking for tests.
-func (t *TraefikOidc) GetNewTokenWithRefreshToken(refreshToken string) (*TokenResponse, error) {
+func (t *TraefikOidc) GetNewTokenWithRefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 	// Note: The original getNewTokenWithRefreshToken helper is defined in helpers.go and is already a method on *TraefikOidc
-	return t.getNewTokenWithRefreshToken(refreshToken)
+	return t.getNewTokenWithRefreshToken(ctx, refreshToken)
 }
 
 // sendErrorResponse sends an error response to the client, adapting the format based
@@ -1782,6 +3373,7 @@ func (t *TraefikOidc) GetNewTokenWithRefreshToken(refreshToken string) (*TokenRe
 //   - message: The error message to display/include in the response.
 //   - code: The HTTP status code to set for the response.
 func (t *TraefikOidc) sendErrorResponse(rw http.ResponseWriter, req *http.Request, message string, code int) {
+	setNoStoreHeaders(rw)
 	acceptHeader := req.Header.Get("Accept")
 
 	// Check if the client prefers JSON
@@ -1827,7 +3419,7 @@ func (t *TraefikOidc) sendErrorResponse(rw http.ResponseWriter, req *http.Reques
         <p><a href="%s">Return to application</a></p>
     </div>
 </body>
-</html>`, message, returnURL) // Use default returnURL
+</html>`, htmltemplate.HTMLEscapeString(message), returnURL) // Use default returnURL
 
 	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 	rw.WriteHeader(code)
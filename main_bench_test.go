@@ -1,9 +1,11 @@
 package traefikoidc
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 // BenchmarkOIDCMiddleware benchmarks the OIDC middleware's ability to handle concurrent requests.
@@ -55,3 +57,55 @@ func BenchmarkOIDCMiddleware(b *testing.B) {
 		}
 	}
 }
+
+// cacheBenchmarkStore is the part of the cache API exercised by the parallel benchmarks.
+type cacheBenchmarkStore interface {
+	Set(key string, value interface{}, expiration time.Duration)
+	Get(key string) (interface{}, bool)
+	SetMaxSize(size int)
+}
+
+// BenchmarkCacheParallel measures cache throughput with many goroutines reading and writing
+// different keys at once, which shows the contention on the cache's single lock.
+func BenchmarkCacheParallel(b *testing.B) {
+	c := NewCache()
+	defer c.Close()
+	benchmarkCacheParallel(b, c)
+}
+
+// BenchmarkShardedCacheParallel runs the same workload against the sharded cache, for
+// comparison with BenchmarkCacheParallel.
+func BenchmarkShardedCacheParallel(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := NewShardedCache(shards)
+			defer c.Close()
+			benchmarkCacheParallel(b, c)
+		})
+	}
+}
+
+// benchmarkCacheParallel runs a read-mostly parallel workload over 1024 keys.
+func benchmarkCacheParallel(b *testing.B, c cacheBenchmarkStore) {
+	c.SetMaxSize(10000)
+
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		c.Set(keys[i], i, time.Hour)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if i%10 == 0 {
+				c.Set(key, i, time.Hour)
+			} else {
+				c.Get(key)
+			}
+			i++
+		}
+	})
+}
@@ -1,21 +1,36 @@
 package traefikoidc
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	htmltemplate "html/template"
+	"log"
 	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/gorilla/sessions"
@@ -130,7 +145,7 @@ func (ts *TestSuite) Setup() {
 				ExpiresIn:    3600,
 			}, nil
 		},
-		RefreshTokenFunc: func(refreshToken string) (*TokenResponse, error) {
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 			// Default mock behavior for refresh (can be overridden in tests)
 			return nil, fmt.Errorf("default mock: refresh not expected")
 		},
@@ -162,7 +177,7 @@ func (m *MockJWKCache) Cleanup() {
 // MockTokenExchanger implements TokenExchanger for testing
 type MockTokenExchanger struct {
 	ExchangeCodeFunc func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error)
-	RefreshTokenFunc func(refreshToken string) (*TokenResponse, error)
+	RefreshTokenFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)
 	RevokeTokenFunc  func(token, tokenType string) error
 }
 
@@ -173,9 +188,9 @@ func (m *MockTokenExchanger) ExchangeCodeForToken(ctx context.Context, grantType
 	return nil, fmt.Errorf("ExchangeCodeFunc not implemented in mock")
 }
 
-func (m *MockTokenExchanger) GetNewTokenWithRefreshToken(refreshToken string) (*TokenResponse, error) {
+func (m *MockTokenExchanger) GetNewTokenWithRefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 	if m.RefreshTokenFunc != nil {
-		return m.RefreshTokenFunc(refreshToken)
+		return m.RefreshTokenFunc(ctx, refreshToken)
 	}
 	return nil, fmt.Errorf("RefreshTokenFunc not implemented in mock")
 }
@@ -306,7 +321,7 @@ func TestVerifyToken(t *testing.T) {
 				}
 			}
 
-			err := ts.tOidc.VerifyToken(tc.token)
+			err := ts.tOidc.VerifyToken(context.Background(), tc.token)
 			if tc.expectedError && err == nil {
 				t.Errorf("Test %s: expected error but got nil", tc.name)
 			}
@@ -317,6 +332,42 @@ func TestVerifyToken(t *testing.T) {
 	}
 }
 
+func TestValidateToken(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	req := httptest.NewRequest("GET", "/webhook", nil)
+	claims, err := ts.tOidc.ValidateToken(req.Context(), ts.token)
+	if err != nil {
+		t.Fatalf("Expected the token to be valid, got %v", err)
+	}
+	if claims["sub"] != "test-subject" {
+		t.Errorf("Expected the token claims, got %v", claims)
+	}
+	claims["sub"] = "modified"
+
+	// A repeated validation is served from the token cache, unaffected by the caller's changes
+	claims, err = ts.tOidc.ValidateToken(req.Context(), ts.token)
+	if err != nil {
+		t.Fatalf("Expected the cached token to be valid, got %v", err)
+	}
+	if claims["sub"] != "test-subject" {
+		t.Errorf("Expected the cached claims to be unchanged, got %v", claims["sub"])
+	}
+
+	if _, err := ts.tOidc.ValidateToken(req.Context(), ts.token+"invalid"); err == nil {
+		t.Error("Expected a token with an invalid signature to be rejected")
+	}
+
+	// The provider metadata must be initialized before any token can be validated
+	ts.tOidc.initComplete = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ts.tOidc.ValidateToken(ctx, ts.token); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected validation to stop when the context is done, got %v", err)
+	}
+}
+
 // TestServeHTTP tests the ServeHTTP method
 func TestServeHTTP(t *testing.T) {
 	ts := &TestSuite{t: t}
@@ -374,7 +425,7 @@ func TestServeHTTP(t *testing.T) {
 		expectedStatus            int
 		expectedBody              string
 		setupSession              func(*SessionData)
-		mockRefreshTokenFunc      func(originalFunc func(refreshToken string) (*TokenResponse, error)) func(refreshToken string) (*TokenResponse, error)
+		mockRefreshTokenFunc      func(originalFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)) func(ctx context.Context, refreshToken string) (*TokenResponse, error)
 		assertSessionAfterRequest func(t *testing.T, rr *httptest.ResponseRecorder, req *http.Request, sessionManager *SessionManager) // Added for post-request checks
 		requestHeaders            map[string]string                                                                                    // Added for setting headers like Accept
 	}{
@@ -403,8 +454,8 @@ func TestServeHTTP(t *testing.T) {
 				session.SetAccessToken("")                                     // No access token
 				session.SetRefreshToken("valid-refresh-token-for-unauth-test") // BUT has refresh token
 			},
-			mockRefreshTokenFunc: func(originalFunc func(refreshToken string) (*TokenResponse, error)) func(refreshToken string) (*TokenResponse, error) {
-				return func(refreshToken string) (*TokenResponse, error) {
+			mockRefreshTokenFunc: func(originalFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)) func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+				return func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 					if refreshToken != "valid-refresh-token-for-unauth-test" {
 						return nil, fmt.Errorf("mock error: unexpected refresh token '%s'", refreshToken)
 					}
@@ -424,8 +475,8 @@ func TestServeHTTP(t *testing.T) {
 				session.SetAccessToken("")                                       // No access token
 				session.SetRefreshToken("invalid-refresh-token-for-unauth-test") // Invalid refresh token
 			},
-			mockRefreshTokenFunc: func(originalFunc func(refreshToken string) (*TokenResponse, error)) func(refreshToken string) (*TokenResponse, error) {
-				return func(refreshToken string) (*TokenResponse, error) {
+			mockRefreshTokenFunc: func(originalFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)) func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+				return func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 					// Simulate failed refresh
 					return nil, fmt.Errorf("mock error: refresh token invalid")
 				}
@@ -463,8 +514,8 @@ func TestServeHTTP(t *testing.T) {
 				session.SetAccessToken(createExpiredToken())   // Set expired token
 				session.SetRefreshToken("valid-refresh-token") // Set valid refresh token
 			},
-			mockRefreshTokenFunc: func(originalFunc func(refreshToken string) (*TokenResponse, error)) func(refreshToken string) (*TokenResponse, error) {
-				return func(refreshToken string) (*TokenResponse, error) {
+			mockRefreshTokenFunc: func(originalFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)) func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+				return func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 					if refreshToken != "valid-refresh-token" {
 						return nil, fmt.Errorf("mock error: expected 'valid-refresh-token', got '%s'", refreshToken)
 					}
@@ -532,8 +583,8 @@ func TestServeHTTP(t *testing.T) {
 				session.SetAccessToken(createExpiredToken())   // Expired access token
 				session.SetRefreshToken("valid-refresh-token") // Valid refresh token
 			},
-			mockRefreshTokenFunc: func(originalFunc func(refreshToken string) (*TokenResponse, error)) func(refreshToken string) (*TokenResponse, error) {
-				return func(refreshToken string) (*TokenResponse, error) {
+			mockRefreshTokenFunc: func(originalFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)) func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+				return func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 					// Simulate failed refresh
 					return nil, fmt.Errorf("mock error: refresh token invalid or provider down")
 				}
@@ -554,8 +605,8 @@ func TestServeHTTP(t *testing.T) {
 				session.SetAccessToken(createExpiredToken())   // Expired access token
 				session.SetRefreshToken("valid-refresh-token") // Valid refresh token
 			},
-			mockRefreshTokenFunc: func(originalFunc func(refreshToken string) (*TokenResponse, error)) func(refreshToken string) (*TokenResponse, error) {
-				return func(refreshToken string) (*TokenResponse, error) {
+			mockRefreshTokenFunc: func(originalFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)) func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+				return func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 					// Simulate failed refresh
 					return nil, fmt.Errorf("mock error: refresh token invalid or provider down")
 				}
@@ -583,8 +634,8 @@ func TestServeHTTP(t *testing.T) {
 				session.SetAccessToken(nearExpiryToken)
 				session.SetRefreshToken("valid-refresh-token-for-near-expiry") // Refresh token MUST exist for proactive refresh
 			},
-			mockRefreshTokenFunc: func(originalFunc func(refreshToken string) (*TokenResponse, error)) func(refreshToken string) (*TokenResponse, error) {
-				return func(refreshToken string) (*TokenResponse, error) {
+			mockRefreshTokenFunc: func(originalFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)) func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+				return func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 					if refreshToken != "valid-refresh-token-for-near-expiry" {
 						return nil, fmt.Errorf("mock error: unexpected refresh token '%s'", refreshToken)
 					}
@@ -614,9 +665,9 @@ func TestServeHTTP(t *testing.T) {
 				session.SetAccessToken(validToken)
 				session.SetRefreshToken("should-not-be-used-refresh-token")
 			},
-			mockRefreshTokenFunc: func(originalFunc func(refreshToken string) (*TokenResponse, error)) func(refreshToken string) (*TokenResponse, error) {
+			mockRefreshTokenFunc: func(originalFunc func(ctx context.Context, refreshToken string) (*TokenResponse, error)) func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 				// This should NOT be called
-				return func(refreshToken string) (*TokenResponse, error) {
+				return func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 					t.Errorf("Refresh token function was called unexpectedly for valid token outside grace period")
 					return nil, fmt.Errorf("refresh should not have been attempted")
 				}
@@ -1660,6 +1711,117 @@ func TestBuildLogoutURL(t *testing.T) {
 	}
 }
 
+// TestBuildLogoutURLWithParams verifies the logout parameter mapping for non-standard providers.
+func TestBuildLogoutURLWithParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      LogoutParams
+		expectedURL string
+	}{
+		{
+			name:        "Standard names",
+			expectedURL: "https://provider/logout?id_token_hint=test.id.token&post_logout_redirect_uri=http%3A%2F%2Fexample.com%2F",
+		},
+		{
+			name:        "Auth0",
+			params:      LogoutParams{PostLogoutRedirectParam: "returnTo", IncludeClientID: true},
+			expectedURL: "https://provider/logout?client_id=test-client&id_token_hint=test.id.token&returnTo=http%3A%2F%2Fexample.com%2F",
+		},
+		{
+			name:        "Cognito",
+			params:      LogoutParams{PostLogoutRedirectParam: "logout_uri", IncludeClientID: true, OmitIDTokenHint: true},
+			expectedURL: "https://provider/logout?client_id=test-client&logout_uri=http%3A%2F%2Fexample.com%2F",
+		},
+		{
+			name:        "Custom client ID name",
+			params:      LogoutParams{IDTokenHintParam: "hint", ClientIDParam: "clientId", IncludeClientID: true},
+			expectedURL: "https://provider/logout?clientId=test-client&hint=test.id.token&post_logout_redirect_uri=http%3A%2F%2Fexample.com%2F",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := BuildLogoutURLWithParams("https://provider/logout", "test.id.token", "http://example.com/", "test-client", tc.params)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if url != tc.expectedURL {
+				t.Errorf("Expected URL %q, got %q", tc.expectedURL, url)
+			}
+		})
+	}
+}
+
+func TestBuildSignedLogoutURL(t *testing.T) {
+	// Without a signer the plain parameterized URL is built
+	plain, err := BuildSignedLogoutURL("https://provider/logout", "test.id.token", "http://example.com/", "test-client", "https://provider", LogoutParams{}, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "https://provider/logout?id_token_hint=test.id.token&post_logout_redirect_uri=http%3A%2F%2Fexample.com%2F"; plain != expected {
+		t.Errorf("Expected URL %q, got %q", expected, plain)
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	tests := []struct {
+		name      string
+		keyPEM    []byte
+		publicKey interface{}
+		alg       string
+	}{
+		{"RSA", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), &rsaKey.PublicKey, "RS256"},
+		{"EC", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}), &ecKey.PublicKey, "ES256"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := NewRequestSigner(string(tc.keyPEM), "client-key-1")
+			if err != nil {
+				t.Fatalf("Failed to create signer: %v", err)
+			}
+			logoutURL, err := BuildSignedLogoutURL("https://provider/logout?tenant=a", "test.id.token", "http://example.com/", "test-client", "https://provider",
+				LogoutParams{PostLogoutRedirectParam: "returnTo"}, signer)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			u, _ := url.Parse(logoutURL)
+			q := u.Query()
+			if q.Get("client_id") != "test-client" || q.Get("tenant") != "a" || q.Get("id_token_hint") != "" || q.Get("returnTo") != "" {
+				t.Errorf("Expected only client_id, request and the endpoint's own parameters in the URL, got %q", u.RawQuery)
+			}
+
+			requestObject := q.Get("request")
+			publicDER, _ := x509.MarshalPKIXPublicKey(tc.publicKey)
+			publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+			if err := verifySignature(requestObject, publicPEM, tc.alg); err != nil {
+				t.Fatalf("Expected a valid %s signature, got: %v", tc.alg, err)
+			}
+			jwt, err := parseJWT(requestObject)
+			if err != nil {
+				t.Fatalf("Failed to parse request object: %v", err)
+			}
+			if jwt.Header["kid"] != "client-key-1" || jwt.Header["alg"] != tc.alg {
+				t.Errorf("Unexpected request object header: %v", jwt.Header)
+			}
+			claims := jwt.Claims
+			if claims["iss"] != "test-client" || claims["aud"] != "https://provider" || claims["client_id"] != "test-client" {
+				t.Errorf("Unexpected request object identity claims: %v", claims)
+			}
+			if claims["id_token_hint"] != "test.id.token" || claims["returnTo"] != "http://example.com/" {
+				t.Errorf("Expected logout parameters in the request object, got %v", claims)
+			}
+			if _, ok := claims["tenant"]; ok {
+				t.Error("Expected the endpoint's own parameters to stay out of the request object")
+			}
+		})
+	}
+
+	if _, err := NewRequestSigner("not a key", ""); err == nil {
+		t.Error("Expected an error for an invalid signing key")
+	}
+}
+
 // Add this new test function
 func TestHandleExpiredToken(t *testing.T) {
 	ts := &TestSuite{t: t}
@@ -2398,6 +2560,22 @@ func TestBuildAuthURL(t *testing.T) {
 	}
 }
 
+func TestRequestScopeOfflineAccess(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.scopes = []string{"openid", "email"}
+
+	if got := tOidc.requestScope(); got != "openid email offline_access" {
+		t.Errorf("Expected offline_access to be added, got %q", got)
+	}
+
+	tOidc.scopes = []string{"openid", "offline_access", "email"}
+	if got := tOidc.requestScope(); got != "openid offline_access email" {
+		t.Errorf("Expected a configured offline_access to be kept in place, got %q", got)
+	}
+}
+
 // TestExchangeCodeForToken tests the exchangeCodeForToken function with PKCE support
 func TestExchangeCodeForToken(t *testing.T) {
 	ts := &TestSuite{t: t}
@@ -2503,7 +2681,7 @@ func TestExchangeCodeForToken(t *testing.T) {
 			tOidc.enablePKCE = tc.enablePKCE
 
 			// Test exchangeCodeForToken
-			response, err := tOidc.exchangeCodeForToken("test-code", "http://callback", tc.codeVerifier)
+			response, err := tOidc.exchangeCodeForToken(context.Background(), "test-code", "http://callback", tc.codeVerifier)
 			if err != nil {
 				t.Errorf("Unexpected error: %v", err)
 			}
@@ -2669,3 +2847,3577 @@ func TestVerifyTimeConstraint(t *testing.T) {
 		})
 	}
 } // Add missing closing brace for TestVerifyTimeConstraint
+
+func TestAccessTokenHeader(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	providerToken := "provider-access-token"
+
+	tests := []struct {
+		name           string
+		header         string
+		existingValue  string
+		template       string
+		forwardIDToken bool
+		expectedHeader string
+		expectedValue  string // "{token}" and "{id}" are replaced by the access and ID tokens
+	}{
+		{
+			name:           "Disabled",
+			header:         "",
+			expectedHeader: "Authorization",
+			expectedValue:  "",
+		},
+		{
+			name:           "Authorization as bearer",
+			header:         "authorization",
+			expectedHeader: "Authorization",
+			expectedValue:  "Bearer {token}",
+		},
+		{
+			name:           "Custom header with raw token",
+			header:         "X-Access-Token",
+			expectedHeader: "X-Access-Token",
+			expectedValue:  "{token}",
+		},
+		{
+			name:           "ID token forwarded",
+			header:         "Authorization",
+			forwardIDToken: true,
+			expectedHeader: "Authorization",
+			expectedValue:  "Bearer {id}",
+		},
+		{
+			name:           "Client-supplied header is replaced",
+			header:         "Authorization",
+			existingValue:  "Bearer forged-token",
+			expectedHeader: "Authorization",
+			expectedValue:  "Bearer {token}",
+		},
+		{
+			name:           "Templated header is not clobbered",
+			header:         "Authorization",
+			existingValue:  "Bearer forged-token",
+			template:       "Token {{.Claims.email}}",
+			expectedHeader: "Authorization",
+			expectedValue:  "Token user@example.com",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Now()
+			token, err := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+				"iss":   "https://test-issuer.com",
+				"aud":   "test-client-id",
+				"exp":   now.Add(1 * time.Hour).Unix(),
+				"iat":   now.Add(-2 * time.Minute).Unix(),
+				"nbf":   now.Add(-2 * time.Minute).Unix(),
+				"sub":   "test-subject",
+				"email": "user@example.com",
+				"jti":   generateRandomString(16),
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test token: %v", err)
+			}
+
+			var forwarded http.Header
+			tOidc := ts.tOidc
+			tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwarded = r.Header.Clone()
+				w.WriteHeader(http.StatusOK)
+			})
+			tOidc.accessTokenHeader = http.CanonicalHeaderKey(tc.header)
+			tOidc.forwardIDToken = tc.forwardIDToken
+			defer func() { tOidc.accessTokenHeader, tOidc.forwardIDToken = "", false }()
+			if tc.template != "" {
+				tOidc.headerTemplates = map[string]*template.Template{
+					tc.expectedHeader: template.Must(template.New(tc.expectedHeader).Parse(tc.template)),
+				}
+				defer func() { tOidc.headerTemplates = nil }()
+			}
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			rr := httptest.NewRecorder()
+			session, err := tOidc.sessionManager.GetSession(req)
+			if err != nil {
+				t.Fatalf("Failed to get session: %v", err)
+			}
+			session.SetAuthenticated(true)
+			session.SetEmail("user@example.com")
+			session.SetAccessToken(token)
+			session.SetProviderAccessToken(providerToken)
+			if err := session.Save(req, rr); err != nil {
+				t.Fatalf("Failed to save session: %v", err)
+			}
+
+			req = httptest.NewRequest("GET", "/protected", nil)
+			for _, cookie := range rr.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+			if tc.existingValue != "" {
+				req.Header.Set(tc.expectedHeader, tc.existingValue)
+			}
+
+			rr = httptest.NewRecorder()
+			tOidc.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+			}
+			expected := strings.NewReplacer("{token}", providerToken, "{id}", token).Replace(tc.expectedValue)
+			if got := forwarded.Get(tc.expectedHeader); got != expected {
+				t.Errorf("Expected header %s to be %q, got %q", tc.expectedHeader, expected, got)
+			}
+		})
+	}
+}
+
+func TestIsUserAuthenticatedCachesClaims(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	ts.tOidc.tokenCache = NewTokenCache()
+
+	req := httptest.NewRequest("GET", "/protected", nil)
+	session, err := ts.tOidc.sessionManager.GetSession(req)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	session.SetAuthenticated(true)
+	session.SetAccessToken(ts.token)
+
+	if authenticated, _, _ := ts.tOidc.isUserAuthenticated(context.Background(), session); !authenticated {
+		t.Fatal("Expected user to be authenticated")
+	}
+	if _, found := ts.tOidc.tokenCache.Get(ts.token); !found {
+		t.Fatal("Expected verified access token claims to be cached")
+	}
+
+	// With the JWKS unavailable, only a cache hit can keep the user authenticated
+	ts.mockJWKCache.Err = fmt.Errorf("jwks unavailable")
+	defer func() { ts.mockJWKCache.Err = nil }()
+	if authenticated, _, _ := ts.tOidc.isUserAuthenticated(context.Background(), session); !authenticated {
+		t.Error("Expected cached claims to be reused on subsequent requests")
+	}
+}
+
+func TestRefreshBeforeAccessTokenExpiry(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	ts.tOidc.refreshGracePeriod = time.Minute
+
+	req := httptest.NewRequest("GET", "/protected", nil)
+	session, err := ts.tOidc.sessionManager.GetSession(req)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	session.SetAuthenticated(true)
+	session.SetAccessToken(ts.token)
+	session.SetRefreshToken("test-refresh-token")
+
+	if authenticated, needsRefresh, _ := ts.tOidc.isUserAuthenticated(context.Background(), session); !authenticated || needsRefresh {
+		t.Fatalf("Expected a valid session not needing refresh, got authenticated=%v needsRefresh=%v", authenticated, needsRefresh)
+	}
+
+	// The provider's access token expires within the grace period, before the ID token
+	session.SetAccessTokenExpiry(time.Now().Add(30 * time.Second))
+	if authenticated, needsRefresh, _ := ts.tOidc.isUserAuthenticated(context.Background(), session); !authenticated || !needsRefresh {
+		t.Errorf("Expected a proactive refresh, got authenticated=%v needsRefresh=%v", authenticated, needsRefresh)
+	}
+}
+
+func TestUnauthenticatedMode(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	tests := []struct {
+		name            string
+		mode            string
+		template        string
+		expectedStatus  int
+		expectedType    string
+		expectedContent string
+	}{
+		{
+			name:           "Redirect",
+			mode:           UnauthenticatedModeRedirect,
+			expectedStatus: http.StatusFound,
+		},
+		{
+			name:            "JSON 401",
+			mode:            UnauthenticatedModeJSON,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedType:    "application/json",
+			expectedContent: `"error":"unauthenticated"`,
+		},
+		{
+			name:            "Custom page",
+			mode:            UnauthenticatedModeCustom,
+			template:        `<a id="login" href="{{.LoginURL}}">Sign in</a>`,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedType:    "text/html; charset=utf-8",
+			expectedContent: `<a id="login" href="https://test-issuer.com/authorize?`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tOidc := ts.tOidc
+			tOidc.authURL = "https://test-issuer.com/authorize"
+			tOidc.unauthenticatedMode = tc.mode
+			tOidc.unauthenticatedPage = nil
+			if tc.template != "" {
+				tOidc.unauthenticatedPage = htmltemplate.Must(htmltemplate.New("unauthenticated").Parse(tc.template))
+			}
+			defer func() { tOidc.unauthenticatedMode = "" }()
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			rr := httptest.NewRecorder()
+			tOidc.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if tc.expectedType != "" {
+				if got := rr.Header().Get("Content-Type"); got != tc.expectedType {
+					t.Errorf("Expected Content-Type %q, got %q", tc.expectedType, got)
+				}
+			}
+			if tc.mode == UnauthenticatedModeJSON {
+				var body map[string]string
+				if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+					t.Fatalf("Failed to decode JSON body: %v", err)
+				}
+				if !strings.HasPrefix(body["login_url"], tOidc.authURL+"?") {
+					t.Errorf("Expected login_url to point at the authorization endpoint, got %q", body["login_url"])
+				}
+			} else if tc.expectedContent != "" && !strings.Contains(rr.Body.String(), tc.expectedContent) {
+				t.Errorf("Expected body to contain %q, got %q", tc.expectedContent, rr.Body.String())
+			}
+			if len(rr.Result().Cookies()) == 0 {
+				t.Error("Expected session cookies carrying the login state to be set")
+			}
+		})
+	}
+}
+
+func TestSilentRenew(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.silentRenewPath = "/silent-renew"
+
+	t.Run("Endpoint redirects with prompt=none", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/silent-renew", nil)
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusFound {
+			t.Fatalf("Expected status %d, got %d", http.StatusFound, rr.Code)
+		}
+		location, err := url.Parse(rr.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Failed to parse redirect location: %v", err)
+		}
+		if got := location.Query().Get("prompt"); got != "none" {
+			t.Errorf("Expected prompt=none, got %q", got)
+		}
+
+		// The session must remember that the pending request is silent
+		callbackReq := httptest.NewRequest("GET", "/callback", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			callbackReq.AddCookie(cookie)
+		}
+		session, err := tOidc.sessionManager.GetSession(callbackReq)
+		if err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+		if !session.GetSilentRenew() {
+			t.Error("Expected session to be marked as silent renew")
+		}
+		if session.GetCSRF() != location.Query().Get("state") {
+			t.Error("Expected state to match the CSRF token stored in session")
+		}
+	})
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedResult string
+	}{
+		{
+			name:           "Provider requires login",
+			query:          "?error=login_required&state=test-csrf-token",
+			expectedResult: `"login_required"`,
+		},
+		{
+			name:           "Renewal succeeds",
+			query:          "?code=test-code&state=test-csrf-token",
+			expectedResult: `"success"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/callback"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			session, _ := tOidc.sessionManager.GetSession(req)
+			session.SetCSRF("test-csrf-token")
+			session.SetNonce("test-nonce")
+			session.SetSilentRenew(true)
+			if err := session.Save(req, rr); err != nil {
+				t.Fatalf("Failed to save session: %v", err)
+			}
+
+			callbackReq := httptest.NewRequest("GET", "/callback"+tc.query, nil)
+			for _, cookie := range rr.Result().Cookies() {
+				callbackReq.AddCookie(cookie)
+			}
+			rr = httptest.NewRecorder()
+			tOidc.handleCallback(rr, callbackReq, "http://example.com/callback")
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+			}
+			body := rr.Body.String()
+			if !strings.Contains(body, "postMessage") || !strings.Contains(body, tc.expectedResult) {
+				t.Errorf("Expected postMessage page reporting %s, got %q", tc.expectedResult, body)
+			}
+		})
+	}
+}
+
+func TestSilentLoginWithoutRefreshToken(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+
+	expiredAt := time.Now().Add(-time.Hour)
+	expiredToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+		"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": expiredAt.Unix(),
+		"iat": expiredAt.Add(-time.Hour).Unix(), "nbf": expiredAt.Add(-time.Hour).Unix(),
+		"sub": "test-subject", "email": "user@example.com", "jti": generateRandomString(16),
+	})
+
+	// sessionRequest builds a request for an authenticated session with an expired token
+	sessionRequest := func(refreshToken string) *http.Request {
+		req := httptest.NewRequest("GET", "/dashboard?tab=1", nil)
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		session.SetAccessToken(expiredToken)
+		session.SetRefreshToken(refreshToken)
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		next := httptest.NewRequest("GET", "/dashboard?tab=1", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			next.AddCookie(cookie)
+		}
+		return next
+	}
+
+	req := sessionRequest("")
+	rr := httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, req)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusFound, rr.Code)
+	}
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to parse redirect location: %v", err)
+	}
+	if got := location.Query().Get("prompt"); got != "none" {
+		t.Fatalf("Expected prompt=none for a session without refresh token, got %q", got)
+	}
+
+	// The provider cannot log the user in silently: the callback restarts an interactive login
+	state := location.Query().Get("state")
+	callbackReq := httptest.NewRequest("GET", "/callback?error=login_required&state="+state, nil)
+	// Clear and the save that follows both set the main cookie; the browser keeps the last one
+	latest := make(map[string]*http.Cookie)
+	for _, cookie := range rr.Result().Cookies() {
+		latest[cookie.Name] = cookie
+	}
+	for _, cookie := range latest {
+		callbackReq.AddCookie(cookie)
+	}
+	rr = httptest.NewRecorder()
+	tOidc.handleCallback(rr, callbackReq, "http://example.com/callback")
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected fallback redirect, got %d: %s", rr.Code, rr.Body.String())
+	}
+	location, err = url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to parse fallback location: %v", err)
+	}
+	if got := location.Query().Get("prompt"); got != "" {
+		t.Errorf("Expected no prompt for the interactive fallback, got %q", got)
+	}
+	if location.Query().Get("state") == state {
+		t.Error("Expected the fallback to use a new state")
+	}
+	fallbackReq := httptest.NewRequest("GET", "/callback", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		fallbackReq.AddCookie(cookie)
+	}
+	session, _ := tOidc.sessionManager.GetSession(fallbackReq)
+	if session.GetSilentLogin() {
+		t.Error("Expected the fallback login not to be silent")
+	}
+	if got := session.GetIncomingPath(); got != "/dashboard?tab=1" {
+		t.Errorf("Expected the post-login destination to be kept, got %q", got)
+	}
+	if session.GetCSRF() != location.Query().Get("state") {
+		t.Error("Expected the new state to be stored in the session")
+	}
+
+	// A session that still has a refresh token is refreshed, not silently logged in
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+			return nil, fmt.Errorf("invalid_grant")
+		},
+	}
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, sessionRequest("refresh-token"))
+	if location, _ := url.Parse(rr.Header().Get("Location")); location != nil && location.Query().Get("prompt") == "none" {
+		t.Error("Expected no silent login for a session with a refresh token")
+	}
+}
+
+// TestRefreshUsesRequestContext verifies that token refreshes run under the request's
+// context, so that a client disconnect cancels a slow token endpoint call.
+func TestRefreshUsesRequestContext(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+
+	type ctxKey struct{}
+	req := httptest.NewRequest("GET", "/dashboard", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, "request"))
+	session, _ := tOidc.sessionManager.GetSession(req)
+	session.SetRefreshToken("refresh-token")
+
+	var got interface{}
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+			got = ctx.Value(ctxKey{})
+			return nil, fmt.Errorf("invalid_grant")
+		},
+	}
+	tOidc.refreshToken(httptest.NewRecorder(), req, session)
+	if got != "request" {
+		t.Errorf("Expected the refresh to receive the request context, got value %v", got)
+	}
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	tOidc.tokenURL = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() {
+		_, err := tOidc.GetNewTokenWithRefreshToken(ctx, "refresh-token")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the refresh to fail with context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected cancelling the context to abort the token endpoint call")
+	}
+}
+
+// countingReader is a deterministic random source yielding the bytes 0, 1, 2, … (mod 256).
+type countingReader struct {
+	next byte
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.next
+		r.next++
+	}
+	return len(p), nil
+}
+
+func TestDeterministicRandomSource(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.enablePKCE = true
+	tOidc.random = &countingReader{}
+
+	// The state, nonce and code verifier consume 32 bytes each, in that order
+	expected := make([]byte, 96)
+	(&countingReader{}).Read(expected)
+	wantState := hex.EncodeToString(expected[:32])
+	wantNonce := base64.URLEncoding.EncodeToString(expected[32:64])
+	wantVerifier := base64.RawURLEncoding.EncodeToString(expected[64:])
+
+	rr := httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil || rr.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect to the provider, got %d: %v", rr.Code, err)
+	}
+	query := location.Query()
+	if got := query.Get("state"); got != wantState {
+		t.Errorf("Expected state %q, got %q", wantState, got)
+	}
+	if got := query.Get("nonce"); got != wantNonce {
+		t.Errorf("Expected nonce %q, got %q", wantNonce, got)
+	}
+	if got := query.Get("code_challenge"); got != deriveCodeChallenge(wantVerifier) {
+		t.Errorf("Expected the code challenge of verifier %q, got %q", wantVerifier, got)
+	}
+
+	// The verifier reaches the token exchange of the callback
+	var gotVerifier string
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+			gotVerifier = codeVerifier
+			return nil, fmt.Errorf("invalid_grant")
+		},
+	}
+	callbackReq := httptest.NewRequest("GET", "/callback?code=test-code&state="+wantState, nil)
+	latest := make(map[string]*http.Cookie)
+	for _, cookie := range rr.Result().Cookies() {
+		latest[cookie.Name] = cookie
+	}
+	for _, cookie := range latest {
+		callbackReq.AddCookie(cookie)
+	}
+	tOidc.ServeHTTP(httptest.NewRecorder(), callbackReq)
+	if gotVerifier != wantVerifier {
+		t.Errorf("Expected code verifier %q in the token exchange, got %q", wantVerifier, gotVerifier)
+	}
+}
+
+func TestCorrelationIDs(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.logCorrelationIDs = true
+	tOidc.correlationIDHeader = "X-Auth-Correlation-Id"
+	var logs bytes.Buffer
+	tOidc.logger = &Logger{
+		logError: log.New(&logs, "ERROR: ", 0),
+		logInfo:  log.New(&logs, "INFO: ", 0),
+		logDebug: log.New(&logs, "DEBUG: ", 0),
+	}
+
+	// Starting a login generates the flow's correlation ID
+	rr := httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusFound, rr.Code)
+	}
+	id := rr.Header().Get("X-Auth-Correlation-Id")
+	if len(id) != 2*correlationIDBytes {
+		t.Fatalf("Expected a %d character correlation ID header, got %q", 2*correlationIDBytes, id)
+	}
+	if !strings.Contains(logs.String(), "DEBUG: ["+id+"] Initiating new OIDC authentication flow") {
+		t.Errorf("Expected the login start to be logged with the correlation ID, got:\n%s", logs.String())
+	}
+
+	// The callback of the same login is logged under the same ID
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	callbackReq := httptest.NewRequest("GET", "/callback?error=access_denied&state="+location.Query().Get("state"), nil)
+	latest := make(map[string]*http.Cookie)
+	for _, cookie := range rr.Result().Cookies() {
+		latest[cookie.Name] = cookie
+	}
+	for _, cookie := range latest {
+		callbackReq.AddCookie(cookie)
+	}
+	logs.Reset()
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, callbackReq)
+	if got := rr.Header().Get("X-Auth-Correlation-Id"); got != id {
+		t.Errorf("Expected callback correlation ID header %q, got %q", id, got)
+	}
+	if !strings.Contains(logs.String(), "ERROR: ["+id+"] Authentication error from provider") {
+		t.Errorf("Expected the callback error to be logged with the correlation ID, got:\n%s", logs.String())
+	}
+
+	// Without logCorrelationIds the header is still emitted but log lines are untagged
+	tOidc.logCorrelationIDs = false
+	logs.Reset()
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+	if id := rr.Header().Get("X-Auth-Correlation-Id"); id == "" || strings.Contains(logs.String(), "["+id+"]") {
+		t.Errorf("Expected an untagged login with correlation ID header, got header %q and logs:\n%s", id, logs.String())
+	}
+}
+
+func TestCallbackProviderError(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	query := "?error=access_denied&error_description=" + url.QueryEscape("<script>alert(1)</script>\nforged log line") + "&state=test-csrf-token"
+
+	t.Run("Default page escapes the description", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		tOidc.handleCallback(rr, httptest.NewRequest("GET", "/callback"+query, nil), "http://example.com/callback")
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("Expected status %d, got %d", http.StatusForbidden, rr.Code)
+		}
+		body := rr.Body.String()
+		if strings.Contains(body, "<script>") {
+			t.Errorf("Expected provider text to be escaped, got %q", body)
+		}
+		if !strings.Contains(body, "&lt;script&gt;alert(1)&lt;/script&gt;forged log line") {
+			t.Errorf("Expected the escaped, sanitized description in the page, got %q", body)
+		}
+	})
+
+	t.Run("OnAuthError renders the response", func(t *testing.T) {
+		var gotCode, gotDescription string
+		tOidc.onAuthError = func(code, description string, w http.ResponseWriter, r *http.Request) {
+			gotCode, gotDescription = code, description
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("You declined consent"))
+		}
+		defer func() { tOidc.onAuthError = nil }()
+
+		rr := httptest.NewRecorder()
+		tOidc.handleCallback(rr, httptest.NewRequest("GET", "/callback"+query, nil), "http://example.com/callback")
+		if rr.Code != http.StatusOK || rr.Body.String() != "You declined consent" {
+			t.Fatalf("Expected the handler's response, got %d: %q", rr.Code, rr.Body.String())
+		}
+		if gotCode != "access_denied" || gotDescription != "<script>alert(1)</script>forged log line" {
+			t.Errorf("Unexpected error passed to the handler: code=%q description=%q", gotCode, gotDescription)
+		}
+	})
+}
+
+func TestRefreshFailureBackoff(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tOidc.refreshBackoff = time.Minute
+	tOidc.maxRefreshFailures = 3
+	tOidc.refreshGracePeriod = time.Minute
+
+	refreshCalls := 0
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+			refreshCalls++
+			return nil, fmt.Errorf("provider unavailable")
+		},
+	}
+
+	// Token still valid but inside the refresh grace period
+	now := time.Now()
+	nearExpiryToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+		"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": now.Add(30 * time.Second).Unix(),
+		"iat": now.Add(-1 * time.Minute).Unix(), "nbf": now.Add(-1 * time.Minute).Unix(),
+		"sub": "test-subject", "email": "user@example.com", "jti": generateRandomString(16),
+	})
+
+	cookies := map[string]*http.Cookie{}
+	keepCookies := func(rr *httptest.ResponseRecorder) {
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+	}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "/protected", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	req := httptest.NewRequest("GET", "/protected", nil)
+	rr := httptest.NewRecorder()
+	session, _ := tOidc.sessionManager.GetSession(req)
+	session.SetAuthenticated(true)
+	session.SetEmail("user@example.com")
+	session.SetAccessToken(nearExpiryToken)
+	session.SetRefreshToken("revoked-refresh-token")
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+	keepCookies(rr)
+
+	// First request attempts the refresh and keeps serving the still-valid token
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, newRequest())
+	if rr.Code != http.StatusOK || refreshCalls != 1 {
+		t.Fatalf("Expected 200 after one refresh attempt, got status %d with %d attempts", rr.Code, refreshCalls)
+	}
+	keepCookies(rr)
+
+	// Second request is within the backoff window: no refresh attempt
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, newRequest())
+	if rr.Code != http.StatusOK || refreshCalls != 1 {
+		t.Fatalf("Expected 200 without a refresh attempt during backoff, got status %d with %d attempts", rr.Code, refreshCalls)
+	}
+
+	// Once the backoff has elapsed and the failure limit is reached, the user must log in again
+	req = newRequest()
+	rr = httptest.NewRecorder()
+	session, _ = tOidc.sessionManager.GetSession(req)
+	if failures, _ := session.GetRefreshFailures(); failures != 1 {
+		t.Fatalf("Expected 1 recorded refresh failure, got %d", failures)
+	}
+	session.SetRefreshFailures(2, time.Now().Add(-time.Second))
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+	keepCookies(rr)
+
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, newRequest())
+	if refreshCalls != 2 {
+		t.Errorf("Expected a refresh attempt after the backoff elapsed, got %d attempts", refreshCalls)
+	}
+	if rr.Code != http.StatusFound {
+		t.Errorf("Expected redirect to login after %d failures, got status %d", tOidc.maxRefreshFailures, rr.Code)
+	}
+}
+
+// TestResourceIndicators verifies that configured resource indicators and audience are sent
+// with both the authorization request and the token request.
+func TestResourceIndicators(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.resources = []string{"https://api.example.com", "https://billing.example.com"}
+	tOidc.audience = "https://api.example.com"
+
+	t.Run("Authorization Request", func(t *testing.T) {
+		authURL := tOidc.buildAuthURL("https://app.example.com/callback", "state", "nonce", "")
+		parsed, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatalf("Failed to parse auth URL: %v", err)
+		}
+		query := parsed.Query()
+		if !stringSliceEqual(query["resource"], tOidc.resources) {
+			t.Errorf("Expected resources %v, got %v", tOidc.resources, query["resource"])
+		}
+		if got := query.Get("audience"); got != tOidc.audience {
+			t.Errorf("Expected audience %q, got %q", tOidc.audience, got)
+		}
+	})
+
+	t.Run("Token Request", func(t *testing.T) {
+		for _, grantType := range []string{"authorization_code", "refresh_token"} {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Fatalf("Failed to parse form: %v", err)
+				}
+				if !stringSliceEqual(r.PostForm["resource"], tOidc.resources) {
+					t.Errorf("%s: expected resources %v, got %v", grantType, tOidc.resources, r.PostForm["resource"])
+				}
+				if got := r.PostForm.Get("audience"); got != tOidc.audience {
+					t.Errorf("%s: expected audience %q, got %q", grantType, tOidc.audience, got)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+			}))
+			tOidc.tokenURL = server.URL
+			if _, err := tOidc.exchangeTokens(context.Background(), grantType, "code-or-token", "http://callback", ""); err != nil {
+				t.Errorf("%s: unexpected error: %v", grantType, err)
+			}
+			server.Close()
+		}
+	})
+	t.Run("Refresh Scope", func(t *testing.T) {
+		for _, sendScope := range []bool{false, true} {
+			tOidc.sendScopeOnRefresh = sendScope
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Fatalf("Failed to parse form: %v", err)
+				}
+				expected := ""
+				if sendScope {
+					expected = tOidc.requestScope()
+				}
+				if got := r.PostForm.Get("scope"); got != expected {
+					t.Errorf("sendScopeOnRefresh=%v: expected scope %q, got %q", sendScope, expected, got)
+				}
+				if !stringSliceEqual(r.PostForm["resource"], tOidc.resources) {
+					t.Errorf("Expected resources %v, got %v", tOidc.resources, r.PostForm["resource"])
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+			}))
+			tOidc.tokenURL = server.URL
+			if _, err := tOidc.exchangeTokens(context.Background(), "refresh_token", "refresh-token", "", ""); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			server.Close()
+		}
+		tOidc.sendScopeOnRefresh = false
+	})
+}
+
+// TestNoStoreHeaders verifies that redirects, unauthorized and callback responses are marked
+// uncacheable and vary on the session cookie.
+func TestNoStoreHeaders(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	checkHeaders := func(t *testing.T, rr *httptest.ResponseRecorder) {
+		t.Helper()
+		if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("Expected Cache-Control no-store, got %q", got)
+		}
+		if got := rr.Header().Values("Vary"); len(got) != 1 || got[0] != "Cookie" {
+			t.Errorf("Expected a single Vary: Cookie header, got %v", got)
+		}
+	}
+
+	t.Run("Redirect", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/protected", nil)
+		ts.tOidc.sendUnauthenticatedResponse(rr, req, "https://test-issuer.com/authorize")
+		checkHeaders(t, rr)
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		rr.Header().Set("Vary", "Cookie")
+		req := httptest.NewRequest("GET", "/protected", nil)
+		req.Header.Set("Accept", "application/json")
+		ts.tOidc.sendErrorResponse(rr, req, "Access denied", http.StatusForbidden)
+		checkHeaders(t, rr)
+	})
+
+	t.Run("Callback", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state=wrong-state", nil)
+		ts.tOidc.handleCallback(rr, req, "http://example.com/callback")
+		checkHeaders(t, rr)
+	})
+
+	t.Run("Implicit callback page", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		ts.tOidc.sendImplicitCallbackPage(rr)
+		checkHeaders(t, rr)
+	})
+
+	t.Run("Silent renew result", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/callback", nil)
+		ts.tOidc.sendSilentRenewResult(rr, req, "success")
+		checkHeaders(t, rr)
+	})
+}
+
+// TestTokenRequestParams verifies that extra token request parameters are sent for both
+// grant types and cannot override reserved parameters.
+func TestTokenRequestParams(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	tOidc := ts.tOidc
+	tOidc.tokenRequestParams = map[string]string{
+		"tenant":    "acme",
+		"client_id": "attacker-client",
+	}
+
+	for _, grantType := range []string{"authorization_code", "refresh_token"} {
+		t.Run(grantType, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Fatalf("Failed to parse form: %v", err)
+				}
+				if got := r.PostForm.Get("tenant"); got != "acme" {
+					t.Errorf("Expected tenant %q, got %q", "acme", got)
+				}
+				if got := r.PostForm.Get("client_id"); got != tOidc.clientID {
+					t.Errorf("Expected reserved client_id %q to be kept, got %q", tOidc.clientID, got)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+			}))
+			defer server.Close()
+
+			tOidc.tokenURL = server.URL
+			if _, err := tOidc.exchangeTokens(context.Background(), grantType, "code-or-token", "http://callback", ""); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenRequestJSONEncoding(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	tOidc := ts.tOidc
+	tOidc.tokenRequestJSON = true
+	tOidc.resources = []string{"https://api.example.com", "https://files.example.com"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Expected Content-Type application/json, got %q", got)
+		}
+		var params map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			t.Fatalf("Failed to decode JSON body: %v", err)
+		}
+		if params["grant_type"] != "authorization_code" || params["code"] != "test-code" || params["client_id"] != tOidc.clientID {
+			t.Errorf("Unexpected token request parameters: %v", params)
+		}
+		if resources, ok := params["resource"].([]interface{}); !ok || len(resources) != 2 {
+			t.Errorf("Expected both resources as an array, got %v", params["resource"])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+	}))
+	defer server.Close()
+
+	tOidc.tokenURL = server.URL
+	if _, err := tOidc.exchangeTokens(context.Background(), "authorization_code", "test-code", "http://callback", ""); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// TestSigningAlgorithmAllowlist verifies that "none" and algorithms outside the allowlist are
+// rejected from the token header, and that a token algorithm must match the key's algorithm.
+func TestSigningAlgorithmAllowlist(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	tests := []struct {
+		name        string
+		alg         string
+		allowed     []string
+		expectedErr string
+	}{
+		{
+			name:        "None Algorithm",
+			alg:         "none",
+			expectedErr: "unsigned tokens (alg: none) are not accepted",
+		},
+		{
+			name:        "Algorithm Not In Default Allowlist",
+			alg:         "RS384",
+			expectedErr: "signing algorithm RS384 is not allowed",
+		},
+		{
+			name:        "Algorithm Not Matching Key",
+			alg:         "RS384",
+			allowed:     []string{"RS256", "RS384"},
+			expectedErr: "token algorithm RS384 does not match key algorithm RS256",
+		},
+		{
+			name:    "Allowed Algorithm",
+			alg:     "RS256",
+			allowed: []string{"RS256"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts.tOidc.allowedSigningAlgs = createStringMap(tc.allowed)
+			token, err := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+				"iss":   "https://test-issuer.com",
+				"aud":   "test-client-id",
+				"exp":   float64(time.Now().Add(1 * time.Hour).Unix()),
+				"iat":   float64(time.Now().Add(-2 * time.Minute).Unix()),
+				"nbf":   float64(time.Now().Add(-2 * time.Minute).Unix()),
+				"sub":   "test-subject",
+				"nonce": "test-nonce",
+				"jti":   generateRandomString(16),
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test JWT: %v", err)
+			}
+			jwt, err := parseJWT(token)
+			if err != nil {
+				t.Fatalf("Failed to parse test JWT: %v", err)
+			}
+			jwt.Header["alg"] = tc.alg
+
+			err = ts.tOidc.VerifyJWTSignatureAndClaims(context.Background(), jwt, token)
+			if tc.expectedErr == "" {
+				if err != nil {
+					t.Errorf("Expected token to verify, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("Expected error containing %q, got: %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+// TestPreserveFragment verifies that the URL fragment is captured in the browser before the
+// login redirect, stored in the session, and restored on the post-login redirect.
+func TestPreserveFragment(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.preserveFragment = true
+
+	t.Run("Capture page served to browsers", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/app", nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+		rr := httptest.NewRecorder()
+		session, _ := tOidc.sessionManager.GetSession(req)
+		tOidc.defaultInitiateAuthentication(rr, req, session, "http://example.com/callback")
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+		}
+		body := rr.Body.String()
+		if !strings.Contains(body, "window.location.hash") || !strings.Contains(body, fragmentParam) {
+			t.Errorf("Expected fragment capture script, got %q", body)
+		}
+	})
+
+	t.Run("Captured fragment stored in session", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/app?tab=1&"+fragmentParam+"=%23%2Fusers%2F42", nil)
+		req.Header.Set("Accept", "text/html")
+		rr := httptest.NewRecorder()
+		session, _ := tOidc.sessionManager.GetSession(req)
+		tOidc.defaultInitiateAuthentication(rr, req, session, "http://example.com/callback")
+
+		if rr.Code != http.StatusFound {
+			t.Fatalf("Expected status %d, got %d", http.StatusFound, rr.Code)
+		}
+		if got := session.GetIncomingPath(); got != "/app?tab=1" {
+			t.Errorf("Expected incoming path %q, got %q", "/app?tab=1", got)
+		}
+		if got := session.GetIncomingFragment(); got != "#/users/42" {
+			t.Errorf("Expected incoming fragment %q, got %q", "#/users/42", got)
+		}
+	})
+
+	t.Run("Invalid fragment dropped", func(t *testing.T) {
+		u, _ := url.Parse("/app?" + fragmentParam + "=%2Fno-hash")
+		fragment, path, ok := extractFragmentParam(u)
+		if !ok || fragment != "" || path != "/app" {
+			t.Errorf("Expected invalid fragment to be dropped, got %q %q %v", fragment, path, ok)
+		}
+	})
+
+	t.Run("Fragment restored after callback", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state=test-csrf-token", nil)
+		rr := httptest.NewRecorder()
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetCSRF("test-csrf-token")
+		session.SetNonce("test-nonce")
+		session.SetIncomingPath("/app")
+		session.SetIncomingFragment("#/users/42")
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Failed to save session: %v", err)
+		}
+
+		callbackReq := httptest.NewRequest("GET", "/callback?code=test-code&state=test-csrf-token", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			callbackReq.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		tOidc.handleCallback(rr, callbackReq, "http://example.com/callback")
+
+		if rr.Code != http.StatusFound {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusFound, rr.Code, rr.Body.String())
+		}
+		if got := rr.Header().Get("Location"); got != "/app#/users/42" {
+			t.Errorf("Expected redirect to %q, got %q", "/app#/users/42", got)
+		}
+	})
+}
+
+func TestPostLoginHTMLRedirect(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.postLoginHTMLRedirect = true
+	// Strict token cookies are supported because the page requests the destination same-site
+	tOidc.sessionManager.SetSameSite(0, http.SameSiteStrictMode)
+
+	req := httptest.NewRequest("GET", "/callback?code=test-code&state=test-csrf-token", nil)
+	rr := httptest.NewRecorder()
+	session, _ := tOidc.sessionManager.GetSession(req)
+	session.SetCSRF("test-csrf-token")
+	session.SetNonce("test-nonce")
+	session.SetIncomingPath("/app?tab=1&view=<b>")
+	session.SetIncomingFragment("#/users/42")
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	callbackReq := httptest.NewRequest("GET", "/callback?code=test-code&state=test-csrf-token", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		callbackReq.AddCookie(cookie)
+	}
+	rr = httptest.NewRecorder()
+	tOidc.handleCallback(rr, callbackReq, "http://example.com/callback")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if location := rr.Header().Get("Location"); location != "" {
+		t.Errorf("Expected no Location header, got %q", location)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "window.location.replace(") || !strings.Contains(body, "#/users/42") {
+		t.Errorf("Expected a navigation to the original page and fragment, got %q", body)
+	}
+	if strings.Contains(body, "<b>") {
+		t.Errorf("Expected the destination to be escaped, got %q", body)
+	}
+	setCookie, setTokenCookie := false, false
+	for _, cookie := range rr.Result().Cookies() {
+		setCookie = setCookie || cookie.Name == mainCookieName
+		if cookie.Name == accessTokenCookie && cookie.MaxAge >= 0 {
+			setTokenCookie = true
+			if cookie.SameSite != http.SameSiteStrictMode {
+				t.Errorf("Expected the access token cookie to be SameSite=Strict, got %v", cookie.SameSite)
+			}
+		}
+	}
+	if !setCookie || !setTokenCookie {
+		t.Error("Expected the session and token cookies to be set on the page response")
+	}
+}
+
+func TestLogoutConfirmation(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.logoutConfirmation = true
+
+	req := httptest.NewRequest("GET", "/callback/logout", nil)
+	rr := httptest.NewRecorder()
+	session, _ := tOidc.sessionManager.GetSession(req)
+	session.SetAuthenticated(true)
+	session.SetEmail("user@example.com")
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+	cookies := make(map[string]*http.Cookie)
+	keepCookies := func(rr *httptest.ResponseRecorder) {
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+	}
+	newRequest := func(method, body string) *http.Request {
+		req := httptest.NewRequest(method, "/callback/logout", strings.NewReader(body))
+		if method == http.MethodPost {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, cookie := range cookies {
+			if cookie.MaxAge >= 0 {
+				req.AddCookie(cookie)
+			}
+		}
+		return req
+	}
+	keepCookies(rr)
+
+	rr = httptest.NewRecorder()
+	tOidc.handleLogout(rr, newRequest(http.MethodGet, ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the confirmation page with status %d, got %d", http.StatusOK, rr.Code)
+	}
+	match := regexp.MustCompile(`name="csrf" value="([^"]+)"`).FindStringSubmatch(rr.Body.String())
+	if match == nil || !strings.Contains(rr.Body.String(), `action="/callback/logout"`) {
+		t.Fatalf("Expected a form posting a CSRF token to the logout path, got %q", rr.Body.String())
+	}
+	keepCookies(rr)
+
+	for name, body := range map[string]string{
+		"missing token": "",
+		"wrong token":   "csrf=" + url.QueryEscape(match[1]+"x"),
+	} {
+		rr = httptest.NewRecorder()
+		tOidc.handleLogout(rr, newRequest(http.MethodPost, body))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusForbidden, rr.Code)
+		}
+	}
+	if session, _ := tOidc.sessionManager.GetSession(newRequest(http.MethodGet, "")); !session.GetAuthenticated() {
+		t.Error("Expected the session to survive rejected logout requests")
+	}
+
+	rr = httptest.NewRecorder()
+	tOidc.handleLogout(rr, newRequest(http.MethodPost, "csrf="+url.QueryEscape(match[1])))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected a confirmed logout to redirect, got %d: %s", rr.Code, rr.Body.String())
+	}
+	keepCookies(rr)
+	if session, _ := tOidc.sessionManager.GetSession(newRequest(http.MethodGet, "")); session.GetAuthenticated() {
+		t.Error("Expected the session to be cleared after a confirmed logout")
+	}
+}
+
+func TestLoginHintToken(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+
+	login := func(req *http.Request) url.Values {
+		rr := httptest.NewRecorder()
+		session, _ := tOidc.sessionManager.GetSession(req)
+		tOidc.defaultInitiateAuthentication(rr, req, session, "http://example.com/callback")
+		location, err := url.Parse(rr.Header().Get("Location"))
+		if err != nil || rr.Code != http.StatusFound {
+			t.Fatalf("Expected a redirect to the provider, got %d: %v", rr.Code, err)
+		}
+		return location.Query()
+	}
+
+	t.Run("From a request header", func(t *testing.T) {
+		tOidc.loginHintTokenHeader = "X-Login-Hint-Token"
+		defer func() { tOidc.loginHintTokenHeader = "" }()
+
+		req := httptest.NewRequest("GET", "/app", nil)
+		req.Header.Set("X-Login-Hint-Token", "header-hint")
+		query := login(req)
+		if got := query.Get("login_hint_token"); got != "header-hint" {
+			t.Errorf("Expected login_hint_token %q, got %q", "header-hint", got)
+		}
+		if query.Has("login_hint") {
+			t.Error("Expected no login_hint alongside login_hint_token")
+		}
+
+		if query := login(httptest.NewRequest("GET", "/app", nil)); query.Has("login_hint_token") {
+			t.Error("Expected no login_hint_token without the header")
+		}
+	})
+
+	t.Run("From a claim of the previous ID token", func(t *testing.T) {
+		tOidc.loginHintTokenClaim = "hints.login"
+		defer func() { tOidc.loginHintTokenClaim = "" }()
+
+		token, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+			"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(-time.Hour).Unix(),
+			"sub": "test-subject", "hints": map[string]interface{}{"login": "claim-hint"},
+		})
+		req := httptest.NewRequest("GET", "/app", nil)
+		rr := httptest.NewRecorder()
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetAccessToken(token)
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Failed to save session: %v", err)
+		}
+
+		if got := login(replayCookies(rr)).Get("login_hint_token"); got != "claim-hint" {
+			t.Errorf("Expected login_hint_token %q, got %q", "claim-hint", got)
+		}
+	})
+}
+
+// TestRefreshOnlyWhenForwarded verifies that proactive refresh is skipped while the access
+// token is not forwarded upstream, and happens once it is.
+func TestRefreshOnlyWhenForwarded(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tOidc.refreshGracePeriod = time.Minute
+	tOidc.refreshOnlyForwarded = true
+
+	refreshCalls := 0
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+			refreshCalls++
+			return nil, fmt.Errorf("provider unavailable")
+		},
+	}
+
+	now := time.Now()
+	nearExpiryToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+		"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": now.Add(30 * time.Second).Unix(),
+		"iat": now.Add(-1 * time.Minute).Unix(), "nbf": now.Add(-1 * time.Minute).Unix(),
+		"sub": "test-subject", "email": "user@example.com", "jti": generateRandomString(16),
+	})
+
+	req := httptest.NewRequest("GET", "/protected", nil)
+	rr := httptest.NewRecorder()
+	session, _ := tOidc.sessionManager.GetSession(req)
+	session.SetAuthenticated(true)
+	session.SetEmail("user@example.com")
+	session.SetAccessToken(nearExpiryToken)
+	session.SetRefreshToken("test-refresh-token")
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+	cookies := rr.Result().Cookies()
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "/protected", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, newRequest())
+	if rr.Code != http.StatusOK || refreshCalls != 0 {
+		t.Fatalf("Expected 200 without refresh while token is not forwarded, got status %d with %d attempts", rr.Code, refreshCalls)
+	}
+
+	tOidc.accessTokenHeader = "Authorization"
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, newRequest())
+	if refreshCalls != 1 {
+		t.Errorf("Expected a refresh attempt once the token is forwarded, got %d", refreshCalls)
+	}
+}
+
+// TestBuildRedirectURL verifies that the redirect URI is derived from the request unless a
+// public callback URL is configured.
+func TestBuildRedirectURL(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.redirURLPath = "/oauth2/callback"
+
+	req := httptest.NewRequest("GET", "http://internal:8080/protected", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "app.example.com")
+	if got := tOidc.buildRedirectURL(req); got != "https://app.example.com/oauth2/callback" {
+		t.Errorf("Expected request-derived redirect URI, got %q", got)
+	}
+
+	tOidc.publicCallbackURL = "https://auth.example.com/sso/callback"
+	if got := tOidc.buildRedirectURL(req); got != tOidc.publicCallbackURL {
+		t.Errorf("Expected configured public callback URL, got %q", got)
+	}
+}
+
+// TestDynamicRedirectURI verifies that logins and callbacks for hosts outside the allowlist are
+// rejected, that authenticated requests are not, and that the token exchange repeats the
+// redirect URI stored with the authorization request.
+func TestDynamicRedirectURI(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.allowedRedirectHosts = []string{"*.tenants.example.com"}
+
+	t.Run("Host not allowed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://attacker.com/protected", nil)
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+
+		rr = httptest.NewRecorder()
+		tOidc.handleCallback(rr, httptest.NewRequest("GET", "http://attacker.com/callback?code=test-code&state=test-state", nil), "http://attacker.com/callback")
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected callback status %d, got %d", http.StatusBadRequest, rr.Code)
+		}
+	})
+
+	t.Run("Authenticated request to another host", func(t *testing.T) {
+		tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		req := httptest.NewRequest("GET", "http://upstream.internal/api", nil)
+		rr := httptest.NewRecorder()
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		session.SetAccessToken(ts.token)
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Failed to save session: %v", err)
+		}
+		for _, cookie := range rr.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("Expected the authenticated request to reach the upstream, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Tenant redirect URI reused for token exchange", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://acme.tenants.example.com/protected", nil)
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, req)
+		if rr.Code != http.StatusFound {
+			t.Fatalf("Expected status %d, got %d", http.StatusFound, rr.Code)
+		}
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		expectedRedirect := "http://acme.tenants.example.com/callback"
+		if got := location.Query().Get("redirect_uri"); got != expectedRedirect {
+			t.Fatalf("Expected redirect_uri %q, got %q", expectedRedirect, got)
+		}
+
+		var exchangedRedirect string
+		tOidc.tokenExchanger = &MockTokenExchanger{
+			ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+				exchangedRedirect = redirectURL
+				return nil, fmt.Errorf("stop after capturing redirect URI")
+			},
+		}
+		callbackReq := httptest.NewRequest("GET", "http://acme.tenants.example.com/callback?code=test-code&state="+location.Query().Get("state"), nil)
+		// Keep only the last Set-Cookie per name; the session is cleared before being rewritten
+		latest := map[string]*http.Cookie{}
+		for _, cookie := range rr.Result().Cookies() {
+			latest[cookie.Name] = cookie
+		}
+		for _, cookie := range latest {
+			callbackReq.AddCookie(cookie)
+		}
+		tOidc.handleCallback(httptest.NewRecorder(), callbackReq, "http://other.tenants.example.com/callback")
+		if exchangedRedirect != expectedRedirect {
+			t.Errorf("Expected token exchange redirect_uri %q, got %q", expectedRedirect, exchangedRedirect)
+		}
+	})
+}
+
+// TestAuditHook verifies that authentication events are reported to the audit hook with the
+// user's email and remote IP, and that raw tokens never appear in them.
+func TestAuditHook(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	var events []AuditEvent
+	ts.tOidc.auditHook = func(event AuditEvent) {
+		events = append(events, event)
+	}
+
+	lastEvent := func(t *testing.T, want AuditEventType) AuditEvent {
+		t.Helper()
+		if len(events) == 0 {
+			t.Fatalf("Expected a %s event, got none", want)
+		}
+		event := events[len(events)-1]
+		if event.Type != want {
+			t.Fatalf("Expected a %s event, got %s", want, event.Type)
+		}
+		if event.Timestamp.IsZero() {
+			t.Error("Expected the event to carry a timestamp")
+		}
+		if event.RemoteIP != "192.0.2.1" {
+			t.Errorf("Expected remote IP 192.0.2.1, got %q", event.RemoteIP)
+		}
+		return event
+	}
+
+	t.Run("LoginFailure", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state=wrong-state", nil)
+		ts.tOidc.handleCallback(rr, req, "http://example.com/callback")
+		event := lastEvent(t, AuditLoginFailure)
+		if event.Reason == "" {
+			t.Error("Expected a reason for the login failure")
+		}
+	})
+
+	t.Run("AccessDenied", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/protected", nil)
+		session, err := ts.tOidc.sessionManager.GetSession(req)
+		if err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+		session.SetEmail("user@not-allowed.org")
+		session.SetAccessToken(ts.token)
+
+		rr := httptest.NewRecorder()
+		ts.tOidc.processAuthorizedRequest(rr, req, session, "http://example.com/callback")
+		event := lastEvent(t, AuditAccessDenied)
+		if event.Email != "user@not-allowed.org" {
+			t.Errorf("Expected email user@not-allowed.org, got %q", event.Email)
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/logout", nil)
+		session, err := ts.tOidc.sessionManager.GetSession(req)
+		if err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Failed to save session: %v", err)
+		}
+		for _, cookie := range rr.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+
+		ts.tOidc.handleLogout(httptest.NewRecorder(), req)
+		event := lastEvent(t, AuditLogout)
+		if event.Email != "user@example.com" {
+			t.Errorf("Expected email user@example.com, got %q", event.Email)
+		}
+	})
+
+	for _, event := range events {
+		if strings.Contains(fmt.Sprintf("%+v", event), ts.token) {
+			t.Errorf("Audit event %s contains a raw token", event.Type)
+		}
+	}
+}
+
+// TestUserIDClaim verifies that the user identifier is read from the configured claim and
+// forwarded downstream, while domain restrictions keep applying to the email claim.
+func TestUserIDClaim(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+
+	claims := map[string]interface{}{
+		"sub":                "user-123",
+		"preferred_username": "jdoe",
+		"email":              "jdoe@example.com",
+	}
+
+	tests := []struct {
+		name           string
+		userIDClaim    string
+		claims         map[string]interface{}
+		expectedUserID string
+		expectedEmail  string
+	}{
+		{name: "Default email claim", userIDClaim: "", claims: claims, expectedUserID: "jdoe@example.com", expectedEmail: "jdoe@example.com"},
+		{name: "Preferred username", userIDClaim: "preferred_username", claims: claims, expectedUserID: "jdoe", expectedEmail: "jdoe@example.com"},
+		{name: "Subject without email", userIDClaim: "sub", claims: map[string]interface{}{"sub": "user-123"}, expectedUserID: "user-123", expectedEmail: ""},
+		{name: "Missing claim", userIDClaim: "upn", claims: claims, expectedUserID: "", expectedEmail: "jdoe@example.com"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tOidc.userIDClaim = tc.userIDClaim
+			userID, email := tOidc.userIdentity(tc.claims)
+			if userID != tc.expectedUserID {
+				t.Errorf("Expected user ID %q, got %q", tc.expectedUserID, userID)
+			}
+			if email != tc.expectedEmail {
+				t.Errorf("Expected email %q, got %q", tc.expectedEmail, email)
+			}
+		})
+	}
+
+	t.Run("Forwarded user header", func(t *testing.T) {
+		var forwardedUser string
+		tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwardedUser = r.Header.Get("X-Forwarded-User")
+		})
+		tOidc.allowedUserDomains = nil
+
+		req := httptest.NewRequest("GET", "/protected", nil)
+		session, err := tOidc.sessionManager.GetSession(req)
+		if err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+		session.SetUserID("user-123")
+		session.SetAccessToken(ts.token)
+
+		tOidc.processAuthorizedRequest(httptest.NewRecorder(), req, session, "http://example.com/callback")
+		if forwardedUser != "user-123" {
+			t.Errorf("Expected X-Forwarded-User %q, got %q", "user-123", forwardedUser)
+		}
+	})
+}
+
+// TestRefreshPreservesOmittedRefreshToken verifies that the stored refresh token is only
+// replaced when the token endpoint returns a new one, as the spec allows it to be omitted.
+func TestRefreshPreservesOmittedRefreshToken(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	tests := []struct {
+		name                 string
+		returnedRefreshToken string
+		expectedRefreshToken string
+	}{
+		{name: "Omitted refresh token is kept", returnedRefreshToken: "", expectedRefreshToken: "original-refresh-token"},
+		{name: "Rotated refresh token is stored", returnedRefreshToken: "rotated-refresh-token", expectedRefreshToken: "rotated-refresh-token"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			newToken, err := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+				"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(1 * time.Hour).Unix(),
+				"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject", "email": "user@example.com",
+				"jti": generateRandomString(16),
+			})
+			if err != nil {
+				t.Fatalf("Failed to create test JWT: %v", err)
+			}
+			ts.tOidc.tokenExchanger = &MockTokenExchanger{
+				RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+					return &TokenResponse{IDToken: newToken, AccessToken: newToken, RefreshToken: tc.returnedRefreshToken, ExpiresIn: 3600}, nil
+				},
+			}
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			session, err := ts.tOidc.sessionManager.GetSession(req)
+			if err != nil {
+				t.Fatalf("Failed to get session: %v", err)
+			}
+			session.SetRefreshToken("original-refresh-token")
+
+			if !ts.tOidc.refreshToken(httptest.NewRecorder(), req, session) {
+				t.Fatal("Expected the refresh to succeed")
+			}
+			if got := session.GetRefreshToken(); got != tc.expectedRefreshToken {
+				t.Errorf("Expected refresh token %q, got %q", tc.expectedRefreshToken, got)
+			}
+		})
+	}
+}
+
+// TestRefreshReplacesIDToken verifies that an ID token returned on refresh replaces the
+// stored one (later used as id_token_hint) and that the user's identity is re-extracted.
+func TestRefreshReplacesIDToken(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	ts.tOidc.userIDClaim = "preferred_username"
+	ts.tOidc.accessTokenHeader = "Authorization"
+
+	newToken, err := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+		"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(1 * time.Hour).Unix(),
+		"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject", "email": "renamed@example.com",
+		"preferred_username": "renamed", "jti": generateRandomString(16),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test JWT: %v", err)
+	}
+	ts.tOidc.tokenExchanger = &MockTokenExchanger{
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+			return &TokenResponse{IDToken: newToken, AccessToken: "opaque-access-token", ExpiresIn: 3600}, nil
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/protected", nil)
+	session, err := ts.tOidc.sessionManager.GetSession(req)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	session.SetEmail("user@example.com")
+	session.SetUserID("user")
+	session.SetAccessToken(ts.token)
+	session.SetRefreshToken("valid-refresh-token")
+
+	if !ts.tOidc.refreshToken(httptest.NewRecorder(), req, session) {
+		t.Fatal("Expected the refresh to succeed")
+	}
+	if session.GetAccessToken() != newToken {
+		t.Error("Expected the stored ID token to be replaced by the refreshed one")
+	}
+	if got := session.GetProviderAccessToken(); got != "opaque-access-token" {
+		t.Errorf("Expected the refreshed provider access token to be stored apart, got %q", got)
+	}
+	if got := session.GetEmail(); got != "renamed@example.com" {
+		t.Errorf("Expected email %q, got %q", "renamed@example.com", got)
+	}
+	if got := session.GetUserID(); got != "renamed" {
+		t.Errorf("Expected user ID %q, got %q", "renamed", got)
+	}
+}
+
+// TestProviderTLS verifies that a provider behind a private CA is reached using the configured
+// CA file, and that an injected provider transport replaces the default one.
+func TestProviderTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ProviderMetadata{
+			Issuer:   "https://private-issuer.example.com",
+			AuthURL:  "https://private-issuer.example.com/auth",
+			TokenURL: "https://private-issuer.example.com/token",
+			JWKSURL:  "https://private-issuer.example.com/jwks",
+		})
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	newMiddleware := func(t *testing.T, config *Config) *TraefikOidc {
+		t.Helper()
+		config.ProviderURL = server.URL
+		config.ClientID = "test-client"
+		config.ClientSecret = "test-secret"
+		config.CallbackURL = "/callback"
+		config.SessionEncryptionKey = "test-encryption-key-thats-long-enough"
+		handler, err := New(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), config, "test")
+		if err != nil {
+			t.Fatalf("Failed to create middleware: %v", err)
+		}
+		m := handler.(*TraefikOidc)
+		select {
+		case <-m.initComplete:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Middleware failed to initialize")
+		}
+		return m
+	}
+
+	t.Run("CA file", func(t *testing.T) {
+		m := newMiddleware(t, &Config{ProviderCAFile: caFile})
+		if m.issuerURL != "https://private-issuer.example.com" {
+			t.Errorf("Expected metadata to be fetched over TLS, got issuer %q", m.issuerURL)
+		}
+	})
+
+	t.Run("Injected transport", func(t *testing.T) {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		m := newMiddleware(t, &Config{ProviderTransport: transport})
+		if m.httpClient.Transport != transport {
+			t.Error("Expected the injected transport to be used for provider requests")
+		}
+		if m.issuerURL != "https://private-issuer.example.com" {
+			t.Errorf("Expected metadata to be fetched over TLS, got issuer %q", m.issuerURL)
+		}
+	})
+
+	t.Run("Unreadable CA file", func(t *testing.T) {
+		config := &Config{
+			ProviderURL:          server.URL,
+			ClientID:             "test-client",
+			ClientSecret:         "test-secret",
+			CallbackURL:          "/callback",
+			SessionEncryptionKey: "test-encryption-key-thats-long-enough",
+			ProviderCAFile:       filepath.Join(t.TempDir(), "missing.pem"),
+		}
+		if _, err := New(context.Background(), http.NotFoundHandler(), config, "test"); err == nil {
+			t.Error("Expected an error for a missing CA file")
+		}
+	})
+}
+
+func TestProviderTimeouts(t *testing.T) {
+	// A provider that accepts connections but never completes the TLS handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := createDefaultHTTPClient()
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSHandshakeTimeout != DefaultProviderTLSHandshakeTimeout {
+		t.Errorf("Expected the default TLS handshake timeout, got %v", transport.TLSHandshakeTimeout)
+	}
+	setProviderTimeouts(transport, time.Second, 200*time.Millisecond)
+
+	start := time.Now()
+	_, err = client.Get("https://" + listener.Addr().String() + "/.well-known/openid-configuration")
+	if err == nil || !strings.Contains(err.Error(), "TLS handshake timeout") {
+		t.Fatalf("Expected a TLS handshake timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the handshake timeout to fail the request well before the client timeout, took %v", elapsed)
+	}
+}
+
+// TestCertificateBinding verifies RFC 8705 certificate binding: bound tokens require the
+// client certificate with the matching thumbprint, from the TLS connection or a forwarded header.
+func TestIntrospectionFallback(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+
+	active := true
+	introspections := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		introspections++
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("token") == "" || r.PostForm.Get("client_id") != tOidc.clientID ||
+			r.PostForm.Get("token_type_hint") != "id_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"active": active})
+	}))
+	defer server.Close()
+	tOidc.httpClient = server.Client()
+	tOidc.introspectionURL = server.URL
+
+	verify := func(ctx context.Context) error {
+		token, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+			"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+			"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject", "jti": generateRandomString(16),
+		})
+		jwt, err := parseJWT(token)
+		if err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		return tOidc.VerifyJWTSignatureAndClaims(ctx, jwt, token)
+	}
+
+	jwks := ts.mockJWKCache.JWKS
+	ts.mockJWKCache.JWKS = nil
+	ts.mockJWKCache.Err = errors.New("jwks endpoint down")
+
+	if err := verify(context.Background()); err == nil || !strings.Contains(err.Error(), "failed to get JWKS") {
+		t.Fatalf("Expected JWKS error without the fallback, got %v", err)
+	}
+
+	tOidc.introspectionFallback = &introspectionFallback{}
+	if err := verify(context.Background()); err != nil {
+		t.Fatalf("Expected token to be verified through introspection, got %v", err)
+	}
+	if introspections != 1 || !tOidc.introspectionFallback.active {
+		t.Fatalf("Expected fallback mode after one introspection, got %d introspections (active=%v)", introspections, tOidc.introspectionFallback.active)
+	}
+
+	// The introspection request is cancelled together with the client request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	introspections = 0
+	if err := verify(ctx); !errors.Is(err, context.Canceled) || introspections != 0 {
+		t.Errorf("Expected a cancelled context to cancel introspection, got %v (%d introspections)", err, introspections)
+	}
+
+	active = false
+	if err := verify(context.Background()); err == nil || !strings.Contains(err.Error(), "not active") {
+		t.Errorf("Expected inactive token to be rejected, got %v", err)
+	}
+
+	// Once the JWKS can be fetched again, local verification resumes
+	ts.mockJWKCache.JWKS = jwks
+	ts.mockJWKCache.Err = nil
+	if err := verify(context.Background()); err == nil {
+		t.Error("Expected the JWKS not to be retried before the retry interval")
+	}
+	tOidc.introspectionFallback.lastAttempt = time.Now().Add(-jwksRetryInterval)
+	introspections = 0
+	if err := verify(context.Background()); err != nil {
+		t.Fatalf("Expected local verification after the JWKS recovered, got %v", err)
+	}
+	if introspections != 0 || tOidc.introspectionFallback.active {
+		t.Errorf("Expected local verification without introspection, got %d introspections (active=%v)", introspections, tOidc.introspectionFallback.active)
+	}
+}
+
+func TestCertificateBinding(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.clientCertHeader = "X-Forwarded-Tls-Client-Cert"
+
+	newCert := func(t *testing.T, serial int64) []byte {
+		t.Helper()
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "client"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, ts.rsaPublicKey, ts.rsaPrivateKey)
+		if err != nil {
+			t.Fatalf("Failed to create certificate: %v", err)
+		}
+		return der
+	}
+	boundDER := newCert(t, 1)
+	otherDER := newCert(t, 2)
+	boundClaims := map[string]interface{}{
+		"cnf": map[string]interface{}{"x5t#S256": certificateThumbprint(boundDER)},
+	}
+	pemCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: boundDER}))
+
+	tests := []struct {
+		name        string
+		claims      map[string]interface{}
+		peerCert    []byte
+		header      string
+		expectError bool
+	}{
+		{name: "Unbound token", claims: map[string]interface{}{"sub": "user"}},
+		{name: "Matching TLS certificate", claims: boundClaims, peerCert: boundDER},
+		{name: "Matching forwarded certificate", claims: boundClaims, header: url.QueryEscape(base64.StdEncoding.EncodeToString(boundDER))},
+		{name: "Matching unescaped certificate", claims: boundClaims, header: base64.StdEncoding.EncodeToString(boundDER)},
+		{name: "Matching XFCC certificate", claims: boundClaims, header: `Hash=abc;Cert="` + url.QueryEscape(pemCert) + `";Subject="CN=client"`},
+		{name: "Different certificate", claims: boundClaims, peerCert: otherDER, expectError: true},
+		{name: "No certificate", claims: boundClaims, expectError: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/protected", nil)
+			if tc.peerCert != nil {
+				cert, err := x509.ParseCertificate(tc.peerCert)
+				if err != nil {
+					t.Fatalf("Failed to parse certificate: %v", err)
+				}
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			}
+			if tc.header != "" {
+				req.Header.Set("X-Forwarded-Tls-Client-Cert", tc.header)
+			}
+
+			err := tOidc.verifyCertificateBinding(req, tc.claims)
+			if tc.expectError && err == nil {
+				t.Error("Expected a certificate binding error")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestOutageGracePeriod verifies that a just-expired session keeps being served while the
+// provider is unavailable, and hard-expires once the grace period passes or the provider
+// rejects the refresh.
+func TestOutageGracePeriod(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	// Tokens must be expired for longer than the clock skew tolerance to need a refresh
+	tOidc.outageGracePeriod = 5 * time.Minute
+
+	tests := []struct {
+		name           string
+		expiredFor     time.Duration
+		refreshErr     error
+		expectedStatus int
+	}{
+		{name: "Provider down within grace period", expiredFor: 3 * time.Minute, refreshErr: &OAuthError{StatusCode: http.StatusServiceUnavailable}, expectedStatus: http.StatusOK},
+		{name: "Provider unreachable within grace period", expiredFor: 3 * time.Minute, refreshErr: &url.Error{Op: "Post", URL: "https://test-issuer.com/token", Err: fmt.Errorf("connection refused")}, expectedStatus: http.StatusOK},
+		{name: "Grace period passed", expiredFor: 10 * time.Minute, refreshErr: &OAuthError{StatusCode: http.StatusBadGateway}, expectedStatus: http.StatusFound},
+		{name: "Provider rejects refresh", expiredFor: 3 * time.Minute, refreshErr: newOAuthError(http.StatusBadRequest, []byte(`{"error":"invalid_grant"}`)), expectedStatus: http.StatusFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tOidc.tokenExchanger = &MockTokenExchanger{
+				RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+					return nil, tc.refreshErr
+				},
+			}
+
+			expiredAt := time.Now().Add(-tc.expiredFor)
+			expiredToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+				"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": expiredAt.Unix(),
+				"iat": expiredAt.Add(-time.Hour).Unix(), "nbf": expiredAt.Add(-time.Hour).Unix(),
+				"sub": "test-subject", "email": "user@example.com", "jti": generateRandomString(16),
+			})
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			rr := httptest.NewRecorder()
+			session, _ := tOidc.sessionManager.GetSession(req)
+			session.SetAuthenticated(true)
+			session.SetEmail("user@example.com")
+			session.SetAccessToken(expiredToken)
+			session.SetRefreshToken("test-refresh-token")
+			if err := session.Save(req, rr); err != nil {
+				t.Fatalf("Failed to save session: %v", err)
+			}
+
+			req = httptest.NewRequest("GET", "/protected", nil)
+			for _, cookie := range rr.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+			rr = httptest.NewRecorder()
+			tOidc.ServeHTTP(rr, req)
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+// TestInvalidateUserForcesReauthentication verifies that a request with an invalidated
+// session is sent back to the provider.
+func TestInvalidateUserForcesReauthentication(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/protected", nil)
+	rr := httptest.NewRecorder()
+	session, _ := tOidc.sessionManager.GetSession(req)
+	session.SetAuthenticated(true)
+	session.SetEmail("user@example.com")
+	session.SetAccessToken(ts.token)
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+	cookies := rr.Result().Cookies()
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "/protected", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		return req
+	}
+
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, newRequest())
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d before invalidation, got %d", http.StatusOK, rr.Code)
+	}
+
+	tOidc.InvalidateUser("user@example.com")
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, newRequest())
+	if rr.Code != http.StatusFound {
+		t.Errorf("Expected status %d after invalidation, got %d", http.StatusFound, rr.Code)
+	}
+}
+
+// TestCORSPreflight verifies that CORS preflights are never redirected to the provider, that
+// only allowed origins are answered, and that HEAD requests to protected routes are handled
+// like GET.
+func TestCORSPreflight(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.corsAllowedOrigins = map[string]struct{}{"https://app.example.com": {}}
+	nextCalled := false
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name           string
+		method         string
+		preflight      bool
+		origin         string
+		mode           string
+		expectedStatus int
+		expectNext     bool
+	}{
+		{name: "Preflight answered", method: http.MethodOptions, preflight: true, mode: CORSPreflightRespond, expectedStatus: http.StatusOK},
+		{name: "Preflight passed through", method: http.MethodOptions, preflight: true, mode: CORSPreflightPassthrough, expectedStatus: http.StatusNoContent, expectNext: true},
+		{name: "Preflight from unlisted origin", method: http.MethodOptions, preflight: true, origin: "https://evil.example.com", mode: CORSPreflightRespond, expectedStatus: http.StatusForbidden},
+		{name: "Plain OPTIONS authenticated", method: http.MethodOptions, mode: CORSPreflightRespond, expectedStatus: http.StatusFound},
+		{name: "HEAD like GET", method: http.MethodHead, mode: CORSPreflightRespond, expectedStatus: http.StatusFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			nextCalled = false
+			tOidc.corsPreflight = tc.mode
+			req := httptest.NewRequest(tc.method, "/api/orders", nil)
+			origin := tc.origin
+			if origin == "" {
+				origin = "https://app.example.com"
+			}
+			if tc.preflight {
+				req.Header.Set("Origin", origin)
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			rr := httptest.NewRecorder()
+			tOidc.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+			if nextCalled != tc.expectNext {
+				t.Errorf("Expected next handler called=%v, got %v", tc.expectNext, nextCalled)
+			}
+			if tc.preflight && tc.mode == CORSPreflightRespond {
+				expected := ""
+				if tc.expectedStatus == http.StatusOK {
+					expected = origin
+				}
+				if got := rr.Header().Get("Access-Control-Allow-Origin"); got != expected {
+					t.Errorf("Expected Access-Control-Allow-Origin %q, got %q", expected, got)
+				}
+			}
+		})
+	}
+
+	t.Run("Authorized responses", func(t *testing.T) {
+		for origin, expected := range map[string]string{
+			"https://app.example.com":  "https://app.example.com",
+			"https://evil.example.com": "",
+		} {
+			req := httptest.NewRequest("GET", "/api/orders", nil)
+			req.Header.Set("Origin", origin)
+			session, _ := tOidc.sessionManager.GetSession(req)
+			session.SetAuthenticated(true)
+			session.SetEmail("user@example.com")
+			session.SetAccessToken(ts.token)
+			rr := httptest.NewRecorder()
+			tOidc.processAuthorizedRequest(rr, req, session, "http://example.com/callback")
+
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != expected {
+				t.Errorf("Origin %s: expected Access-Control-Allow-Origin %q, got %q", origin, expected, got)
+			}
+			if credentials := rr.Header().Get("Access-Control-Allow-Credentials"); expected == "" && credentials != "" {
+				t.Errorf("Origin %s: expected no Access-Control-Allow-Credentials, got %q", origin, credentials)
+			}
+		}
+	})
+}
+
+// TestDevMode verifies that dev mode only activates behind the environment guard and then
+// authenticates requests with the static claims without contacting a provider.
+func TestDevMode(t *testing.T) {
+	var forwarded *http.Request
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+		w.WriteHeader(http.StatusOK)
+	})
+	newConfig := func() *Config {
+		config := CreateConfig()
+		config.ProviderURL = "https://unreachable-provider.invalid"
+		config.ClientID = "test-client"
+		config.ClientSecret = "test-secret"
+		config.CallbackURL = "/callback"
+		config.SessionEncryptionKey = "test-encryption-key-thats-long-enough"
+		config.DevMode = true
+		config.DevClaims = map[string]interface{}{
+			"email":  "dev@example.com",
+			"sub":    "dev-user",
+			"groups": []interface{}{"admin", "developers"},
+		}
+		return config
+	}
+
+	t.Setenv(DevModeEnvVar, "")
+	if _, err := New(context.Background(), next, newConfig(), "test"); err == nil {
+		t.Fatal("Expected dev mode to refuse activation without the environment guard")
+	}
+
+	t.Setenv(DevModeEnvVar, "true")
+	handler, err := New(context.Background(), next, newConfig(), "test")
+	if err != nil {
+		t.Fatalf("Failed to create dev mode middleware: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/protected", nil))
+	if rr.Code != http.StatusOK || forwarded == nil {
+		t.Fatalf("Expected request to be forwarded, got status %d", rr.Code)
+	}
+	if got := forwarded.Header.Get("X-Forwarded-User"); got != "dev@example.com" {
+		t.Errorf("Expected X-Forwarded-User dev@example.com, got %q", got)
+	}
+	if got := forwarded.Header.Get("X-User-Groups"); got != "admin,developers" {
+		t.Errorf("Expected X-User-Groups admin,developers, got %q", got)
+	}
+	if cookies := rr.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected no session cookies in dev mode, got %d", len(cookies))
+	}
+
+	config := newConfig()
+	config.AllowedRolesAndGroups = []string{"ops"}
+	handler, err = New(context.Background(), next, config, "test")
+	if err != nil {
+		t.Fatalf("Failed to create dev mode middleware: %v", err)
+	}
+	forwarded = nil
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/protected", nil))
+	if rr.Code != http.StatusForbidden || forwarded != nil {
+		t.Errorf("Expected dev mode user without allowed groups to be denied, got status %d", rr.Code)
+	}
+}
+
+// TestCallbackStateSingleUse verifies that a callback state is accepted only once and only
+// within the state TTL, so stale or replayed authorization responses are rejected.
+func TestCallbackStateSingleUse(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.stateTTL = time.Minute
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+			return nil, fmt.Errorf("invalid_grant")
+		},
+	}
+
+	startLogin := func(issuedAt time.Time) []*http.Cookie {
+		req := httptest.NewRequest("GET", "/callback", nil)
+		rr := httptest.NewRecorder()
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetCSRF("test-csrf-token")
+		session.SetNonce("test-nonce")
+		session.mainSession.Values["csrf_issued_at"] = issuedAt.Unix()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Failed to save session: %v", err)
+		}
+		return rr.Result().Cookies()
+	}
+	callback := func(cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state=test-csrf-token", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		tOidc.handleCallback(rr, req, "http://example.com/callback")
+		return rr
+	}
+
+	t.Run("Replay rejected", func(t *testing.T) {
+		first := callback(startLogin(time.Now()))
+		if first.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected the failed token exchange to be reported, got %d: %s", first.Code, first.Body.String())
+		}
+		replay := callback(first.Result().Cookies())
+		if replay.Code != http.StatusBadRequest || !strings.Contains(replay.Body.String(), "already been used") {
+			t.Errorf("Expected replayed state to be rejected, got %d: %s", replay.Code, replay.Body.String())
+		}
+	})
+
+	t.Run("Stale state rejected", func(t *testing.T) {
+		rr := callback(startLogin(time.Now().Add(-2 * time.Minute)))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "expired") {
+			t.Errorf("Expected stale state to be rejected, got %d: %s", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Fresh state per authorization attempt", func(t *testing.T) {
+		first, _, _, _, _ := tOidc.generateAuthRequestState(httptest.NewRecorder())
+		second, _, _, _, _ := tOidc.generateAuthRequestState(httptest.NewRecorder())
+		if first == "" || first == second {
+			t.Errorf("Expected distinct state values, got %q and %q", first, second)
+		}
+	})
+}
+
+// TestPendingAuthStore verifies that a callback landing on a replica that cannot see the
+// session the login was started in is resolved through the pending authorization store.
+func TestPendingAuthStore(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.pendingAuthStore = NewMemoryPendingAuthStore()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/app/page?tab=2", nil)
+	session, _ := tOidc.sessionManager.GetSession(req)
+	tOidc.defaultInitiateAuthentication(rr, req, session, "http://example.com/callback")
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to parse authorization redirect: %v", err)
+	}
+	state := location.Query().Get("state")
+	nonce := location.Query().Get("nonce")
+	var binding *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == pendingAuthBindingCookie {
+			binding = cookie
+		}
+	}
+	if binding == nil {
+		t.Fatal("Expected the login to set the pending authorization binding cookie")
+	}
+
+	var exchangedRedirectURL string
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+			exchangedRedirectURL = redirectURL
+			idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+				"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+				"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject",
+				"email": "user@example.com", "nonce": nonce, "jti": generateRandomString(16),
+			})
+			return &TokenResponse{IDToken: idToken, RefreshToken: "refresh-token"}, nil
+		},
+	}
+
+	// Another browser presenting the state cannot resolve the pending login (login CSRF)
+	rr = httptest.NewRecorder()
+	tOidc.handleCallback(rr, httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(state), nil), "http://other-replica/callback")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a callback without the binding cookie to be rejected, got %d", rr.Code)
+	}
+	foreignReq := httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(state), nil)
+	foreignReq.AddCookie(&http.Cookie{Name: pendingAuthBindingCookie, Value: strings.Repeat("a", 64)})
+	rr = httptest.NewRecorder()
+	tOidc.handleCallback(rr, foreignReq, "http://other-replica/callback")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a callback with another browser's binding cookie to be rejected, got %d", rr.Code)
+	}
+
+	// nor spoil it for the browser that started it
+	callbackReq := httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(state), nil)
+	callbackReq.AddCookie(binding)
+	rr = httptest.NewRecorder()
+	tOidc.handleCallback(rr, callbackReq, "http://other-replica/callback")
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected the starting browser's callback to succeed after a rejected one, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// The callback carries no session cookie, as if another replica had started the login,
+	// but comes from the browser that started it
+	rr = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/app/page?tab=2", nil)
+	req.AddCookie(binding)
+	session, _ = tOidc.sessionManager.GetSession(req)
+	tOidc.defaultInitiateAuthentication(rr, req, session, "http://example.com/callback")
+	location, _ = url.Parse(rr.Header().Get("Location"))
+	state = location.Query().Get("state")
+	nonce = location.Query().Get("nonce")
+	callbackReq = httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(state), nil)
+	callbackReq.AddCookie(binding)
+	rr = httptest.NewRecorder()
+	tOidc.handleCallback(rr, callbackReq, "http://other-replica/callback")
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected callback to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Location"); got != "/app/page?tab=2" {
+		t.Errorf("Expected redirect to the incoming path, got %q", got)
+	}
+	if exchangedRedirectURL != "http://example.com/callback" {
+		t.Errorf("Expected the original redirect URI in the token exchange, got %q", exchangedRedirectURL)
+	}
+
+	rr = httptest.NewRecorder()
+	callbackReq = httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(state), nil)
+	callbackReq.AddCookie(binding)
+	tOidc.handleCallback(rr, callbackReq, "http://other-replica/callback")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected the pending state to be usable only once, got %d", rr.Code)
+	}
+}
+
+// TestSessionExpiredRedirectsToLogin verifies that a session past the absolute timeout is
+// reported as ErrSessionExpired and leads to a clean login redirect that keeps the requested
+// page, or the configured sessionExpiredRedirectPath.
+func TestSessionExpiredRedirectsToLogin(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected expired session not to reach the next handler")
+	})
+
+	expiredSessionCookies := func() []*http.Cookie {
+		req := httptest.NewRequest("GET", "/", nil)
+		rr := httptest.NewRecorder()
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		session.SetAccessToken(ts.token)
+		session.mainSession.Values["created_at"] = time.Now().Add(-absoluteSessionTimeout - time.Minute).Unix()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Failed to save session: %v", err)
+		}
+		return rr.Result().Cookies()
+	}
+	loginFor := func(path string) (*httptest.ResponseRecorder, *SessionData) {
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range expiredSessionCookies() {
+			req.AddCookie(cookie)
+		}
+		if _, err := tOidc.sessionManager.GetSession(req); !errors.Is(err, ErrSessionExpired) {
+			t.Fatalf("Expected ErrSessionExpired, got %v", err)
+		}
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, req)
+
+		// Clearing expires the old cookies before the login session is written; keep the latest
+		latest := make(map[string]*http.Cookie)
+		for _, cookie := range rr.Result().Cookies() {
+			latest[cookie.Name] = cookie
+		}
+		next := httptest.NewRequest("GET", "/", nil)
+		for _, cookie := range latest {
+			next.AddCookie(cookie)
+		}
+		session, err := tOidc.sessionManager.GetSession(next)
+		if err != nil {
+			t.Fatalf("Expected the login session to load, got %v", err)
+		}
+		return rr, session
+	}
+
+	rr, session := loginFor("/reports?month=5")
+	if rr.Code != http.StatusFound || !strings.HasPrefix(rr.Header().Get("Location"), tOidc.authURL) {
+		t.Fatalf("Expected redirect to the provider, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if session.GetAuthenticated() {
+		t.Error("Expected the expired session to be cleared")
+	}
+	if got := session.GetIncomingPath(); got != "/reports?month=5" {
+		t.Errorf("Expected the requested page to be preserved, got %q", got)
+	}
+
+	tOidc.sessionExpiredPath = "/dashboard"
+	_, session = loginFor("/reports?month=5")
+	if got := session.GetIncomingPath(); got != "/dashboard" {
+		t.Errorf("Expected the configured post-expiry path, got %q", got)
+	}
+}
+
+// TestRestoreHeadersAfterLogin verifies that allowlisted headers of the request that started
+// the login are restored once on the first request to the original page after login.
+func TestRestoreHeadersAfterLogin(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.restoreHeaders = []string{"accept", "X-App-Context"}
+	var forwarded []*http.Request
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r)
+	})
+
+	cookies := make(map[string]*http.Cookie)
+	send := func(req *http.Request, handle http.HandlerFunc) *httptest.ResponseRecorder {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handle(rr, req)
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		return rr
+	}
+
+	start := httptest.NewRequest("GET", "/reports?month=5", nil)
+	start.Header.Set("Accept", "application/json")
+	start.Header.Set("X-App-Context", "tenant-7")
+	start.Header.Set("X-Other", "not-allowlisted")
+	rr := send(start, tOidc.ServeHTTP)
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	nonce := location.Query().Get("nonce")
+
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+			idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+				"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+				"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject",
+				"email": "user@example.com", "nonce": nonce, "jti": generateRandomString(16),
+			})
+			return &TokenResponse{IDToken: idToken, RefreshToken: "refresh-token"}, nil
+		},
+	}
+	callback := httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	rr = send(callback, func(rw http.ResponseWriter, req *http.Request) {
+		tOidc.handleCallback(rw, req, "http://example.com/callback")
+	})
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/reports?month=5" {
+		t.Fatalf("Expected redirect to the original page, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/reports?month=5", nil)
+		req.Header.Set("Accept", "text/html")
+		send(req, tOidc.ServeHTTP)
+	}
+	if len(forwarded) != 2 {
+		t.Fatalf("Expected 2 forwarded requests, got %d", len(forwarded))
+	}
+	if got := forwarded[0].Header.Get("Accept"); got != "application/json" {
+		t.Errorf("Expected Accept to be restored, got %q", got)
+	}
+	if got := forwarded[0].Header.Get("X-App-Context"); got != "tenant-7" {
+		t.Errorf("Expected X-App-Context to be restored, got %q", got)
+	}
+	if got := forwarded[0].Header.Get("X-Other"); got != "" {
+		t.Errorf("Expected headers outside the allowlist not to be restored, got %q", got)
+	}
+	if got := forwarded[1].Header.Get("Accept"); got != "text/html" {
+		t.Errorf("Expected headers to be restored only once, got Accept %q", got)
+	}
+}
+
+// TestAllowedIssuers verifies that tokens from configured additional issuers are accepted
+// while unknown issuers are still rejected.
+func TestAllowedIssuers(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	ts.tOidc.allowedIssuers = []string{"https://eu.test-issuer.com", "https://us.test-issuer.com"}
+
+	tokenFrom := func(issuer string) string {
+		now := time.Now()
+		token, err := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+			"iss": issuer, "aud": "test-client-id", "exp": now.Add(time.Hour).Unix(),
+			"iat": now.Unix(), "nbf": now.Unix(), "sub": "test-subject",
+			"email": "user@example.com", "jti": generateRandomString(16),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		return token
+	}
+
+	for _, issuer := range []string{"https://test-issuer.com", "https://eu.test-issuer.com", "https://us.test-issuer.com"} {
+		if err := ts.tOidc.VerifyToken(context.Background(), tokenFrom(issuer)); err != nil {
+			t.Errorf("Expected token from %s to be accepted, got: %v", issuer, err)
+		}
+	}
+	if err := ts.tOidc.VerifyToken(context.Background(), tokenFrom("https://ap.test-issuer.com")); err == nil || !strings.Contains(err.Error(), "invalid issuer") {
+		t.Errorf("Expected token from an unknown issuer to be rejected, got: %v", err)
+	}
+}
+
+// TestRouteRules verifies that per-route authorization rules are enforced after
+// authentication, with the most specific rule applying.
+func TestRouteRules(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	ts.tOidc.routeRules = compileRouteRules([]RouteRule{
+		{PathPrefix: "/admin", RequiredRoles: []string{"admin"}},
+		{PathPrefix: "/admin/reports", RequiredScopes: []string{"reports:read"}},
+		{PathPrefix: "/finance", RequiredClaims: map[string]string{"department": "finance", "email_verified": "true"}},
+	})
+	ts.tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(path string, claims map[string]interface{}) int {
+		now := time.Now()
+		tokenClaims := map[string]interface{}{
+			"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": now.Add(time.Hour).Unix(),
+			"iat": now.Unix(), "nbf": now.Unix(), "sub": "test-subject",
+			"email": "user@example.com", "jti": generateRandomString(16),
+		}
+		for k, v := range claims {
+			tokenClaims[k] = v
+		}
+		token, err := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", tokenClaims)
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", path, nil)
+		session, _ := ts.tOidc.sessionManager.GetSession(req)
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		session.SetUserID("test-subject")
+		session.SetAccessToken(token)
+		rr := httptest.NewRecorder()
+		ts.tOidc.processAuthorizedRequest(rr, req, session, "http://example.com/callback")
+		return rr.Code
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		claims   map[string]interface{}
+		expected int
+	}{
+		{"no rule only requires login", "/app", nil, http.StatusOK},
+		{"missing role", "/admin/users", map[string]interface{}{"roles": []interface{}{"viewer"}}, http.StatusForbidden},
+		{"required role", "/admin/users", map[string]interface{}{"roles": []interface{}{"admin"}}, http.StatusOK},
+		{"more specific rule applies", "/admin/reports/q1", map[string]interface{}{"roles": []interface{}{"admin"}}, http.StatusForbidden},
+		{"scope from scope claim", "/admin/reports/q1", map[string]interface{}{"scope": "openid reports:read"}, http.StatusOK},
+		{"scope from scp claim", "/admin/reports/q1", map[string]interface{}{"scp": []interface{}{"reports:read"}}, http.StatusOK},
+		{"required claims", "/finance", map[string]interface{}{"department": []interface{}{"sales", "finance"}, "email_verified": true}, http.StatusOK},
+		{"mismatched claim", "/finance", map[string]interface{}{"department": "sales", "email_verified": true}, http.StatusForbidden},
+		{"missing claim", "/finance", map[string]interface{}{"department": "finance"}, http.StatusForbidden},
+		{"prefix itself", "/admin", map[string]interface{}{"roles": []interface{}{"viewer"}}, http.StatusForbidden},
+		{"prefix with trailing slash", "/admin/", map[string]interface{}{"roles": []interface{}{"viewer"}}, http.StatusForbidden},
+		{"prefix matches whole segments only", "/administrator", map[string]interface{}{"roles": []interface{}{"viewer"}}, http.StatusOK},
+		{"double slash", "//admin/users", map[string]interface{}{"roles": []interface{}{"viewer"}}, http.StatusForbidden},
+		{"dot segment", "/./admin/users", map[string]interface{}{"roles": []interface{}{"viewer"}}, http.StatusForbidden},
+		{"parent segment", "/x/../admin/users", map[string]interface{}{"roles": []interface{}{"viewer"}}, http.StatusForbidden},
+		{"parent segment leaving the prefix", "/admin/../public", map[string]interface{}{"roles": []interface{}{"viewer"}}, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := serve(tc.path, tc.claims); code != tc.expected {
+				t.Errorf("Expected status %d for %s, got %d", tc.expected, tc.path, code)
+			}
+		})
+	}
+}
+
+// TestJWKSRefetchOnUnknownKeyID verifies that a token signed with a key missing from the
+// cached JWKS triggers a single rate-limited refetch before it is rejected.
+func TestJWKSRefetchOnUnknownKeyID(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	rotatedKey := ts.mockJWKCache.JWKS.Keys[0]
+	rotatedKey.Kid = "rotated-key-id"
+	var fetches int
+	var published []JWK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(JWKSet{Keys: published})
+	}))
+	defer server.Close()
+	ts.tOidc.jwksURL = server.URL
+	ts.tOidc.jwkCache = &JWKCache{MinRefreshInterval: time.Hour}
+
+	tokenWithKid := func(kid string) string {
+		now := time.Now()
+		token, err := createTestJWT(ts.rsaPrivateKey, "RS256", kid, map[string]interface{}{
+			"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": now.Add(time.Hour).Unix(),
+			"iat": now.Unix(), "nbf": now.Unix(), "sub": "test-subject",
+			"email": "user@example.com", "jti": generateRandomString(16),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		return token
+	}
+
+	// The cached set only holds the old key
+	published = []JWK{ts.mockJWKCache.JWKS.Keys[0]}
+	if err := ts.tOidc.VerifyToken(context.Background(), tokenWithKid("test-key-id")); err != nil {
+		t.Fatalf("Expected token signed with the cached key to verify, got: %v", err)
+	}
+
+	// After rotation, the new key is found by refetching the JWKS once
+	published = []JWK{rotatedKey}
+	if err := ts.tOidc.VerifyToken(context.Background(), tokenWithKid("rotated-key-id")); err != nil {
+		t.Fatalf("Expected token signed with the rotated key to verify after refetch, got: %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("Expected 2 JWKS fetches, got %d", fetches)
+	}
+
+	// Unknown keys are rejected with a clear error, and refetches are rate limited
+	for i := 0; i < 3; i++ {
+		err := ts.tOidc.VerifyToken(context.Background(), tokenWithKid("unknown-key-id"))
+		if err == nil || !strings.Contains(err.Error(), "even after refetching the JWKS") {
+			t.Errorf("Expected unknown key ID to be rejected after refetch, got: %v", err)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("Expected refetches to be rate limited, got %d fetches", fetches)
+	}
+}
+
+// TestImplicitFlow verifies that, when explicitly allowed, tokens returned on the callback
+// URL are verified and establish a session, and that fragment responses are resent as query.
+func TestImplicitFlow(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.allowImplicitFlow = true
+
+	cookies := make(map[string]*http.Cookie)
+	send := func(req *http.Request, handle http.HandlerFunc) *httptest.ResponseRecorder {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		handle(rr, req)
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		return rr
+	}
+	callback := func(rw http.ResponseWriter, req *http.Request) {
+		tOidc.handleCallback(rw, req, "http://example.com/callback")
+	}
+
+	rr := send(httptest.NewRequest("GET", "/dashboard", nil), tOidc.ServeHTTP)
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	if got := location.Query().Get("response_type"); got != "id_token token" {
+		t.Fatalf("Expected implicit response_type, got %q", got)
+	}
+
+	// A fragment response is resent by the browser as query parameters
+	rr = send(httptest.NewRequest("GET", "/callback", nil), callback)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "window.location.hash") {
+		t.Fatalf("Expected the fragment forwarding page, got %d", rr.Code)
+	}
+
+	idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+		"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject",
+		"email": "user@example.com", "nonce": location.Query().Get("nonce"), "jti": generateRandomString(16),
+	})
+	query := url.Values{}
+	query.Set("state", location.Query().Get("state"))
+	query.Set("id_token", idToken)
+	query.Set("access_token", "opaque-access-token")
+	rr = send(httptest.NewRequest("GET", "/callback?"+query.Encode(), nil), callback)
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("Expected redirect to the original page, got %d to %q: %s", rr.Code, rr.Header().Get("Location"), rr.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/dashboard", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	session, _ := tOidc.sessionManager.GetSession(req)
+	if !session.GetAuthenticated() || session.GetEmail() != "user@example.com" {
+		t.Error("Expected the implicit flow to establish an authenticated session")
+	}
+
+	// Tokens in the callback URL are ignored unless the implicit flow is allowed
+	tOidc.allowImplicitFlow = false
+	cookies = make(map[string]*http.Cookie)
+	rr = send(httptest.NewRequest("GET", "/dashboard", nil), tOidc.ServeHTTP)
+	location, _ = url.Parse(rr.Header().Get("Location"))
+	query.Set("state", location.Query().Get("state"))
+	rr = send(httptest.NewRequest("GET", "/callback?"+query.Encode(), nil), callback)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected tokens in the callback to be rejected without allowImplicitFlow, got %d", rr.Code)
+	}
+
+	if got := redactedURL(&url.URL{Path: "/callback", RawQuery: query.Encode()}); strings.Contains(got, idToken) || strings.Contains(got, "opaque-access-token") {
+		t.Errorf("Expected tokens to be redacted from logged URLs, got %s", got)
+	}
+}
+
+// TestImplicitFlowAccessTokenHash verifies that an implicit flow access token is only used for
+// UserInfo when the ID token's at_hash binds it, and that a mismatching token fails the login.
+func TestImplicitFlowAccessTokenHash(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.allowImplicitFlow = true
+	tOidc.enableUserInfo = true
+	tOidc.userInfoCache = NewTokenCache()
+
+	var userInfoTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userInfoTokens = append(userInfoTokens, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"sub": "test-subject", "email": "user@example.com"})
+	}))
+	defer server.Close()
+	tOidc.userInfoURL = server.URL
+
+	atHash := func(accessToken string) string {
+		sum := crypto.SHA256.New()
+		sum.Write([]byte(accessToken))
+		return base64.RawURLEncoding.EncodeToString(sum.Sum(nil)[:16])
+	}
+	login := func(accessToken, hash string) int {
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		claims := map[string]interface{}{
+			"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+			"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject",
+			"email": "user@example.com", "nonce": location.Query().Get("nonce"), "jti": generateRandomString(16),
+		}
+		if hash != "" {
+			claims["at_hash"] = hash
+		}
+		idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", claims)
+		query := url.Values{}
+		query.Set("state", location.Query().Get("state"))
+		query.Set("id_token", idToken)
+		query.Set("access_token", accessToken)
+		cookies := make(map[string]*http.Cookie)
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		req := httptest.NewRequest("GET", "/callback?"+query.Encode(), nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		tOidc.handleCallback(rr, req, "http://example.com/callback")
+		return rr.Code
+	}
+
+	if code := login("unbound-access-token", ""); code != http.StatusFound {
+		t.Errorf("Expected a login without at_hash to succeed, got %d", code)
+	}
+	if len(userInfoTokens) != 0 {
+		t.Errorf("Expected an access token without at_hash not to be used for UserInfo, got %v", userInfoTokens)
+	}
+
+	if code := login("bound-access-token", atHash("bound-access-token")); code != http.StatusFound {
+		t.Errorf("Expected a login with a matching at_hash to succeed, got %d", code)
+	}
+	if len(userInfoTokens) != 1 || userInfoTokens[0] != "bound-access-token" {
+		t.Errorf("Expected UserInfo to be fetched with the bound access token, got %v", userInfoTokens)
+	}
+
+	if code := login("substituted-access-token", atHash("bound-access-token")); code != http.StatusInternalServerError {
+		t.Errorf("Expected a mismatching at_hash to fail the login, got %d", code)
+	}
+	if len(userInfoTokens) != 1 {
+		t.Errorf("Expected no UserInfo request for a substituted access token, got %v", userInfoTokens)
+	}
+}
+
+// TestDisallowTokenEndpointRedirects verifies that a redirecting token endpoint is reported
+// instead of followed when redirects are disallowed.
+func TestDisallowTokenEndpointRedirects(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "test-access-token", TokenType: "Bearer"})
+	}))
+	defer target.Close()
+	redirecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusMovedPermanently)
+	}))
+	defer redirecting.Close()
+	ts.tOidc.tokenURL = redirecting.URL
+
+	if _, err := ts.tOidc.exchangeTokens(context.Background(), "refresh_token", "refresh-token", "", ""); err != nil {
+		t.Errorf("Expected redirects to be followed by default, got: %v", err)
+	}
+
+	ts.tOidc.noTokenRedirects = true
+	_, err := ts.tOidc.exchangeTokens(context.Background(), "refresh_token", "refresh-token", "", "")
+	if !errors.Is(err, ErrTokenEndpointRedirect) {
+		t.Fatalf("Expected ErrTokenEndpointRedirect, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), target.URL) {
+		t.Errorf("Expected the error to name the redirect target, got: %v", err)
+	}
+}
+
+// TestFetchUserInfo verifies that UserInfo claims fill in identity claims missing from the
+// ID token, are cached per access token, and are rejected for a different subject.
+func TestFetchUserInfo(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.enableUserInfo = true
+	tOidc.userInfoCache = NewTokenCache()
+
+	var fetches int
+	userInfoSub := "test-subject"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Header.Get("Authorization") != "Bearer opaque-access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub": userInfoSub, "email": "user@example.com", "department": "finance",
+		})
+	}))
+	defer server.Close()
+	tOidc.userInfoURL = server.URL
+
+	login := func() (*httptest.ResponseRecorder, *SessionData) {
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		cookies := make(map[string]*http.Cookie)
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+
+		tOidc.tokenExchanger = &MockTokenExchanger{
+			ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+				// The ID token carries no email
+				idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+					"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+					"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject",
+					"nonce": location.Query().Get("nonce"), "jti": generateRandomString(16),
+				})
+				return &TokenResponse{IDToken: idToken, AccessToken: "opaque-access-token"}, nil
+			},
+		}
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		tOidc.handleCallback(rr, req, "http://example.com/callback")
+
+		latest := make(map[string]*http.Cookie)
+		for _, cookie := range rr.Result().Cookies() {
+			latest[cookie.Name] = cookie
+		}
+		next := httptest.NewRequest("GET", "/dashboard", nil)
+		for _, cookie := range latest {
+			next.AddCookie(cookie)
+		}
+		session, _ := tOidc.sessionManager.GetSession(next)
+		return rr, session
+	}
+
+	rr, session := login()
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := session.GetEmail(); got != "user@example.com" {
+		t.Errorf("Expected email from UserInfo, got %q", got)
+	}
+	claims, err := tOidc.sessionClaims(session)
+	if err != nil || claims["department"] != "finance" || claims["sub"] != "test-subject" {
+		t.Errorf("Expected session claims to include UserInfo claims, got %v (%v)", claims, err)
+	}
+
+	// Responses are cached per access token
+	if _, err := tOidc.fetchUserInfo(context.Background(), "opaque-access-token"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fetches != 1 {
+		t.Errorf("Expected 1 UserInfo fetch, got %d", fetches)
+	}
+
+	// A response for another subject fails the login
+	userInfoSub = "someone-else"
+	tOidc.userInfoCache = NewTokenCache()
+	if rr, _ := login(); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected UserInfo subject mismatch to fail the login, got %d", rr.Code)
+	}
+}
+
+func TestAllowMissingNonce(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+
+	// login completes a login whose ID token carries the nonce returned by nonceFor
+	login := func(nonceFor func(sent string) string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		cookies := make(map[string]*http.Cookie)
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+
+		tOidc.tokenExchanger = &MockTokenExchanger{
+			ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+				claims := map[string]interface{}{
+					"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+					"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject",
+					"email": "user@example.com", "jti": generateRandomString(16),
+				}
+				if nonce := nonceFor(location.Query().Get("nonce")); nonce != "" {
+					claims["nonce"] = nonce
+				}
+				idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", claims)
+				return &TokenResponse{IDToken: idToken, AccessToken: idToken}, nil
+			},
+		}
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		tOidc.handleCallback(rr, req, "http://example.com/callback")
+		return rr
+	}
+	missing := func(string) string { return "" }
+	mismatched := func(string) string { return "some-other-nonce" }
+	echoed := func(sent string) string { return sent }
+
+	if rr := login(missing); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected a missing nonce to be rejected by default, got %d", rr.Code)
+	}
+
+	tOidc.relaxNonce = true
+	if rr := login(missing); rr.Code != http.StatusFound {
+		t.Errorf("Expected a missing nonce to be accepted with allowMissingNonce, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := login(mismatched); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected a mismatched nonce to be rejected with allowMissingNonce, got %d", rr.Code)
+	}
+	if rr := login(echoed); rr.Code != http.StatusFound {
+		t.Errorf("Expected a matching nonce to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if CreateConfig().AllowMissingNonce {
+		t.Error("Expected allowMissingNonce to be disabled by default")
+	}
+}
+
+func TestGoogleHostedDomain(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.hostedDomain = "example.com"
+
+	// login completes a login whose ID token carries the given hd claim (none if empty)
+	login := func(hd string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		if got := location.Query().Get("hd"); got != "example.com" {
+			t.Errorf("Expected hd=example.com in the authorization request, got %q", got)
+		}
+		cookies := make(map[string]*http.Cookie)
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+
+		tOidc.tokenExchanger = &MockTokenExchanger{
+			ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+				claims := map[string]interface{}{
+					"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+					"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject",
+					"email": "user@example.com", "nonce": location.Query().Get("nonce"), "jti": generateRandomString(16),
+				}
+				if hd != "" {
+					claims["hd"] = hd
+				}
+				idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", claims)
+				return &TokenResponse{IDToken: idToken, AccessToken: idToken}, nil
+			},
+		}
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		tOidc.handleCallback(rr, req, "http://example.com/callback")
+		return rr
+	}
+
+	if rr := login("example.com"); rr.Code != http.StatusFound {
+		t.Errorf("Expected a matching hosted domain to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// A personal account with an email address in the domain has no hd claim
+	if rr := login(""); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a missing hd claim to be rejected, got %d", rr.Code)
+	}
+	if rr := login("other.com"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected another hosted domain to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestMaxAuthAge(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.maxAuthAge = 15 * time.Minute
+
+	// callback completes the authorization request at location with an ID token reporting
+	// auth_time, collecting the new cookies
+	callback := func(location *url.URL, cookies map[string]*http.Cookie, authTime time.Time) *httptest.ResponseRecorder {
+		tOidc.tokenExchanger = &MockTokenExchanger{
+			ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+				idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+					"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+					"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject", "email": "user@example.com",
+					"auth_time": authTime.Unix(), "nonce": location.Query().Get("nonce"), "jti": generateRandomString(16),
+				})
+				return &TokenResponse{IDToken: idToken, AccessToken: idToken}, nil
+			},
+		}
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		tOidc.handleCallback(rr, req, "http://example.com/callback")
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		return rr
+	}
+
+	// login completes a login whose ID token reports the given auth_time
+	login := func(authTime time.Time) (*httptest.ResponseRecorder, map[string]*http.Cookie) {
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		if got := location.Query().Get("max_age"); got != "900" {
+			t.Errorf("Expected max_age=900 in the authorization request, got %q", got)
+		}
+		cookies := make(map[string]*http.Cookie)
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		return callback(location, cookies, authTime), cookies
+	}
+
+	// A provider that ignored max_age is asked once more for a fresh login
+	rr, stale := login(time.Now().Add(-time.Hour))
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected a stale auth_time to be sent back to the provider, got %d: %s", rr.Code, rr.Body.String())
+	}
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	if location.Host != "test-issuer.com" || location.Query().Get("prompt") != "login" || location.Query().Get("max_age") != "900" {
+		t.Errorf("Expected a prompt=login authorization request, got %s", rr.Header().Get("Location"))
+	}
+	// and rejected when the forced login is still too old
+	if rr := callback(location, stale, time.Now().Add(-time.Hour)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a stale auth_time after a forced login to be rejected, got %d", rr.Code)
+	}
+
+	rr, cookies := login(time.Now())
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("Expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	req := httptest.NewRequest("GET", "/dashboard", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	session, _ := tOidc.sessionManager.GetSession(req)
+	if time.Since(session.GetAuthTime()) > time.Minute {
+		t.Errorf("Expected auth_time to be stored in the session, got %v", session.GetAuthTime())
+	}
+	if tOidc.authTooOld(session) {
+		t.Error("Expected a fresh authentication to be accepted")
+	}
+
+	// Once the authentication ages out the user is sent back to the provider with prompt=login
+	session.SetAuthTime(time.Now().Add(-time.Hour))
+	saved := httptest.NewRecorder()
+	if err := session.Save(req, saved); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	next := httptest.NewRequest("GET", "/dashboard", nil)
+	for _, cookie := range cookies {
+		if cookie.Name != mainCookieName {
+			next.AddCookie(cookie)
+		}
+	}
+	for _, cookie := range saved.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, next)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect to the provider, got %d", rr.Code)
+	}
+	location, _ = url.Parse(rr.Header().Get("Location"))
+	if location.Host != "test-issuer.com" || location.Query().Get("prompt") != "login" {
+		t.Errorf("Expected a prompt=login authorization request, got %s", rr.Header().Get("Location"))
+	}
+}
+
+func TestRequireMFA(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.requireMFA = true
+	tOidc.mfaMethods = DefaultMFAMethods
+	tOidc.mfaACRValues = "mfa"
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cookies := make(map[string]*http.Cookie)
+	// visit requests /dashboard with the current cookies and collects the new ones
+	visit := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, req)
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		return rr
+	}
+	// callback completes the authorization request at location with an ID token reporting amr
+	callback := func(location *url.URL, amr []interface{}) {
+		tOidc.tokenExchanger = &MockTokenExchanger{
+			ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+				idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+					"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+					"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject", "email": "user@example.com",
+					"amr": amr, "nonce": location.Query().Get("nonce"), "jti": generateRandomString(16),
+				})
+				return &TokenResponse{IDToken: idToken, AccessToken: idToken}, nil
+			},
+		}
+		req := httptest.NewRequest("GET", "/callback?code=test-code&state="+url.QueryEscape(location.Query().Get("state")), nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		tOidc.handleCallback(rr, req, "http://example.com/callback")
+		if rr.Code != http.StatusFound {
+			t.Fatalf("Expected login to succeed, got %d: %s", rr.Code, rr.Body.String())
+		}
+		for _, cookie := range rr.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+	}
+
+	rr := visit()
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	if got := location.Query().Get("acr_values"); got != "mfa" {
+		t.Errorf("Expected acr_values=mfa in the authorization request, got %q", got)
+	}
+	if location.Query().Get("prompt") == "login" {
+		t.Error("Expected no prompt=login on the first login")
+	}
+
+	// A password-only login is sent back to the provider to step up
+	callback(location, []interface{}{"pwd"})
+	rr = visit()
+	if rr.Code != http.StatusFound {
+		t.Fatalf("Expected a step-up redirect, got %d", rr.Code)
+	}
+	location, _ = url.Parse(rr.Header().Get("Location"))
+	if location.Host != "test-issuer.com" || location.Query().Get("prompt") != "login" {
+		t.Errorf("Expected a prompt=login authorization request, got %s", rr.Header().Get("Location"))
+	}
+
+	// A step-up that still did not use MFA is denied instead of looping
+	callback(location, []interface{}{"pwd"})
+	if rr = visit(); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected access to be denied after a failed step-up, got %d", rr.Code)
+	}
+
+	// Logging in with a second factor grants access
+	for name := range cookies {
+		delete(cookies, name)
+	}
+	location, _ = url.Parse(visit().Header().Get("Location"))
+	callback(location, []interface{}{"pwd", "otp"})
+	if rr = visit(); rr.Code != http.StatusOK {
+		t.Errorf("Expected access to be granted after MFA, got %d", rr.Code)
+	}
+}
+
+func TestMissingAccessTokenRecovery(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	var forwarded string
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get("X-Forwarded-User")
+		w.WriteHeader(http.StatusOK)
+	})
+	refreshed := 0
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+			refreshed++
+			idToken, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+				"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+				"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject", "email": "user@example.com",
+				"jti": generateRandomString(16),
+			})
+			return &TokenResponse{IDToken: idToken, AccessToken: idToken, RefreshToken: "new-refresh-token"}, nil
+		},
+	}
+
+	// evictedRequest builds a request for an authenticated session whose access token chunks
+	// were partly evicted by the browser
+	evictedRequest := func(refreshToken string) *http.Request {
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		session.SetAccessToken(generateRandomString(8000))
+		if refreshToken != "" {
+			session.SetRefreshToken(refreshToken)
+		}
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		next := httptest.NewRequest("GET", "/dashboard", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name != accessTokenCookie+"_1" {
+				next.AddCookie(cookie)
+			}
+		}
+		return next
+	}
+
+	// With a refresh token the access token is recovered transparently
+	rr := httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, evictedRequest("refresh-token"))
+	if rr.Code != http.StatusOK || refreshed != 1 {
+		t.Fatalf("Expected a refresh and access to be granted, got %d after %d refreshes", rr.Code, refreshed)
+	}
+	if forwarded != "user@example.com" {
+		t.Errorf("Expected the user to be forwarded after the refresh, got %q", forwarded)
+	}
+
+	// Without one the session is cleared and the user logs in again
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, evictedRequest(""))
+	if rr.Code != http.StatusFound || refreshed != 1 {
+		t.Fatalf("Expected a redirect without a refresh, got %d after %d refreshes", rr.Code, refreshed)
+	}
+	if location, _ := url.Parse(rr.Header().Get("Location")); location.Host != "test-issuer.com" {
+		t.Errorf("Expected a redirect to the provider, got %s", rr.Header().Get("Location"))
+	}
+}
+
+func TestCallbackRateLimit(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.callbackLimiter = newCallbackLimiter(3)
+	exchanges := 0
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		ExchangeCodeFunc: func(ctx context.Context, grantType, codeOrToken, redirectURL, codeVerifier string) (*TokenResponse, error) {
+			exchanges++
+			return nil, fmt.Errorf("invalid code")
+		},
+	}
+
+	callback := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/callback?code=bogus&state=bogus", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		tOidc.handleCallback(rr, req, "http://example.com/callback")
+		return rr.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := callback("192.0.2.1:1234"); code == http.StatusTooManyRequests {
+			t.Fatalf("Expected callback %d to be within the limit", i+1)
+		}
+	}
+	if code := callback("192.0.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 once the limit is exceeded, got %d", code)
+	}
+	if code := callback("192.0.2.2:1234"); code == http.StatusTooManyRequests {
+		t.Error("Expected other clients to keep their own limit")
+	}
+	if exchanges != 0 {
+		t.Errorf("Expected no token exchange for bogus callbacks, got %d", exchanges)
+	}
+
+	// Behind a trusted proxy, clients are told apart by X-Forwarded-For
+	tOidc.trustedProxies, _ = parseTrustedProxies([]string{"10.0.0.0/8"})
+	req := httptest.NewRequest("GET", "/callback", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.9")
+	if ip := tOidc.clientIP(req); ip != "198.51.100.7" {
+		t.Errorf("Expected the forwarded client address, got %s", ip)
+	}
+	req.RemoteAddr = "203.0.113.5:1234"
+	if ip := tOidc.clientIP(req); ip != "203.0.113.5" {
+		t.Errorf("Expected X-Forwarded-For from untrusted peers to be ignored, got %s", ip)
+	}
+}
+
+func TestSubjectChange(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.authURL = "https://test-issuer.com/authorize"
+	tOidc.refreshGracePeriod = time.Minute
+	forwarded := 0
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded++
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tokenFor := func(sub string, ttl time.Duration) string {
+		token, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+			"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(ttl).Unix(),
+			"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": sub, "email": "user@example.com",
+			"jti": generateRandomString(16),
+		})
+		return token
+	}
+	refreshedSub := "test-subject"
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+			idToken := tokenFor(refreshedSub, time.Hour)
+			return &TokenResponse{IDToken: idToken, AccessToken: idToken, RefreshToken: "new-refresh-token"}, nil
+		},
+	}
+
+	// sessionRequest builds a request for a session established for test-subject
+	sessionRequest := func(token string) *http.Request {
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		session.SetSubject("test-subject")
+		session.SetAccessToken(token)
+		session.SetRefreshToken("refresh-token")
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		next := httptest.NewRequest("GET", "/dashboard", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			next.AddCookie(cookie)
+		}
+		return next
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		refreshedTo string
+		wantCode    int
+	}{
+		{name: "same subject", token: tokenFor("test-subject", time.Hour), wantCode: http.StatusOK},
+		{name: "token for another subject", token: tokenFor("other-subject", time.Hour), wantCode: http.StatusFound},
+		{name: "refresh to the same subject", token: tokenFor("test-subject", 30*time.Second), refreshedTo: "test-subject", wantCode: http.StatusOK},
+		{name: "refresh to another subject", token: tokenFor("test-subject", 30*time.Second), refreshedTo: "other-subject", wantCode: http.StatusFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			forwarded = 0
+			refreshedSub = tc.refreshedTo
+			rr := httptest.NewRecorder()
+			tOidc.ServeHTTP(rr, sessionRequest(tc.token))
+			if rr.Code != tc.wantCode {
+				t.Fatalf("Expected status %d, got %d", tc.wantCode, rr.Code)
+			}
+			if tc.wantCode == http.StatusFound {
+				if forwarded != 0 {
+					t.Error("Expected the request not to be forwarded after the subject changed")
+				}
+				if location, _ := url.Parse(rr.Header().Get("Location")); location.Host != "test-issuer.com" {
+					t.Errorf("Expected a redirect to the provider, got %s", rr.Header().Get("Location"))
+				}
+			}
+		})
+	}
+}
+
+func TestSessionExpiresHeader(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.sessionExpiresHeader = "X-Session-Expires-In"
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	token, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+		"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject", "email": "user@example.com",
+		"jti": generateRandomString(16),
+	})
+
+	// expiresIn serves an authenticated request and returns the reported seconds
+	expiresIn := func() int {
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		session.SetAccessToken(token)
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		next := httptest.NewRequest("GET", "/dashboard", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			next.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		tOidc.ServeHTTP(rr, next)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected access to be granted, got %d", rr.Code)
+		}
+		seconds, err := strconv.Atoi(rr.Header().Get("X-Session-Expires-In"))
+		if err != nil {
+			t.Fatalf("Expected a numeric X-Session-Expires-In header, got %q", rr.Header().Get("X-Session-Expires-In"))
+		}
+		return seconds
+	}
+
+	// Without an idle timeout the absolute timeout applies
+	if seconds := expiresIn(); seconds < int(absoluteSessionTimeout.Seconds())-5 || seconds > int(absoluteSessionTimeout.Seconds()) {
+		t.Errorf("Expected the absolute timeout to be reported, got %d seconds", seconds)
+	}
+
+	tOidc.sessionManager.SetIdleTimeout(10 * time.Minute)
+	if seconds := expiresIn(); seconds < 595 || seconds > 600 {
+		t.Errorf("Expected the idle timeout to be reported, got %d seconds", seconds)
+	}
+}
+
+func TestClaimsRefreshInterval(t *testing.T) {
+	ts := &TestSuite{t: t}
+	ts.Setup()
+	tOidc := ts.tOidc
+	tOidc.claimsRefreshInterval = 5 * time.Minute
+	tOidc.allowedRolesAndGroups = map[string]struct{}{"admin": {}}
+	tOidc.next = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tokenWithGroups := func(groups ...interface{}) string {
+		token, _ := createTestJWT(ts.rsaPrivateKey, "RS256", "test-key-id", map[string]interface{}{
+			"iss": "https://test-issuer.com", "aud": "test-client-id", "exp": time.Now().Add(time.Hour).Unix(),
+			"iat": time.Now().Unix(), "nbf": time.Now().Unix(), "sub": "test-subject", "email": "user@example.com",
+			"groups": groups, "jti": generateRandomString(16),
+		})
+		return token
+	}
+	refreshed := 0
+	tOidc.tokenExchanger = &MockTokenExchanger{
+		RefreshTokenFunc: func(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+			refreshed++
+			// The admin group was revoked at the provider
+			idToken := tokenWithGroups("viewer")
+			return &TokenResponse{IDToken: idToken, AccessToken: idToken, RefreshToken: "new-refresh-token"}, nil
+		},
+	}
+
+	// sessionRequest builds a request for an admin session whose claims were obtained at claimsAt
+	sessionRequest := func(claimsAt time.Time) *http.Request {
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		session, _ := tOidc.sessionManager.GetSession(req)
+		session.SetAuthenticated(true)
+		session.SetEmail("user@example.com")
+		session.SetAccessToken(tokenWithGroups("admin"))
+		session.SetRefreshToken("refresh-token")
+		session.SetClaimsUpdatedAt(claimsAt)
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		next := httptest.NewRequest("GET", "/dashboard", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			next.AddCookie(cookie)
+		}
+		return next
+	}
+
+	// Claims within the interval are used as they are
+	rr := httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, sessionRequest(time.Now().Add(-time.Minute)))
+	if rr.Code != http.StatusOK || refreshed != 0 {
+		t.Fatalf("Expected access without a refresh, got %d after %d refreshes", rr.Code, refreshed)
+	}
+
+	// Older claims are refreshed and the revoked group takes effect
+	rr = httptest.NewRecorder()
+	tOidc.ServeHTTP(rr, sessionRequest(time.Now().Add(-10*time.Minute)))
+	if refreshed != 1 {
+		t.Fatalf("Expected stale claims to be refreshed, got %d refreshes", refreshed)
+	}
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected the revoked group to deny access, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,60 @@
+package traefikoidc
+
+import (
+	"strings"
+)
+
+// DefaultMFAMethods are the 'amr' values accepted as proof of multi-factor authentication
+// when requireMFA is enabled without an explicit mfaMethods list.
+var DefaultMFAMethods = []string{"mfa", "otp", "hwk"}
+
+// authMethods returns the authentication methods reported in the 'amr' claim of an ID token.
+//
+// Parameters:
+//   - claims: The ID token claims.
+//
+// Returns:
+//   - The authentication method references, or nil if the claim is missing.
+func authMethods(claims map[string]interface{}) []string {
+	values, ok := claims["amr"].([]interface{})
+	if !ok {
+		return nil
+	}
+	methods := make([]string, 0, len(values))
+	for _, value := range values {
+		if method, ok := value.(string); ok {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// mfaSatisfied reports whether any of the authentication methods is an accepted MFA method.
+//
+// Parameters:
+//   - methods: The authentication methods of the user's login.
+//
+// Returns:
+//   - true if the login used multi-factor authentication.
+func (t *TraefikOidc) mfaSatisfied(methods []string) bool {
+	for _, method := range methods {
+		for _, accepted := range t.mfaMethods {
+			if strings.EqualFold(method, accepted) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// needsMFAStepUp reports whether requireMFA is enabled and the authenticated session's login
+// did not use multi-factor authentication.
+//
+// Parameters:
+//   - session: The user's session data.
+//
+// Returns:
+//   - true if the user must complete a step-up login before being granted access.
+func (t *TraefikOidc) needsMFAStepUp(session *SessionData) bool {
+	return t.requireMFA && session.GetAuthenticated() && !t.mfaSatisfied(session.GetAMR())
+}
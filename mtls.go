@@ -0,0 +1,128 @@
+package traefikoidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// certificateThumbprint computes the RFC 8705 "x5t#S256" thumbprint of a certificate:
+// the base64url-encoded (unpadded) SHA-256 digest of its DER encoding.
+//
+// Parameters:
+//   - der: The DER-encoded certificate.
+//
+// Returns:
+//   - The certificate thumbprint.
+func certificateThumbprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// parseForwardedCertificate decodes a client certificate forwarded by a TLS-terminating proxy.
+// It accepts the value set by Traefik's PassTLSClientCert middleware (base64 DER, optionally
+// URL-escaped and wrapped in PEM markers) and the Cert field of an Envoy-style
+// X-Forwarded-Client-Cert header (URL-escaped PEM). Only the first certificate is used.
+//
+// Parameters:
+//   - value: The raw header value.
+//
+// Returns:
+//   - The DER-encoded certificate.
+//   - An error if no certificate can be decoded.
+func parseForwardedCertificate(value string) ([]byte, error) {
+	// Envoy XFCC: By=...;Hash=...;Cert="<url-escaped PEM>";...
+	if idx := strings.Index(value, "Cert="); idx >= 0 {
+		value = value[idx+len("Cert="):]
+		value = strings.TrimPrefix(value, `"`)
+		if end := strings.IndexAny(value, `";,`); end >= 0 {
+			value = value[:end]
+		}
+	}
+
+	unescaped, err := url.PathUnescape(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unescape forwarded certificate: %w", err)
+	}
+
+	// PathUnescape keeps the "+" of unescaped base64 intact; only the spaces of query-escaped
+	// PEM markers need restoring.
+	unescaped = strings.ReplaceAll(unescaped, "+CERTIFICATE-----", " CERTIFICATE-----")
+
+	// Keep only the first certificate of a chain
+	const beginMarker, endMarker = "-----BEGIN CERTIFICATE-----", "-----END CERTIFICATE-----"
+	unescaped = strings.TrimPrefix(strings.TrimSpace(unescaped), beginMarker)
+	if end := strings.Index(unescaped, endMarker); end >= 0 {
+		unescaped = unescaped[:end]
+	}
+	if end := strings.Index(unescaped, ","); end >= 0 {
+		unescaped = unescaped[:end]
+	}
+	encoded := strings.Join(strings.Fields(unescaped), "")
+
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode forwarded certificate: %w", err)
+	}
+	if len(der) == 0 {
+		return nil, fmt.Errorf("forwarded certificate is empty")
+	}
+	return der, nil
+}
+
+// presentedCertificate returns the client certificate presented with the request, read from
+// the TLS connection or, when configured, from the header set by a TLS-terminating proxy.
+//
+// Parameters:
+//   - req: The incoming HTTP request.
+//
+// Returns:
+//   - The DER-encoded client certificate.
+//   - An error if no client certificate was presented or it cannot be decoded.
+func (t *TraefikOidc) presentedCertificate(req *http.Request) ([]byte, error) {
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		return req.TLS.PeerCertificates[0].Raw, nil
+	}
+	if t.clientCertHeader != "" {
+		if value := req.Header.Get(t.clientCertHeader); value != "" {
+			return parseForwardedCertificate(value)
+		}
+	}
+	return nil, fmt.Errorf("no client certificate presented")
+}
+
+// verifyCertificateBinding enforces RFC 8705 certificate-bound tokens. When the token carries
+// a "cnf" claim with an "x5t#S256" thumbprint, the client certificate presented with the
+// request must have the same thumbprint. Tokens without a confirmation claim are not bound
+// and are accepted.
+//
+// Parameters:
+//   - req: The incoming HTTP request.
+//   - claims: The validated token claims.
+//
+// Returns:
+//   - nil if the token is unbound or bound to the presented certificate.
+//   - An error if the certificate is missing or its thumbprint does not match.
+func (t *TraefikOidc) verifyCertificateBinding(req *http.Request, claims map[string]interface{}) error {
+	cnf, ok := claims["cnf"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	expected, ok := cnf["x5t#S256"].(string)
+	if !ok || expected == "" {
+		return nil
+	}
+
+	der, err := t.presentedCertificate(req)
+	if err != nil {
+		return fmt.Errorf("token is certificate-bound but %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(certificateThumbprint(der)), []byte(expected)) != 1 {
+		return fmt.Errorf("client certificate does not match the token's x5t#S256 confirmation")
+	}
+	return nil
+}
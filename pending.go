@@ -0,0 +1,226 @@
+package traefikoidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// pendingAuthBindingCookie holds a random value whose hash is stored with pending
+// authorization requests, so that a callback can only resolve a pending request in the
+// browser that started it.
+const pendingAuthBindingCookie = "_oidc_raczylo_b"
+
+// PendingAuth is the state of an authorization request that has been sent to the provider
+// and is waiting for its callback.
+type PendingAuth struct {
+	// Nonce is the OIDC nonce sent with the authorization request.
+	Nonce string
+
+	// CodeVerifier is the PKCE code verifier, empty without PKCE.
+	CodeVerifier string
+
+	// RedirectURI is the redirect_uri sent with the authorization request.
+	RedirectURI string
+
+	// IncomingPath is the path (and query) the user was trying to access.
+	IncomingPath string
+
+	// IncomingFragment is the URL fragment captured in the browser, if any.
+	IncomingFragment string
+
+	// IncomingHeaders are the allowlisted request headers to restore after login, if any.
+	IncomingHeaders map[string]string
+
+	// SilentRenew is true for prompt=none silent renew requests.
+	SilentRenew bool
+
+	// SilentLogin is true for prompt=none logins of sessions that lost their refresh token.
+	SilentLogin bool
+
+	// RememberMe is true if the login asked for a remember-me session.
+	RememberMe bool
+
+	// MFAStepUp is true for a login requested to complete multi-factor authentication.
+	MFAStepUp bool
+
+	// AuthAgeStepUp is true for a login forced because the previous authentication was too old.
+	AuthAgeStepUp bool
+
+	// CorrelationID is the correlation ID of the login, empty if correlation IDs are disabled.
+	CorrelationID string
+
+	// IssuedAt is when the authorization request was started.
+	IssuedAt time.Time
+
+	// BindingHash is the hex encoded SHA-256 hash of the binding cookie set in the browser
+	// that started the login.
+	BindingHash string
+}
+
+// PendingAuthStore holds pending authorization requests keyed by their state value, so that
+// a callback can be resolved by any replica, not only the one that started the login. Use a
+// store shared between replicas when sessions are kept server-side per instance.
+type PendingAuthStore interface {
+	// Put stores a pending authorization request until it is taken or the TTL passes.
+	Put(state string, pending PendingAuth, ttl time.Duration) error
+
+	// Take returns and removes the pending authorization request for state. A state can be
+	// taken at most once.
+	Take(state string) (PendingAuth, bool)
+}
+
+// memoryPendingAuthStore is an in-process PendingAuthStore. It is not shared between
+// replicas and mainly serves single-instance deployments and tests.
+type memoryPendingAuthStore struct {
+	cache *Cache
+	mutex sync.Mutex
+}
+
+// NewMemoryPendingAuthStore creates an in-process PendingAuthStore.
+func NewMemoryPendingAuthStore() PendingAuthStore {
+	return &memoryPendingAuthStore{cache: NewCache()}
+}
+
+// Put stores a pending authorization request until it is taken or the TTL passes.
+func (s *memoryPendingAuthStore) Put(state string, pending PendingAuth, ttl time.Duration) error {
+	s.cache.Set(state, pending, ttl)
+	return nil
+}
+
+// Take returns and removes the pending authorization request for state.
+func (s *memoryPendingAuthStore) Take(state string) (PendingAuth, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value, ok := s.cache.Get(state)
+	if !ok {
+		return PendingAuth{}, false
+	}
+	s.cache.Delete(state)
+	pending, ok := value.(PendingAuth)
+	return pending, ok
+}
+
+// storePendingAuth records the authorization request just prepared in the session in the
+// pending authorization store, if one is configured. The request is bound to the browser
+// through the binding cookie, which is set (or renewed, keeping the value of an existing one
+// so that concurrent logins in several tabs stay valid) on the response.
+//
+// Parameters:
+//   - rw: The response redirecting to the provider, which receives the binding cookie.
+//   - req: The request starting the login.
+//   - state: The state value sent with the authorization request.
+//   - session: The session holding the request's nonce, verifier and incoming path.
+func (t *TraefikOidc) storePendingAuth(rw http.ResponseWriter, req *http.Request, state string, session *SessionData) {
+	if t.pendingAuthStore == nil {
+		return
+	}
+	var binding string
+	if cookie, err := req.Cookie(pendingAuthBindingCookie); err == nil && len(cookie.Value) == 64 {
+		binding = cookie.Value
+	} else if binding, err = generateSecureRandomString(t.random, 32); err != nil {
+		t.logger.Errorf("Failed to generate pending authorization binding: %v", err)
+		return
+	}
+	ttl := t.pendingAuthTTL()
+	_, headers := session.GetIncomingHeaders()
+	pending := PendingAuth{
+		Nonce:            session.GetNonce(),
+		CodeVerifier:     session.GetCodeVerifier(),
+		RedirectURI:      session.GetRedirectURI(),
+		IncomingPath:     session.GetIncomingPath(),
+		IncomingFragment: session.GetIncomingFragment(),
+		IncomingHeaders:  headers,
+		SilentRenew:      session.GetSilentRenew(),
+		SilentLogin:      session.GetSilentLogin(),
+		RememberMe:       session.GetRememberMe(),
+		MFAStepUp:        session.GetMFAStepUp(),
+		AuthAgeStepUp:    session.GetAuthAgeStepUp(),
+		CorrelationID:    session.GetCorrelationID(),
+		IssuedAt:         session.GetCSRFIssuedAt(),
+		BindingHash:      pendingAuthBindingHash(binding),
+	}
+	if err := t.pendingAuthStore.Put(state, pending, ttl); err != nil {
+		t.logger.Errorf("Failed to store pending authorization request: %v", err)
+		return
+	}
+	// The cookie must reach the callback like the main session cookie does
+	options := t.sessionManager.getSessionOptions(strings.HasPrefix(req.URL.Scheme, "https"), t.sessionManager.mainSameSite, int(ttl/time.Second))
+	http.SetCookie(rw, sessions.NewCookie(pendingAuthBindingCookie, binding, options))
+}
+
+// pendingAuthTTL returns how long pending authorization requests are kept: the state TTL.
+func (t *TraefikOidc) pendingAuthTTL() time.Duration {
+	if t.stateTTL <= 0 {
+		return DefaultStateTTLSeconds * time.Second
+	}
+	return t.stateTTL
+}
+
+// pendingAuthBindingHash returns the value stored in PendingAuth.BindingHash for a binding
+// cookie value.
+func pendingAuthBindingHash(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
+
+// restorePendingAuth resolves a callback's state through the pending authorization store and
+// copies the request's values into the session, for callbacks handled by a replica that
+// cannot see the session the login was started in. A session that holds a different pending
+// state is left untouched, and a pending request is only restored when the callback carries
+// the binding cookie of the browser that started it. Otherwise an attacker could complete
+// their own login in a victim's browser (login CSRF) by sending it a callback URL. A request
+// presented by another browser is put back, so that a leaked state cannot be used to spoil
+// the login of the browser that started it.
+//
+// Parameters:
+//   - req: The callback request, carrying the binding cookie.
+//   - state: The state parameter received in the callback.
+//   - session: The callback request's session.
+func (t *TraefikOidc) restorePendingAuth(req *http.Request, state string, session *SessionData) {
+	if t.pendingAuthStore == nil || state == "" {
+		return
+	}
+	if csrf := session.GetCSRF(); csrf != "" && csrf != state {
+		return
+	}
+	pending, ok := t.pendingAuthStore.Take(state)
+	if !ok {
+		return
+	}
+	cookie, err := req.Cookie(pendingAuthBindingCookie)
+	if err != nil || pending.BindingHash == "" ||
+		subtle.ConstantTimeCompare([]byte(pendingAuthBindingHash(cookie.Value)), []byte(pending.BindingHash)) != 1 {
+		t.logger.Infof("Ignoring pending authorization request that was not started in this browser")
+		if ttl := t.pendingAuthTTL() - time.Since(pending.IssuedAt); ttl > 0 {
+			if err := t.pendingAuthStore.Put(state, pending, ttl); err != nil {
+				t.logger.Errorf("Failed to put back pending authorization request: %v", err)
+			}
+		}
+		return
+	}
+
+	session.SetCSRF(state)
+	if !pending.IssuedAt.IsZero() {
+		session.setMainValue("csrf_issued_at", pending.IssuedAt.Unix())
+	}
+	session.SetNonce(pending.Nonce)
+	session.SetCodeVerifier(pending.CodeVerifier)
+	session.SetRedirectURI(pending.RedirectURI)
+	session.SetIncomingPath(pending.IncomingPath)
+	session.SetIncomingFragment(pending.IncomingFragment)
+	session.SetIncomingHeaders(pending.IncomingPath, pending.IncomingHeaders)
+	session.SetSilentRenew(pending.SilentRenew)
+	session.SetSilentLogin(pending.SilentLogin)
+	session.SetRememberMe(pending.RememberMe)
+	session.SetMFAStepUp(pending.MFAStepUp)
+	session.SetAuthAgeStepUp(pending.AuthAgeStepUp)
+	session.SetCorrelationID(pending.CorrelationID)
+}
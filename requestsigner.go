@@ -0,0 +1,128 @@
+package traefikoidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+)
+
+// RequestSigner signs request objects (JWTs sent to the provider on behalf of the client)
+// with the client's private key, for providers that require signed requests.
+type RequestSigner struct {
+	// key is the client's private signing key.
+	key crypto.Signer
+
+	// alg is the JWS algorithm derived from the key type (RS256, ES256 or ES384).
+	alg string
+
+	// keyID is the optional 'kid' header identifying the key to the provider.
+	keyID string
+}
+
+// NewRequestSigner parses a PEM-encoded RSA or EC private key (PKCS#1, SEC 1 or PKCS#8) and
+// returns a signer for request objects. RSA keys sign with RS256, P-256 keys with ES256 and
+// P-384 keys with ES384.
+//
+// Parameters:
+//   - keyPEM: The PEM-encoded private key.
+//   - keyID: The key ID to put in the JWT header; empty omits it.
+//
+// Returns:
+//   - The request signer.
+//   - An error if the key cannot be parsed or is of an unsupported type.
+func NewRequestSigner(keyPEM, keyID string) (*RequestSigner, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse PEM block containing the signing key")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &RequestSigner{key: k, alg: "RS256", keyID: keyID}, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return &RequestSigner{key: k, alg: "ES256", keyID: keyID}, nil
+		case elliptic.P384():
+			return &RequestSigner{key: k, alg: "ES384", keyID: keyID}, nil
+		}
+		return nil, fmt.Errorf("unsupported signing key curve: %s", k.Curve.Params().Name)
+	default:
+		return nil, fmt.Errorf("unsupported signing key type: %T", key)
+	}
+}
+
+// Sign encodes the claims as a JWT signed with the client's key.
+//
+// Parameters:
+//   - claims: The JWT claims.
+//
+// Returns:
+//   - The signed JWT.
+//   - An error if the claims cannot be encoded or signing fails.
+func (s *RequestSigner) Sign(claims map[string]interface{}) (string, error) {
+	header := map[string]string{"alg": s.alg, "typ": "JWT"}
+	if s.keyID != "" {
+		header["kid"] = s.keyID
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request object header: %w", err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request object claims: %w", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	hashFunc := crypto.SHA256
+	if s.alg == "ES384" {
+		hashFunc = crypto.SHA384
+	}
+	h := hashFunc.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	switch k := s.key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, hashFunc, digest)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign request object: %w", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, sig, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return "", fmt.Errorf("failed to sign request object: %w", err)
+		}
+		// JWS uses the fixed-size concatenation of r and s rather than ASN.1
+		size := (k.Curve.Params().BitSize + 7) / 8
+		signature = make([]byte, 2*size)
+		r.FillBytes(signature[:size])
+		sig.FillBytes(signature[size:])
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
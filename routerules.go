@@ -0,0 +1,163 @@
+package traefikoidc
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// routeRule is a RouteRule compiled for evaluation: the role and scope lists are turned into
+// sets, and rules are kept sorted so that the longest matching prefix wins.
+type routeRule struct {
+	pathPrefix     string
+	requiredRoles  map[string]struct{}
+	requiredScopes []string
+	requiredClaims map[string]string
+}
+
+// compileRouteRules prepares the configured per-route authorization rules, sorted by path
+// prefix length so that more specific rules take precedence.
+//
+// Parameters:
+//   - rules: The configured rules.
+//
+// Returns:
+//   - The compiled rules, longest prefix first.
+func compileRouteRules(rules []RouteRule) []routeRule {
+	compiled := make([]routeRule, 0, len(rules))
+	for _, rule := range rules {
+		compiled = append(compiled, routeRule{
+			pathPrefix:     rule.PathPrefix,
+			requiredRoles:  createStringMap(rule.RequiredRoles),
+			requiredScopes: rule.RequiredScopes,
+			requiredClaims: rule.RequiredClaims,
+		})
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return len(compiled[i].pathPrefix) > len(compiled[j].pathPrefix)
+	})
+	return compiled
+}
+
+// matchRouteRule returns the most specific route rule whose prefix matches the path. The
+// path is cleaned first so that empty, "." and ".." segments cannot bypass a rule, and
+// prefixes match whole path segments only: "/admin" matches "/admin" and "/admin/users" but
+// not "/administrator".
+//
+// Parameters:
+//   - requestPath: The request path.
+//
+// Returns:
+//   - The matching rule, or nil if no rule applies to the path.
+func (t *TraefikOidc) matchRouteRule(requestPath string) *routeRule {
+	cleaned := path.Clean("/" + requestPath)
+	for i := range t.routeRules {
+		if pathHasPrefix(cleaned, t.routeRules[i].pathPrefix) {
+			return &t.routeRules[i]
+		}
+	}
+	return nil
+}
+
+// pathHasPrefix reports whether a cleaned path lies at or below the prefix, comparing whole
+// path segments. A trailing slash on the prefix is ignored.
+//
+// Parameters:
+//   - cleaned: The cleaned request path.
+//   - prefix: The configured path prefix.
+//
+// Returns:
+//   - true if the path equals the prefix or is below it.
+func pathHasPrefix(cleaned, prefix string) bool {
+	if cleaned == prefix {
+		return true
+	}
+	trimmed := strings.TrimSuffix(prefix, "/")
+	return cleaned == trimmed || strings.HasPrefix(cleaned, trimmed+"/")
+}
+
+// authorizeRoute checks an authenticated request against the route rule for its path.
+//
+// Parameters:
+//   - path: The request path.
+//   - claims: The claims of the session's token.
+//   - rolesAndGroups: The user's roles and groups.
+//
+// Returns:
+//   - An error describing the unmet requirement, or nil if the request is allowed.
+func (t *TraefikOidc) authorizeRoute(path string, claims map[string]interface{}, rolesAndGroups []string) error {
+	rule := t.matchRouteRule(path)
+	if rule == nil {
+		return nil
+	}
+
+	if len(rule.requiredRoles) > 0 {
+		allowed := false
+		for _, roleOrGroup := range rolesAndGroups {
+			if _, ok := rule.requiredRoles[roleOrGroup]; ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("none of the roles or groups required for %s", rule.pathPrefix)
+		}
+	}
+
+	if len(rule.requiredScopes) > 0 {
+		granted := createStringMap(tokenScopes(claims))
+		for _, scope := range rule.requiredScopes {
+			if _, ok := granted[scope]; !ok {
+				return fmt.Errorf("missing scope %s required for %s", scope, rule.pathPrefix)
+			}
+		}
+	}
+
+	for name, expected := range rule.requiredClaims {
+		if !claimMatches(claims[name], expected) {
+			return fmt.Errorf("claim %s does not match the value required for %s", name, rule.pathPrefix)
+		}
+	}
+	return nil
+}
+
+// tokenScopes returns the scopes granted to a token, read from the space-separated 'scope'
+// claim or, for providers such as Azure AD and Okta, the 'scp' claim.
+func tokenScopes(claims map[string]interface{}) []string {
+	for _, name := range []string{"scope", "scp"} {
+		switch v := claims[name].(type) {
+		case string:
+			return strings.Fields(v)
+		case []interface{}:
+			scopes := make([]string, 0, len(v))
+			for _, scope := range v {
+				if s, ok := scope.(string); ok {
+					scopes = append(scopes, s)
+				}
+			}
+			return scopes
+		}
+	}
+	return nil
+}
+
+// claimMatches reports whether a claim value equals the expected value or, for array
+// claims, contains it. Non-string values are compared in their default text form.
+func claimMatches(value interface{}, expected string) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		return v == expected
+	case []interface{}:
+		for _, item := range v {
+			if claimMatches(item, expected) {
+				return true
+			}
+		}
+		return false
+	default:
+		return fmt.Sprint(v) == expected
+	}
+}
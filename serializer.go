@@ -0,0 +1,107 @@
+package traefikoidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/securecookie"
+)
+
+// jsonSessionSerializer is a securecookie.Serializer that stores session values as a JSON
+// object. gob writes the type name of every interface value, so for the small maps of
+// strings, booleans and integers kept in session cookies JSON is considerably shorter,
+// which leaves more room for tokens before they need chunking.
+//
+// Integers are decoded as int64; session getters read numeric values through sessionInt so
+// the change of encoding is transparent to them. Values that are not valid JSON objects are
+// decoded with gob, so cookies written before switching encodings stay readable.
+type jsonSessionSerializer struct{}
+
+// Serialize encodes session values, which must have string keys, as a JSON object.
+func (jsonSessionSerializer) Serialize(src interface{}) ([]byte, error) {
+	values, ok := src.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("json session serializer: unsupported type %T", src)
+	}
+	out := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("json session serializer: unsupported key type %T", k)
+		}
+		out[key] = v
+	}
+	return json.Marshal(out)
+}
+
+// Deserialize decodes a JSON object written by Serialize into session values, falling back
+// to gob for values written with the default encoding.
+func (jsonSessionSerializer) Deserialize(src []byte, dst interface{}) error {
+	if len(src) == 0 || src[0] != '{' {
+		return securecookie.GobEncoder{}.Deserialize(src, dst)
+	}
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("json session serializer: unsupported type %T", dst)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(src))
+	decoder.UseNumber()
+	var in map[string]interface{}
+	if err := decoder.Decode(&in); err != nil {
+		return fmt.Errorf("json session serializer: %w", err)
+	}
+
+	if *values == nil {
+		*values = make(map[interface{}]interface{}, len(in))
+	}
+	for k, v := range in {
+		(*values)[k] = jsonSessionValue(v)
+	}
+	return nil
+}
+
+// jsonSessionValue converts a value decoded with json.Decoder.UseNumber to the type session
+// getters expect: integers become int64 and other numbers float64.
+//
+// Parameters:
+//   - value: The decoded value.
+//
+// Returns:
+//   - The value with numbers converted.
+func jsonSessionValue(value interface{}) interface{} {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	if f, err := number.Float64(); err == nil {
+		return f
+	}
+	return value
+}
+
+// sessionInt reads an integer session value regardless of the encoding it was stored with
+// (gob keeps int and int64, JSON decodes to int64).
+//
+// Parameters:
+//   - value: The raw session value.
+//
+// Returns:
+//   - The integer value.
+//   - false if the value is missing or not an integer.
+func sessionInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -1,44 +1,69 @@
 package traefikoidc
 
 import (
-	"bytes"
-	"compress/gzip"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	mathrand "math/rand"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 )
 
 // generateSecureRandomString creates a cryptographically secure, hex-encoded random string.
-// It reads the specified number of bytes from crypto/rand and encodes them as a hexadecimal string.
+// It reads the specified number of bytes from the random source and encodes them as a hexadecimal string.
 //
 // Parameters:
+//   - random: The source of randomness; nil uses crypto/rand.
 //   - length: The number of random bytes to generate (the resulting hex string will be twice this length).
 //
 // Returns:
 //   - A hex-encoded random string.
 //   - An error if reading random bytes fails.
-func generateSecureRandomString(length int) (string, error) {
+func generateSecureRandomString(random io.Reader, length int) (string, error) {
 	bytes := make([]byte, length)
-	if _, err := rand.Read(bytes); err != nil {
+	if err := readRandom(random, bytes); err != nil {
 		return "", fmt.Errorf("failed to generate random bytes: %w", err)
 	}
 	return hex.EncodeToString(bytes), nil
 }
 
+// readRandom fills buf from the random source. Production code uses crypto/rand; tests can
+// inject a deterministic reader to assert exact state, nonce and verifier values.
+//
+// Parameters:
+//   - random: The source of randomness; nil uses crypto/rand.
+//   - buf: The buffer to fill.
+//
+// Returns:
+//   - An error if the source cannot fill the buffer.
+func readRandom(random io.Reader, buf []byte) error {
+	if random == nil {
+		random = rand.Reader
+	}
+	_, err := io.ReadFull(random, buf)
+	return err
+}
+
 // Cookie names and configuration constants used for session management
 const (
 	// Using fixed prefixes for consistent cookie naming across restarts
 	mainCookieName     = "_oidc_raczylo_m"
 	accessTokenCookie  = "_oidc_raczylo_a"
 	refreshTokenCookie = "_oidc_raczylo_r"
+	claimsCookie       = "_oidc_raczylo_c"
+	providerCookie     = "_oidc_raczylo_p"
 )
 
 const (
@@ -61,8 +86,36 @@ const (
 
 	// minEncryptionKeyLength defines the minimum length for the encryption key
 	minEncryptionKeyLength = 32
+
+	// activityWriteInterval is how stale the recorded activity of a session must be before
+	// a request records it again, bounding how often the main cookie is rewritten.
+	activityWriteInterval = time.Minute
+
+	// serverTokenStoreSize is the maximum number of tokens kept in the server-side
+	// token store; the least recently used tokens are evicted first.
+	serverTokenStoreSize = 10000
+
+	// userSessionIndexSize is the maximum number of users tracked by the user to session
+	// index of SingleSessionPerUser; the least recently used entries are evicted first.
+	userSessionIndexSize = 100000
 )
 
+// ErrTokenTooLarge is returned by SetAccessToken and SetRefreshToken when chunking is
+// disabled and the compressed token does not fit within a single cookie.
+var ErrTokenTooLarge = errors.New("token exceeds maximum cookie size and chunking is disabled")
+
+// ErrCookieBudgetExceeded is returned by Save when the combined size of all session cookies
+// exceeds the configured budget and the budget is enforced.
+var ErrCookieBudgetExceeded = errors.New("session cookies exceed configured size budget")
+
+// ErrDecompressionFailed is returned for a token stored as compressed that cannot be
+// decompressed, telling a corrupted token apart from one that was never compressed.
+var ErrDecompressionFailed = errors.New("failed to decompress token")
+
+// ErrSessionExpired is returned by GetSession and GetSessionContext, together with the loaded
+// session, when the session is older than the absolute session timeout.
+var ErrSessionExpired = errors.New("session expired")
+
 // compressToken compresses the input string using gzip and then encodes the result using standard base64 encoding.
 // If any error occurs during compression, it returns the original uncompressed token as a fallback.
 //
@@ -72,20 +125,13 @@ const (
 // Returns:
 //   - The base64 encoded, gzipped string, or the original string if compression fails.
 func compressToken(token string) string {
-	var b bytes.Buffer
-	gz := gzip.NewWriter(&b)
-	if _, err := gz.Write([]byte(token)); err != nil {
-		return token // fallback to uncompressed on error
-	}
-	if err := gz.Close(); err != nil {
-		return token
-	}
-	return base64.StdEncoding.EncodeToString(b.Bytes())
+	return compressTokenWithCodec(gzipCodec{}, token)
 }
 
 // decompressToken decodes a standard base64 encoded string and then decompresses the result using gzip.
-// If base64 decoding or gzip decompression fails, it returns the original input string as a fallback,
-// assuming it might not have been compressed.
+// If base64 decoding fails, the decoded data does not start with the gzip magic bytes, or gzip
+// decompression fails, it returns the original input string as a fallback, assuming it might not
+// have been compressed.
 //
 // Parameters:
 //   - compressed: The base64 encoded, gzipped string.
@@ -93,23 +139,66 @@ func compressToken(token string) string {
 // Returns:
 //   - The decompressed original string, or the input string if decompression fails.
 func decompressToken(compressed string) string {
-	data, err := base64.StdEncoding.DecodeString(compressed)
+	return decompressTokenWithCodec(gzipCodec{}, compressed)
+}
+
+// compressTokenWithCodec compresses the input string using the given codec and then encodes
+// the result using standard base64 encoding. If compression fails, it returns the original
+// uncompressed token as a fallback.
+//
+// Parameters:
+//   - codec: The compression codec to use.
+//   - token: The string to compress.
+//
+// Returns:
+//   - The base64 encoded, compressed string, or the original string if compression fails.
+func compressTokenWithCodec(codec CompressionCodec, token string) string {
+	data, err := codec.Compress([]byte(token))
 	if err != nil {
-		return compressed // return as-is if not base64
+		return token // fallback to uncompressed on error
 	}
+	return base64.StdEncoding.EncodeToString(data)
+}
 
-	gz, err := gzip.NewReader(bytes.NewReader(data))
+// decompressTokenWithCodec decodes a standard base64 encoded string and then decompresses the
+// result using the given codec. If base64 decoding or decompression fails, it returns the
+// original input string as a fallback, assuming it might not have been compressed.
+//
+// Parameters:
+//   - codec: The compression codec that produced the input.
+//   - compressed: The base64 encoded, compressed string.
+//
+// Returns:
+//   - The decompressed original string, or the input string if decompression fails.
+func decompressTokenWithCodec(codec CompressionCodec, compressed string) string {
+	decompressed, err := decompressTokenStrict(codec, compressed)
 	if err != nil {
-		return compressed
+		return compressed // return as-is, assuming it was not compressed
 	}
-	defer gz.Close()
+	return decompressed
+}
 
-	decompressed, err := io.ReadAll(gz)
+// decompressTokenStrict decodes a standard base64 encoded string and then decompresses the
+// result using the given codec. Unlike decompressTokenWithCodec it reports failures, for
+// values known to have been stored compressed.
+//
+// Parameters:
+//   - codec: The compression codec that produced the input.
+//   - compressed: The base64 encoded, compressed string.
+//
+// Returns:
+//   - The decompressed original string.
+//   - An error wrapping ErrDecompressionFailed if base64 decoding or decompression fails.
+func decompressTokenStrict(codec CompressionCodec, compressed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(compressed)
 	if err != nil {
-		return compressed
+		return "", fmt.Errorf("%w: invalid base64: %v", ErrDecompressionFailed, err)
 	}
-
-	return string(decompressed)
+	decompressed, err := codec.Decompress(data)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrDecompressionFailed, codec.Name(), err)
+	}
+	return string(decompressed), nil
 }
 
 // SessionManager handles the management of multiple session cookies for OIDC authentication.
@@ -125,6 +214,104 @@ type SessionManager struct {
 	// logger provides structured logging capabilities.
 	logger *Logger
 
+	// random is the source of randomness for session IDs (crypto/rand unless a test
+	// injects a deterministic reader).
+	random io.Reader
+
+	// codec is the compression codec used when storing tokens.
+	codec CompressionCodec
+
+	// compressionThreshold is the token length in bytes below which tokens are stored
+	// uncompressed (0 compresses every token).
+	compressionThreshold int
+
+	// disableChunking rejects tokens that do not fit in a single cookie instead of
+	// splitting them across multiple chunk cookies.
+	disableChunking bool
+
+	// compressClaims compresses the UserInfo claims blob kept in the main session.
+	compressClaims bool
+
+	// cookieBudget is the maximum total number of bytes all session cookies may occupy
+	// (0 disables the check).
+	cookieBudget int
+
+	// enforceCookieBudget makes Save fail instead of only logging when cookieBudget is exceeded.
+	enforceCookieBudget bool
+
+	// mainSameSite is the SameSite mode of the main session cookie (Lax if unset).
+	mainSameSite http.SameSite
+
+	// tokenSameSite is the SameSite mode of the access and refresh token cookies
+	// and their chunks (Lax if unset).
+	tokenSameSite http.SameSite
+
+	// tokenStore holds encrypted tokens server-side, keyed by a random token session ID kept
+	// in the main session cookie. When nil, tokens are stored in (chunked) cookies.
+	tokenStore *Cache
+
+	// tokenCodecs encrypt and authenticate tokens written to tokenStore.
+	tokenCodecs []securecookie.Codec
+
+	// storeMutex protects store, tokenCodecs and the key rotation state below, which are
+	// replaced when rotated session keys are picked up.
+	storeMutex sync.RWMutex
+
+	// keyProvider supplies the session keys, newest first; nil keeps the initial key.
+	keyProvider func() [][]byte
+
+	// keyRefreshInterval is how often keyProvider is consulted for rotated keys.
+	keyRefreshInterval time.Duration
+
+	// keys are the session keys in use when keyProvider is set.
+	keys [][]byte
+
+	// keysCheckedAt is when keyProvider was last consulted.
+	keysCheckedAt time.Time
+
+	// serializer is the cookie serializer applied to the cookie store's codecs.
+	serializer securecookie.Serializer
+
+	// invalidatedUsers maps user IDs to the time their sessions were invalidated by
+	// InvalidateUser; sessions authenticated at or before that time are terminated.
+	invalidatedUsers map[string]time.Time
+
+	// invalidatedMutex protects invalidatedUsers.
+	invalidatedMutex sync.RWMutex
+
+	// userSessions maps user IDs to the ID of their current session when each user is
+	// limited to a single session; nil otherwise.
+	userSessions *Cache
+
+	// timeoutJitter is the fraction by which each session's absolute timeout is randomly
+	// lengthened or shortened, spreading out re-logins of users who logged in together.
+	timeoutJitter float64
+
+	// rememberMeTimeout is the absolute timeout of sessions whose login asked to be
+	// remembered. When set, other sessions use browser-session cookies. 0 disables remember-me.
+	rememberMeTimeout time.Duration
+
+	// loginTimeout is how long a started login may stay pending before its state, nonce and
+	// code verifier are discarded. 0 keeps them until the login completes.
+	loginTimeout time.Duration
+
+	// idleTimeout is how long an authenticated session may go unused before it expires.
+	// 0 disables the idle timeout.
+	idleTimeout time.Duration
+
+	// cookiePath is the Path attribute of every session cookie.
+	cookiePath string
+
+	// singleCookie packs sessions that fit into a single cookie instead of splitting the main
+	// session and tokens across separate cookies.
+	singleCookie bool
+
+	// cookieStatsHook receives the chunk counts and size of the cookies written by each Save.
+	cookieStatsHook func(stats CookieStats)
+
+	// tokenTooLargeHook is notified of tokens rejected for not fitting in the cookies.
+	tokenTooLargeHook func(session *SessionData, tokenType string, size int)
+
 	// sessionPool is a sync.Pool for reusing SessionData objects.
 	sessionPool sync.Pool
 }
@@ -137,237 +324,1276 @@ type SessionManager struct {
 //
 // Returns an error if the encryption key does not meet minimum length requirements.
 func NewSessionManager(encryptionKey string, forceHTTPS bool, logger *Logger) (*SessionManager, error) {
+	return NewSessionManagerWithStore(sessions.NewCookieStore([]byte(encryptionKey)), encryptionKey, forceHTTPS, logger)
+}
+
+// NewSessionManagerWithStore creates a session manager backed by the given session store
+// instead of the default cookie store. It is mainly intended for tests, which can inject an
+// in-memory store to exercise token storage and chunking without real cookies. Like the
+// cookie store, the store must mark sessions it holds no data for with IsNew, which is how
+// missing token chunks are detected.
+// Parameters:
+//   - store: The session store used to load and save every session (main, tokens and chunks)
+//   - encryptionKey: Key used to encrypt server-side stored tokens (must be at least 32 bytes)
+//   - forceHTTPS: When true, forces secure cookie attributes regardless of request scheme
+//   - logger: Logger instance for recording session-related events
+//
+// Returns an error if the store is nil or the encryption key does not meet minimum length requirements.
+func NewSessionManagerWithStore(store sessions.Store, encryptionKey string, forceHTTPS bool, logger *Logger) (*SessionManager, error) {
+	if store == nil {
+		return nil, fmt.Errorf("session store is required")
+	}
 	// Validate encryption key length.
 	if len(encryptionKey) < minEncryptionKeyLength {
 		return nil, fmt.Errorf("encryption key must be at least %d bytes long", minEncryptionKeyLength)
 	}
 
 	sm := &SessionManager{
-		store:      sessions.NewCookieStore([]byte(encryptionKey)),
-		forceHTTPS: forceHTTPS,
-		logger:     logger,
+		store:          store,
+		forceHTTPS:     forceHTTPS,
+		logger:         logger,
+		codec:          gzipCodec{},
+		compressClaims: true,
+		cookiePath:     "/",
+		random:         rand.Reader,
 	}
+	sm.tokenCodecs = newTokenCodecs([][]byte{[]byte(encryptionKey)})
 
 	// Initialize session pool.
 	sm.sessionPool.New = func() interface{} {
 		// Initialize SessionData with necessary fields and the mutex.
 		return &SessionData{
-			manager:            sm,
-			accessTokenChunks:  make(map[int]*sessions.Session),
-			refreshTokenChunks: make(map[int]*sessions.Session),
-			refreshMutex:       sync.Mutex{}, // Initialize the mutex
+			manager:             sm,
+			accessTokenChunks:   make(map[int]*sessions.Session),
+			refreshTokenChunks:  make(map[int]*sessions.Session),
+			providerTokenChunks: make(map[int]*sessions.Session),
+			claimsChunks:        make(map[int]*sessions.Session),
+			expiredChunks:       make(map[string]*sessions.Session),
+			refreshMutex:        sync.Mutex{}, // Initialize the mutex
 		}
 	}
 
 	return sm, nil
 }
 
-// getSessionOptions returns a sessions.Options struct configured with security best practices.
-// It sets HttpOnly to true, Secure based on the request scheme or forceHTTPS setting,
-// SameSite to LaxMode, MaxAge to the absoluteSessionTimeout, and Path to "/".
+// InvalidateUser terminates every session of the given user that was authenticated up to
+// now, so that the user's next request triggers re-authentication. Sessions created by a
+// later login are not affected.
 //
-// Parameters:
-//   - isSecure: A boolean indicating if the current request context is secure (HTTPS).
+// Sessions live in client cookies and cannot be enumerated, so instead of deleting them the
+// invalidation is recorded in memory and checked against each session's authentication time
+// when it is next used. The record is local to this process: with several replicas the call
+// must be made on each of them. Records are dropped once every affected session would have
+// reached its absolute timeout anyway.
 //
-// Returns:
-//   - A pointer to a configured sessions.Options struct.
-func (sm *SessionManager) getSessionOptions(isSecure bool) *sessions.Options {
-	return &sessions.Options{
-		HttpOnly: true,
-		Secure:   isSecure || sm.forceHTTPS,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   int(absoluteSessionTimeout.Seconds()),
-		Path:     "/",
+// Parameters:
+//   - userID: The user identifier, as returned by SessionData.GetUserID.
+func (sm *SessionManager) InvalidateUser(userID string) {
+	if userID == "" {
+		return
 	}
-}
+	now := time.Now()
 
-// GetSession retrieves all session data for the current request.
-// It loads the main session and token sessions, including any chunked token data,
-// and combines them into a single SessionData structure for easy access.
-// Returns an error if any session component cannot be loaded.
-func (sm *SessionManager) GetSession(r *http.Request) (*SessionData, error) {
-	// Get session from pool.
-	sessionData := sm.sessionPool.Get().(*SessionData)
-	sessionData.request = r
+	sm.invalidatedMutex.Lock()
+	defer sm.invalidatedMutex.Unlock()
 
-	var err error
-	sessionData.mainSession, err = sm.store.Get(r, mainCookieName)
-	if err != nil {
-		sm.sessionPool.Put(sessionData)
-		return nil, fmt.Errorf("failed to get main session: %w", err)
+	if sm.invalidatedUsers == nil {
+		sm.invalidatedUsers = make(map[string]time.Time)
 	}
-
-	// Check for absolute session timeout.
-	if createdAt, ok := sessionData.mainSession.Values["created_at"].(int64); ok {
-		if time.Since(time.Unix(createdAt, 0)) > absoluteSessionTimeout {
-			sessionData.Clear(r, nil)
-			return nil, fmt.Errorf("session expired")
+	for id, invalidatedAt := range sm.invalidatedUsers {
+		if now.Sub(invalidatedAt) > sm.maxSessionTimeout() {
+			delete(sm.invalidatedUsers, id)
 		}
 	}
+	sm.invalidatedUsers[userID] = now
+}
 
-	sessionData.accessSession, err = sm.store.Get(r, accessTokenCookie)
-	if err != nil {
-		sm.sessionPool.Put(sessionData)
-		return nil, fmt.Errorf("failed to get access token session: %w", err)
+// SetSingleSessionPerUser limits each user to one active session. Every login is recorded
+// with BindUserSession in an index of user IDs to session IDs, and sessions other than the
+// one last bound for their user are reported by IsInvalidated. Like InvalidateUser, the
+// index is local to this process.
+//
+// Parameters:
+//   - enable: True to let a new login terminate the user's previous session.
+func (sm *SessionManager) SetSingleSessionPerUser(enable bool) {
+	if !enable {
+		sm.userSessions = nil
+		return
+	}
+	if sm.userSessions == nil {
+		sm.userSessions = NewCache()
+		sm.userSessions.SetMaxSize(userSessionIndexSize)
 	}
+}
 
-	sessionData.refreshSession, err = sm.store.Get(r, refreshTokenCookie)
+// BindUserSession gives the authenticated session a new session ID and records it as the
+// current session of its user, evicting the session bound before. The login flow calls it
+// once the user ID is set. It does nothing unless sessions are limited to one per user.
+//
+// Parameters:
+//   - sd: The newly authenticated session.
+//
+// Returns:
+//   - An error if a session ID cannot be generated.
+func (sm *SessionManager) BindUserSession(sd *SessionData) error {
+	userID := sd.GetUserID()
+	if sm.userSessions == nil || userID == "" {
+		return nil
+	}
+	sid, err := generateSecureRandomString(sm.random, 32)
 	if err != nil {
-		sm.sessionPool.Put(sessionData)
-		return nil, fmt.Errorf("failed to get refresh token session: %w", err)
+		return fmt.Errorf("failed to generate session id: %w", err)
 	}
+	sd.setMainValue("sid", sid)
+	sm.userSessions.Set(userID, sid, sm.maxSessionTimeout())
+	return nil
+}
 
-	// Clear and reuse chunk maps.
-	for k := range sessionData.accessTokenChunks {
-		delete(sessionData.accessTokenChunks, k)
+// UserSession returns the ID of the session currently bound to the user by BindUserSession.
+//
+// Parameters:
+//   - userID: The user identifier, as returned by SessionData.GetUserID.
+//
+// Returns:
+//   - The session ID, as returned by SessionData.GetSessionID.
+//   - false if no session is bound to the user or sessions are not limited to one per user.
+func (sm *SessionManager) UserSession(userID string) (string, bool) {
+	if sm.userSessions == nil {
+		return "", false
 	}
-	for k := range sessionData.refreshTokenChunks {
-		delete(sessionData.refreshTokenChunks, k)
+	value, ok := sm.userSessions.Get(userID)
+	if !ok {
+		return "", false
 	}
-
-	// Retrieve chunked token sessions.
-	sm.getTokenChunkSessions(r, accessTokenCookie, sessionData.accessTokenChunks)
-	sm.getTokenChunkSessions(r, refreshTokenCookie, sessionData.refreshTokenChunks)
-
-	return sessionData, nil
+	sid, ok := value.(string)
+	return sid, ok
 }
 
-// getTokenChunkSessions retrieves all cookie chunks associated with a large token (access or refresh).
-// It iteratively attempts to load cookies named "{baseName}_0", "{baseName}_1", etc., until
-// a cookie is not found or returns an error. The loaded sessions are stored in the provided chunks map.
+// IsInvalidated reports whether the session belongs to a user whose sessions were terminated
+// by InvalidateUser after this session was authenticated, or, when sessions are limited to
+// one per user, whether a later login of the user replaced it.
 //
 // Parameters:
-//   - r: The incoming HTTP request containing the cookies.
-//   - baseName: The base name of the cookie (e.g., accessTokenCookie).
-//   - chunks: The map (typically SessionData.accessTokenChunks or SessionData.refreshTokenChunks) to populate with the found session chunks.
-func (sm *SessionManager) getTokenChunkSessions(r *http.Request, baseName string, chunks map[int]*sessions.Session) {
-	for i := 0; ; i++ {
-		sessionName := fmt.Sprintf("%s_%d", baseName, i)
-		session, err := sm.store.Get(r, sessionName)
-		if err != nil || session.IsNew {
-			break
-		}
-		chunks[i] = session
+//   - sd: The session to check.
+//
+// Returns:
+//   - true if the session must not be used anymore.
+func (sm *SessionManager) IsInvalidated(sd *SessionData) bool {
+	createdAt := sd.CreatedAt()
+	if createdAt.IsZero() {
+		return false
 	}
-}
 
-// SessionData holds all session information for an authenticated user.
-// It manages multiple session cookies to handle the main session state
-// and potentially large access and refresh tokens that may need to be
-// split across multiple cookies due to browser size limitations.
-type SessionData struct {
-	// manager is the SessionManager that created this SessionData.
-	manager *SessionManager
+	if current, ok := sm.UserSession(sd.GetUserID()); ok && current != sd.GetSessionID() {
+		return true
+	}
 
-	// request is the current HTTP request associated with this session.
-	request *http.Request
+	sm.invalidatedMutex.RLock()
+	invalidatedAt, ok := sm.invalidatedUsers[sd.GetUserID()]
+	sm.invalidatedMutex.RUnlock()
 
-	// mainSession stores authentication state and basic user info.
-	mainSession *sessions.Session
+	// created_at has second precision, so a session created in the same second is terminated too
+	return ok && !createdAt.After(invalidatedAt.Truncate(time.Second))
+}
 
-	// accessSession stores the primary access token cookie.
-	accessSession *sessions.Session
+// SetCompressionCodec selects the codec used to compress tokens written from now on.
+// Tokens already stored in cookies keep their codec marker and remain readable.
+//
+// Parameters:
+//   - name: The configuration name of a registered codec (e.g. "gzip", "deflate").
+//
+// Returns:
+//   - An error if no codec with that name is registered.
+func (sm *SessionManager) SetCompressionCodec(name string) error {
+	codec, err := getCompressionCodec(name)
+	if err != nil {
+		return err
+	}
+	sm.codec = codec
+	return nil
+}
 
-	// refreshSession stores the primary refresh token cookie.
-	refreshSession *sessions.Session
+// SetCompressionThreshold sets the token length below which tokens are stored uncompressed.
+// Compressing tokens well under a cookie's size costs CPU and can even make them larger.
+// Tokens already stored keep their compressed flag and remain readable.
+//
+// Parameters:
+//   - threshold: The length in bytes; 0 compresses every token.
+func (sm *SessionManager) SetCompressionThreshold(threshold int) {
+	sm.compressionThreshold = threshold
+}
 
-	// accessTokenChunks stores additional chunks of the access token
-	// when it exceeds the maximum cookie size.
-	accessTokenChunks map[int]*sessions.Session
+// encodeToken prepares a token for storage in cookies, compressing it with the configured
+// codec unless it is shorter than the compression threshold.
+//
+// Parameters:
+//   - token: The token to store.
+//
+// Returns:
+//   - The token as it is to be stored.
+//   - true if the token was compressed.
+func (sm *SessionManager) encodeToken(token string) (string, bool) {
+	if len(token) < sm.compressionThreshold {
+		return token, false
+	}
+	return compressTokenWithCodec(sm.codec, token), true
+}
 
-	// refreshTokenChunks stores additional chunks of the refresh token
-	// when it exceeds the maximum cookie size.
-	refreshTokenChunks map[int]*sessions.Session
+// SetDisableChunking controls whether tokens that exceed a single cookie are split across
+// chunk cookies (the default) or rejected with ErrTokenTooLarge.
+//
+// Parameters:
+//   - disable: True to reject oversized tokens instead of chunking them.
+func (sm *SessionManager) SetDisableChunking(disable bool) {
+	sm.disableChunking = disable
+}
 
-	// refreshMutex protects refresh token operations within this session instance.
-	refreshMutex sync.Mutex
+// SetCompressClaims controls whether the UserInfo claims blob kept in the main session is
+// compressed (the default). Compression is skipped for blobs it would not shrink.
+//
+// Parameters:
+//   - compress: False to store the claims as plain JSON.
+func (sm *SessionManager) SetCompressClaims(compress bool) {
+	sm.compressClaims = compress
 }
 
-// Save persists all parts of the session (main, access token, refresh token, and any chunks)
-// back to the client as cookies in the HTTP response. It applies secure cookie options
-// obtained via getSessionOptions based on the request's security context.
+// SetCookieBudget configures the pre-flight check performed by Save on the combined size
+// of all session cookies.
 //
 // Parameters:
-//   - r: The original HTTP request (used to determine security context for cookie options).
-//   - w: The HTTP response writer to which the Set-Cookie headers will be added.
+//   - maxBytes: The maximum total size in bytes of all session cookies; 0 disables the check.
+//   - enforce: When true, Save returns ErrCookieBudgetExceeded instead of only logging a warning.
+func (sm *SessionManager) SetCookieBudget(maxBytes int, enforce bool) {
+	sm.cookieBudget = maxBytes
+	sm.enforceCookieBudget = enforce
+}
+
+// SetCookieStatsHook registers a callback that receives the number of token chunk cookies
+// and the total cookie size written by every successful Save, so that operators can alert
+// on cookie bloat before browsers start dropping cookies. The hook is called synchronously
+// and must be safe for concurrent use.
 //
-// Returns:
-//   - An error if saving any of the session components fails.
-func (sd *SessionData) Save(r *http.Request, w http.ResponseWriter) error {
-	isSecure := strings.HasPrefix(r.URL.Scheme, "https") || sd.manager.forceHTTPS
+// Parameters:
+//   - hook: The callback; nil disables it.
+func (sm *SessionManager) SetCookieStatsHook(hook func(stats CookieStats)) {
+	sm.cookieStatsHook = hook
+}
 
-	// Set options for all sessions.
-	options := sd.manager.getSessionOptions(isSecure)
-	sd.mainSession.Options = options
-	sd.accessSession.Options = options
-	sd.refreshSession.Options = options
+// SetTokenTooLargeHook registers a callback invoked by SetAccessToken and SetRefreshToken
+// when a token is rejected because it does not fit in the session cookies, so that operators
+// can log, count or otherwise react to oversize tokens. The hook is called synchronously
+// with the session, the token type ("access" or "refresh") and the compressed token size,
+// and must be safe for concurrent use.
+//
+// Parameters:
+//   - hook: The callback; nil disables it.
+func (sm *SessionManager) SetTokenTooLargeHook(hook func(session *SessionData, tokenType string, size int)) {
+	sm.tokenTooLargeHook = hook
+}
 
-	// Save main session.
-	if err := sd.mainSession.Save(r, w); err != nil {
-		return fmt.Errorf("failed to save main session: %w", err)
+// SetCookiePath scopes every session cookie (main, token, chunk and single-layout cookies) to
+// the given path, so that other applications on the same host do not receive them. The
+// callback and logout paths must lie within it, otherwise the browser does not send the
+// session back to them. Cookies are read regardless of their path, but can only be replaced
+// and expired with the path they were set with, so the path applies to every cookie written,
+// including the ones expiring chunks. With the cookie store, the store's default options are
+// updated too.
+//
+// Parameters:
+//   - path: The cookie path; "" keeps the default ("/").
+func (sm *SessionManager) SetCookiePath(path string) {
+	if path == "" {
+		path = "/"
 	}
+	sm.cookiePath = path
 
-	// Save access token session.
-	if err := sd.accessSession.Save(r, w); err != nil {
-		return fmt.Errorf("failed to save access token session: %w", err)
+	sm.storeMutex.Lock()
+	defer sm.storeMutex.Unlock()
+	if store, ok := sm.store.(*sessions.CookieStore); ok && store.Options != nil {
+		store.Options.Path = path
 	}
+}
 
-	// Save refresh token session.
-	if err := sd.refreshSession.Save(r, w); err != nil {
-		return fmt.Errorf("failed to save refresh token session: %w", err)
-	}
+// SetSameSite configures the SameSite attribute separately for the main session cookie and
+// for the token cookies. The main cookie must be sent on the top-level redirect back from the
+// provider, so it is normally Lax (or None), while token cookies can be Strict when the first
+// page after login is requested same-site (postLoginHtmlRedirect).
+//
+// Parameters:
+//   - main: The SameSite mode of the main session cookie; 0 keeps the default (Lax).
+//   - token: The SameSite mode of the access/refresh token cookies; 0 keeps the default (Lax).
+func (sm *SessionManager) SetSameSite(main, token http.SameSite) {
+	sm.mainSameSite = main
+	sm.tokenSameSite = token
+}
 
-	// Save access token chunks.
-	for _, session := range sd.accessTokenChunks {
-		session.Options = options
-		if err := session.Save(r, w); err != nil {
-			return fmt.Errorf("failed to save access token chunk session: %w", err)
+// SetTokenStorage selects where access and refresh tokens are kept. With TokenStorageCookie
+// (the default) they are compressed into (chunked) session cookies. With TokenStorageMemory
+// only a random token session ID is stored in the main session cookie and the encrypted
+// tokens are held in an in-process cache, which avoids chunking and keeps tokens off the
+// wire but does not survive restarts and is not shared between instances.
+//
+// Parameters:
+//   - storage: TokenStorageCookie, TokenStorageMemory, or "" for the default.
+//
+// Returns:
+//   - An error if the storage mode is unknown.
+func (sm *SessionManager) SetTokenStorage(storage string) error {
+	switch storage {
+	case "", TokenStorageCookie:
+		if sm.tokenStore != nil {
+			sm.tokenStore.Close()
+			sm.tokenStore = nil
 		}
-	}
-
-	// Save refresh token chunks.
-	for _, session := range sd.refreshTokenChunks {
-		session.Options = options
-		if err := session.Save(r, w); err != nil {
-			return fmt.Errorf("failed to save refresh token chunk session: %w", err)
+	case TokenStorageMemory:
+		if sm.tokenStore == nil {
+			sm.tokenStore = NewCache()
+			sm.tokenStore.SetMaxSize(serverTokenStoreSize)
 		}
+	default:
+		return fmt.Errorf("unknown token storage: %s", storage)
 	}
-
 	return nil
 }
 
-// Clear removes all session data associated with this SessionData instance.
-// It clears the values map of the main, access, and refresh sessions, sets their MaxAge to -1
-// to expire the cookies immediately, and clears any associated token chunk cookies.
-// If a ResponseWriter is provided, it attempts to save the expired sessions to send the
-// expiring Set-Cookie headers. Finally, it clears internal fields and returns the SessionData
-// object to the pool.
+// SetCookieEncoding selects how session values are serialized before they are encrypted into
+// cookies. CookieEncodingGob (the default) uses encoding/gob; CookieEncodingJSON produces a
+// noticeably smaller payload. Cookies written with gob remain readable after switching to
+// JSON. The setting only applies to the built-in cookie store; custom stores are unaffected.
 //
 // Parameters:
-//   - r: The HTTP request (required by the underlying session store).
-//   - w: The HTTP response writer (optional). If provided, expiring Set-Cookie headers will be sent.
+//   - encoding: CookieEncodingGob, CookieEncodingJSON, or "" for the default.
 //
 // Returns:
-//   - An error if saving the expired sessions fails (only if w is not nil).
-func (sd *SessionData) Clear(r *http.Request, w http.ResponseWriter) error {
-	// Clear and expire all sessions.
-	sd.mainSession.Options.MaxAge = -1
-	sd.accessSession.Options.MaxAge = -1
-	sd.refreshSession.Options.MaxAge = -1
+//   - An error if the encoding is unknown.
+func (sm *SessionManager) SetCookieEncoding(encoding string) error {
+	var serializer securecookie.Serializer
+	switch encoding {
+	case "", CookieEncodingGob:
+		serializer = securecookie.GobEncoder{}
+	case CookieEncodingJSON:
+		serializer = jsonSessionSerializer{}
+	default:
+		return fmt.Errorf("unknown cookie encoding: %s", encoding)
+	}
 
-	for k := range sd.mainSession.Values {
-		delete(sd.mainSession.Values, k)
+	sm.storeMutex.Lock()
+	defer sm.storeMutex.Unlock()
+	sm.serializer = serializer
+	store, ok := sm.store.(*sessions.CookieStore)
+	if !ok {
+		return nil
 	}
-	for k := range sd.accessSession.Values {
-		delete(sd.accessSession.Values, k)
+	for _, codec := range store.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.SetSerializer(serializer)
+		}
 	}
-	for k := range sd.refreshSession.Values {
-		delete(sd.refreshSession.Values, k)
+	return nil
+}
+
+// SetSessionTimeoutJitter spreads out session expiry: each newly authenticated session gets
+// an absolute timeout randomly chosen within ±fraction of the default, so users who logged in
+// at the same time do not all have to log in again at once. The chosen timeout is stored in
+// the session so every later check uses the same value.
+//
+// Parameters:
+//   - fraction: The maximum relative deviation (e.g. 0.1 for ±10%); 0 disables jitter.
+func (sm *SessionManager) SetSessionTimeoutJitter(fraction float64) {
+	if fraction < 0 {
+		fraction = 0
 	}
+	sm.timeoutJitter = fraction
+}
 
-	// Clear chunk sessions.
-	sd.clearTokenChunks(r, sd.accessTokenChunks)
-	sd.clearTokenChunks(r, sd.refreshTokenChunks)
+// SetLoginTimeout limits how long a started login stays pending. Loading a session whose
+// pending authorization request is older than the timeout discards its state, nonce and code
+// verifier, so an abandoned login cannot be completed later.
+//
+// Parameters:
+//   - timeout: The maximum age of a pending login; 0 disables the limit.
+func (sm *SessionManager) SetLoginTimeout(timeout time.Duration) {
+	sm.loginTimeout = timeout
+}
+
+// SetIdleTimeout configures how long an authenticated session may go unused before
+// GetSession reports it as expired. Activity is recorded with MarkActive.
+//
+// Parameters:
+//   - timeout: The idle timeout; 0 disables it.
+func (sm *SessionManager) SetIdleTimeout(timeout time.Duration) {
+	sm.idleTimeout = timeout
+}
+
+// jitteredSessionTimeout picks the absolute timeout of a newly authenticated session.
+func (sm *SessionManager) jitteredSessionTimeout() time.Duration {
+	if sm.timeoutJitter <= 0 {
+		return absoluteSessionTimeout
+	}
+	deviation := (mathrand.Float64()*2 - 1) * sm.timeoutJitter
+	return time.Duration(float64(absoluteSessionTimeout) * (1 + deviation)).Truncate(time.Second)
+}
+
+// maxSessionTimeout returns the longest absolute timeout any session can have, used for
+// cookie lifetimes and server-side records that must outlive every session.
+func (sm *SessionManager) maxSessionTimeout() time.Duration {
+	timeout := time.Duration(float64(absoluteSessionTimeout) * (1 + sm.timeoutJitter))
+	if sm.rememberMeTimeout > timeout {
+		return sm.rememberMeTimeout
+	}
+	return timeout
+}
+
+// SetRememberMeTimeout enables "remember me" sessions. Sessions whose login asked to be
+// remembered (see SetRememberMe) get the given absolute timeout and persistent cookies; all
+// other sessions keep the default timeout with cookies that end with the browser session.
+//
+// Parameters:
+//   - timeout: The absolute timeout of remembered sessions; 0 disables remember-me.
+func (sm *SessionManager) SetRememberMeTimeout(timeout time.Duration) {
+	sm.rememberMeTimeout = timeout
+}
+
+// cookieMaxAge returns the lifetime in seconds of the cookies written for this session:
+// 0 (browser-session cookies) for sessions that were not asked to be remembered while
+// remember-me is enabled, otherwise long enough to outlive any session.
+func (sd *SessionData) cookieMaxAge() int {
+	if sd.manager.rememberMeTimeout > 0 && !sd.GetRememberMe() {
+		return 0
+	}
+	return int(sd.manager.maxSessionTimeout().Seconds())
+}
+
+// decompressStoredToken decompresses a token read from the given session, using the codec
+// recorded in the session's "codec" marker. Tokens without a marker are treated as gzip.
+//
+// Parameters:
+//   - session: The primary token session holding the codec marker.
+//   - token: The base64 encoded, compressed token.
+//
+// Returns:
+//   - The decompressed token.
+//   - An error wrapping ErrDecompressionFailed if the codec is unknown or decompression fails.
+func (sd *SessionData) decompressStoredToken(session *sessions.Session, token string) (string, error) {
+	codecID, ok := session.Values["codec"].(byte)
+	if !ok {
+		// JSON-encoded sessions decode the marker as an integer
+		if id, isInt := sessionInt(session.Values["codec"]); isInt {
+			codecID = byte(id)
+		}
+	}
+	codec, known := compressionCodecByID(codecID)
+	if !known {
+		return "", fmt.Errorf("%w: unknown compression codec marker %d", ErrDecompressionFailed, codecID)
+	}
+	return decompressTokenStrict(codec, token)
+}
+
+// getSessionOptions returns a sessions.Options struct configured with security best practices.
+// It sets HttpOnly to true, Secure based on the request scheme or forceHTTPS setting,
+// SameSite to the given mode (LaxMode if unset), MaxAge to the given cookie lifetime, and Path to the
+// configured cookie path ("/" by default).
+//
+// Parameters:
+//   - isSecure: A boolean indicating if the current request context is secure (HTTPS).
+//   - sameSite: The SameSite mode for the cookie type being written.
+//   - maxAge: The cookie lifetime in seconds; 0 writes browser-session cookies.
+//
+// Returns:
+//   - A pointer to a configured sessions.Options struct.
+func (sm *SessionManager) getSessionOptions(isSecure bool, sameSite http.SameSite, maxAge int) *sessions.Options {
+	if sameSite == 0 {
+		sameSite = http.SameSiteLaxMode
+	}
+	return &sessions.Options{
+		HttpOnly: true,
+		Secure:   isSecure || sm.forceHTTPS,
+		SameSite: sameSite,
+		MaxAge:   maxAge,
+		Path:     sm.cookiePath,
+	}
+}
+
+// GetSession retrieves all session data for the current request.
+// It loads the main session and token sessions, including any chunked token data,
+// and combines them into a single SessionData structure for easy access.
+// It is equivalent to calling GetSessionContext with the request's own context.
+// Returns an error if any session component cannot be loaded, or ErrSessionExpired along with
+// the session if it has reached the absolute session timeout.
+func (sm *SessionManager) GetSession(r *http.Request) (*SessionData, error) {
+	return sm.GetSessionContext(r.Context(), r)
+}
+
+// GetSessionContext retrieves all session data for the current request, honoring
+// cancellation and deadlines of the provided context. The context is checked before
+// each store lookup so that an abandoned request (client disconnect, expired deadline)
+// stops loading session components instead of continuing to perform store I/O.
+//
+// Parameters:
+//   - ctx: The context controlling cancellation of the session load.
+//   - r: The incoming HTTP request containing the session cookies.
+//
+// Returns:
+//   - The loaded SessionData.
+//   - ErrSessionExpired, together with the loaded session, if the absolute session timeout
+//     or the idle timeout has passed; the caller should clear the session and start a new login.
+//   - An error if the context is done or any session component cannot be loaded.
+func (sm *SessionManager) GetSessionContext(ctx context.Context, r *http.Request) (*SessionData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("session load aborted: %w", err)
+	}
+
+	sm.refreshKeys()
+
+	// Get session from pool.
+	sessionData := sm.sessionPool.Get().(*SessionData)
+	sessionData.request = r
+	sessionData.mainDirty, sessionData.accessDirty, sessionData.refreshDirty, sessionData.providerDirty = false, false, false, false
+	sessionData.packedLoaded = false
+	sessionData.subjectChanged = false
+
+	var err error
+	sessionData.mainSession, err = sm.getSessionOrReset(r, mainCookieName)
+	if err != nil {
+		sm.sessionPool.Put(sessionData)
+		return nil, fmt.Errorf("failed to get main session: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		sm.sessionPool.Put(sessionData)
+		return nil, fmt.Errorf("session load aborted: %w", err)
+	}
+
+	sessionData.accessSession, err = sm.getSessionOrReset(r, accessTokenCookie)
+	if err != nil {
+		sm.sessionPool.Put(sessionData)
+		return nil, fmt.Errorf("failed to get access token session: %w", err)
+	}
+
+	sessionData.refreshSession, err = sm.getSessionOrReset(r, refreshTokenCookie)
+	if err != nil {
+		sm.sessionPool.Put(sessionData)
+		return nil, fmt.Errorf("failed to get refresh token session: %w", err)
+	}
+
+	sessionData.providerSession, err = sm.getSessionOrReset(r, providerCookie)
+	if err != nil {
+		sm.sessionPool.Put(sessionData)
+		return nil, fmt.Errorf("failed to get provider access token session: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		sm.sessionPool.Put(sessionData)
+		return nil, fmt.Errorf("session load aborted: %w", err)
+	}
+
+	// Clear and reuse chunk maps.
+	for k := range sessionData.accessTokenChunks {
+		delete(sessionData.accessTokenChunks, k)
+	}
+	for k := range sessionData.refreshTokenChunks {
+		delete(sessionData.refreshTokenChunks, k)
+	}
+	for k := range sessionData.providerTokenChunks {
+		delete(sessionData.providerTokenChunks, k)
+	}
+	for k := range sessionData.claimsChunks {
+		delete(sessionData.claimsChunks, k)
+	}
+	for k := range sessionData.expiredChunks {
+		delete(sessionData.expiredChunks, k)
+	}
+
+	// Retrieve chunked token sessions.
+	sm.getTokenChunkSessions(r, accessTokenCookie, chunkCount(sessionData.accessSession), sessionData.accessTokenChunks)
+	sm.getTokenChunkSessions(r, refreshTokenCookie, chunkCount(sessionData.refreshSession), sessionData.refreshTokenChunks)
+	sm.getTokenChunkSessions(r, providerCookie, chunkCount(sessionData.providerSession), sessionData.providerTokenChunks)
+
+	// A session written with the single-cookie layout replaces the split cookies.
+	sessionData.loadPackedSession(r)
+
+	// Claims chunks are only written by sessions that recorded their count.
+	if count := claimsChunkCount(sessionData.mainSession); count > 0 {
+		sm.getTokenChunkSessions(r, claimsCookie, count, sessionData.claimsChunks)
+	}
+
+	// Check for absolute session timeout. The whole session is still returned so that the
+	// caller can expire every cookie, including token chunks.
+	expired := false
+	if createdAt, ok := sessionInt(sessionData.mainSession.Values["created_at"]); ok {
+		expired = time.Since(time.Unix(createdAt, 0)) > sessionData.sessionTimeout()
+	}
+	if idleExpiresAt := sessionData.IdleExpiresAt(); !idleExpiresAt.IsZero() && time.Now().After(idleExpiresAt) {
+		expired = true
+	}
+
+	if sm.loginTimeout > 0 {
+		if issuedAt := sessionData.GetCSRFIssuedAt(); !issuedAt.IsZero() && time.Since(issuedAt) > sm.loginTimeout {
+			sm.logger.Debugf("Discarding login started at %v that exceeded the %v login timeout", issuedAt, sm.loginTimeout)
+			sessionData.clearPendingAuth()
+		}
+	}
+
+	if expired {
+		return sessionData, ErrSessionExpired
+	}
+	return sessionData, nil
+}
+
+// getSessionOrReset loads the named session from the store. If the cookie exists but cannot
+// be decoded (it is corrupt, or was signed or encrypted with a key that is no longer
+// configured), the failure is logged and the fresh, empty session returned by the store is
+// used instead, so the user is treated as having no session and simply re-authenticates;
+// the undecodable cookie is overwritten the next time the session is modified and saved.
+// Other store errors are returned unchanged.
+//
+// Parameters:
+//   - r: The incoming HTTP request containing the session cookies.
+//   - name: The session cookie name.
+//
+// Returns:
+//   - The loaded session, or a fresh session if the cookie could not be decoded.
+//   - An error for failures other than decoding.
+func (sm *SessionManager) getSessionOrReset(r *http.Request, name string) (*sessions.Session, error) {
+	session, err := sm.getStore().Get(r, name)
+	if err != nil && session != nil && isDecodeError(err) {
+		sm.logger.Infof("Discarding undecodable session cookie %s: %v", name, err)
+		return session, nil
+	}
+	return session, err
+}
+
+// isDecodeError reports whether err is a securecookie failure to decode or authenticate a
+// cookie value, as opposed to an internal or usage error.
+//
+// Parameters:
+//   - err: The error returned by the session store.
+//
+// Returns:
+//   - true if the error is a decode error.
+func isDecodeError(err error) bool {
+	var cookieErr securecookie.Error
+	return errors.As(err, &cookieErr) && cookieErr.IsDecode()
+}
+
+// getTokenChunkSessions retrieves all cookie chunks associated with a large token (access or refresh).
+// When the primary session recorded how many chunks were written, exactly that many cookies
+// named "{baseName}_0" … "{baseName}_{count-1}" are loaded and any missing one is logged; reassembly
+// then rejects the incomplete token. For sessions written before the chunk count was recorded
+// (count < 0), it iteratively loads cookies until one is not found or returns an error, probing the
+// following index as well so that a gap can still be detected during reassembly.
+// The loaded sessions are stored in the provided chunks map.
+//
+// Parameters:
+//   - r: The incoming HTTP request containing the cookies.
+//   - baseName: The base name of the cookie (e.g., accessTokenCookie).
+//   - count: The number of chunks recorded in the primary session, or -1 if unknown.
+//   - chunks: The map (typically SessionData.accessTokenChunks or SessionData.refreshTokenChunks) to populate with the found session chunks.
+func (sm *SessionManager) getTokenChunkSessions(r *http.Request, baseName string, count int, chunks map[int]*sessions.Session) {
+	if count >= 0 {
+		for i := 0; i < count; i++ {
+			sessionName := fmt.Sprintf("%s_%d", baseName, i)
+			session, err := sm.getStore().Get(r, sessionName)
+			if err != nil || session.IsNew {
+				sm.logger.Infof("Incomplete token chunks: %s is missing (expected %d chunks)", sessionName, count)
+				continue
+			}
+			chunks[i] = session
+		}
+		return
+	}
+
+	for i := 0; ; i++ {
+		sessionName := fmt.Sprintf("%s_%d", baseName, i)
+		session, err := sm.getStore().Get(r, sessionName)
+		if err != nil || session.IsNew {
+			// Probe the next index so that a dropped intermediate chunk is detected
+			// during reassembly instead of silently truncating the token.
+			nextName := fmt.Sprintf("%s_%d", baseName, i+1)
+			if next, nextErr := sm.getStore().Get(r, nextName); nextErr == nil && !next.IsNew {
+				sm.logger.Infof("Incomplete token chunks: %s is missing but %s is present", sessionName, nextName)
+				chunks[i+1] = next
+			}
+			break
+		}
+		chunks[i] = session
+	}
+}
+
+// SessionData holds all session information for an authenticated user.
+// It manages multiple session cookies to handle the main session state
+// and potentially large access and refresh tokens that may need to be
+// split across multiple cookies due to browser size limitations.
+type SessionData struct {
+	// manager is the SessionManager that created this SessionData.
+	manager *SessionManager
+
+	// request is the current HTTP request associated with this session.
+	request *http.Request
+
+	// mainSession stores authentication state and basic user info.
+	mainSession *sessions.Session
+
+	// accessSession stores the primary access token cookie.
+	accessSession *sessions.Session
+
+	// refreshSession stores the primary refresh token cookie.
+	refreshSession *sessions.Session
+
+	// providerSession stores the primary cookie of the provider's OAuth access token, kept
+	// apart from the ID token held by accessSession.
+	providerSession *sessions.Session
+
+	// accessTokenChunks stores additional chunks of the access token
+	// when it exceeds the maximum cookie size.
+	accessTokenChunks map[int]*sessions.Session
+
+	// refreshTokenChunks stores additional chunks of the refresh token
+	// when it exceeds the maximum cookie size.
+	refreshTokenChunks map[int]*sessions.Session
+
+	// providerTokenChunks stores additional chunks of the provider access token
+	// when it exceeds the maximum cookie size.
+	providerTokenChunks map[int]*sessions.Session
+
+	// claimsChunks stores the chunks of the UserInfo claims blob when it exceeds the
+	// maximum cookie size. They are saved along with the main session.
+	claimsChunks map[int]*sessions.Session
+
+	// expiredChunks holds chunk sessions of a previously stored token, keyed by
+	// cookie name, that must be expired on the next Save unless reused by the new token.
+	expiredChunks map[string]*sessions.Session
+
+	// mainDirty, accessDirty, refreshDirty and providerDirty record which sessions were modified
+	// since they were loaded. Save writes only modified sessions; token chunks follow their token
+	// session.
+	mainDirty     bool
+	accessDirty   bool
+	refreshDirty  bool
+	providerDirty bool
+
+	// packedLoaded records that the client holds this session in the single cookie of the
+	// single-cookie layout rather than in split cookies.
+	packedLoaded bool
+
+	// subjectChanged records that a token refresh during this request returned a token for a
+	// different subject than the session was established for.
+	subjectChanged bool
+
+	// refreshMutex protects refresh token operations within this session instance.
+	refreshMutex sync.Mutex
+}
+
+// Save persists the parts of the session (main, access token, refresh token, and any chunks)
+// modified since the session was loaded back to the client as cookies in the HTTP response.
+// Unchanged sessions are not re-sent, so a request that modified nothing emits no Set-Cookie
+// headers. It applies secure cookie options obtained via getSessionOptions based on the
+// request's security context.
+//
+// Parameters:
+//   - r: The original HTTP request (used to determine security context for cookie options).
+//   - w: The HTTP response writer to which the Set-Cookie headers will be added.
+//
+// Returns:
+//   - An error if saving any of the session components fails.
+func (sd *SessionData) Save(r *http.Request, w http.ResponseWriter) error {
+	if !sd.mainDirty && !sd.accessDirty && !sd.refreshDirty && !sd.providerDirty && len(sd.expiredChunks) == 0 {
+		return nil
+	}
+
+	isSecure := strings.HasPrefix(r.URL.Scheme, "https") || sd.manager.forceHTTPS
+
+	// Set options for all sessions; token cookies may use a stricter SameSite mode.
+	maxAge := sd.cookieMaxAge()
+	mainOptions := sd.manager.getSessionOptions(isSecure, sd.manager.mainSameSite, maxAge)
+	options := sd.manager.getSessionOptions(isSecure, sd.manager.tokenSameSite, maxAge)
+
+	// Check the combined cookie size before writing anything.
+	if err := sd.checkCookieBudget(); err != nil {
+		return err
+	}
+
+	if sd.manager.singleCookie {
+		encoded, ok, err := sd.encodePackedCookie()
+		if err != nil {
+			return err
+		}
+		if ok {
+			// The single cookie carries the tokens too, so it follows the main cookie's SameSite mode.
+			if err := sd.savePacked(r, w, encoded, mainOptions); err != nil {
+				return err
+			}
+			if sd.manager.cookieStatsHook != nil {
+				sd.manager.cookieStatsHook(sd.cookieStats())
+			}
+			return nil
+		}
+	}
+	if sd.packedLoaded {
+		// Moving from the single cookie to split cookies: every part has to be written.
+		sd.mainDirty, sd.accessDirty, sd.refreshDirty = true, true, true
+		sd.providerDirty = sd.providerDirty || len(sd.providerSession.Values) > 0
+		sd.expirePackedCookie(w, mainOptions)
+	}
+
+	// Save main session and claims chunks.
+	if sd.mainDirty {
+		sd.mainSession.Options = mainOptions
+		if err := sd.mainSession.Save(r, w); err != nil {
+			return fmt.Errorf("failed to save main session: %w", err)
+		}
+		if err := sd.saveClaimsChunks(r, w, mainOptions); err != nil {
+			return err
+		}
+		sd.mainDirty = false
+	}
+
+	// Save access token session and chunks.
+	if sd.accessDirty {
+		sd.accessSession.Options = options
+		if err := sd.accessSession.Save(r, w); err != nil {
+			return fmt.Errorf("failed to save access token session: %w", err)
+		}
+		for _, session := range sd.accessTokenChunks {
+			session.Options = options
+			if err := session.Save(r, w); err != nil {
+				return fmt.Errorf("failed to save access token chunk session: %w", err)
+			}
+		}
+		sd.accessDirty = false
+	}
+
+	// Save refresh token session and chunks.
+	if sd.refreshDirty {
+		sd.refreshSession.Options = options
+		if err := sd.refreshSession.Save(r, w); err != nil {
+			return fmt.Errorf("failed to save refresh token session: %w", err)
+		}
+		for _, session := range sd.refreshTokenChunks {
+			session.Options = options
+			if err := session.Save(r, w); err != nil {
+				return fmt.Errorf("failed to save refresh token chunk session: %w", err)
+			}
+		}
+		sd.refreshDirty = false
+	}
+
+	// Save provider access token session and chunks.
+	if sd.providerDirty {
+		sd.providerSession.Options = options
+		if err := sd.providerSession.Save(r, w); err != nil {
+			return fmt.Errorf("failed to save provider access token session: %w", err)
+		}
+		for _, session := range sd.providerTokenChunks {
+			session.Options = options
+			if err := session.Save(r, w); err != nil {
+				return fmt.Errorf("failed to save provider access token chunk session: %w", err)
+			}
+		}
+		sd.providerDirty = false
+	}
+
+	// Expire chunk cookies of previous tokens that the current tokens no longer use.
+	if err := sd.saveExpiredChunks(r, w, options); err != nil {
+		return err
+	}
+
+	if sd.manager.cookieStatsHook != nil {
+		sd.manager.cookieStatsHook(sd.cookieStats())
+	}
+
+	return nil
+}
+
+// SaveMain persists only the main session cookie, leaving the token cookies the client
+// already holds untouched. Use it after changes confined to the main session (CSRF state,
+// incoming path, refresh backoff) to avoid re-writing large chunked token cookies. Changes
+// to the access or refresh token are not persisted; use Save for those. With the
+// single-cookie layout the main session shares its cookie with the tokens, so the whole
+// session is saved.
+//
+// Parameters:
+//   - r: The original HTTP request (used to determine security context for cookie options).
+//   - w: The HTTP response writer to which the Set-Cookie header will be added.
+//
+// Returns:
+//   - An error if saving the main session fails.
+func (sd *SessionData) SaveMain(r *http.Request, w http.ResponseWriter) error {
+	if sd.manager.singleCookie || sd.packedLoaded {
+		// The main session shares its cookie with the tokens, or has to move out of it.
+		sd.mainDirty = true
+		return sd.Save(r, w)
+	}
+
+	isSecure := strings.HasPrefix(r.URL.Scheme, "https") || sd.manager.forceHTTPS
+	options := sd.manager.getSessionOptions(isSecure, sd.manager.mainSameSite, sd.cookieMaxAge())
+	sd.mainSession.Options = options
+
+	if err := sd.mainSession.Save(r, w); err != nil {
+		return fmt.Errorf("failed to save main session: %w", err)
+	}
+	if err := sd.saveClaimsChunks(r, w, options); err != nil {
+		return err
+	}
+	sd.mainDirty = false
+	return sd.saveExpiredClaimsChunks(r, w, options)
+}
+
+// saveClaimsChunks writes the chunk cookies of the UserInfo claims blob.
+//
+// Parameters:
+//   - r: The HTTP request (required by the underlying session store).
+//   - w: The HTTP response writer to which the Set-Cookie headers will be added.
+//   - options: The cookie options of the main session.
+//
+// Returns:
+//   - An error if saving a chunk session fails.
+func (sd *SessionData) saveClaimsChunks(r *http.Request, w http.ResponseWriter, options *sessions.Options) error {
+	for _, session := range sd.claimsChunks {
+		session.Options = options
+		if err := session.Save(r, w); err != nil {
+			return fmt.Errorf("failed to save claims chunk session: %w", err)
+		}
+	}
+	return nil
+}
+
+// saveExpiredClaimsChunks expires the claims chunk cookies the current claims no longer use,
+// leaving expired token chunks for the next Save.
+//
+// Parameters:
+//   - r: The HTTP request (required by the underlying session store).
+//   - w: The HTTP response writer to which the expiring Set-Cookie headers will be added.
+//   - options: The cookie options of the main session.
+//
+// Returns:
+//   - An error if saving an expired chunk session fails.
+func (sd *SessionData) saveExpiredClaimsChunks(r *http.Request, w http.ResponseWriter, options *sessions.Options) error {
+	inUse := make(map[string]bool, len(sd.claimsChunks))
+	for _, session := range sd.claimsChunks {
+		inUse[session.Name()] = true
+	}
+
+	expiredOptions := *options
+	expiredOptions.MaxAge = -1
+	for name, session := range sd.expiredChunks {
+		if !strings.HasPrefix(name, claimsCookie+"_") {
+			continue
+		}
+		if !inUse[name] {
+			session.Options = &expiredOptions
+			session.Values = make(map[interface{}]interface{})
+			if err := session.Save(r, w); err != nil {
+				return fmt.Errorf("failed to save expired claims chunk session: %w", err)
+			}
+		}
+		delete(sd.expiredChunks, name)
+	}
+	return nil
+}
+
+// setMainValue stores a value in the main session and marks it for the next Save.
+//
+// Parameters:
+//   - key: The session value key.
+//   - value: The value to store.
+func (sd *SessionData) setMainValue(key string, value interface{}) {
+	sd.mainSession.Values[key] = value
+	sd.mainDirty = true
+}
+
+// deleteMainValue removes a value from the main session, marking it for the next Save only
+// if the value was present.
+//
+// Parameters:
+//   - key: The session value key.
+func (sd *SessionData) deleteMainValue(key string) {
+	if _, ok := sd.mainSession.Values[key]; !ok {
+		return
+	}
+	delete(sd.mainSession.Values, key)
+	sd.mainDirty = true
+}
+
+// CookieStats summarizes the session cookies written by one Save.
+type CookieStats struct {
+	// AccessChunks is the number of chunk cookies holding the access token, 0 when it fits
+	// in a single cookie or is stored server-side.
+	AccessChunks int
+
+	// RefreshChunks is the number of chunk cookies holding the refresh token.
+	RefreshChunks int
+
+	// TotalBytes is the approximate combined size of all session cookies held by the client
+	// after the Save, including unchanged ones that were not re-sent, 0 when the session
+	// store is not cookie based.
+	TotalBytes int
+}
+
+// cookieStats computes the chunk counts and total size of the session cookies as written.
+func (sd *SessionData) cookieStats() CookieStats {
+	stats := CookieStats{
+		AccessChunks:  len(sd.accessTokenChunks),
+		RefreshChunks: len(sd.refreshTokenChunks),
+	}
+	infos, err := sd.cookieInfos()
+	if err != nil {
+		sd.manager.logger.Errorf("Failed to compute session cookie sizes: %v", err)
+	}
+	for _, info := range infos {
+		if !info.Expired {
+			stats.TotalBytes += info.Size
+		}
+	}
+	return stats
+}
+
+// CookieInfo describes one session cookie as Save would write it.
+type CookieInfo struct {
+	// Name is the cookie name.
+	Name string
+
+	// Size is the approximate size in bytes of the encoded "name=value" pair.
+	Size int
+
+	// ChunkIndex is the index of a token chunk cookie, or -1 for the main, access and
+	// refresh cookies.
+	ChunkIndex int
+
+	// Expired reports whether the cookie is written as an expiring (deleted) cookie.
+	Expired bool
+}
+
+// DebugCookies lists every session cookie Save would write (main, access, refresh and all
+// token chunks), with sizes computed by encoding them exactly as Save does, but without
+// writing anything to a response. It is intended for debug endpoints and tests that need to
+// report, for example, how many chunks an access token occupies.
+//
+// Returns:
+//   - The cookies in write order, or nil if the session store is not cookie based or a
+//     cookie cannot be encoded.
+func (sd *SessionData) DebugCookies() []CookieInfo {
+	infos, err := sd.cookieInfos()
+	if err != nil {
+		sd.manager.logger.Errorf("Failed to compute session cookie sizes: %v", err)
+		return nil
+	}
+	return infos
+}
+
+// cookieInfos encodes every session cookie that Save is about to write to determine its size.
+//
+// Returns:
+//   - The cookies in write order, or nil if the session store is not cookie based.
+//   - An error if a cookie cannot be encoded.
+func (sd *SessionData) cookieInfos() ([]CookieInfo, error) {
+	store, ok := sd.manager.getStore().(*sessions.CookieStore)
+	if !ok {
+		return nil, nil
+	}
+
+	if sd.manager.singleCookie {
+		encoded, ok, err := sd.encodePackedCookie()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return []CookieInfo{{Name: packedCookieName, Size: len(packedCookieName) + 1 + len(encoded), ChunkIndex: -1}}, nil
+		}
+	}
+
+	type pendingCookie struct {
+		session *sessions.Session
+		index   int
+	}
+	pending := []pendingCookie{{sd.mainSession, -1}, {sd.accessSession, -1}, {sd.refreshSession, -1}}
+	if !sd.providerSession.IsNew || sd.providerDirty {
+		pending = append(pending, pendingCookie{sd.providerSession, -1})
+	}
+	for i := 0; i < len(sd.accessTokenChunks); i++ {
+		if session, ok := sd.accessTokenChunks[i]; ok {
+			pending = append(pending, pendingCookie{session, i})
+		}
+	}
+	for i := 0; i < len(sd.refreshTokenChunks); i++ {
+		if session, ok := sd.refreshTokenChunks[i]; ok {
+			pending = append(pending, pendingCookie{session, i})
+		}
+	}
+	for i := 0; i < len(sd.providerTokenChunks); i++ {
+		if session, ok := sd.providerTokenChunks[i]; ok {
+			pending = append(pending, pendingCookie{session, i})
+		}
+	}
+	for i := 0; i < len(sd.claimsChunks); i++ {
+		if session, ok := sd.claimsChunks[i]; ok {
+			pending = append(pending, pendingCookie{session, i})
+		}
+	}
+
+	infos := make([]CookieInfo, 0, len(pending))
+	for _, p := range pending {
+		info := CookieInfo{Name: p.session.Name(), ChunkIndex: p.index}
+		if p.session.Options != nil && p.session.Options.MaxAge < 0 {
+			info.Expired = true
+			info.Size = len(info.Name) + 1
+		} else {
+			encoded, err := securecookie.EncodeMulti(p.session.Name(), p.session.Values, store.Codecs...)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode session %s for size check: %w", p.session.Name(), err)
+			}
+			info.Size = len(info.Name) + 1 + len(encoded)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// checkCookieBudget sums the serialized size of every session cookie that Save is about to
+// write (main, access, refresh and all chunks) and compares it with the configured budget.
+// When the budget is exceeded a warning with a per-cookie breakdown is logged, and if the
+// budget is enforced an error wrapping ErrCookieBudgetExceeded is returned so that no
+// partially truncated cookie set reaches the browser.
+//
+// Returns:
+//   - An error if the budget is enforced and exceeded, or if a cookie cannot be encoded.
+func (sd *SessionData) checkCookieBudget() error {
+	if sd.manager.cookieBudget <= 0 {
+		return nil
+	}
+	infos, err := sd.cookieInfos()
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	breakdown := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Expired {
+			continue
+		}
+		total += info.Size
+		breakdown = append(breakdown, fmt.Sprintf("%s=%d", info.Name, info.Size))
+	}
+
+	if total <= sd.manager.cookieBudget {
+		return nil
+	}
+
+	sd.manager.logger.Infof("Session cookies total %d bytes, exceeding budget of %d bytes: %s",
+		total, sd.manager.cookieBudget, strings.Join(breakdown, ", "))
+	if sd.manager.enforceCookieBudget {
+		return fmt.Errorf("%d bytes over budget of %d: %w", total-sd.manager.cookieBudget, sd.manager.cookieBudget, ErrCookieBudgetExceeded)
+	}
+	return nil
+}
+
+// saveExpiredChunks emits expiring Set-Cookie headers for chunk cookies left over from a
+// previously stored token. Chunk indices reused by the current access or refresh token are
+// skipped, so that a token which now needs fewer chunks than before does not leave stale
+// higher-numbered cookies behind in the browser.
+//
+// Parameters:
+//   - r: The HTTP request (required by the underlying session store).
+//   - w: The HTTP response writer to which the expiring Set-Cookie headers will be added.
+//   - options: The cookie options applied to the other session cookies.
+//
+// Returns:
+//   - An error if saving any expired chunk session fails.
+func (sd *SessionData) saveExpiredChunks(r *http.Request, w http.ResponseWriter, options *sessions.Options) error {
+	if len(sd.expiredChunks) == 0 {
+		return nil
+	}
+
+	inUse := make(map[string]bool, len(sd.accessTokenChunks)+len(sd.refreshTokenChunks)+len(sd.providerTokenChunks)+len(sd.claimsChunks))
+	for _, session := range sd.accessTokenChunks {
+		inUse[session.Name()] = true
+	}
+	for _, session := range sd.refreshTokenChunks {
+		inUse[session.Name()] = true
+	}
+	for _, session := range sd.providerTokenChunks {
+		inUse[session.Name()] = true
+	}
+	for _, session := range sd.claimsChunks {
+		inUse[session.Name()] = true
+	}
+
+	expiredOptions := *options
+	expiredOptions.MaxAge = -1
+	for name, session := range sd.expiredChunks {
+		if inUse[name] {
+			delete(sd.expiredChunks, name)
+			continue
+		}
+		session.Options = &expiredOptions
+		session.Values = make(map[interface{}]interface{})
+		if err := session.Save(r, w); err != nil {
+			return fmt.Errorf("failed to save expired token chunk session: %w", err)
+		}
+		delete(sd.expiredChunks, name)
+	}
+
+	return nil
+}
+
+// Clear removes all session data associated with this SessionData instance.
+// It clears the values map of the main, access, refresh and provider access token sessions, sets their MaxAge to -1
+// to expire the cookies immediately, and expires every token and claims chunk cookie the
+// request carries, whether or not GetSession loaded it, so that logout removes all cookies
+// even on a SessionData that was never loaded from the request.
+// If a ResponseWriter is provided, it attempts to save the expired sessions to send the
+// expiring Set-Cookie headers. Finally, it clears internal fields and returns the SessionData
+// object to the pool.
+//
+// Parameters:
+//   - r: The HTTP request (required by the underlying session store).
+//   - w: The HTTP response writer (optional). If provided, expiring Set-Cookie headers will be sent.
+//
+// Returns:
+//   - An error if saving the expired sessions fails (only if w is not nil).
+func (sd *SessionData) Clear(r *http.Request, w http.ResponseWriter) error {
+	if err := sd.loadPrimarySessions(r); err != nil {
+		return err
+	}
+	tokenSID, _ := sd.mainSession.Values["token_sid"].(string)
+
+	// Clear and expire all sessions.
+	sd.mainDirty, sd.accessDirty, sd.refreshDirty = true, true, true
+	sd.mainSession.Options.MaxAge = -1
+	sd.accessSession.Options.MaxAge = -1
+	sd.refreshSession.Options.MaxAge = -1
+	if !sd.providerSession.IsNew {
+		// Only expire a provider access token cookie the client actually holds.
+		sd.providerDirty = true
+		sd.providerSession.Options.MaxAge = -1
+	}
+
+	for k := range sd.mainSession.Values {
+		delete(sd.mainSession.Values, k)
+	}
+	for k := range sd.accessSession.Values {
+		delete(sd.accessSession.Values, k)
+	}
+	for k := range sd.refreshSession.Values {
+		delete(sd.refreshSession.Values, k)
+	}
+	for k := range sd.providerSession.Values {
+		delete(sd.providerSession.Values, k)
+	}
+
+	// Expire chunk sessions.
+	sd.clearTokenChunks(r, accessTokenCookie, sd.accessTokenChunks)
+	sd.clearTokenChunks(r, refreshTokenCookie, sd.refreshTokenChunks)
+	sd.clearTokenChunks(r, providerCookie, sd.providerTokenChunks)
+	sd.clearTokenChunks(r, claimsCookie, sd.claimsChunks)
+
+	// Drop server-side tokens; the ID is read before the main session values are cleared.
+	if tokenSID != "" && sd.manager.tokenStore != nil {
+		sd.manager.tokenStore.Delete(storedTokenKey(tokenSID, "access"))
+		sd.manager.tokenStore.Delete(storedTokenKey(tokenSID, "refresh"))
+		sd.manager.tokenStore.Delete(storedTokenKey(tokenSID, "provider"))
+	}
 
 	var err error
 	if w != nil {
@@ -383,19 +1609,63 @@ func (sd *SessionData) Clear(r *http.Request, w http.ResponseWriter) error {
 	return err
 }
 
-// clearTokenChunks iterates through a map of session chunks, clears their values,
-// and sets their MaxAge to -1 to expire them. This is used internally by Clear.
+// clearTokenChunks drops the loaded chunk sessions and records every chunk cookie of the given
+// base name that the request carries for expiry by the next Save. This is used internally by Clear.
 //
 // Parameters:
-//   - r: The HTTP request (required by the underlying session store, though not directly used here).
-//   - chunks: The map of session chunks (e.g., sd.accessTokenChunks) to clear and expire.
-func (sd *SessionData) clearTokenChunks(r *http.Request, chunks map[int]*sessions.Session) {
-	for _, session := range chunks {
-		session.Options.MaxAge = -1
-		for k := range session.Values {
-			delete(session.Values, k)
+//   - r: The HTTP request carrying the chunk cookies.
+//   - baseName: The base name of the chunk cookies (e.g., accessTokenCookie).
+//   - chunks: The map of loaded chunk sessions (e.g., sd.accessTokenChunks) to empty.
+func (sd *SessionData) clearTokenChunks(r *http.Request, baseName string, chunks map[int]*sessions.Session) {
+	for k, session := range chunks {
+		for key := range session.Values {
+			delete(session.Values, key)
+		}
+		delete(chunks, k)
+	}
+	sd.expireTokenChunks(r, nil, baseName)
+}
+
+// loadPrimarySessions loads the main and token sessions from the request when this
+// SessionData has not been loaded by GetSession, e.g. when it was taken fresh from the pool,
+// discarding whatever a previous request left in it.
+//
+// Parameters:
+//   - r: The HTTP request carrying the session cookies.
+//
+// Returns:
+//   - An error if a session cannot be loaded.
+func (sd *SessionData) loadPrimarySessions(r *http.Request) error {
+	if sd.request != nil {
+		return nil
+	}
+	sd.request = r
+	for _, chunks := range []map[int]*sessions.Session{sd.accessTokenChunks, sd.refreshTokenChunks, sd.providerTokenChunks, sd.claimsChunks} {
+		for k := range chunks {
+			delete(chunks, k)
 		}
 	}
+	for k := range sd.expiredChunks {
+		delete(sd.expiredChunks, k)
+	}
+	var err error
+	if sd.mainSession, err = sd.manager.getSessionOrReset(r, mainCookieName); err != nil {
+		return fmt.Errorf("failed to get main session: %w", err)
+	}
+	if sd.accessSession, err = sd.manager.getSessionOrReset(r, accessTokenCookie); err != nil {
+		return fmt.Errorf("failed to get access token session: %w", err)
+	}
+	if sd.refreshSession, err = sd.manager.getSessionOrReset(r, refreshTokenCookie); err != nil {
+		return fmt.Errorf("failed to get refresh token session: %w", err)
+	}
+	if sd.providerSession, err = sd.manager.getSessionOrReset(r, providerCookie); err != nil {
+		return fmt.Errorf("failed to get provider access token session: %w", err)
+	}
+	if _, err := r.Cookie(packedCookieName); err == nil {
+		// Let Save expire the single-cookie session as well.
+		sd.packedLoaded = true
+	}
+	return nil
 }
 
 // GetAuthenticated checks if the session is marked as authenticated and has not exceeded
@@ -411,11 +1681,11 @@ func (sd *SessionData) GetAuthenticated() bool {
 	}
 
 	// Check session expiration.
-	createdAt, ok := sd.mainSession.Values["created_at"].(int64)
+	createdAt, ok := sessionInt(sd.mainSession.Values["created_at"])
 	if !ok {
 		return false
 	}
-	return time.Since(time.Unix(createdAt, 0)) <= absoluteSessionTimeout
+	return time.Since(time.Unix(createdAt, 0)) <= sd.sessionTimeout()
 }
 
 // SetAuthenticated sets the authentication status of the session.
@@ -429,14 +1699,109 @@ func (sd *SessionData) GetAuthenticated() bool {
 //   - An error if generating a new session ID fails when setting value to true.
 func (sd *SessionData) SetAuthenticated(value bool) error {
 	if value {
-		id, err := generateSecureRandomString(32)
-		if err != nil {
-			return fmt.Errorf("failed to generate secure session id: %w", err)
+		if err := sd.RegenerateID(); err != nil {
+			return err
+		}
+		sd.setMainValue("created_at", time.Now().Unix())
+		if sd.manager.idleTimeout > 0 {
+			sd.setMainValue("last_activity", time.Now().Unix())
 		}
-		sd.mainSession.ID = id
-		sd.mainSession.Values["created_at"] = time.Now().Unix()
+		if sd.manager.rememberMeTimeout > 0 && sd.GetRememberMe() {
+			sd.setMainValue("session_timeout", int64(sd.manager.rememberMeTimeout.Seconds()))
+		} else if timeout := sd.manager.jitteredSessionTimeout(); timeout != absoluteSessionTimeout {
+			sd.setMainValue("session_timeout", int64(timeout.Seconds()))
+		} else {
+			sd.deleteMainValue("session_timeout")
+		}
+	}
+	sd.setMainValue("authenticated", value)
+	return nil
+}
+
+// CreatedAt returns the time the session was authenticated, as recorded by SetAuthenticated.
+//
+// Returns:
+//   - The session creation time, or the zero time if the session has never been authenticated.
+func (sd *SessionData) CreatedAt() time.Time {
+	createdAt, ok := sessionInt(sd.mainSession.Values["created_at"])
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(createdAt, 0)
+}
+
+// ExpiresAt returns the time at which the session reaches its absolute timeout and the user
+// must log in again, regardless of activity or token refreshes. Applications can use it to
+// show the remaining session lifetime or prompt for re-login before the cutoff.
+//
+// Returns:
+//   - The session expiry time, or the zero time if the session has never been authenticated.
+func (sd *SessionData) ExpiresAt() time.Time {
+	createdAt := sd.CreatedAt()
+	if createdAt.IsZero() {
+		return createdAt
+	}
+	return createdAt.Add(sd.sessionTimeout())
+}
+
+// IdleExpiresAt returns the time at which the session expires for lack of activity, so that
+// clients can warn the user before it happens. Every request that records activity with
+// MarkActive pushes it back.
+//
+// Returns:
+//   - The idle expiry time, or the zero time if there is no idle timeout or the session has
+//     recorded no activity.
+func (sd *SessionData) IdleExpiresAt() time.Time {
+	if sd.manager.idleTimeout <= 0 {
+		return time.Time{}
+	}
+	lastActivity, ok := sessionInt(sd.mainSession.Values["last_activity"])
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(lastActivity, 0).Add(sd.manager.idleTimeout)
+}
+
+// MarkActive records that the session was used now, restarting its idle timeout. To avoid
+// rewriting the main cookie on every request, activity is only recorded once it is older
+// than activityWriteInterval.
+//
+// Returns:
+//   - true if the main session changed and must be saved.
+func (sd *SessionData) MarkActive() bool {
+	if sd.manager.idleTimeout <= 0 {
+		return false
+	}
+	if lastActivity, ok := sessionInt(sd.mainSession.Values["last_activity"]); ok && time.Since(time.Unix(lastActivity, 0)) < activityWriteInterval {
+		return false
+	}
+	sd.setMainValue("last_activity", time.Now().Unix())
+	return true
+}
+
+// sessionTimeout returns the absolute timeout of this session: the jittered timeout
+// recorded when it was authenticated, or the default absolute session timeout.
+func (sd *SessionData) sessionTimeout() time.Duration {
+	if seconds, ok := sessionInt(sd.mainSession.Values["session_timeout"]); ok && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return absoluteSessionTimeout
+}
+
+// RegenerateID issues a fresh secure identifier for the main session while preserving
+// all stored values (authentication state, email, tokens). Callers should invoke it
+// whenever the security context of the session escalates (e.g. after a step-up
+// authentication or a role change) to prevent session fixation without forcing a
+// full re-login.
+//
+// Returns:
+//   - An error if generating a new session ID fails.
+func (sd *SessionData) RegenerateID() error {
+	id, err := generateSecureRandomString(sd.manager.random, 32)
+	if err != nil {
+		return fmt.Errorf("failed to generate secure session id: %w", err)
 	}
-	sd.mainSession.Values["authenticated"] = value
+	sd.mainSession.ID = id
 	return nil
 }
 
@@ -447,73 +1812,181 @@ func (sd *SessionData) SetAuthenticated(value bool) error {
 // Returns:
 //   - The complete, decompressed access token string, or an empty string if not found.
 func (sd *SessionData) GetAccessToken() string {
-	token, _ := sd.accessSession.Values["token"].(string)
-	if token != "" {
-		compressed, _ := sd.accessSession.Values["compressed"].(bool)
-		if compressed {
-			return decompressToken(token)
+	if sd.manager.tokenStore != nil {
+		return sd.getStoredToken("access")
+	}
+	return sd.getToken(sd.accessSession, sd.accessTokenChunks, "access")
+}
+
+// GetAccessTokenExpiry returns when the access token expires, as reported by the provider's
+// expires_in when the token was stored.
+//
+// Returns:
+//   - The expiry time, or the zero time if none was recorded.
+func (sd *SessionData) GetAccessTokenExpiry() time.Time {
+	expiresAt, ok := sessionInt(sd.accessSession.Values["expires_at"])
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(expiresAt, 0)
+}
+
+// SetAccessTokenExpiry records when the access token expires. The expiry is kept in the
+// primary access token session alongside the token, so it survives chunking and is replaced
+// together with the token.
+//
+// Parameters:
+//   - expiresAt: The expiry time; the zero time removes a recorded expiry.
+func (sd *SessionData) SetAccessTokenExpiry(expiresAt time.Time) {
+	if expiresAt.IsZero() {
+		if _, ok := sd.accessSession.Values["expires_at"]; ok {
+			delete(sd.accessSession.Values, "expires_at")
+			sd.accessDirty = true
 		}
-		return token
+		return
 	}
+	sd.accessSession.Values["expires_at"] = expiresAt.Unix()
+	sd.accessDirty = true
+}
 
-	// Reassemble token from chunks.
-	if len(sd.accessTokenChunks) == 0 {
-		return ""
+// AccessTokenExpiresIn returns the remaining lifetime of the access token, for deciding
+// whether to refresh it.
+//
+// Returns:
+//   - The time until the recorded expiry, 0 if it has passed or none was recorded.
+func (sd *SessionData) AccessTokenExpiresIn() time.Duration {
+	expiresAt := sd.GetAccessTokenExpiry()
+	if expiresAt.IsZero() {
+		return 0
 	}
+	if remaining := time.Until(expiresAt); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
 
-	var chunks []string
-	for i := 0; ; i++ {
-		session, ok := sd.accessTokenChunks[i]
-		if !ok {
-			break
+// getToken returns the token held in the given primary session, reassembling it from the
+// chunk sessions when it was split across several cookies and decompressing it when it
+// was stored compressed. If the chunk sequence has a gap (a later chunk is present but an
+// earlier one is missing, e.g. after browser cookie eviction), the token is treated as
+// absent so that the user is cleanly re-authenticated instead of a corrupt token being
+// passed downstream.
+//
+// Parameters:
+//   - primary: The primary token session (access or refresh).
+//   - chunks: The chunk sessions loaded for that token.
+//   - tokenType: A label ("access" or "refresh") used for logging.
+//
+// Returns:
+//   - The complete, decompressed token string, or an empty string if not found or incomplete.
+func (sd *SessionData) getToken(primary *sessions.Session, chunks map[int]*sessions.Session, tokenType string) string {
+	token, _ := primary.Values["token"].(string)
+	if token == "" {
+		// Reassemble token from chunks.
+		if len(chunks) == 0 {
+			return ""
+		}
+
+		// Fail fast when the recorded chunk count is not fully present.
+		if count := chunkCount(primary); count >= 0 && len(chunks) != count {
+			sd.manager.logger.Infof("Incomplete %s token chunks: expected %d chunk cookies, found %d; treating token as absent", tokenType, count, len(chunks))
+			return ""
+		}
+
+		var parts []string
+		for i := 0; ; i++ {
+			session, ok := chunks[i]
+			if !ok {
+				break
+			}
+			chunk, _ := session.Values["token_chunk"].(string)
+			parts = append(parts, chunk)
+		}
+
+		if len(parts) != len(chunks) {
+			sd.manager.logger.Infof("Incomplete %s token chunks: found %d chunk cookies but only %d are contiguous; treating token as absent", tokenType, len(chunks), len(parts))
+			return ""
 		}
-		chunk, _ := session.Values["token_chunk"].(string)
-		chunks = append(chunks, chunk)
+
+		token = strings.Join(parts, "")
 	}
 
-	token = strings.Join(chunks, "")
-	compressed, _ := sd.accessSession.Values["compressed"].(bool)
-	if compressed {
-		return decompressToken(token)
+	compressed, _ := primary.Values["compressed"].(bool)
+	if !compressed {
+		return token
 	}
-	return token
+	// A token stored compressed that no longer decompresses is corrupt; treat it as absent so
+	// the user is refreshed or re-authenticated instead of garbage being passed downstream.
+	decompressed, err := sd.decompressStoredToken(primary, token)
+	if err != nil {
+		sd.manager.logger.Infof("Discarding corrupt %s token: %v", tokenType, err)
+		return ""
+	}
+	return decompressed
 }
 
 // SetAccessToken stores the provided access token in the session.
 // It first expires any existing access token chunk cookies.
-// It then compresses the token. If the compressed token fits within a single cookie (maxCookieSize),
-// it's stored directly in the primary access token session. Otherwise, the compressed token
+// It then compresses the token with the configured codec, recording the codec marker alongside it,
+// unless the token is shorter than the compression threshold, in which case it is stored as is.
+// If the stored token fits within a single cookie (maxCookieSize),
+// it's stored directly in the primary access token session. Otherwise, the stored token
 // is split into chunks, and each chunk is stored in a separate numbered cookie (_oidc_raczylo_a_0, _oidc_raczylo_a_1, etc.).
+// If chunking is disabled and the stored token does not fit in a single cookie, the session
+// is left untouched, the token-too-large hook is notified and ErrTokenTooLarge is returned.
 //
 // Parameters:
 //   - token: The access token string to store.
-func (sd *SessionData) SetAccessToken(token string) {
+//
+// Returns:
+//   - An error wrapping ErrTokenTooLarge if chunking is disabled and the token is too large.
+func (sd *SessionData) SetAccessToken(token string) error {
+	if sd.manager.tokenStore != nil {
+		return sd.setStoredToken("access", token)
+	}
+
+	// Compress token unless it is below the compression threshold.
+	stored, compressed := sd.manager.encodeToken(token)
+	if sd.manager.disableChunking && len(stored) > maxCookieSize {
+		if sd.manager.tokenTooLargeHook != nil {
+			sd.manager.tokenTooLargeHook(sd, "access", len(stored))
+		}
+		return fmt.Errorf("access token is %d bytes as stored, limit is %d: %w", len(stored), maxCookieSize, ErrTokenTooLarge)
+	}
+
 	// Expire any existing chunk cookies first.
 	if sd.request != nil {
 		sd.expireAccessTokenChunks(nil) // Will be saved when Save() is called.
 	}
+	sd.accessDirty = true
 
 	// Clear and prepare chunks map for new token.
 	sd.accessTokenChunks = make(map[int]*sessions.Session)
 
-	// Compress token.
-	compressed := compressToken(token)
+	if compressed {
+		sd.accessSession.Values["codec"] = sd.manager.codec.ID()
+	} else {
+		delete(sd.accessSession.Values, "codec")
+	}
+	sd.accessSession.Values["compressed"] = compressed
 
-	if len(compressed) <= maxCookieSize {
-		sd.accessSession.Values["token"] = compressed
-		sd.accessSession.Values["compressed"] = true
+	if len(stored) <= maxCookieSize {
+		sd.accessSession.Values["token"] = stored
+		delete(sd.accessSession.Values, "chunk_count")
 	} else {
-		// Split compressed token into chunks.
+		// Split stored token into chunks.
 		sd.accessSession.Values["token"] = ""
-		sd.accessSession.Values["compressed"] = true
-		chunks := splitIntoChunks(compressed, maxCookieSize)
+		chunks := splitIntoChunks(stored, maxCookieSize)
+		sd.accessSession.Values["chunk_count"] = len(chunks)
 		for i, chunk := range chunks {
 			sessionName := fmt.Sprintf("%s_%d", accessTokenCookie, i)
-			session, _ := sd.manager.store.Get(sd.request, sessionName)
+			session, _ := sd.manager.getStore().Get(sd.request, sessionName)
 			session.Values["token_chunk"] = chunk
 			sd.accessTokenChunks[i] = session
 		}
 	}
+
+	return nil
 }
 
 // GetRefreshToken retrieves the refresh token stored in the session.
@@ -523,121 +1996,357 @@ func (sd *SessionData) SetAccessToken(token string) {
 // Returns:
 //   - The complete, decompressed refresh token string, or an empty string if not found.
 func (sd *SessionData) GetRefreshToken() string {
-	token, _ := sd.refreshSession.Values["token"].(string)
-	if token != "" {
-		compressed, _ := sd.refreshSession.Values["compressed"].(bool)
-		if compressed {
-			return decompressToken(token)
-		}
-		return token
-	}
-
-	// Reassemble token from chunks.
-	if len(sd.refreshTokenChunks) == 0 {
-		return ""
-	}
-
-	var chunks []string
-	for i := 0; ; i++ {
-		session, ok := sd.refreshTokenChunks[i]
-		if !ok {
-			break
-		}
-		chunk, _ := session.Values["token_chunk"].(string)
-		chunks = append(chunks, chunk)
-	}
-
-	token = strings.Join(chunks, "")
-	compressed, _ := sd.refreshSession.Values["compressed"].(bool)
-	if compressed {
-		return decompressToken(token)
+	if sd.manager.tokenStore != nil {
+		return sd.getStoredToken("refresh")
 	}
-	return token
+	return sd.getToken(sd.refreshSession, sd.refreshTokenChunks, "refresh")
 }
 
 // SetRefreshToken stores the provided refresh token in the session.
 // It first expires any existing refresh token chunk cookies.
-// It then compresses the token. If the compressed token fits within a single cookie (maxCookieSize),
-// it's stored directly in the primary refresh token session. Otherwise, the compressed token
+// It then compresses the token with the configured codec, recording the codec marker alongside it,
+// unless the token is shorter than the compression threshold, in which case it is stored as is.
+// If the stored token fits within a single cookie (maxCookieSize),
+// it's stored directly in the primary refresh token session. Otherwise, the stored token
 // is split into chunks, and each chunk is stored in a separate numbered cookie (_oidc_raczylo_r_0, _oidc_raczylo_r_1, etc.).
+// If chunking is disabled and the stored token does not fit in a single cookie, the session
+// is left untouched, the token-too-large hook is notified and ErrTokenTooLarge is returned.
 //
 // Parameters:
 //   - token: The refresh token string to store.
-func (sd *SessionData) SetRefreshToken(token string) {
+//
+// Returns:
+//   - An error wrapping ErrTokenTooLarge if chunking is disabled and the token is too large.
+func (sd *SessionData) SetRefreshToken(token string) error {
+	if sd.manager.tokenStore != nil {
+		return sd.setStoredToken("refresh", token)
+	}
+
+	// Compress token unless it is below the compression threshold.
+	stored, compressed := sd.manager.encodeToken(token)
+	if sd.manager.disableChunking && len(stored) > maxCookieSize {
+		if sd.manager.tokenTooLargeHook != nil {
+			sd.manager.tokenTooLargeHook(sd, "refresh", len(stored))
+		}
+		return fmt.Errorf("refresh token is %d bytes as stored, limit is %d: %w", len(stored), maxCookieSize, ErrTokenTooLarge)
+	}
+
 	// Expire any existing chunk cookies first.
 	if sd.request != nil {
 		sd.expireRefreshTokenChunks(nil) // Will be saved when Save() is called.
 	}
+	sd.refreshDirty = true
 
 	// Clear and prepare chunks map for new token.
 	sd.refreshTokenChunks = make(map[int]*sessions.Session)
 
-	// Compress token.
-	compressed := compressToken(token)
+	if compressed {
+		sd.refreshSession.Values["codec"] = sd.manager.codec.ID()
+	} else {
+		delete(sd.refreshSession.Values, "codec")
+	}
+	sd.refreshSession.Values["compressed"] = compressed
 
-	if len(compressed) <= maxCookieSize {
-		sd.refreshSession.Values["token"] = compressed
-		sd.refreshSession.Values["compressed"] = true
+	if len(stored) <= maxCookieSize {
+		sd.refreshSession.Values["token"] = stored
+		delete(sd.refreshSession.Values, "chunk_count")
 	} else {
-		// Split compressed token into chunks.
+		// Split stored token into chunks.
 		sd.refreshSession.Values["token"] = ""
-		sd.refreshSession.Values["compressed"] = true
-		chunks := splitIntoChunks(compressed, maxCookieSize)
+		chunks := splitIntoChunks(stored, maxCookieSize)
+		sd.refreshSession.Values["chunk_count"] = len(chunks)
 		for i, chunk := range chunks {
 			sessionName := fmt.Sprintf("%s_%d", refreshTokenCookie, i)
-			session, _ := sd.manager.store.Get(sd.request, sessionName)
+			session, _ := sd.manager.getStore().Get(sd.request, sessionName)
 			session.Values["token_chunk"] = chunk
 			sd.refreshTokenChunks[i] = session
 		}
 	}
+
+	return nil
+}
+
+// GetIDToken retrieves the verified ID token of the session. The session keeps it in the slot
+// read by GetAccessToken; the provider's OAuth access token, when stored, is returned by
+// GetProviderAccessToken instead.
+//
+// Returns:
+//   - The complete, decompressed ID token string, or an empty string if not found.
+func (sd *SessionData) GetIDToken() string {
+	return sd.GetAccessToken()
+}
+
+// GetProviderAccessToken retrieves the OAuth access token issued by the provider, as opposed
+// to the ID token returned by GetAccessToken. It is only stored for configurations that
+// forward it upstream.
+//
+// Returns:
+//   - The complete, decompressed provider access token, or an empty string if not stored.
+func (sd *SessionData) GetProviderAccessToken() string {
+	if sd.manager.tokenStore != nil {
+		return sd.getStoredToken("provider")
+	}
+	return sd.getToken(sd.providerSession, sd.providerTokenChunks, "provider")
+}
+
+// SetProviderAccessToken stores the OAuth access token issued by the provider in its own
+// cookie (_oidc_raczylo_p), compressed and chunked (_oidc_raczylo_p_0, _oidc_raczylo_p_1, etc.)
+// like the refresh token.
+// If chunking is disabled and the stored token does not fit in a single cookie, the session
+// is left untouched, the token-too-large hook is notified and ErrTokenTooLarge is returned.
+//
+// Parameters:
+//   - token: The provider access token to store; an empty string removes it.
+//
+// Returns:
+//   - An error wrapping ErrTokenTooLarge if chunking is disabled and the token is too large.
+func (sd *SessionData) SetProviderAccessToken(token string) error {
+	if sd.manager.tokenStore != nil {
+		return sd.setStoredToken("provider", token)
+	}
+
+	// Compress token unless it is below the compression threshold.
+	stored, compressed := sd.manager.encodeToken(token)
+	if sd.manager.disableChunking && len(stored) > maxCookieSize {
+		if sd.manager.tokenTooLargeHook != nil {
+			sd.manager.tokenTooLargeHook(sd, "provider", len(stored))
+		}
+		return fmt.Errorf("provider access token is %d bytes as stored, limit is %d: %w", len(stored), maxCookieSize, ErrTokenTooLarge)
+	}
+
+	// Expire any existing chunk cookies first.
+	if sd.request != nil {
+		sd.expireTokenChunks(sd.request, nil, providerCookie) // Will be saved when Save() is called.
+	}
+	sd.providerDirty = true
+
+	// Clear and prepare chunks map for new token.
+	sd.providerTokenChunks = make(map[int]*sessions.Session)
+
+	if compressed {
+		sd.providerSession.Values["codec"] = sd.manager.codec.ID()
+	} else {
+		delete(sd.providerSession.Values, "codec")
+	}
+	sd.providerSession.Values["compressed"] = compressed
+
+	if len(stored) <= maxCookieSize {
+		sd.providerSession.Values["token"] = stored
+		delete(sd.providerSession.Values, "chunk_count")
+	} else {
+		// Split stored token into chunks.
+		sd.providerSession.Values["token"] = ""
+		chunks := splitIntoChunks(stored, maxCookieSize)
+		sd.providerSession.Values["chunk_count"] = len(chunks)
+		for i, chunk := range chunks {
+			sessionName := fmt.Sprintf("%s_%d", providerCookie, i)
+			session, _ := sd.manager.getStore().Get(sd.request, sessionName)
+			session.Values["token_chunk"] = chunk
+			sd.providerTokenChunks[i] = session
+		}
+	}
+
+	return nil
+}
+
+// expireAccessTokenChunks finds all existing access token chunk cookies (_oidc_raczylo_a_N)
+// associated with the current request, clears their values, and sets their MaxAge to -1.
+// If a ResponseWriter is provided, it attempts to save the expired chunk sessions to send
+// the expiring Set-Cookie headers; otherwise they are recorded so that the next Save expires
+// every chunk the new token does not reuse. This is used internally when setting a new access token.
+//
+// Parameters:
+//   - w: The HTTP response writer (optional). If provided, expiring Set-Cookie headers will be sent.
+func (sd *SessionData) expireAccessTokenChunks(w http.ResponseWriter) {
+	sd.expireTokenChunks(sd.request, w, accessTokenCookie)
+}
+
+// expireRefreshTokenChunks finds all existing refresh token chunk cookies (_oidc_raczylo_r_N)
+// associated with the current request, clears their values, and sets their MaxAge to -1.
+// If a ResponseWriter is provided, it attempts to save the expired chunk sessions to send
+// the expiring Set-Cookie headers; otherwise they are recorded so that the next Save expires
+// every chunk the new token does not reuse. This is used internally when setting a new refresh token.
+//
+// Parameters:
+//   - w: The HTTP response writer (optional). If provided, expiring Set-Cookie headers will be sent.
+func (sd *SessionData) expireRefreshTokenChunks(w http.ResponseWriter) {
+	sd.expireTokenChunks(sd.request, w, refreshTokenCookie)
+}
+
+// expireTokenChunks expires every chunk cookie of a token (or of the claims) that the request
+// carries. The cookies are taken from the request rather than the recorded chunk count, so
+// stale chunks beyond the count or behind a gap, left over from an earlier and larger token,
+// are expired too and cannot be mixed into the new token on the next request. Chunks that no
+// longer decode are expired as well.
+//
+// Parameters:
+//   - r: The HTTP request carrying the chunk cookies.
+//   - w: The HTTP response writer (optional). If provided, expiring Set-Cookie headers will be sent.
+//   - baseName: The base name of the chunk cookies (e.g., accessTokenCookie).
+func (sd *SessionData) expireTokenChunks(r *http.Request, w http.ResponseWriter, baseName string) {
+	for _, sessionName := range requestChunkNames(r, baseName) {
+		session, _ := sd.manager.getStore().Get(r, sessionName)
+		if session == nil {
+			session = sessions.NewSession(sd.manager.getStore(), sessionName)
+		}
+		session.Options = sd.manager.getSessionOptions(strings.HasPrefix(r.URL.Scheme, "https"), sd.manager.tokenSameSite, -1)
+		session.Values = make(map[interface{}]interface{})
+		if w != nil {
+			if err := session.Save(r, w); err != nil {
+				sd.manager.logger.Errorf("failed to save expired chunk cookie %s: %v", sessionName, err)
+			}
+		} else {
+			// Defer the expiry to the next Save.
+			sd.expiredChunks[sessionName] = session
+		}
+	}
+}
+
+// requestChunkNames returns the names of the chunk cookies ("{baseName}_N") the request
+// carries, in index order.
+//
+// Parameters:
+//   - r: The incoming HTTP request.
+//   - baseName: The base name of the chunk cookies.
+//
+// Returns:
+//   - The chunk cookie names, or nil if there are none.
+func requestChunkNames(r *http.Request, baseName string) []string {
+	if r == nil {
+		return nil
+	}
+	var indexes []int
+	seen := make(map[int]bool)
+	for _, cookie := range r.Cookies() {
+		suffix, ok := strings.CutPrefix(cookie.Name, baseName+"_")
+		if !ok {
+			continue
+		}
+		index, err := strconv.Atoi(suffix)
+		if err != nil || index < 0 || seen[index] {
+			continue
+		}
+		seen[index] = true
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	names := make([]string, len(indexes))
+	for i, index := range indexes {
+		names[i] = fmt.Sprintf("%s_%d", baseName, index)
+	}
+	return names
+}
+
+// storedTokenKey returns the server-side token store key for a token of the given type.
+//
+// Parameters:
+//   - sid: The token session ID kept in the main session cookie.
+//   - tokenType: "access", "refresh" or "provider".
+//
+// Returns:
+//   - The cache key.
+func storedTokenKey(sid, tokenType string) string {
+	return "sid:" + sid + ":" + tokenType
+}
+
+// getStoredToken reads and decrypts a token from the server-side token store using the
+// token session ID recorded in the main session.
+//
+// Parameters:
+//   - tokenType: "access", "refresh" or "provider".
+//
+// Returns:
+//   - The token, or an empty string if the session has no token session ID, the token was
+//     evicted or expired (e.g. after a restart), or it cannot be decrypted.
+func (sd *SessionData) getStoredToken(tokenType string) string {
+	sid, _ := sd.mainSession.Values["token_sid"].(string)
+	if sid == "" {
+		return ""
+	}
+	value, found := sd.manager.tokenStore.Get(storedTokenKey(sid, tokenType))
+	if !found {
+		return ""
+	}
+	encoded, _ := value.(string)
+	var token string
+	if err := securecookie.DecodeMulti(tokenType, encoded, &token, sd.manager.getTokenCodecs()...); err != nil {
+		sd.manager.logger.Errorf("Failed to decrypt stored %s token: %v", tokenType, err)
+		return ""
+	}
+	return token
 }
 
-// expireAccessTokenChunks finds all existing access token chunk cookies (_oidc_raczylo_a_N)
-// associated with the current request, clears their values, and sets their MaxAge to -1.
-// If a ResponseWriter is provided, it attempts to save the expired chunk sessions to send
-// the expiring Set-Cookie headers. This is used internally when setting a new access token.
+// setStoredToken encrypts a token and writes it to the server-side token store, generating
+// the session's token session ID on first use. Any token previously kept in cookies for
+// this session is removed so it is no longer sent over the wire. An empty token deletes the
+// stored entry.
 //
 // Parameters:
-//   - w: The HTTP response writer (optional). If provided, expiring Set-Cookie headers will be sent.
-func (sd *SessionData) expireAccessTokenChunks(w http.ResponseWriter) {
-	for i := 0; ; i++ {
-		sessionName := fmt.Sprintf("%s_%d", accessTokenCookie, i)
-		session, err := sd.manager.store.Get(sd.request, sessionName)
-		if err != nil || session.IsNew {
-			break
+//   - tokenType: "access", "refresh" or "provider".
+//   - token: The token to store.
+//
+// Returns:
+//   - An error if the token session ID cannot be generated or the token cannot be encrypted.
+func (sd *SessionData) setStoredToken(tokenType, token string) error {
+	sid, _ := sd.mainSession.Values["token_sid"].(string)
+	if sid == "" {
+		var err error
+		sid, err = generateSecureRandomString(sd.manager.random, 32)
+		if err != nil {
+			return fmt.Errorf("failed to generate token session id: %w", err)
 		}
-		session.Options.MaxAge = -1
-		session.Values = make(map[interface{}]interface{})
-		if w != nil {
-			if err := session.Save(sd.request, w); err != nil {
-				sd.manager.logger.Errorf("failed to save expired access token cookie: %v", err)
-			}
+		sd.setMainValue("token_sid", sid)
+	}
+
+	// Remove any cookie-stored copy left over from cookie storage.
+	primary, chunks, baseName, dirty := sd.accessSession, &sd.accessTokenChunks, accessTokenCookie, &sd.accessDirty
+	switch tokenType {
+	case "refresh":
+		primary, chunks, baseName, dirty = sd.refreshSession, &sd.refreshTokenChunks, refreshTokenCookie, &sd.refreshDirty
+	case "provider":
+		primary, chunks, baseName, dirty = sd.providerSession, &sd.providerTokenChunks, providerCookie, &sd.providerDirty
+	}
+	if sd.request != nil {
+		sd.expireTokenChunks(sd.request, nil, baseName)
+		*chunks = make(map[int]*sessions.Session)
+	}
+	for _, key := range []string{"token", "compressed", "codec", "chunk_count"} {
+		if _, ok := primary.Values[key]; ok {
+			delete(primary.Values, key)
+			*dirty = true
 		}
 	}
+
+	key := storedTokenKey(sid, tokenType)
+	if token == "" {
+		sd.manager.tokenStore.Delete(key)
+		return nil
+	}
+	encoded, err := securecookie.EncodeMulti(tokenType, token, sd.manager.getTokenCodecs()...)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt %s token: %w", tokenType, err)
+	}
+	sd.manager.tokenStore.Set(key, encoded, sd.manager.maxSessionTimeout())
+	return nil
 }
 
-// expireRefreshTokenChunks finds all existing refresh token chunk cookies (_oidc_raczylo_r_N)
-// associated with the current request, clears their values, and sets their MaxAge to -1.
-// If a ResponseWriter is provided, it attempts to save the expired chunk sessions to send
-// the expiring Set-Cookie headers. This is used internally when setting a new refresh token.
+// chunkCount returns the number of chunk cookies recorded in a primary token session
+// when the token was split, or -1 if the session predates chunk counting or holds
+// no chunk count.
 //
 // Parameters:
-//   - w: The HTTP response writer (optional). If provided, expiring Set-Cookie headers will be sent.
-func (sd *SessionData) expireRefreshTokenChunks(w http.ResponseWriter) {
-	for i := 0; ; i++ {
-		sessionName := fmt.Sprintf("%s_%d", refreshTokenCookie, i)
-		session, err := sd.manager.store.Get(sd.request, sessionName)
-		if err != nil || session.IsNew {
-			break
-		}
-		session.Options.MaxAge = -1
-		session.Values = make(map[interface{}]interface{})
-		if w != nil {
-			if err := session.Save(sd.request, w); err != nil {
-				sd.manager.logger.Errorf("failed to save expired refresh token cookie: %v", err)
-			}
-		}
+//   - session: The primary access or refresh token session.
+//
+// Returns:
+//   - The recorded chunk count, or -1 if unknown.
+func chunkCount(session *sessions.Session) int {
+	if session == nil {
+		return -1
+	}
+	if count, ok := sessionInt(session.Values["chunk_count"]); ok {
+		return int(count)
 	}
+	return -1
 }
 
 // splitIntoChunks divides a string `s` into a slice of strings, where each element
@@ -674,11 +2383,57 @@ func (sd *SessionData) GetCSRF() string {
 
 // SetCSRF stores the provided CSRF token string in the main session.
 // This token is typically generated at the start of the authentication flow.
+// The time it was issued is recorded alongside it so stale tokens can be rejected.
 //
 // Parameters:
-//   - token: The CSRF token to store.
+//   - token: The CSRF token to store, or "" to clear it.
 func (sd *SessionData) SetCSRF(token string) {
-	sd.mainSession.Values["csrf"] = token
+	sd.setMainValue("csrf", token)
+	if token == "" {
+		sd.deleteMainValue("csrf_issued_at")
+		return
+	}
+	sd.setMainValue("csrf_issued_at", time.Now().Unix())
+}
+
+// GetCSRFIssuedAt returns when the current CSRF token was issued.
+//
+// Returns:
+//   - The issue time, or the zero time if no token is set or it predates issue tracking.
+func (sd *SessionData) GetCSRFIssuedAt() time.Time {
+	issuedAt, ok := sessionInt(sd.mainSession.Values["csrf_issued_at"])
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(issuedAt, 0)
+}
+
+// ConsumeCSRF invalidates the current CSRF token once a callback has presented it, so that
+// the same authorization response cannot be replayed. The consumed token is remembered to
+// tell a replay apart from a callback without any pending login.
+func (sd *SessionData) ConsumeCSRF() {
+	sd.setMainValue("csrf_consumed", sd.GetCSRF())
+	sd.SetCSRF("")
+}
+
+// clearPendingAuth discards the values of a pending authorization request: its state (CSRF
+// token), nonce, PKCE code verifier and redirect URI.
+func (sd *SessionData) clearPendingAuth() {
+	for _, key := range []string{"csrf", "csrf_issued_at", "nonce", "code_verifier", "redirect_uri"} {
+		sd.deleteMainValue(key)
+	}
+}
+
+// IsCSRFConsumed reports whether the given state matches the most recently consumed CSRF token.
+//
+// Parameters:
+//   - state: The state parameter received in a callback.
+//
+// Returns:
+//   - true if the state was already used by an earlier callback.
+func (sd *SessionData) IsCSRFConsumed(state string) bool {
+	consumed, _ := sd.mainSession.Values["csrf_consumed"].(string)
+	return consumed != "" && consumed == state
 }
 
 // GetNonce retrieves the OIDC nonce value stored in the main session.
@@ -697,7 +2452,7 @@ func (sd *SessionData) GetNonce() string {
 // Parameters:
 //   - nonce: The nonce string to store.
 func (sd *SessionData) SetNonce(nonce string) {
-	sd.mainSession.Values["nonce"] = nonce
+	sd.setMainValue("nonce", nonce)
 }
 
 // GetCodeVerifier retrieves the PKCE (Proof Key for Code Exchange) code verifier
@@ -716,7 +2471,130 @@ func (sd *SessionData) GetCodeVerifier() string {
 // Parameters:
 //   - codeVerifier: The PKCE code verifier string to store.
 func (sd *SessionData) SetCodeVerifier(codeVerifier string) {
-	sd.mainSession.Values["code_verifier"] = codeVerifier
+	sd.setMainValue("code_verifier", codeVerifier)
+}
+
+// GetRefreshFailures returns the number of consecutive failed token refreshes recorded for
+// this session and the earliest time at which another refresh may be attempted.
+//
+// Returns:
+//   - The consecutive failure count (0 if none recorded).
+//   - The next allowed refresh attempt time (zero time if not in backoff).
+func (sd *SessionData) GetRefreshFailures() (int, time.Time) {
+	failures, _ := sessionInt(sd.mainSession.Values["refresh_failures"])
+	count := int(failures)
+	next, ok := sessionInt(sd.mainSession.Values["refresh_next_attempt"])
+	if !ok {
+		return count, time.Time{}
+	}
+	return count, time.Unix(next, 0)
+}
+
+// SetRefreshFailures records the consecutive failed token refresh count and the earliest time
+// of the next refresh attempt, so that the backoff survives across requests.
+//
+// Parameters:
+//   - count: The consecutive failure count; 0 clears the recorded failures.
+//   - nextAttempt: The earliest time another refresh may be attempted.
+func (sd *SessionData) SetRefreshFailures(count int, nextAttempt time.Time) {
+	if count <= 0 {
+		sd.deleteMainValue("refresh_failures")
+		sd.deleteMainValue("refresh_next_attempt")
+		return
+	}
+	sd.setMainValue("refresh_failures", count)
+	sd.setMainValue("refresh_next_attempt", nextAttempt.Unix())
+}
+
+// GetProviderUnavailable reports whether the last token refresh for this session failed
+// because the provider was unreachable or temporarily unavailable.
+//
+// Returns:
+//   - true if the last refresh failed due to a provider outage.
+func (sd *SessionData) GetProviderUnavailable() bool {
+	unavailable, _ := sd.mainSession.Values["provider_unavailable"].(bool)
+	return unavailable
+}
+
+// SetProviderUnavailable records whether the last token refresh failed because the provider
+// was unavailable, so that the outage grace period survives across requests.
+//
+// Parameters:
+//   - unavailable: true after a refresh failed due to a provider outage, false otherwise.
+func (sd *SessionData) SetProviderUnavailable(unavailable bool) {
+	if !unavailable {
+		sd.deleteMainValue("provider_unavailable")
+		return
+	}
+	sd.setMainValue("provider_unavailable", true)
+}
+
+// GetSilentRenew reports whether the authorization request in progress was started by the
+// silent renew endpoint (prompt=none), in which case the callback answers with a
+// postMessage page instead of a redirect.
+//
+// Returns:
+//   - true if a silent renew is in progress, false otherwise.
+func (sd *SessionData) GetSilentRenew() bool {
+	silent, _ := sd.mainSession.Values["silent_renew"].(bool)
+	return silent
+}
+
+// SetSilentRenew marks or unmarks the authorization request in progress as a silent renew.
+//
+// Parameters:
+//   - silent: true when starting a prompt=none request, false once the callback has been handled.
+func (sd *SessionData) SetSilentRenew(silent bool) {
+	if silent {
+		sd.setMainValue("silent_renew", true)
+	} else {
+		sd.deleteMainValue("silent_renew")
+	}
+}
+
+// GetCorrelationID returns the correlation ID of the auth flow the session belongs to,
+// generated when the login was started.
+//
+// Returns:
+//   - The correlation ID, or an empty string if none is set.
+func (sd *SessionData) GetCorrelationID() string {
+	id, _ := sd.mainSession.Values["correlation_id"].(string)
+	return id
+}
+
+// SetCorrelationID stores the correlation ID of the auth flow in the session.
+//
+// Parameters:
+//   - id: The correlation ID; empty removes it.
+func (sd *SessionData) SetCorrelationID(id string) {
+	if id == "" {
+		sd.deleteMainValue("correlation_id")
+		return
+	}
+	sd.setMainValue("correlation_id", id)
+}
+
+// GetSilentLogin reports whether the authorization request in progress is a prompt=none
+// re-authentication of a session that lost its refresh token, in which case a provider error
+// in the callback falls back to an interactive login instead of failing.
+//
+// Returns:
+//   - true if a silent login is in progress, false otherwise.
+func (sd *SessionData) GetSilentLogin() bool {
+	silent, _ := sd.mainSession.Values["silent_login"].(bool)
+	return silent
+}
+
+// SetSilentLogin marks or unmarks the authorization request in progress as a silent login.
+//
+// Parameters:
+//   - silent: true when starting a prompt=none login, false once the callback has been handled.
+func (sd *SessionData) SetSilentLogin(silent bool) {
+	if silent {
+		sd.setMainValue("silent_login", true)
+	} else {
+		sd.deleteMainValue("silent_login")
+	}
 }
 
 // GetEmail retrieves the authenticated user's email address stored in the main session.
@@ -735,7 +2613,38 @@ func (sd *SessionData) GetEmail() string {
 // Parameters:
 //   - email: The user's email address to store.
 func (sd *SessionData) SetEmail(email string) {
-	sd.mainSession.Values["email"] = email
+	sd.setMainValue("email", email)
+}
+
+// GetSessionID retrieves the ID given to the session by SessionManager.BindUserSession.
+//
+// Returns:
+//   - The session ID, or an empty string if the session was not bound to its user.
+func (sd *SessionData) GetSessionID() string {
+	sid, _ := sd.mainSession.Values["sid"].(string)
+	return sid
+}
+
+// GetUserID retrieves the authenticated user's identifier stored in the main session.
+// This is the value of the configured user ID claim. Sessions created before the
+// identifier was stored fall back to the email address.
+//
+// Returns:
+//   - The user identifier string, or an empty string if not set.
+func (sd *SessionData) GetUserID() string {
+	if userID, _ := sd.mainSession.Values["user_id"].(string); userID != "" {
+		return userID
+	}
+	return sd.GetEmail()
+}
+
+// SetUserID stores the provided user identifier in the main session.
+// This is typically called after successful authentication and claim extraction.
+//
+// Parameters:
+//   - userID: The user identifier to store.
+func (sd *SessionData) SetUserID(userID string) {
+	sd.setMainValue("user_id", userID)
 }
 
 // GetIncomingPath retrieves the original request URI (including query parameters)
@@ -755,5 +2664,354 @@ func (sd *SessionData) GetIncomingPath() string {
 // Parameters:
 //   - path: The original request URI string (e.g., "/protected/resource?id=123").
 func (sd *SessionData) SetIncomingPath(path string) {
-	sd.mainSession.Values["incoming_path"] = path
+	sd.setMainValue("incoming_path", path)
+}
+
+// GetRedirectURI retrieves the redirect URI sent with the pending authorization request, so
+// the token exchange can repeat it exactly.
+//
+// Returns:
+//   - The redirect URI, or an empty string if not set.
+func (sd *SessionData) GetRedirectURI() string {
+	redirectURI, _ := sd.mainSession.Values["redirect_uri"].(string)
+	return redirectURI
+}
+
+// SetRedirectURI stores the redirect URI sent with a new authorization request. An empty
+// value removes any stored redirect URI.
+//
+// Parameters:
+//   - redirectURI: The absolute redirect URI.
+func (sd *SessionData) SetRedirectURI(redirectURI string) {
+	if redirectURI == "" {
+		sd.deleteMainValue("redirect_uri")
+		return
+	}
+	sd.setMainValue("redirect_uri", redirectURI)
+}
+
+// GetIncomingFragment retrieves the URL fragment (e.g. "#/some/route") captured in the
+// browser before the user was redirected for authentication.
+//
+// Returns:
+//   - The fragment including the leading "#", or an empty string if not set.
+func (sd *SessionData) GetIncomingFragment() string {
+	fragment, _ := sd.mainSession.Values["incoming_fragment"].(string)
+	return fragment
+}
+
+// SetIncomingFragment stores the URL fragment captured in the browser in the main session,
+// so it can be restored together with the incoming path after login. An empty fragment
+// removes any stored value.
+//
+// Parameters:
+//   - fragment: The fragment including the leading "#".
+func (sd *SessionData) SetIncomingFragment(fragment string) {
+	if fragment == "" {
+		sd.deleteMainValue("incoming_fragment")
+		return
+	}
+	sd.setMainValue("incoming_fragment", fragment)
+}
+
+// GetAuthTime returns when the user last actively authenticated with the provider, as
+// reported by the auth_time claim of the login's ID token.
+//
+// Returns:
+//   - The authentication time, or the zero time if the provider did not report it.
+func (sd *SessionData) GetAuthTime() time.Time {
+	authTime, ok := sessionInt(sd.mainSession.Values["auth_time"])
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(authTime, 0)
+}
+
+// SetAuthTime records when the user last actively authenticated with the provider.
+//
+// Parameters:
+//   - authTime: The authentication time; the zero time removes it.
+func (sd *SessionData) SetAuthTime(authTime time.Time) {
+	if authTime.IsZero() {
+		sd.deleteMainValue("auth_time")
+		return
+	}
+	sd.setMainValue("auth_time", authTime.Unix())
+}
+
+// GetSubject returns the subject ('sub' claim) of the user the session was established for.
+//
+// Returns:
+//   - The subject, or an empty string if the session predates subject tracking.
+func (sd *SessionData) GetSubject() string {
+	sub, _ := sd.mainSession.Values["sub"].(string)
+	return sub
+}
+
+// SetSubject records the subject ('sub' claim) of the user the session is established for.
+// Tokens obtained later for the session must carry the same subject.
+//
+// Parameters:
+//   - sub: The subject; empty removes it.
+func (sd *SessionData) SetSubject(sub string) {
+	if sub == "" {
+		sd.deleteMainValue("sub")
+		return
+	}
+	sd.setMainValue("sub", sub)
+}
+
+// GetClaimsUpdatedAt returns when the session's claims were last obtained from the provider,
+// at login or by a token refresh.
+//
+// Returns:
+//   - The time of the last claims update, or the zero time if none was recorded.
+func (sd *SessionData) GetClaimsUpdatedAt() time.Time {
+	updatedAt, ok := sessionInt(sd.mainSession.Values["claims_at"])
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(updatedAt, 0)
+}
+
+// SetClaimsUpdatedAt records when the session's claims were obtained from the provider.
+//
+// Parameters:
+//   - updatedAt: The time of the claims update.
+func (sd *SessionData) SetClaimsUpdatedAt(updatedAt time.Time) {
+	sd.setMainValue("claims_at", updatedAt.Unix())
+}
+
+// GetAMR returns the authentication methods ('amr' claim) of the session's login.
+//
+// Returns:
+//   - The authentication methods, or nil if none were reported.
+func (sd *SessionData) GetAMR() []string {
+	amr, _ := sd.mainSession.Values["amr"].(string)
+	return strings.Fields(amr)
+}
+
+// SetAMR records the authentication methods ('amr' claim) of the session's login.
+//
+// Parameters:
+//   - methods: The authentication methods; empty removes them.
+func (sd *SessionData) SetAMR(methods []string) {
+	if len(methods) == 0 {
+		sd.deleteMainValue("amr")
+		return
+	}
+	sd.setMainValue("amr", strings.Join(methods, " "))
+}
+
+// GetMFAStepUp reports whether the session's login was a step-up requested because the
+// previous login did not use multi-factor authentication.
+//
+// Returns:
+//   - true if the login was an MFA step-up.
+func (sd *SessionData) GetMFAStepUp() bool {
+	stepUp, _ := sd.mainSession.Values["mfa_step_up"].(bool)
+	return stepUp
+}
+
+// SetMFAStepUp records whether the login being started is an MFA step-up.
+//
+// Parameters:
+//   - stepUp: true for a step-up login.
+func (sd *SessionData) SetMFAStepUp(stepUp bool) {
+	if !stepUp {
+		sd.deleteMainValue("mfa_step_up")
+		return
+	}
+	sd.setMainValue("mfa_step_up", true)
+}
+
+// GetAuthAgeStepUp reports whether the session's login was forced because the previous
+// authentication at the provider was older than maxAuthAgeSeconds.
+//
+// Returns:
+//   - true if the login was a forced re-authentication.
+func (sd *SessionData) GetAuthAgeStepUp() bool {
+	stepUp, _ := sd.mainSession.Values["auth_age_step_up"].(bool)
+	return stepUp
+}
+
+// SetAuthAgeStepUp records whether the login being started is a forced re-authentication.
+//
+// Parameters:
+//   - stepUp: true for a forced re-authentication.
+func (sd *SessionData) SetAuthAgeStepUp(stepUp bool) {
+	if !stepUp {
+		sd.deleteMainValue("auth_age_step_up")
+		return
+	}
+	sd.setMainValue("auth_age_step_up", true)
+}
+
+// GetRememberMe reports whether the login of this session asked to be remembered.
+//
+// Returns:
+//   - true if the session should get the longer remember-me lifetime.
+func (sd *SessionData) GetRememberMe() bool {
+	remember, _ := sd.mainSession.Values["remember_me"].(bool)
+	return remember
+}
+
+// SetRememberMe records whether the login being started asked to be remembered. It takes
+// effect when the session is authenticated.
+//
+// Parameters:
+//   - remember: true to give the session the remember-me lifetime.
+func (sd *SessionData) SetRememberMe(remember bool) {
+	if !remember {
+		sd.deleteMainValue("remember_me")
+		return
+	}
+	sd.setMainValue("remember_me", true)
+}
+
+// GetUserInfo retrieves the UserInfo claims stored at login, reassembling them from the
+// claims chunk cookies when they were split. Incomplete chunks are treated as no claims.
+//
+// Returns:
+//   - The UserInfo claims, or nil if none are stored.
+func (sd *SessionData) GetUserInfo() map[string]interface{} {
+	blob, _ := sd.mainSession.Values["userinfo"].(string)
+	if count := claimsChunkCount(sd.mainSession); count > 0 {
+		var parts []string
+		for i := 0; i < count; i++ {
+			session, ok := sd.claimsChunks[i]
+			if !ok {
+				sd.manager.logger.Infof("Incomplete claims chunks: expected %d chunk cookies, found %d; treating claims as absent", count, len(sd.claimsChunks))
+				return nil
+			}
+			chunk, _ := session.Values["claims_chunk"].(string)
+			parts = append(parts, chunk)
+		}
+		blob = strings.Join(parts, "")
+	}
+	if blob == "" {
+		return nil
+	}
+	// Sessions written before the compressed_claims flag always compressed the claims.
+	if compressed, ok := sd.mainSession.Values["compressed_claims"].(bool); compressed || !ok {
+		blob = decompressToken(blob)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal([]byte(blob), &claims); err != nil {
+		sd.manager.logger.Errorf("Discarding undecodable UserInfo claims: %v", err)
+		return nil
+	}
+	return claims
+}
+
+// SetUserInfo stores the claims returned by the provider's UserInfo endpoint in the main
+// session, compressed unless disabled or not worthwhile, with the compressed_claims flag
+// recording which. Like tokens, a blob that still exceeds maxCookieSize is split into chunk
+// cookies (_oidc_raczylo_c_0, _oidc_raczylo_c_1, etc.), saved along with the main session;
+// with chunking disabled such claims are not stored. Empty claims remove any stored value.
+//
+// Parameters:
+//   - claims: The UserInfo claims.
+func (sd *SessionData) SetUserInfo(claims map[string]interface{}) {
+	var blob string
+	compressed := false
+	if len(claims) > 0 {
+		data, err := json.Marshal(claims)
+		if err != nil {
+			sd.manager.logger.Errorf("Failed to encode UserInfo claims: %v", err)
+			return
+		}
+		blob = string(data)
+		if sd.manager.compressClaims {
+			if packed := compressToken(blob); len(packed) < len(blob) {
+				blob, compressed = packed, true
+			}
+		}
+		if sd.manager.disableChunking && len(blob) > maxCookieSize {
+			sd.manager.logger.Errorf("Not storing UserInfo claims of %d bytes, limit is %d with chunking disabled", len(blob), maxCookieSize)
+			blob, compressed = "", false
+		}
+	}
+
+	// Expire the chunks of the previous claims; the next Save keeps those reused below.
+	for _, session := range sd.claimsChunks {
+		sd.expiredChunks[session.Name()] = session
+	}
+	sd.claimsChunks = make(map[int]*sessions.Session)
+
+	if blob == "" {
+		sd.deleteMainValue("userinfo")
+		sd.deleteMainValue("compressed_claims")
+		sd.deleteMainValue("claims_chunk_count")
+		return
+	}
+	sd.setMainValue("compressed_claims", compressed)
+	if len(blob) <= maxCookieSize {
+		sd.setMainValue("userinfo", blob)
+		sd.deleteMainValue("claims_chunk_count")
+		return
+	}
+	chunks := splitIntoChunks(blob, maxCookieSize)
+	sd.setMainValue("userinfo", "")
+	sd.setMainValue("claims_chunk_count", len(chunks))
+	for i, chunk := range chunks {
+		session, _ := sd.manager.getStore().Get(sd.request, fmt.Sprintf("%s_%d", claimsCookie, i))
+		session.Values = map[interface{}]interface{}{"claims_chunk": chunk}
+		sd.claimsChunks[i] = session
+	}
+}
+
+// claimsChunkCount returns the number of chunk cookies the UserInfo claims were split into.
+//
+// Parameters:
+//   - session: The main session.
+//
+// Returns:
+//   - The recorded chunk count, or 0 if the claims are not chunked.
+func claimsChunkCount(session *sessions.Session) int {
+	count, _ := sessionInt(session.Values["claims_chunk_count"])
+	return int(count)
+}
+
+// incomingHeaders is the serialized form of the request headers captured at login start.
+type incomingHeaders struct {
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+}
+
+// GetIncomingHeaders retrieves the request headers captured when the login started.
+//
+// Returns:
+//   - The path (and query) the headers belong to.
+//   - The captured headers, or nil if none are stored.
+func (sd *SessionData) GetIncomingHeaders() (string, map[string]string) {
+	compressed, _ := sd.mainSession.Values["incoming_headers"].(string)
+	if compressed == "" {
+		return "", nil
+	}
+	var captured incomingHeaders
+	if err := json.Unmarshal([]byte(decompressToken(compressed)), &captured); err != nil {
+		sd.manager.logger.Errorf("Discarding undecodable incoming headers: %v", err)
+		return "", nil
+	}
+	return captured.Path, captured.Headers
+}
+
+// SetIncomingHeaders stores, compressed, the request headers to restore on the first request
+// to path after login. Empty headers remove any stored value.
+//
+// Parameters:
+//   - path: The path (and query) the headers belong to.
+//   - headers: The captured header values keyed by canonical header name.
+func (sd *SessionData) SetIncomingHeaders(path string, headers map[string]string) {
+	if len(headers) == 0 {
+		sd.deleteMainValue("incoming_headers")
+		return
+	}
+	data, err := json.Marshal(incomingHeaders{Path: path, Headers: headers})
+	if err != nil {
+		sd.manager.logger.Errorf("Failed to encode incoming headers: %v", err)
+		return
+	}
+	sd.setMainValue("incoming_headers", compressToken(string(data)))
 }
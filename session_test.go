@@ -1,12 +1,21 @@
 package traefikoidc
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
 )
 
 // generateRandomString creates a random string of specified length
@@ -387,3 +396,1809 @@ func calculateExpectedCookieCount(accessToken, refreshToken string) int {
 
 	return count
 }
+
+// largeTestToken returns a random token whose compressed form is split into exactly the given
+// number of chunk cookies by the session manager's codec. Random content barely compresses, so
+// the token is grown until it crosses into the requested chunk count.
+func largeTestToken(t *testing.T, sm *SessionManager, chunks int) string {
+	t.Helper()
+	for length := maxCookieSize / 4; length < (chunks+1)*maxCookieSize*2; length += maxCookieSize / 4 {
+		token := generateRandomString(length)
+		compressed := compressTokenWithCodec(sm.codec, token)
+		if len(compressed) > maxCookieSize && len(splitIntoChunks(compressed, maxCookieSize)) == chunks {
+			return token
+		}
+	}
+	t.Fatalf("Could not generate a token needing %d chunks", chunks)
+	return ""
+}
+
+// replayCookies returns a new request carrying every cookie set on the recorder, so that a
+// saved session can be loaded as the browser would send it back.
+func replayCookies(rr *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			req.AddCookie(cookie)
+		}
+	}
+	return req
+}
+
+// chunkCookies returns the MaxAge of each chunk cookie of the given token set on the recorder.
+func chunkCookies(rr *httptest.ResponseRecorder, baseName string) map[string]int {
+	maxAge := make(map[string]int)
+	for _, cookie := range rr.Result().Cookies() {
+		if strings.HasPrefix(cookie.Name, baseName+"_") {
+			maxAge[cookie.Name] = cookie.MaxAge
+		}
+	}
+	return maxAge
+}
+
+func TestSyntheticLargeToken(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+
+	for _, chunks := range []int{2, 3, 5} {
+		t.Run(fmt.Sprintf("%d chunks", chunks), func(t *testing.T) {
+			token := largeTestToken(t, sm, chunks)
+			req := httptest.NewRequest("GET", "/test", nil)
+			rr := httptest.NewRecorder()
+
+			session, _ := sm.GetSession(req)
+			if err := session.SetAccessToken(token); err != nil {
+				t.Fatalf("Failed to set access token: %v", err)
+			}
+			if len(session.accessTokenChunks) != chunks || chunkCount(session.accessSession) != chunks {
+				t.Fatalf("Expected %d chunks, got %d (recorded count %d)", chunks, len(session.accessTokenChunks), chunkCount(session.accessSession))
+			}
+			if err := session.Save(req, rr); err != nil {
+				t.Fatalf("Failed to save session: %v", err)
+			}
+			if got := len(chunkCookies(rr, accessTokenCookie)); got != chunks {
+				t.Fatalf("Expected %d access token chunk cookies, got %d", chunks, got)
+			}
+
+			newReq := replayCookies(rr)
+			newSession, err := sm.GetSession(newReq)
+			if err != nil {
+				t.Fatalf("Failed to get session: %v", err)
+			}
+			if got := newSession.GetAccessToken(); got != token {
+				t.Fatalf("Reassembled token differs from the original (len %d, want %d)", len(got), len(token))
+			}
+
+			expireRr := httptest.NewRecorder()
+			newSession.expireAccessTokenChunks(expireRr)
+			expired := chunkCookies(expireRr, accessTokenCookie)
+			if len(expired) != chunks {
+				t.Fatalf("Expected %d expired chunk cookies, got %d", chunks, len(expired))
+			}
+			for name, age := range expired {
+				if age >= 0 {
+					t.Errorf("Chunk %s was not expired (MaxAge=%d)", name, age)
+				}
+			}
+		})
+	}
+}
+
+func TestCookiePath(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	sm.SetCookiePath("/app")
+	req := httptest.NewRequest("GET", "/app/page", nil)
+	rr := httptest.NewRecorder()
+
+	session, _ := sm.GetSession(req)
+	session.SetAuthenticated(true)
+	session.SetAccessToken(largeTestToken(t, sm, 3))
+	session.SetRefreshToken("refresh-token")
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	newReq := replayCookies(rr)
+	newSession, err := sm.GetSession(newReq)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	expireRr := httptest.NewRecorder()
+	newSession.expireAccessTokenChunks(expireRr)
+	clearRr := httptest.NewRecorder()
+	if err := newSession.Clear(newReq, clearRr); err != nil {
+		t.Fatalf("Failed to clear session: %v", err)
+	}
+
+	for name, recorder := range map[string]*httptest.ResponseRecorder{"save": rr, "expire": expireRr, "clear": clearRr} {
+		cookies := recorder.Result().Cookies()
+		if len(cookies) == 0 {
+			t.Errorf("Expected %s to set cookies", name)
+		}
+		for _, cookie := range cookies {
+			if cookie.Path != "/app" {
+				t.Errorf("Expected %s to set cookie %s with path /app, got %q", name, cookie.Name, cookie.Path)
+			}
+		}
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	sm.SetCompressionThreshold(100)
+	shortToken := "short-access-token"
+	longToken := strings.Repeat("long-refresh-token.", 10)
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+	session, _ := sm.GetSession(req)
+	session.SetAccessToken(longToken)
+	session.SetAccessToken(shortToken)
+	session.SetRefreshToken(longToken)
+
+	if compressed, _ := session.accessSession.Values["compressed"].(bool); compressed {
+		t.Error("Expected a token below the threshold to be stored uncompressed")
+	}
+	if _, ok := session.accessSession.Values["codec"]; ok {
+		t.Error("Expected the codec marker to be removed for an uncompressed token")
+	}
+	if stored, _ := session.accessSession.Values["token"].(string); stored != shortToken {
+		t.Errorf("Expected the token to be stored as is, got %q", stored)
+	}
+	if compressed, _ := session.refreshSession.Values["compressed"].(bool); !compressed {
+		t.Error("Expected a token above the threshold to be stored compressed")
+	}
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	newSession, err := sm.GetSession(replayCookies(rr))
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if got := newSession.GetAccessToken(); got != shortToken {
+		t.Errorf("Expected access token %q, got %q", shortToken, got)
+	}
+	if got := newSession.GetRefreshToken(); got != longToken {
+		t.Errorf("Expected refresh token %q, got %q", longToken, got)
+	}
+}
+
+func TestAccessTokenExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+
+	for _, layout := range []string{SessionLayoutSplit, SessionLayoutSingle} {
+		t.Run(layout, func(t *testing.T) {
+			sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+			sm.SetSessionLayout(layout)
+			token := "small-access-token"
+			if layout == SessionLayoutSplit {
+				token = largeTestToken(t, sm, 3)
+			}
+			req := httptest.NewRequest("GET", "/test", nil)
+			rr := httptest.NewRecorder()
+
+			session, _ := sm.GetSession(req)
+			if session.AccessTokenExpiresIn() != 0 || !session.GetAccessTokenExpiry().IsZero() {
+				t.Fatal("Expected no access token expiry on a new session")
+			}
+			session.SetAccessToken(token)
+			session.SetAccessTokenExpiry(expiresAt)
+			if err := session.Save(req, rr); err != nil {
+				t.Fatalf("Failed to save session: %v", err)
+			}
+			if cookies := rr.Result().Cookies(); layout == SessionLayoutSingle && (len(cookies) != 1 || cookies[0].Name != packedCookieName) {
+				t.Fatalf("Expected a single %s cookie, got %d cookies", packedCookieName, len(cookies))
+			}
+
+			newSession, err := sm.GetSession(replayCookies(rr))
+			if err != nil {
+				t.Fatalf("Failed to get session: %v", err)
+			}
+			if got := newSession.GetAccessTokenExpiry(); !got.Equal(expiresAt) {
+				t.Errorf("Expected access token expiry %v, got %v", expiresAt, got)
+			}
+			if remaining := newSession.AccessTokenExpiresIn(); remaining <= 9*time.Minute || remaining > 10*time.Minute {
+				t.Errorf("Expected about 10 minutes of remaining lifetime, got %v", remaining)
+			}
+			if got := newSession.GetAccessToken(); got != token {
+				t.Errorf("Expected the token to survive alongside its expiry")
+			}
+
+			newSession.SetAccessTokenExpiry(time.Now().Add(-time.Minute))
+			if remaining := newSession.AccessTokenExpiresIn(); remaining != 0 {
+				t.Errorf("Expected no remaining lifetime for an expired token, got %v", remaining)
+			}
+			newSession.SetAccessTokenExpiry(time.Time{})
+			if !newSession.GetAccessTokenExpiry().IsZero() {
+				t.Error("Expected the zero time to remove the recorded expiry")
+			}
+		})
+	}
+}
+
+func TestSessionMarshalBinary(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetAuthenticated(true)
+	session.SetEmail("user@example.com")
+	session.SetCSRF("test-csrf")
+	session.SetNonce("test-nonce")
+	session.SetCodeVerifier("test-verifier")
+	accessToken := largeTestToken(t, sm, 3)
+	session.SetAccessToken(accessToken)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	session.SetAccessTokenExpiry(expiresAt)
+	session.SetRefreshToken("test-refresh-token")
+	session.SetUserInfo(map[string]interface{}{"groups": []interface{}{"admins"}, "level": 3})
+
+	data, err := session.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal session: %v", err)
+	}
+
+	// Restore into a session of a manager with a different key and cookie layout
+	other, _ := NewSessionManager("fedcba9876543210fedcba9876543210", true, NewLogger("debug"))
+	other.SetSessionLayout(SessionLayoutSingle)
+	restoreReq := httptest.NewRequest("GET", "/test", nil)
+	restored, _ := other.GetSession(restoreReq)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Failed to unmarshal session: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	if err := restored.Save(restoreReq, rr); err != nil {
+		t.Fatalf("Failed to save restored session: %v", err)
+	}
+
+	loaded, err := other.GetSession(replayCookies(rr))
+	if err != nil {
+		t.Fatalf("Failed to load restored session: %v", err)
+	}
+	if !loaded.GetAuthenticated() || loaded.GetEmail() != "user@example.com" {
+		t.Errorf("Expected an authenticated session of user@example.com, got %v %q", loaded.GetAuthenticated(), loaded.GetEmail())
+	}
+	if loaded.GetCSRF() != "test-csrf" || loaded.GetNonce() != "test-nonce" || loaded.GetCodeVerifier() != "test-verifier" {
+		t.Errorf("Expected the pending login state to be restored, got %q %q %q", loaded.GetCSRF(), loaded.GetNonce(), loaded.GetCodeVerifier())
+	}
+	if loaded.GetAccessToken() != accessToken || loaded.GetRefreshToken() != "test-refresh-token" {
+		t.Error("Expected the tokens to be restored")
+	}
+	if !loaded.GetAccessTokenExpiry().Equal(expiresAt) {
+		t.Errorf("Expected access token expiry %v, got %v", expiresAt, loaded.GetAccessTokenExpiry())
+	}
+	if info := loaded.GetUserInfo(); fmt.Sprint(info["groups"]) != "[admins]" || fmt.Sprint(info["level"]) != "3" {
+		t.Errorf("Expected the UserInfo claims to be restored, got %v", info)
+	}
+
+	if err := restored.UnmarshalBinary([]byte(`{"version":99}`)); err == nil {
+		t.Error("Expected an unsupported version to be rejected")
+	}
+}
+
+func TestRegenerateID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/test", nil)
+
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	session, err := sm.GetSession(req)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+
+	if err := session.SetAuthenticated(true); err != nil {
+		t.Fatalf("Failed to set authenticated: %v", err)
+	}
+	session.SetEmail("user@example.com")
+	session.SetAccessToken("access_token")
+	session.SetRefreshToken("refresh_token")
+	originalID := session.mainSession.ID
+
+	if err := session.RegenerateID(); err != nil {
+		t.Fatalf("RegenerateID failed: %v", err)
+	}
+
+	if session.mainSession.ID == "" || session.mainSession.ID == originalID {
+		t.Errorf("Expected a fresh session ID, got %q (was %q)", session.mainSession.ID, originalID)
+	}
+	if !session.GetAuthenticated() {
+		t.Error("Authentication status not preserved after RegenerateID")
+	}
+	if email := session.GetEmail(); email != "user@example.com" {
+		t.Errorf("Expected email to be preserved, got %s", email)
+	}
+	if token := session.GetAccessToken(); token != "access_token" {
+		t.Errorf("Expected access token to be preserved, got %s", token)
+	}
+	if token := session.GetRefreshToken(); token != "refresh_token" {
+		t.Errorf("Expected refresh token to be preserved, got %s", token)
+	}
+}
+
+func TestGetSessionContextCancelled(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/test", nil).WithContext(ctx)
+
+	session, err := sm.GetSessionContext(ctx, req)
+	if err == nil {
+		t.Fatal("Expected an error for a cancelled context, got nil")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error to wrap context.Canceled, got %v", err)
+	}
+	if session != nil {
+		t.Error("Expected no session to be returned for a cancelled context")
+	}
+
+	// A live context must still load the session normally
+	liveReq := httptest.NewRequest("GET", "/test", nil)
+	if _, err := sm.GetSessionContext(context.Background(), liveReq); err != nil {
+		t.Errorf("Expected session load to succeed with a live context, got %v", err)
+	}
+}
+
+func TestCompressionCodecs(t *testing.T) {
+	token := strings.Repeat("eyJhbGciOiJSUzI1NiJ9.", 200)
+
+	for _, name := range []string{"gzip", "deflate"} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := getCompressionCodec(name)
+			if err != nil {
+				t.Fatalf("Failed to get codec %s: %v", name, err)
+			}
+			compressed := compressTokenWithCodec(codec, token)
+			if len(compressed) >= len(token) {
+				t.Errorf("Expected %s to shrink the token, got %d >= %d", name, len(compressed), len(token))
+			}
+			if got := decompressTokenWithCodec(codec, compressed); got != token {
+				t.Errorf("%s round trip failed", name)
+			}
+		})
+	}
+
+	t.Run("Codec marker survives config change", func(t *testing.T) {
+		sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+		if err := sm.SetCompressionCodec("deflate"); err != nil {
+			t.Fatalf("Failed to select deflate codec: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/test", nil)
+		rr := httptest.NewRecorder()
+		session, _ := sm.GetSession(req)
+		session.SetAccessToken(token)
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Failed to save session: %v", err)
+		}
+
+		// Switch back to gzip; the stored token must still decode with deflate
+		if err := sm.SetCompressionCodec("gzip"); err != nil {
+			t.Fatalf("Failed to select gzip codec: %v", err)
+		}
+		newReq := httptest.NewRequest("GET", "/test", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			newReq.AddCookie(cookie)
+		}
+		newSession, err := sm.GetSession(newReq)
+		if err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+		if got := newSession.GetAccessToken(); got != token {
+			t.Errorf("Access token not preserved across codec change: got len=%d, want len=%d", len(got), len(token))
+		}
+	})
+
+	t.Run("Uncompressed base64 value returned unchanged", func(t *testing.T) {
+		// Valid base64 that is not a gzip stream must not be decoded into garbage
+		plain := "dGhpcyBpcyBub3QgZ3ppcA=="
+		if got := decompressToken(plain); got != plain {
+			t.Errorf("Expected non-gzip value to be returned unchanged, got %q", got)
+		}
+		if _, err := (gzipCodec{}).Decompress([]byte("plain")); !errors.Is(err, errNotGzip) {
+			t.Errorf("Expected errNotGzip, got %v", err)
+		}
+	})
+
+	t.Run("Unknown codec rejected", func(t *testing.T) {
+		sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+		if err := sm.SetCompressionCodec("zstd"); err == nil {
+			t.Error("Expected error selecting an unregistered codec")
+		}
+		if err := RegisterCompressionCodec(gzipCodec{}); err == nil {
+			t.Error("Expected error registering a duplicate codec")
+		}
+	})
+}
+
+func TestChunkReassemblyWithMissingChunk(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+
+	session, _ := sm.GetSession(req)
+	token := generateRandomString(8000)
+	session.SetAccessToken(token)
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	// Replay every cookie except the second access token chunk
+	missing := accessTokenCookie + "_1"
+	found := false
+	newReq := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == missing {
+			found = true
+			continue
+		}
+		newReq.AddCookie(cookie)
+	}
+	if !found {
+		t.Fatalf("Expected token to be split into at least 3 chunks")
+	}
+
+	newSession, err := sm.GetSession(newReq)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if got := newSession.GetAccessToken(); got != "" {
+		t.Errorf("Expected empty access token when a chunk is missing, got len=%d", len(got))
+	}
+}
+
+func TestChunkCountDetectsMissingTrailingChunk(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+
+	session, _ := sm.GetSession(req)
+	session.SetAccessToken(generateRandomString(8000))
+	count := chunkCount(session.accessSession)
+	if count < 2 {
+		t.Fatalf("Expected chunk count of at least 2, got %d", count)
+	}
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	// Drop the last chunk: without a recorded count the truncation would go unnoticed
+	missing := fmt.Sprintf("%s_%d", accessTokenCookie, count-1)
+	newReq := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name != missing {
+			newReq.AddCookie(cookie)
+		}
+	}
+
+	newSession, err := sm.GetSession(newReq)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if got := chunkCount(newSession.accessSession); got != count {
+		t.Errorf("Expected stored chunk count %d, got %d", count, got)
+	}
+	if got := newSession.GetAccessToken(); got != "" {
+		t.Errorf("Expected empty access token when the last chunk is missing, got len=%d", len(got))
+	}
+
+	// A token that fits in a single cookie clears the recorded count
+	newSession.SetAccessToken("small_token")
+	if got := chunkCount(newSession.accessSession); got != -1 {
+		t.Errorf("Expected no chunk count for unchunked token, got %d", got)
+	}
+}
+
+func TestSaveExpiresOrphanedChunks(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+
+	session, _ := sm.GetSession(req)
+	session.SetAccessToken(generateRandomString(8000))
+	oldCount := chunkCount(session.accessSession)
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	newReq := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		newReq.AddCookie(cookie)
+	}
+	newSession, err := sm.GetSession(newReq)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+
+	// Replace with a token needing fewer chunks and save without explicit expiry
+	newSession.SetAccessToken(generateRandomString(2500))
+	newCount := chunkCount(newSession.accessSession)
+	if newCount >= oldCount {
+		t.Fatalf("Expected new token to need fewer chunks: old=%d new=%d", oldCount, newCount)
+	}
+	newRr := httptest.NewRecorder()
+	if err := newSession.Save(newReq, newRr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	maxAge := make(map[string]int)
+	for _, cookie := range newRr.Result().Cookies() {
+		maxAge[cookie.Name] = cookie.MaxAge
+	}
+	for i := 0; i < oldCount; i++ {
+		name := fmt.Sprintf("%s_%d", accessTokenCookie, i)
+		age, ok := maxAge[name]
+		if !ok {
+			t.Errorf("Expected Set-Cookie for chunk %s", name)
+			continue
+		}
+		if i < newCount && age < 0 {
+			t.Errorf("Chunk %s is still in use but was expired", name)
+		}
+		if i >= newCount && age >= 0 {
+			t.Errorf("Orphaned chunk %s was not expired (MaxAge=%d)", name, age)
+		}
+	}
+}
+
+func TestCorruptCompressedTokenTreatedAsAbsent(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetAccessToken(strings.Repeat("access-token.", 20))
+	session.SetRefreshToken(strings.Repeat("refresh-token.", 20))
+
+	// Not base64, and base64 that is not valid gzip.
+	session.accessSession.Values["token"] = "!!not-base64!!"
+	session.refreshSession.Values["token"] = base64.StdEncoding.EncodeToString([]byte("not gzip data"))
+
+	if got := session.GetAccessToken(); got != "" {
+		t.Errorf("Expected a corrupt compressed access token to be treated as absent, got %q", got)
+	}
+	if got := session.GetRefreshToken(); got != "" {
+		t.Errorf("Expected a corrupt compressed refresh token to be treated as absent, got %q", got)
+	}
+
+	if _, err := decompressTokenStrict(gzipCodec{}, "!!not-base64!!"); !errors.Is(err, ErrDecompressionFailed) {
+		t.Errorf("Expected ErrDecompressionFailed, got %v", err)
+	}
+	if got := decompressToken("plain-token"); got != "plain-token" {
+		t.Errorf("Expected an uncompressed value to be returned as is, got %q", got)
+	}
+}
+
+func TestTokenShrinkExpiresStaleChunks(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+
+	session, _ := sm.GetSession(req)
+	session.SetAccessToken(largeTestToken(t, sm, 5))
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	// The browser also holds a chunk left over from an even larger token, beyond the
+	// recorded count and behind a gap
+	newReq := replayCookies(rr)
+	newReq.AddCookie(&http.Cookie{Name: accessTokenCookie + "_7", Value: "stale"})
+	newSession, err := sm.GetSession(newReq)
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	token := largeTestToken(t, sm, 2)
+	newSession.SetAccessToken(token)
+	newRr := httptest.NewRecorder()
+	if err := newSession.Save(newReq, newRr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	chunks := chunkCookies(newRr, accessTokenCookie)
+	for _, i := range []int{0, 1} {
+		if age, ok := chunks[fmt.Sprintf("%s_%d", accessTokenCookie, i)]; !ok || age < 0 {
+			t.Errorf("Expected chunk %d to be written, got MaxAge=%d (set=%v)", i, age, ok)
+		}
+	}
+	for _, i := range []int{2, 3, 4, 7} {
+		if age, ok := chunks[fmt.Sprintf("%s_%d", accessTokenCookie, i)]; !ok || age >= 0 {
+			t.Errorf("Expected stale chunk %d to be expired, got MaxAge=%d (set=%v)", i, age, ok)
+		}
+	}
+
+	finalSession, err := sm.GetSession(replayCookies(newRr))
+	if err != nil {
+		t.Fatalf("Failed to get session: %v", err)
+	}
+	if got := finalSession.GetAccessToken(); got != token {
+		t.Errorf("Reassembled token differs from the new token (len %d, want %d)", len(got), len(token))
+	}
+}
+
+func TestClearExpiresAllChunkCookies(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+
+	session, _ := sm.GetSession(req)
+	session.SetAuthenticated(true)
+	session.SetAccessToken(largeTestToken(t, sm, 3))
+	session.SetRefreshToken(largeTestToken(t, sm, 2))
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Failed to save session: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		get  func(r *http.Request) *SessionData
+	}{
+		{"loaded session", func(r *http.Request) *SessionData {
+			loaded, _ := sm.GetSession(r)
+			return loaded
+		}},
+		{"pooled session", func(r *http.Request) *SessionData {
+			return sm.sessionPool.New().(*SessionData)
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// A stale chunk from an earlier token lies beyond the recorded count
+			logoutReq := replayCookies(rr)
+			logoutReq.AddCookie(&http.Cookie{Name: accessTokenCookie + "_5", Value: "stale"})
+			clearRr := httptest.NewRecorder()
+			if err := tc.get(logoutReq).Clear(logoutReq, clearRr); err != nil {
+				t.Fatalf("Failed to clear session: %v", err)
+			}
+
+			expired := make(map[string]int)
+			for _, cookie := range clearRr.Result().Cookies() {
+				expired[cookie.Name] = cookie.MaxAge
+			}
+			for _, name := range []string{accessTokenCookie + "_0", accessTokenCookie + "_1", accessTokenCookie + "_2", accessTokenCookie + "_5", refreshTokenCookie + "_0", refreshTokenCookie + "_1"} {
+				if age, ok := expired[name]; !ok || age >= 0 {
+					t.Errorf("Expected chunk cookie %s to be expired, got MaxAge=%d (set=%v)", name, age, ok)
+				}
+			}
+			if _, ok := expired[mainCookieName]; !ok {
+				t.Errorf("Expected the main session cookie to be cleared")
+			}
+		})
+	}
+}
+
+func TestDisableChunking(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	sm.SetDisableChunking(true)
+	req := httptest.NewRequest("GET", "/test", nil)
+
+	session, _ := sm.GetSession(req)
+	if err := session.SetAccessToken("small_token"); err != nil {
+		t.Fatalf("Expected small token to be accepted, got: %v", err)
+	}
+
+	err := session.SetAccessToken(generateRandomString(8000))
+	if !errors.Is(err, ErrTokenTooLarge) {
+		t.Fatalf("Expected ErrTokenTooLarge, got: %v", err)
+	}
+	if err := session.SetRefreshToken(generateRandomString(8000)); !errors.Is(err, ErrTokenTooLarge) {
+		t.Fatalf("Expected ErrTokenTooLarge for refresh token, got: %v", err)
+	}
+	if len(session.accessTokenChunks) != 0 {
+		t.Errorf("Expected no chunks when chunking is disabled, got %d", len(session.accessTokenChunks))
+	}
+	if got := session.GetAccessToken(); got != "small_token" {
+		t.Errorf("Expected rejected token to leave the previous one in place, got %q", got)
+	}
+}
+
+func TestCookieBudget(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+
+	session, _ := sm.GetSession(req)
+	session.SetAccessToken(generateRandomString(8000))
+
+	sm.SetCookieBudget(1000, false)
+	if err := session.Save(req, httptest.NewRecorder()); err != nil {
+		t.Errorf("Expected unenforced budget to only warn, got: %v", err)
+	}
+
+	sm.SetCookieBudget(1000, true)
+	session.SetAccessToken(generateRandomString(8000))
+	rr := httptest.NewRecorder()
+	if err := session.Save(req, rr); !errors.Is(err, ErrCookieBudgetExceeded) {
+		t.Errorf("Expected ErrCookieBudgetExceeded, got: %v", err)
+	}
+	if len(rr.Result().Cookies()) != 0 {
+		t.Errorf("Expected no cookies to be written when budget is exceeded")
+	}
+
+	sm.SetCookieBudget(100000, true)
+	if err := session.Save(req, httptest.NewRecorder()); err != nil {
+		t.Errorf("Expected save within budget to succeed, got: %v", err)
+	}
+}
+
+// TestMemoryTokenStorage verifies that with memory token storage tokens round-trip through
+// the server-side cache, only a session ID reaches the cookies, and Clear drops the tokens.
+func TestMemoryTokenStorage(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	if err := sm.SetTokenStorage(TokenStorageMemory); err != nil {
+		t.Fatalf("Failed to enable memory token storage: %v", err)
+	}
+	defer sm.SetTokenStorage(TokenStorageCookie)
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	accessToken := generateRandomString(8000)
+	refreshToken := generateRandomString(100)
+	if err := session.SetAccessToken(accessToken); err != nil {
+		t.Fatalf("SetAccessToken failed: %v", err)
+	}
+	if err := session.SetRefreshToken(refreshToken); err != nil {
+		t.Fatalf("SetRefreshToken failed: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if strings.HasPrefix(cookie.Name, accessTokenCookie+"_") {
+			t.Errorf("Expected no chunk cookies with memory storage, got %s", cookie.Name)
+		}
+	}
+
+	newReq := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		newReq.AddCookie(cookie)
+	}
+	loaded, err := sm.GetSession(newReq)
+	if err != nil {
+		t.Fatalf("Failed to load session: %v", err)
+	}
+	if got := loaded.GetAccessToken(); got != accessToken {
+		t.Errorf("Access token mismatch: got %d bytes, want %d", len(got), len(accessToken))
+	}
+	if got := loaded.GetRefreshToken(); got != refreshToken {
+		t.Errorf("Refresh token mismatch: got %q, want %q", got, refreshToken)
+	}
+
+	sid, _ := loaded.mainSession.Values["token_sid"].(string)
+	loaded.Clear(newReq, httptest.NewRecorder())
+	if _, found := sm.tokenStore.Get(storedTokenKey(sid, "access")); found {
+		t.Error("Expected access token to be removed from the store after Clear")
+	}
+}
+
+// TestUndecodableSessionCookie verifies that corrupt cookies and cookies written with a
+// different key are treated as an empty session instead of failing the session load.
+func TestUndecodableSessionCookie(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	otherSM, _ := NewSessionManager("fedcba9876543210fedcba9876543210", true, NewLogger("debug"))
+
+	// Produce a valid, authenticated session under a different key.
+	otherReq := httptest.NewRequest("GET", "/test", nil)
+	otherSession, _ := otherSM.GetSession(otherReq)
+	otherSession.SetAuthenticated(true)
+	rr := httptest.NewRecorder()
+	otherSession.Save(otherReq, rr)
+
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+	}{
+		{
+			name:    "Corrupt Cookie",
+			cookies: []*http.Cookie{{Name: mainCookieName, Value: "not-a-valid-cookie"}},
+		},
+		{
+			name:    "Rotated Key",
+			cookies: rr.Result().Cookies(),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			for _, cookie := range tc.cookies {
+				req.AddCookie(cookie)
+			}
+			session, err := sm.GetSession(req)
+			if err != nil {
+				t.Fatalf("Expected undecodable cookie to be discarded, got error: %v", err)
+			}
+			if session.GetAuthenticated() {
+				t.Error("Expected session from undecodable cookie to be unauthenticated")
+			}
+			if err := session.Save(req, httptest.NewRecorder()); err != nil {
+				t.Errorf("Expected fresh session to save, got: %v", err)
+			}
+		})
+	}
+}
+
+// TestSessionTimestamps verifies the session creation and expiry accessors.
+func TestSessionTimestamps(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+
+	if !session.CreatedAt().IsZero() || !session.ExpiresAt().IsZero() {
+		t.Errorf("Expected zero timestamps for an unauthenticated session, got %v and %v", session.CreatedAt(), session.ExpiresAt())
+	}
+
+	before := time.Now().Truncate(time.Second)
+	if err := session.SetAuthenticated(true); err != nil {
+		t.Fatalf("SetAuthenticated failed: %v", err)
+	}
+	createdAt := session.CreatedAt()
+	if createdAt.Before(before) || createdAt.After(time.Now()) {
+		t.Errorf("Expected creation time around now, got %v", createdAt)
+	}
+	if got := session.ExpiresAt().Sub(createdAt); got != absoluteSessionTimeout {
+		t.Errorf("Expected expiry %v after creation, got %v", absoluteSessionTimeout, got)
+	}
+}
+
+// TestDebugCookies verifies that DebugCookies reports every cookie Save would write,
+// including the chunk cookies of a large token.
+func TestDebugCookies(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetAccessToken(generateRandomString(8000))
+
+	infos := session.DebugCookies()
+	chunks := 0
+	for _, info := range infos {
+		if info.Size <= len(info.Name) {
+			t.Errorf("Expected a positive encoded size for %s, got %d", info.Name, info.Size)
+		}
+		if info.ChunkIndex >= 0 {
+			chunks++
+			if want := fmt.Sprintf("%s_%d", accessTokenCookie, info.ChunkIndex); info.Name != want {
+				t.Errorf("Expected chunk %d to be named %s, got %s", info.ChunkIndex, want, info.Name)
+			}
+		}
+	}
+	if want := len(session.accessTokenChunks); chunks != want || want == 0 {
+		t.Errorf("Expected %d chunk cookies, got %d", want, chunks)
+	}
+	if len(infos) != 3+chunks {
+		t.Errorf("Expected main, access and refresh cookies plus chunks, got %d entries", len(infos))
+	}
+}
+
+// TestSameSitePerCookieType verifies that the main and token cookies use their own SameSite modes.
+func TestSameSitePerCookieType(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	sm.SetSameSite(http.SameSiteNoneMode, http.SameSiteStrictMode)
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetAuthenticated(true)
+	session.SetAccessToken(generateRandomString(8000))
+	rr := httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	for _, cookie := range rr.Result().Cookies() {
+		want := http.SameSiteStrictMode
+		if cookie.Name == mainCookieName {
+			want = http.SameSiteNoneMode
+		}
+		if cookie.SameSite != want {
+			t.Errorf("Expected cookie %s to have SameSite %v, got %v", cookie.Name, want, cookie.SameSite)
+		}
+	}
+}
+
+// TestSessionUserID verifies that GetUserID returns the stored identifier and falls back to
+// the email address for sessions created before the identifier was stored.
+func TestSessionUserID(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+
+	session.SetEmail("user@example.com")
+	if got := session.GetUserID(); got != "user@example.com" {
+		t.Errorf("Expected user ID to fall back to the email, got %q", got)
+	}
+
+	session.SetUserID("user-123")
+	if got := session.GetUserID(); got != "user-123" {
+		t.Errorf("Expected user ID %q, got %q", "user-123", got)
+	}
+	if got := session.GetEmail(); got != "user@example.com" {
+		t.Errorf("Expected email to be kept, got %q", got)
+	}
+}
+
+// memoryStore is a minimal in-memory sessions.Store used to test SessionManager without
+// cookies. All requests share the same sessions, keyed by name.
+type memoryStore struct {
+	values map[string]map[interface{}]interface{}
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{values: make(map[string]map[interface{}]interface{})}
+}
+
+func (m *memoryStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(m, name)
+}
+
+func (m *memoryStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(m, name)
+	session.Options = &sessions.Options{Path: "/"}
+	session.IsNew = true
+	if values, ok := m.values[name]; ok {
+		for k, v := range values {
+			session.Values[k] = v
+		}
+		session.IsNew = false
+	}
+	return session, nil
+}
+
+func (m *memoryStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options != nil && session.Options.MaxAge < 0 {
+		delete(m.values, session.Name())
+		return nil
+	}
+	values := make(map[interface{}]interface{}, len(session.Values))
+	for k, v := range session.Values {
+		values[k] = v
+	}
+	m.values[session.Name()] = values
+	return nil
+}
+
+// TestSessionManagerWithStore verifies that a session manager backed by an injected store
+// round-trips chunked tokens without any cookies being exchanged.
+func TestSessionManagerWithStore(t *testing.T) {
+	if _, err := NewSessionManagerWithStore(nil, "0123456789abcdef0123456789abcdef", true, NewLogger("debug")); err == nil {
+		t.Error("Expected an error for a nil store")
+	}
+
+	store := newMemoryStore()
+	sm, err := NewSessionManagerWithStore(store, "0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+
+	token := generateRandomString(8000)
+	session, _ := sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+	if err := session.SetAccessToken(token); err != nil {
+		t.Fatalf("SetAccessToken failed: %v", err)
+	}
+	if err := session.Save(httptest.NewRequest("GET", "/test", nil), httptest.NewRecorder()); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, ok := store.values[accessTokenCookie+"_1"]; !ok {
+		t.Errorf("Expected the token to be split into chunks, got sessions %v", len(store.values))
+	}
+
+	reloaded, err := sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+	if err != nil {
+		t.Fatalf("Failed to reload session: %v", err)
+	}
+	if got := reloaded.GetAccessToken(); got != token {
+		t.Errorf("Expected reloaded token of length %d, got length %d", len(token), len(got))
+	}
+}
+
+// TestInvalidateUser verifies that InvalidateUser terminates the user's existing sessions
+// while leaving other users and later logins untouched.
+func TestInvalidateUser(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	newSession := func(userID string) *SessionData {
+		session, _ := sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+		if err := session.SetAuthenticated(true); err != nil {
+			t.Fatalf("SetAuthenticated failed: %v", err)
+		}
+		session.SetUserID(userID)
+		return session
+	}
+
+	session := newSession("alice")
+	other := newSession("bob")
+	if sm.IsInvalidated(session) {
+		t.Fatal("Expected the session to be valid before invalidation")
+	}
+
+	sm.InvalidateUser("alice")
+	if !sm.IsInvalidated(session) {
+		t.Error("Expected alice's session to be invalidated")
+	}
+	if sm.IsInvalidated(other) {
+		t.Error("Expected bob's session to be unaffected")
+	}
+
+	later := newSession("alice")
+	later.mainSession.Values["created_at"] = time.Now().Add(2 * time.Second).Unix()
+	if sm.IsInvalidated(later) {
+		t.Error("Expected a session authenticated after the invalidation to be valid")
+	}
+}
+
+func TestSingleSessionPerUser(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	login := func(userID string) *SessionData {
+		session, _ := sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+		if err := session.SetAuthenticated(true); err != nil {
+			t.Fatalf("SetAuthenticated failed: %v", err)
+		}
+		session.SetUserID(userID)
+		if err := sm.BindUserSession(session); err != nil {
+			t.Fatalf("BindUserSession failed: %v", err)
+		}
+		return session
+	}
+
+	// Without the option sessions are not bound and coexist
+	first, second := login("alice"), login("alice")
+	if first.GetSessionID() != "" || sm.IsInvalidated(first) || sm.IsInvalidated(second) {
+		t.Fatal("Expected concurrent sessions to be allowed by default")
+	}
+
+	sm.SetSingleSessionPerUser(true)
+	first = login("alice")
+	other := login("bob")
+	if sm.IsInvalidated(first) {
+		t.Fatal("Expected the user's only session to be valid")
+	}
+	if sid, ok := sm.UserSession("alice"); !ok || sid != first.GetSessionID() {
+		t.Errorf("Expected alice to be bound to %q, got %q", first.GetSessionID(), sid)
+	}
+
+	second = login("alice")
+	if !sm.IsInvalidated(first) {
+		t.Error("Expected a new login to evict the user's previous session")
+	}
+	if sm.IsInvalidated(second) || sm.IsInvalidated(other) {
+		t.Error("Expected the new session and other users' sessions to stay valid")
+	}
+
+	// The session ID survives a round trip through the cookies
+	rr := httptest.NewRecorder()
+	if err := second.Save(httptest.NewRequest("GET", "/test", nil), rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	reloaded, _ := sm.GetSession(req)
+	if reloaded.GetSessionID() != second.GetSessionID() || sm.IsInvalidated(reloaded) {
+		t.Error("Expected the reloaded session to remain the user's current session")
+	}
+}
+
+// TestCookieEncoding verifies that JSON cookie encoding produces smaller cookies, keeps
+// integer values readable, and still decodes cookies written with gob.
+func TestCookieEncoding(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	if err := sm.SetCookieEncoding("msgpack"); err == nil {
+		t.Error("Expected an error for an unknown cookie encoding")
+	}
+
+	token := generateRandomString(500)
+	nextAttempt := time.Now().Add(time.Minute).Truncate(time.Second)
+	saveSession := func(session *SessionData) *httptest.ResponseRecorder {
+		if err := session.SetAuthenticated(true); err != nil {
+			t.Fatalf("SetAuthenticated failed: %v", err)
+		}
+		session.SetEmail("user@example.com")
+		session.SetRefreshFailures(2, nextAttempt)
+		if err := session.SetAccessToken(token); err != nil {
+			t.Fatalf("SetAccessToken failed: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		if err := session.Save(httptest.NewRequest("GET", "/test", nil), rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		return rr
+	}
+	loadSession := func(rr *httptest.ResponseRecorder) *SessionData {
+		req := httptest.NewRequest("GET", "/test", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		session, err := sm.GetSession(req)
+		if err != nil {
+			t.Fatalf("Failed to load session: %v", err)
+		}
+		return session
+	}
+	mainCookieSize := func(rr *httptest.ResponseRecorder) int {
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == mainCookieName {
+				return len(cookie.Value)
+			}
+		}
+		t.Fatal("Main session cookie not written")
+		return 0
+	}
+
+	gobSession, _ := sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+	gobCookies := saveSession(gobSession)
+
+	if err := sm.SetCookieEncoding(CookieEncodingJSON); err != nil {
+		t.Fatalf("Failed to enable JSON cookie encoding: %v", err)
+	}
+	if legacy := loadSession(gobCookies); !legacy.GetAuthenticated() || legacy.GetEmail() != "user@example.com" {
+		t.Error("Expected a gob-encoded session to remain readable after switching to JSON")
+	}
+
+	jsonSession, _ := sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+	jsonCookies := saveSession(jsonSession)
+	if gobSize, jsonSize := mainCookieSize(gobCookies), mainCookieSize(jsonCookies); jsonSize >= gobSize {
+		t.Errorf("Expected JSON main cookie to be smaller than gob, got %d >= %d bytes", jsonSize, gobSize)
+	}
+
+	loaded := loadSession(jsonCookies)
+	if !loaded.GetAuthenticated() {
+		t.Error("Expected JSON-encoded session to be authenticated")
+	}
+	if loaded.CreatedAt().IsZero() {
+		t.Error("Expected creation time to survive JSON encoding")
+	}
+	if count, next := loaded.GetRefreshFailures(); count != 2 || !next.Equal(nextAttempt) {
+		t.Errorf("Expected 2 refresh failures until %v, got %d until %v", nextAttempt, count, next)
+	}
+	if got := loaded.GetAccessToken(); got != token {
+		t.Errorf("Expected access token of length %d, got length %d", len(token), len(got))
+	}
+}
+
+// TestSessionTimeoutJitter verifies that jittered sessions get a timeout within the configured
+// range that is stored in the session and used consistently by the expiry checks.
+func TestSessionTimeoutJitter(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	sm.SetSessionTimeoutJitter(0.1)
+
+	minTimeout := time.Duration(float64(absoluteSessionTimeout) * 0.9)
+	maxTimeout := time.Duration(float64(absoluteSessionTimeout) * 1.1)
+	timeouts := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		session, _ := sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+		if err := session.SetAuthenticated(true); err != nil {
+			t.Fatalf("SetAuthenticated failed: %v", err)
+		}
+		timeout := session.ExpiresAt().Sub(session.CreatedAt())
+		if timeout < minTimeout || timeout > maxTimeout {
+			t.Errorf("Expected timeout within ±10%% of %v, got %v", absoluteSessionTimeout, timeout)
+		}
+		timeouts[timeout] = true
+	}
+	if len(timeouts) < 2 {
+		t.Error("Expected session timeouts to vary")
+	}
+
+	// A session past its own jittered timeout is expired even though the default has not passed
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+	session, _ := sm.GetSession(req)
+	session.SetAuthenticated(true)
+	session.mainSession.Values["session_timeout"] = int64(minTimeout.Seconds())
+	session.mainSession.Values["created_at"] = time.Now().Add(-minTimeout - time.Minute).Unix()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.MaxAge < int(maxTimeout.Seconds()) {
+			t.Errorf("Expected cookie %s to outlive the longest session, got MaxAge %d", cookie.Name, cookie.MaxAge)
+		}
+	}
+	newReq := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		newReq.AddCookie(cookie)
+	}
+	if _, err := sm.GetSession(newReq); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expected the jittered timeout to expire the session, got %v", err)
+	}
+}
+
+func TestRememberMeSessions(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	rememberMeTimeout := 30 * 24 * time.Hour
+	sm.SetRememberMeTimeout(rememberMeTimeout)
+
+	saveSession := func(remember bool) (*SessionData, []*http.Cookie) {
+		req := httptest.NewRequest("GET", "/test", nil)
+		rr := httptest.NewRecorder()
+		session, _ := sm.GetSession(req)
+		session.SetRememberMe(remember)
+		if err := session.SetAuthenticated(true); err != nil {
+			t.Fatalf("SetAuthenticated failed: %v", err)
+		}
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		return session, rr.Result().Cookies()
+	}
+
+	// A remembered session gets the longer timeout and persistent cookies
+	session, cookies := saveSession(true)
+	if timeout := session.ExpiresAt().Sub(session.CreatedAt()); timeout != rememberMeTimeout {
+		t.Errorf("Expected remembered session timeout %v, got %v", rememberMeTimeout, timeout)
+	}
+	for _, cookie := range cookies {
+		if cookie.MaxAge < int(rememberMeTimeout.Seconds()) {
+			t.Errorf("Expected cookie %s to persist for the remember-me lifetime, got MaxAge %d", cookie.Name, cookie.MaxAge)
+		}
+	}
+
+	// A remembered session outlives the default timeout
+	req := httptest.NewRequest("GET", "/test", nil)
+	rr := httptest.NewRecorder()
+	session, _ = sm.GetSession(req)
+	session.SetRememberMe(true)
+	session.SetAuthenticated(true)
+	session.mainSession.Values["created_at"] = time.Now().Add(-absoluteSessionTimeout - time.Hour).Unix()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	newReq := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		newReq.AddCookie(cookie)
+	}
+	if _, err := sm.GetSession(newReq); err != nil {
+		t.Errorf("Expected remembered session to outlive the default timeout, got %v", err)
+	}
+
+	// Other sessions keep the default timeout with browser-session cookies
+	session, cookies = saveSession(false)
+	if timeout := session.ExpiresAt().Sub(session.CreatedAt()); timeout != absoluteSessionTimeout {
+		t.Errorf("Expected default session timeout %v, got %v", absoluteSessionTimeout, timeout)
+	}
+	for _, cookie := range cookies {
+		if cookie.MaxAge != 0 || !cookie.Expires.IsZero() {
+			t.Errorf("Expected cookie %s to be a browser-session cookie, got MaxAge %d", cookie.Name, cookie.MaxAge)
+		}
+	}
+}
+
+// TestCookieStatsHook verifies that Save reports the token chunk counts and the total size
+// of the cookies it wrote.
+func TestCookieStatsHook(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	var reported []CookieStats
+	sm.SetCookieStatsHook(func(stats CookieStats) {
+		reported = append(reported, stats)
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetEmail("user@example.com")
+	session.SetAccessToken(generateRandomString(8000))
+	session.SetRefreshToken(generateRandomString(100))
+	rr := httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if len(reported) != 1 {
+		t.Fatalf("Expected one stats report, got %d", len(reported))
+	}
+	stats := reported[0]
+	if stats.AccessChunks < 2 {
+		t.Errorf("Expected the access token to need several chunks, got %d", stats.AccessChunks)
+	}
+	if stats.RefreshChunks != 0 {
+		t.Errorf("Expected the refresh token to fit in one cookie, got %d chunks", stats.RefreshChunks)
+	}
+	written := 0
+	for _, cookie := range rr.Result().Cookies() {
+		written += len(cookie.Name) + 1 + len(cookie.Value)
+	}
+	if stats.TotalBytes != written {
+		t.Errorf("Expected total size %d, got %d", written, stats.TotalBytes)
+	}
+
+	// A failed save is not reported
+	sm.SetCookieBudget(1000, true)
+	session.SetAccessToken(generateRandomString(8000))
+	if err := session.Save(req, httptest.NewRecorder()); err == nil {
+		t.Fatal("Expected save over the enforced budget to fail")
+	}
+	if len(reported) != 1 {
+		t.Errorf("Expected no stats report for a failed save, got %d reports", len(reported))
+	}
+}
+
+func TestSaveMain(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetAccessToken(generateRandomString(8000))
+	session.SetRefreshToken(generateRandomString(100))
+	rr := httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	next := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+	session, _ = sm.GetSession(next)
+	accessToken := session.GetAccessToken()
+	session.SetIncomingPath("/after-login")
+	rr = httptest.NewRecorder()
+	if err := session.SaveMain(next, rr); err != nil {
+		t.Fatalf("SaveMain failed: %v", err)
+	}
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != mainCookieName {
+		t.Fatalf("Expected only the main session cookie to be written, got %d cookies", len(cookies))
+	}
+
+	// The token cookies held by the client still combine with the new main cookie
+	last := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range next.Cookies() {
+		if cookie.Name != mainCookieName {
+			last.AddCookie(cookie)
+		}
+	}
+	last.AddCookie(cookies[0])
+	session, _ = sm.GetSession(last)
+	if got := session.GetIncomingPath(); got != "/after-login" {
+		t.Errorf("Expected incoming path to be saved, got %q", got)
+	}
+	if accessToken == "" || session.GetAccessToken() != accessToken {
+		t.Error("Expected the access token to be unchanged")
+	}
+}
+
+func TestSaveSkipsUnchangedSessions(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetEmail("user@example.com")
+	session.SetAccessToken(generateRandomString(8000))
+	session.SetRefreshToken(generateRandomString(100))
+	rr := httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	next := httptest.NewRequest("GET", "/test", nil)
+	for _, cookie := range rr.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+
+	// A request that changes nothing writes no cookies
+	session, _ = sm.GetSession(next)
+	session.GetAccessToken()
+	session.SetIncomingHeaders("", nil)
+	rr = httptest.NewRecorder()
+	if err := session.Save(next, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if cookies := rr.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected no cookies for an unchanged session, got %d", len(cookies))
+	}
+
+	// Changing the main session re-sends only the main cookie
+	session, _ = sm.GetSession(next)
+	session.SetEmail("other@example.com")
+	rr = httptest.NewRecorder()
+	if err := session.Save(next, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if cookies := rr.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != mainCookieName {
+		t.Errorf("Expected only the main cookie to be written, got %d cookies", len(cookies))
+	}
+
+	// Changing the refresh token leaves the access token chunks alone
+	session, _ = sm.GetSession(next)
+	session.SetRefreshToken(generateRandomString(100))
+	rr = httptest.NewRecorder()
+	if err := session.Save(next, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name != refreshTokenCookie {
+			t.Errorf("Expected only the refresh token cookie to be written, got %s", cookie.Name)
+		}
+	}
+}
+
+func TestTokenTooLargeHook(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	sm.SetDisableChunking(true)
+	type rejection struct {
+		tokenType string
+		size      int
+	}
+	var rejections []rejection
+	sm.SetTokenTooLargeHook(func(session *SessionData, tokenType string, size int) {
+		if session == nil {
+			t.Error("Expected the hook to receive the session")
+		}
+		rejections = append(rejections, rejection{tokenType, size})
+	})
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	if err := session.SetAccessToken("small_token"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rejections) != 0 {
+		t.Fatalf("Expected no hook call for a token that fits, got %v", rejections)
+	}
+
+	if err := session.SetAccessToken(generateRandomString(8000)); !errors.Is(err, ErrTokenTooLarge) {
+		t.Fatalf("Expected ErrTokenTooLarge, got: %v", err)
+	}
+	if err := session.SetRefreshToken(generateRandomString(8000)); !errors.Is(err, ErrTokenTooLarge) {
+		t.Fatalf("Expected ErrTokenTooLarge, got: %v", err)
+	}
+	if len(rejections) != 2 || rejections[0].tokenType != "access" || rejections[1].tokenType != "refresh" {
+		t.Fatalf("Expected access and refresh rejections, got %v", rejections)
+	}
+	if rejections[0].size <= maxCookieSize {
+		t.Errorf("Expected the reported size to exceed %d, got %d", maxCookieSize, rejections[0].size)
+	}
+}
+
+func TestSessionKeyProvider(t *testing.T) {
+	oldKey := []byte("0123456789abcdef0123456789abcdef")
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	sm, _ := NewSessionManager(string(oldKey), true, NewLogger("debug"))
+
+	keys := [][]byte{oldKey}
+	if err := sm.SetKeyProvider(func() [][]byte { return keys }, time.Nanosecond); err != nil {
+		t.Fatalf("SetKeyProvider failed: %v", err)
+	}
+
+	// save writes a session for the given user and returns its cookies
+	save := func(userID string) []*http.Cookie {
+		req := httptest.NewRequest("GET", "/test", nil)
+		session, _ := sm.GetSession(req)
+		session.SetUserID(userID)
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		return rr.Result().Cookies()
+	}
+	// load reads the user of a session from its cookies
+	load := func(cookies []*http.Cookie) string {
+		req := httptest.NewRequest("GET", "/test", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		session, err := sm.GetSession(req)
+		if err != nil {
+			t.Fatalf("GetSession failed: %v", err)
+		}
+		return session.GetUserID()
+	}
+
+	oldCookies := save("old-user")
+
+	// After rotation new sessions use the new key and old sessions stay readable
+	keys = [][]byte{newKey, oldKey}
+	newCookies := save("new-user")
+	if got := load(oldCookies); got != "old-user" {
+		t.Errorf("Expected a session signed with the previous key to stay valid, got %q", got)
+	}
+
+	// Once the previous key is dropped only sessions signed with the new key are accepted
+	keys = [][]byte{newKey}
+	if got := load(newCookies); got != "new-user" {
+		t.Errorf("Expected a session signed with the new key to be valid, got %q", got)
+	}
+	if got := load(oldCookies); got != "" {
+		t.Errorf("Expected a session signed with a retired key to be discarded, got %q", got)
+	}
+
+	// An unusable key set is ignored
+	keys = [][]byte{[]byte("short")}
+	if got := load(newCookies); got != "new-user" {
+		t.Errorf("Expected the current keys to be kept, got %q", got)
+	}
+	if err := sm.SetKeyProvider(func() [][]byte { return nil }, 0); err == nil {
+		t.Error("Expected a provider without keys to be rejected")
+	}
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session-keys")
+	content := "fedcba9876543210fedcba9876543210\n\n  0123456789abcdef0123456789abcdef  \n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	keys := FileKeyProvider(path, NewLogger("debug"))()
+	if len(keys) != 2 || string(keys[0]) != "fedcba9876543210fedcba9876543210" || string(keys[1]) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("Unexpected keys: %q", keys)
+	}
+	if keys := FileKeyProvider(path+".missing", NewLogger("debug"))(); keys != nil {
+		t.Errorf("Expected no keys for a missing file, got %q", keys)
+	}
+}
+
+func TestLoginTimeout(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	sm.SetLoginTimeout(10 * time.Minute)
+
+	// startLogin saves a session holding a pending login started at issuedAt
+	startLogin := func(issuedAt time.Time) *http.Request {
+		req := httptest.NewRequest("GET", "/test", nil)
+		session, _ := sm.GetSession(req)
+		session.SetCSRF("test-state")
+		session.setMainValue("csrf_issued_at", issuedAt.Unix())
+		session.SetNonce("test-nonce")
+		session.SetCodeVerifier("test-verifier")
+		session.SetIncomingPath("/after-login")
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		next := httptest.NewRequest("GET", "/test", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			next.AddCookie(cookie)
+		}
+		return next
+	}
+
+	session, _ := sm.GetSession(startLogin(time.Now().Add(-time.Minute)))
+	if session.GetCSRF() != "test-state" || session.GetNonce() != "test-nonce" || session.GetCodeVerifier() != "test-verifier" {
+		t.Error("Expected a recent pending login to be kept")
+	}
+
+	session, _ = sm.GetSession(startLogin(time.Now().Add(-time.Hour)))
+	if session.GetCSRF() != "" || session.GetNonce() != "" || session.GetCodeVerifier() != "" {
+		t.Error("Expected an abandoned pending login to be discarded")
+	}
+	if !session.GetCSRFIssuedAt().IsZero() {
+		t.Error("Expected the issue time of an abandoned login to be discarded")
+	}
+	if got := session.GetIncomingPath(); got != "/after-login" {
+		t.Errorf("Expected unrelated session values to be kept, got incoming path %q", got)
+	}
+}
+
+func TestSingleCookieLayout(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	if err := sm.SetSessionLayout("triple"); err == nil {
+		t.Error("Expected an unknown session layout to be rejected")
+	}
+	if err := sm.SetSessionLayout(SessionLayoutSingle); err != nil {
+		t.Fatalf("SetSessionLayout failed: %v", err)
+	}
+
+	// reload builds a request carrying the live cookies written to rr
+	reload := func(rr *httptest.ResponseRecorder) *http.Request {
+		req := httptest.NewRequest("GET", "/test", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.MaxAge >= 0 {
+				req.AddCookie(cookie)
+			}
+		}
+		return req
+	}
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetEmail("user@example.com")
+	session.SetAccessToken("small-access-token")
+	session.SetRefreshToken("small-refresh-token")
+	session.SetProviderAccessToken("small-provider-token")
+	rr := httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != packedCookieName {
+		t.Fatalf("Expected a single %s cookie, got %d cookies", packedCookieName, len(cookies))
+	}
+
+	req = reload(rr)
+	session, _ = sm.GetSession(req)
+	if session.GetEmail() != "user@example.com" || session.GetAccessToken() != "small-access-token" || session.GetRefreshToken() != "small-refresh-token" {
+		t.Error("Expected the session to be restored from the single cookie")
+	}
+	if got := session.GetProviderAccessToken(); got != "small-provider-token" {
+		t.Errorf("Expected the provider access token to be restored from the single cookie, got %q", got)
+	}
+
+	// A session that no longer fits falls back to split cookies and expires the single cookie
+	largeToken := generateRandomString(8000)
+	session.SetAccessToken(largeToken)
+	rr = httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	expiredPacked := false
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == packedCookieName && cookie.MaxAge < 0 {
+			expiredPacked = true
+		}
+	}
+	if !expiredPacked {
+		t.Error("Expected the single cookie to be expired after falling back to split cookies")
+	}
+	session, _ = sm.GetSession(reload(rr))
+	if session.GetEmail() != "user@example.com" || session.GetAccessToken() != largeToken || session.GetRefreshToken() != "small-refresh-token" {
+		t.Error("Expected the session to be restored from split cookies")
+	}
+	if got := session.GetProviderAccessToken(); got != "small-provider-token" {
+		t.Errorf("Expected the provider access token to move to its own cookie, got %q", got)
+	}
+
+	// Sessions written with the single cookie stay readable after switching back to split
+	packed := httptest.NewRecorder()
+	small, _ := sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+	small.SetEmail("other@example.com")
+	small.SetAccessToken("small-access-token")
+	if err := small.Save(req, packed); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	sm.SetSessionLayout(SessionLayoutSplit)
+	session, _ = sm.GetSession(reload(packed))
+	if session.GetEmail() != "other@example.com" || session.GetAccessToken() != "small-access-token" {
+		t.Error("Expected a single-cookie session to be readable with the split layout")
+	}
+}
+
+func TestClaimsCompression(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+
+	// reload builds a request carrying the live cookies written to rr
+	reload := func(rr *httptest.ResponseRecorder) *http.Request {
+		req := httptest.NewRequest("GET", "/test", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.MaxAge >= 0 {
+				req.AddCookie(cookie)
+			}
+		}
+		return req
+	}
+	groupClaims := func(count int, name func(i int) string) map[string]interface{} {
+		groups := make([]interface{}, count)
+		for i := range groups {
+			groups[i] = name(i)
+		}
+		return map[string]interface{}{"email": "user@example.com", "groups": groups}
+	}
+
+	// Group-heavy claims are compressed into the main cookie
+	req := httptest.NewRequest("GET", "/test", nil)
+	session, _ := sm.GetSession(req)
+	session.SetUserInfo(groupClaims(60, func(i int) string { return fmt.Sprintf("engineering-platform-team-%d", i) }))
+	if compressed, _ := session.mainSession.Values["compressed_claims"].(bool); !compressed {
+		t.Error("Expected group-heavy claims to be compressed")
+	}
+	rr := httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if len(session.claimsChunks) != 0 {
+		t.Errorf("Expected compressed claims to fit in the main cookie, got %d chunks", len(session.claimsChunks))
+	}
+	session, _ = sm.GetSession(reload(rr))
+	if groups, _ := session.GetUserInfo()["groups"].([]interface{}); len(groups) != 60 {
+		t.Errorf("Expected 60 groups to be restored, got %d", len(groups))
+	}
+
+	// Claims that stay too large after compression are chunked like tokens
+	req = reload(rr)
+	session, _ = sm.GetSession(req)
+	session.SetUserInfo(groupClaims(200, func(i int) string { return generateRandomString(30) }))
+	rr = httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	chunks := 0
+	for _, cookie := range rr.Result().Cookies() {
+		if strings.HasPrefix(cookie.Name, claimsCookie+"_") {
+			chunks++
+		}
+	}
+	if chunks < 2 {
+		t.Fatalf("Expected the claims to be split into chunk cookies, got %d", chunks)
+	}
+	req = reload(rr)
+	session, _ = sm.GetSession(req)
+	if groups, _ := session.GetUserInfo()["groups"].([]interface{}); len(groups) != 200 {
+		t.Errorf("Expected 200 groups to be reassembled from %d chunks, got %d", chunks, len(groups))
+	}
+
+	// Smaller claims expire the chunks left over from the previous ones
+	session.SetUserInfo(map[string]interface{}{"email": "user@example.com"})
+	rr = httptest.NewRecorder()
+	if err := session.Save(req, rr); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	expired := 0
+	for _, cookie := range rr.Result().Cookies() {
+		if strings.HasPrefix(cookie.Name, claimsCookie+"_") && cookie.MaxAge < 0 {
+			expired++
+		}
+	}
+	if expired != chunks {
+		t.Errorf("Expected %d claims chunks to be expired, got %d", chunks, expired)
+	}
+	session, _ = sm.GetSession(reload(rr))
+	if email, _ := session.GetUserInfo()["email"].(string); email != "user@example.com" {
+		t.Errorf("Expected the new claims to be restored, got %v", session.GetUserInfo())
+	}
+
+	// With compression disabled the claims are stored as plain JSON
+	sm.SetCompressClaims(false)
+	session, _ = sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+	session.SetUserInfo(map[string]interface{}{"email": "user@example.com"})
+	if blob, _ := session.mainSession.Values["userinfo"].(string); blob != `{"email":"user@example.com"}` {
+		t.Errorf("Expected uncompressed claims, got %q", blob)
+	}
+
+	// Sessions written before the compressed_claims flag hold compressed claims
+	session, _ = sm.GetSession(httptest.NewRequest("GET", "/test", nil))
+	session.mainSession.Values["userinfo"] = compressToken(`{"email":"legacy@example.com"}`)
+	if email, _ := session.GetUserInfo()["email"].(string); email != "legacy@example.com" {
+		t.Errorf("Expected legacy compressed claims to be read, got %v", session.GetUserInfo())
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	sm, _ := NewSessionManager("0123456789abcdef0123456789abcdef", true, NewLogger("debug"))
+	sm.SetIdleTimeout(30 * time.Minute)
+
+	// saved builds a request carrying the session saved after applying change
+	saved := func(change func(session *SessionData)) *http.Request {
+		req := httptest.NewRequest("GET", "/test", nil)
+		session, _ := sm.GetSession(req)
+		if err := session.SetAuthenticated(true); err != nil {
+			t.Fatalf("SetAuthenticated failed: %v", err)
+		}
+		change(session)
+		rr := httptest.NewRecorder()
+		if err := session.Save(req, rr); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		next := httptest.NewRequest("GET", "/test", nil)
+		for _, cookie := range rr.Result().Cookies() {
+			next.AddCookie(cookie)
+		}
+		return next
+	}
+
+	session, err := sm.GetSession(saved(func(session *SessionData) {}))
+	if err != nil {
+		t.Fatalf("Expected a fresh session to be valid, got %v", err)
+	}
+	if until := time.Until(session.IdleExpiresAt()); until < 29*time.Minute || until > 30*time.Minute {
+		t.Errorf("Expected the session to expire in 30 minutes when idle, got %v", until)
+	}
+	if session.MarkActive() {
+		t.Error("Expected recent activity not to be recorded again")
+	}
+
+	session, err = sm.GetSession(saved(func(session *SessionData) {
+		session.mainSession.Values["last_activity"] = time.Now().Add(-10 * time.Minute).Unix()
+	}))
+	if err != nil {
+		t.Fatalf("Expected a session idle for less than the timeout to be valid, got %v", err)
+	}
+	if !session.MarkActive() {
+		t.Error("Expected stale activity to be recorded")
+	}
+	if until := time.Until(session.IdleExpiresAt()); until < 29*time.Minute {
+		t.Errorf("Expected activity to restart the idle timeout, got %v left", until)
+	}
+
+	_, err = sm.GetSession(saved(func(session *SessionData) {
+		session.mainSession.Values["last_activity"] = time.Now().Add(-31 * time.Minute).Unix()
+	}))
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expected an idle session to be expired, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package traefikoidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sessionStateVersion identifies the format written by SessionData.MarshalBinary.
+const sessionStateVersion = 1
+
+// cookieOnlySessionKeys are main session values that only describe how the session is laid
+// out in cookies or in the server-side token store. They are left out of the serialized
+// state, which carries the tokens and claims themselves instead.
+var cookieOnlySessionKeys = map[string]bool{
+	"userinfo":           true,
+	"compressed_claims":  true,
+	"claims_chunk_count": true,
+	"token_sid":          true,
+}
+
+// sessionState is the storage-independent form of a session written by MarshalBinary.
+type sessionState struct {
+	// Version is the format version, sessionStateVersion when written.
+	Version int `json:"version"`
+
+	// Values holds the main session values: authentication flag, user identity, timestamps,
+	// and the CSRF token, nonce and PKCE verifier of a pending login.
+	Values map[string]interface{} `json:"values,omitempty"`
+
+	// AccessToken is the session's token, uncompressed.
+	AccessToken string `json:"accessToken,omitempty"`
+
+	// AccessTokenExpiry is the provider-reported expiry of the access token in Unix seconds.
+	AccessTokenExpiry int64 `json:"accessTokenExpiry,omitempty"`
+
+	// RefreshToken is the session's refresh token, uncompressed.
+	RefreshToken string `json:"refreshToken,omitempty"`
+
+	// ProviderAccessToken is the provider's OAuth access token, uncompressed, when stored.
+	ProviderAccessToken string `json:"providerAccessToken,omitempty"`
+
+	// UserInfo holds the claims returned by the UserInfo endpoint at login.
+	UserInfo map[string]interface{} `json:"userInfo,omitempty"`
+}
+
+// MarshalBinary serializes the session state (authentication flag, user identity, tokens,
+// UserInfo claims, timestamps, CSRF token, nonce and PKCE verifier) in a stable JSON format
+// that does not depend on the cookie encoding or layout, so that an external store can
+// persist the session and restore it with UnmarshalBinary.
+//
+// Returns:
+//   - The serialized session.
+//   - An error if a session value cannot be serialized.
+func (sd *SessionData) MarshalBinary() ([]byte, error) {
+	if sd.mainSession == nil {
+		return nil, errors.New("session is not loaded")
+	}
+	state := sessionState{
+		Version:             sessionStateVersion,
+		Values:              make(map[string]interface{}, len(sd.mainSession.Values)),
+		AccessToken:         sd.GetAccessToken(),
+		RefreshToken:        sd.GetRefreshToken(),
+		ProviderAccessToken: sd.GetProviderAccessToken(),
+		UserInfo:            sd.GetUserInfo(),
+	}
+	if expiresAt := sd.GetAccessTokenExpiry(); !expiresAt.IsZero() {
+		state.AccessTokenExpiry = expiresAt.Unix()
+	}
+	for k, v := range sd.mainSession.Values {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to serialize session: unsupported key type %T", k)
+		}
+		if !cookieOnlySessionKeys[key] {
+			state.Values[key] = v
+		}
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize session: %w", err)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary restores a session serialized by MarshalBinary, replacing the current
+// session state. The session must have been obtained from a SessionManager (e.g. with
+// GetSession), whose settings decide how the restored tokens and claims are stored; the
+// restored session is written to cookies by the next Save.
+//
+// Parameters:
+//   - data: The serialized session.
+//
+// Returns:
+//   - An error if the data is not a serialized session of a supported version, or a token
+//     cannot be stored.
+func (sd *SessionData) UnmarshalBinary(data []byte) error {
+	if sd.mainSession == nil || sd.accessSession == nil || sd.refreshSession == nil || sd.providerSession == nil {
+		return errors.New("session is not loaded")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var state sessionState
+	if err := decoder.Decode(&state); err != nil {
+		return fmt.Errorf("failed to deserialize session: %w", err)
+	}
+	if state.Version != sessionStateVersion {
+		return fmt.Errorf("unsupported serialized session version %d", state.Version)
+	}
+
+	for k := range sd.mainSession.Values {
+		delete(sd.mainSession.Values, k)
+	}
+	sd.mainDirty = true
+	for k, v := range state.Values {
+		if !cookieOnlySessionKeys[k] {
+			sd.setMainValue(k, jsonSessionValue(v))
+		}
+	}
+
+	if err := sd.SetAccessToken(state.AccessToken); err != nil {
+		return err
+	}
+	var expiresAt time.Time
+	if state.AccessTokenExpiry > 0 {
+		expiresAt = time.Unix(state.AccessTokenExpiry, 0)
+	}
+	sd.SetAccessTokenExpiry(expiresAt)
+	if err := sd.SetRefreshToken(state.RefreshToken); err != nil {
+		return err
+	}
+	if state.ProviderAccessToken != "" || sd.GetProviderAccessToken() != "" {
+		if err := sd.SetProviderAccessToken(state.ProviderAccessToken); err != nil {
+			return err
+		}
+	}
+	sd.SetUserInfo(state.UserInfo)
+	return nil
+}
@@ -2,6 +2,7 @@ package traefikoidc
 
 import (
 	"fmt"
+	htmltemplate "html/template"
 	"io"
 	"log"
 	"net/http"
@@ -22,6 +23,53 @@ type TemplatedHeader struct {
 	Value string `json:"value"`
 }
 
+// LogoutParams describes the query parameters of the provider's logout endpoint, for
+// providers that deviate from OpenID Connect RP-Initiated Logout. Empty names use the
+// standard parameter names.
+type LogoutParams struct {
+	// IDTokenHintParam is the name of the ID token hint parameter (default "id_token_hint")
+	IDTokenHintParam string `json:"idTokenHintParam"`
+
+	// PostLogoutRedirectParam is the name of the post-logout redirect parameter
+	// (default "post_logout_redirect_uri"; e.g. "returnTo" for Auth0, "logout_uri" for Cognito)
+	PostLogoutRedirectParam string `json:"postLogoutRedirectParam"`
+
+	// ClientIDParam is the name of the client ID parameter (default "client_id")
+	ClientIDParam string `json:"clientIdParam"`
+
+	// IncludeClientID adds the client ID to the logout request, as Auth0 and Cognito require
+	IncludeClientID bool `json:"includeClientId"`
+
+	// OmitIDTokenHint leaves out the ID token hint for providers that do not accept it
+	OmitIDTokenHint bool `json:"omitIdTokenHint"`
+}
+
+// RouteRule is an authorization rule enforced, after authentication, on requests whose path
+// is PathPrefix or lies below it. Paths are cleaned before matching and compared by whole
+// segments, so "/admin" covers "/admin/users" but not "/administrator". When several rules
+// match, the one with the longest prefix applies. Requests that do not meet the rule are
+// denied with 403 Forbidden.
+type RouteRule struct {
+	// PathPrefix selects the requests the rule applies to (must start with /)
+	PathPrefix string `json:"pathPrefix"`
+
+	// RequiredRoles requires the user to have at least one of these roles or groups
+	RequiredRoles []string `json:"requiredRoles"`
+
+	// RequiredScopes requires the token to have been granted all of these scopes
+	RequiredScopes []string `json:"requiredScopes"`
+
+	// RequiredClaims requires each claim to equal the given value, or to contain it for
+	// array claims
+	RequiredClaims map[string]string `json:"requiredClaims"`
+}
+
+// AuthErrorHandler writes the response for an error the provider returned to the callback.
+// The code and description come from the error and error_description parameters, with
+// non-printable characters removed and the length capped; they must still be escaped when
+// rendered.
+type AuthErrorHandler func(code, description string, w http.ResponseWriter, r *http.Request)
+
 // Config holds the configuration for the OIDC middleware.
 // It provides all necessary settings to configure OpenID Connect authentication
 // with various providers like Auth0, Logto, or any standard OIDC provider.
@@ -55,6 +103,7 @@ type Config struct {
 
 	// Scopes defines the OAuth 2.0 scopes to request (optional)
 	// Defaults to ["openid", "profile", "email"] if not provided
+	// "openid" is always requested (added if missing) and duplicate entries are ignored.
 	Scopes []string `json:"scopes"`
 
 	// LogLevel sets the logging verbosity (optional)
@@ -94,9 +143,54 @@ type Config struct {
 	// Default: "/"
 	PostLogoutRedirectURI string `json:"postLogoutRedirectURI"`
 
+	// HTTPClient through OnAuthError hold Go values (clients, hooks, stores) and can only be set
+	// when the middleware is embedded in Go code; the Traefik configuration cannot express them.
+
 	// HTTPClient allows customizing the HTTP client used for OIDC operations (optional)
 	HTTPClient *http.Client
 
+	// ProviderTransport replaces the transport of the default HTTP client used for every request
+	// to the provider (discovery, JWKS, token, revocation), e.g. to supply a custom TLS
+	// configuration (optional). It only applies to provider-bound requests and is ignored when
+	// HTTPClient is set.
+	ProviderTransport *http.Transport `json:"-"`
+
+	// AuditHook receives a structured event for every login success or failure, logout, token
+	// refresh and access denial, for shipping to a SIEM (optional). Events never contain raw
+	// tokens. It is called synchronously and must be safe for concurrent use.
+	AuditHook func(event AuditEvent) `json:"-"`
+
+	// PendingAuthStore keeps pending authorization requests (nonce, PKCE verifier, incoming
+	// path) keyed by their state, so a callback can be completed by a different replica than
+	// the one that started the login (optional). Use a store shared between replicas when
+	// sessions are kept server-side per instance.
+	PendingAuthStore PendingAuthStore `json:"-"`
+
+	// CookieStatsHook receives the number of access and refresh token chunk cookies and the
+	// total size of the session cookies every time a session save writes cookies, for
+	// metrics and alerting on cookie bloat (optional). It is called synchronously and must
+	// be safe for concurrent use.
+	CookieStatsHook func(stats CookieStats) `json:"-"`
+
+	// OnTokenTooLarge is called when a token is rejected because it does not fit in the
+	// session cookies (with disableChunking), with the session, the token type ("access" or
+	// "refresh") and the compressed token size, so that operators can observe and respond to
+	// oversize tokens (optional). It is called synchronously and must be safe for concurrent
+	// use.
+	OnTokenTooLarge func(session *SessionData, tokenType string, size int) `json:"-"`
+
+	// SessionKeyProvider supplies the session keys, newest first, in place of
+	// SessionEncryptionKey (optional). It is consulted once a minute so rotated keys are picked
+	// up without a restart; the first key signs new sessions and all keys are accepted when
+	// reading existing ones.
+	SessionKeyProvider func() [][]byte `json:"-"`
+
+	// OnAuthError renders the response when the provider returns an error to the callback,
+	// e.g. error=access_denied when the user declined consent, so that applications can show
+	// a friendly page (optional). By default a 403 error page showing the escaped description
+	// is returned.
+	OnAuthError AuthErrorHandler `json:"-"`
+
 	// RefreshGracePeriodSeconds defines how many seconds before a token expires
 	// the plugin should attempt to refresh it proactively (optional)
 	// Default: 60
@@ -112,6 +206,560 @@ type Config struct {
 	//	[{Name: "X-Forwarded-Email", Value: "{{.Claims.email}}"}]
 	//	[{Name: "Authorization", Value: "Bearer {{.AccessToken}}"}]
 	Headers []TemplatedHeader `json:"headers"`
+
+	// CompressionCodec selects the algorithm used to compress tokens stored in cookies (optional)
+	// Valid values: "gzip", "deflate", or any codec registered with RegisterCompressionCodec
+	// (e.g. zstd when the middleware is embedded in a compiled binary)
+	// Default: "gzip"
+	CompressionCodec string `json:"compressionCodec"`
+
+	// DisableChunking rejects tokens that do not fit in a single cookie instead of
+	// splitting them across multiple chunk cookies (optional)
+	// When enabled, logins and refreshes producing oversized tokens fail loudly, signalling
+	// that a server-side session store is required.
+	// Default: false
+	DisableChunking bool `json:"disableChunking"`
+
+	// MaxCookieBytes is the maximum combined size in bytes of all session cookies (optional)
+	// Before writing, the serialized size of the main, token and chunk cookies is summed and a
+	// warning with a per-cookie breakdown is logged if it exceeds this budget. Set to 0 to disable.
+	// Default: 0
+	MaxCookieBytes int `json:"maxCookieBytes"`
+
+	// EnforceCookieBudget makes the session save fail when MaxCookieBytes is exceeded,
+	// instead of only logging a warning (optional)
+	// Default: false
+	EnforceCookieBudget bool `json:"enforceCookieBudget"`
+
+	// AccessTokenHeader is the name of an outbound header that receives the provider's OAuth
+	// access token when forwarding authenticated requests upstream (optional). The token is kept
+	// in its own session cookie only when this is set, and replaced on every refresh.
+	// "Authorization" is sent as "Bearer <token>"; any other header receives the raw token.
+	// A value sent by the client is removed; only a header template may set the header instead.
+	// Default: "" (disabled)
+	AccessTokenHeader string `json:"accessTokenHeader"`
+
+	// ForwardedToken selects which token AccessTokenHeader carries (optional)
+	// Valid values:
+	// - "access": the provider's OAuth access token, for upstreams calling further APIs
+	// - "id": the verified ID token, for upstreams parsing the identity claims themselves
+	// Default: "access"
+	ForwardedToken string `json:"forwardedToken"`
+
+	// UnauthenticatedMode selects how requests without a valid session are answered (optional)
+	// Valid values:
+	// - "redirect": redirect to the provider's login page
+	// - "json-401": respond 401 with {"error":"unauthenticated","login_url":"..."} for SPAs
+	// - "custom": respond 401 with the page rendered from UnauthenticatedTemplate
+	// Default: "redirect"
+	UnauthenticatedMode string `json:"unauthenticatedMode"`
+
+	// UnauthenticatedTemplate is the HTML template served in "custom" unauthenticated mode
+	// (required when UnauthenticatedMode is "custom")
+	// The login URL is available as {{.LoginURL}}.
+	UnauthenticatedTemplate string `json:"unauthenticatedTemplate"`
+
+	// SilentRenewPath is the path of an endpoint that silently renews the session with an
+	// authorization request using prompt=none, meant to be loaded in a hidden iframe (optional)
+	// The callback then returns a page posting {type: "oidc-silent-renew", result: "success"}
+	// or the provider's error (e.g. "login_required") to the parent window.
+	// Default: "" (disabled)
+	SilentRenewPath string `json:"silentRenewPath"`
+
+	// RefreshBackoffSeconds is the delay after a failed token refresh before another refresh
+	// is attempted for the same session; it doubles with each consecutive failure, up to
+	// 5 minutes (optional). Set to 0 to retry on every request.
+	// Default: 10
+	RefreshBackoffSeconds int `json:"refreshBackoffSeconds"`
+
+	// MaxRefreshFailures is the number of consecutive failed refreshes after which the session
+	// is cleared and the user must log in again (optional). Set to 0 to never force re-login.
+	// Default: 5
+	MaxRefreshFailures int `json:"maxRefreshFailures"`
+
+	// Resources lists the target resource URIs (RFC 8707) sent as "resource" parameters in the
+	// authorization and token requests, so the access token is issued for those APIs (optional)
+	// Each value must be an absolute URI without a fragment.
+	// Default: none
+	Resources []string `json:"resources"`
+
+	// Audience is sent as the "audience" parameter in the authorization and token requests,
+	// as required by providers such as Auth0 to issue access tokens for an API (optional)
+	// Default: ""
+	Audience string `json:"audience"`
+
+	// TokenStorage selects where access and refresh tokens are kept (optional)
+	// "cookie" stores them compressed in (chunked) session cookies. "memory" keeps only a random
+	// session ID in the cookie and stores the encrypted tokens in an in-process cache; tokens
+	// are then lost on restart and not shared between instances.
+	// Default: "cookie"
+	TokenStorage string `json:"tokenStorage"`
+
+	// TokenRequestParams are extra form fields added to every token endpoint request, for
+	// providers that require non-standard parameters such as "tenant" or "organization" (optional)
+	// Reserved OAuth 2.0 parameters (grant_type, client_id, client_secret, code, refresh_token,
+	// code_verifier, redirect_uri) cannot be overridden.
+	// Default: none
+	TokenRequestParams map[string]string `json:"tokenRequestParams"`
+
+	// AllowedSigningAlgorithms lists the JWS "alg" values accepted in token headers (optional)
+	// Tokens using any other algorithm, including "none", are rejected before signature verification.
+	// Supported values: RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512.
+	// Default: ["RS256", "ES256"]
+	AllowedSigningAlgorithms []string `json:"allowedSigningAlgorithms"`
+
+	// PreserveFragment serves browsers a small script on the login redirect that captures the
+	// URL fragment (e.g. "#/some/route"), which is never sent to the server, so users return to
+	// the exact client-side route after login (optional). Only applies to the redirect mode.
+	// Default: false
+	PreserveFragment bool `json:"preserveFragment"`
+
+	// RefreshOnlyWhenForwarded skips proactive token refresh unless the access token is forwarded
+	// upstream, via accessTokenHeader or a header template using {{.AccessToken}} (optional).
+	// Expired tokens are still refreshed. This reduces token endpoint traffic for backends that
+	// rely only on the session.
+	// Default: false
+	RefreshOnlyWhenForwarded bool `json:"refreshOnlyWhenForwarded"`
+
+	// PublicCallbackURL overrides the redirect_uri sent to the provider with a fixed absolute URL,
+	// for deployments where the public scheme, host or path cannot be derived from the request
+	// (optional). Callbacks are still handled on the CallbackURL path.
+	// Default: "" (built from the request scheme and host plus CallbackURL)
+	// Example: https://auth.example.com/oauth2/callback
+	PublicCallbackURL string `json:"publicCallbackURL"`
+
+	// AllowedRedirectHosts restricts the hosts the redirect URI may be built from in multi-tenant
+	// setups where each tenant has its own host (optional). Entries are host names, optionally
+	// with a port, or "*.example.com" to allow any subdomain of example.com. Logins and
+	// callbacks for other hosts are rejected with 400 Bad Request; requests of authenticated
+	// sessions are not affected.
+	// Default: none (any host)
+	AllowedRedirectHosts []string `json:"allowedRedirectHosts"`
+
+	// MainCookieSameSite sets the SameSite attribute of the main session cookie: "lax" or "none"
+	// (optional). It cannot be "strict" because the cookie must survive the redirect back from
+	// the provider. "none" requires forceHTTPS, since browsers reject SameSite=None cookies
+	// that are not Secure.
+	// Default: "lax"
+	MainCookieSameSite string `json:"mainCookieSameSite"`
+
+	// TokenCookieSameSite sets the SameSite attribute of the access and refresh token cookies:
+	// "lax", "strict" or "none" (optional). "strict" keeps the tokens off every cross-site
+	// request and requires postLoginHtmlRedirect, so that the first page after login is
+	// requested same-site. Users arriving through a link from another site then have no token
+	// cookies and log in again. "none" requires forceHTTPS.
+	// Default: "lax"
+	TokenCookieSameSite string `json:"tokenCookieSameSite"`
+
+	// UserIDClaim names the ID token claim that identifies the user, e.g. "sub",
+	// "preferred_username" or "upn" for providers that do not return an email (optional).
+	// The identifier is forwarded in X-Forwarded-User and X-Auth-Request-User. Domain
+	// restrictions still apply to the "email" claim.
+	// Default: "email"
+	UserIDClaim string `json:"userIdClaim"`
+
+	// MaxRequestBodyBytes limits how many bytes are read from the body of requests handled by
+	// the middleware's own endpoints, such as POSTed logout forms (optional). Larger bodies are
+	// rejected with 400 Bad Request.
+	// Default: 16384
+	MaxRequestBodyBytes int64 `json:"maxRequestBodyBytes"`
+
+	// ProviderCAFile is the path to a PEM bundle of CAs trusted in addition to the system roots
+	// when connecting to the provider, for providers behind a private CA (optional).
+	// Ignored when HTTPClient or ProviderTransport is set.
+	ProviderCAFile string `json:"providerCAFile"`
+
+	// ProviderClientCertFile is the path to a PEM client certificate presented to the provider
+	// for mTLS-protected endpoints (optional). Requires ProviderClientKeyFile.
+	ProviderClientCertFile string `json:"providerClientCertFile"`
+
+	// ProviderClientKeyFile is the path to the PEM private key of ProviderClientCertFile (optional).
+	ProviderClientKeyFile string `json:"providerClientKeyFile"`
+
+	// ValidateCertificateBinding enforces RFC 8705 certificate-bound tokens (optional). When the
+	// session's token carries a cnf.x5t#S256 claim, the request must present a client certificate
+	// with that SHA-256 thumbprint or it is rejected with 401. Tokens without the claim are accepted.
+	// Default: false
+	ValidateCertificateBinding bool `json:"validateCertificateBinding"`
+
+	// ClientCertHeader names the header from which the client certificate is read when TLS is
+	// terminated before the middleware, e.g. "X-Forwarded-Tls-Client-Cert" (Traefik
+	// PassTLSClientCert) or "X-Forwarded-Client-Cert" (optional). Only set this when the proxy
+	// always overwrites the header, as clients could otherwise supply it.
+	// Default: none (only the TLS connection is used)
+	ClientCertHeader string `json:"clientCertHeader"`
+
+	// OutageGracePeriodSeconds keeps serving a session whose token has just expired when the
+	// refresh fails because the provider is unreachable or answers with 5xx/429 (optional).
+	// The session hard-expires once the token has been expired for longer than this, or as soon
+	// as the provider is reachable again and rejects the refresh.
+	// Default: 0 (disabled)
+	OutageGracePeriodSeconds int `json:"outageGracePeriodSeconds"`
+
+	// TokenCacheMaxTTLSeconds caps how long the claims of a verified token are cached before
+	// the token is verified again (optional). Entries never outlive the token's own expiry.
+	// Default: 0 (cached until the token expires)
+	TokenCacheMaxTTLSeconds int `json:"tokenCacheMaxTTLSeconds"`
+
+	// CORSPreflight selects how CORS preflight requests (OPTIONS with Origin and
+	// Access-Control-Request-Method) are handled; they carry no cookies and are never
+	// authenticated (optional). "respond" answers them with CORS headers for origins listed in
+	// corsAllowedOrigins and rejects the others, "passthrough" forwards them to the backend for
+	// apps that manage CORS themselves. "respond" requires corsAllowedOrigins.
+	// Default: "respond" if corsAllowedOrigins is set, otherwise "passthrough"
+	CORSPreflight string `json:"corsPreflight"`
+
+	// CORSAllowedOrigins lists the origins allowed to make credentialed cross-origin requests
+	// to protected routes (optional). Only these origins are echoed in
+	// Access-Control-Allow-Origin together with Access-Control-Allow-Credentials; requests
+	// from other origins get no CORS headers from the middleware.
+	// Default: none (the middleware sends no CORS headers)
+	// Example: ["https://app.example.com"]
+	CORSAllowedOrigins []string `json:"corsAllowedOrigins"`
+
+	// CookieEncoding selects how session values are serialized inside the encrypted cookies
+	// (optional). "json" is more compact than "gob", leaving more room for tokens before
+	// they are split into chunks; cookies written with gob stay readable after switching.
+	// Default: "gob"
+	CookieEncoding string `json:"cookieEncoding"`
+
+	// DevMode authenticates every request with DevClaims instead of redirecting to the
+	// provider, for local development without an identity provider (optional). It refuses
+	// to activate unless the TRAEFIKOIDC_DEV_MODE environment variable is set to "true".
+	// Never enable it in production.
+	// Default: false
+	DevMode bool `json:"devMode"`
+
+	// DevClaims are the static claims (e.g. email, sub, groups, roles) of the dev mode user
+	// (optional, required with devMode).
+	DevClaims map[string]interface{} `json:"devClaims"`
+
+	// StateTTLSeconds limits how long the state of an authorization request stays valid
+	// (optional). Callbacks presenting an older state are rejected, in addition to states
+	// that were already used, and the state, nonce and PKCE verifier of a login abandoned
+	// for longer are discarded when the session is next loaded. 0 disables the age check.
+	// Default: 600
+	StateTTLSeconds int `json:"stateTTLSeconds"`
+
+	// LogoutParams maps the logout request parameters for providers with a non-standard
+	// logout endpoint (optional).
+	// Default: standard id_token_hint and post_logout_redirect_uri parameters
+	LogoutParams LogoutParams `json:"logoutParams"`
+
+	// SessionExpiredRedirectPath is where users are sent after logging in again when their
+	// session reached the absolute session timeout (optional). Must start with /.
+	// Default: "" (the page they requested)
+	SessionExpiredRedirectPath string `json:"sessionExpiredRedirectPath"`
+
+	// RestoreHeaders lists request headers (e.g. Accept or custom app headers) that are
+	// captured, compressed, in the session when a login starts and restored on the first
+	// request to the original page after login (optional). Cookie and Authorization cannot
+	// be restored.
+	RestoreHeaders []string `json:"restoreHeaders"`
+
+	// SessionTimeoutJitterPercent randomly lengthens or shortens each session's 24 hour
+	// absolute timeout by up to this percentage, so users who logged in at the same time do
+	// not all have to log in again at once (optional). Must be between 0 and 50.
+	// Default: 0 (no jitter)
+	SessionTimeoutJitterPercent int `json:"sessionTimeoutJitterPercent"`
+
+	// AllowedIssuers lists issuer URLs accepted in the token 'iss' claim in addition to the
+	// issuer discovered from the provider, for providers with region-specific issuers or
+	// during an issuer migration (optional). Tokens from any other issuer are rejected.
+	AllowedIssuers []string `json:"allowedIssuers"`
+
+	// RememberMeParam enables "remember me" sessions (optional). When the request that starts
+	// a login carries this query parameter with a true value (1, true, yes or on), the session
+	// gets the longer RememberMeTimeoutSeconds lifetime and persistent cookies; other sessions
+	// keep the 24 hour timeout with cookies that end when the browser is closed.
+	// Default: "" (disabled; all sessions use persistent cookies)
+	RememberMeParam string `json:"rememberMeParam"`
+
+	// RememberMeTimeoutSeconds is the absolute timeout of remembered sessions (optional).
+	// Default: 2592000 (30 days)
+	RememberMeTimeoutSeconds int `json:"rememberMeTimeoutSeconds"`
+
+	// TokenCacheShards is the number of independently locked shards of the verified token
+	// cache (optional). More shards reduce lock contention when many requests are validated
+	// concurrently; 1 uses a single lock.
+	// Default: 16
+	TokenCacheShards int `json:"tokenCacheShards"`
+
+	// RouteRules adds authorization requirements for individual path prefixes, on top of
+	// the global allowedRolesAndGroups check (optional). Paths without a matching rule only
+	// require login.
+	RouteRules []RouteRule `json:"routeRules"`
+
+	// ClientSigningKey is the client's PEM-encoded RSA, P-256 or P-384 private key, used to
+	// sign request objects for providers that require signed requests (optional).
+	ClientSigningKey string `json:"clientSigningKey"`
+
+	// ClientSigningKeyID is the key ID ('kid') of ClientSigningKey as registered with the
+	// provider (optional).
+	ClientSigningKeyID string `json:"clientSigningKeyId"`
+
+	// SignLogoutRequests sends the logout parameters to the provider's end session endpoint
+	// as a request object signed with ClientSigningKey, as FAPI and other high-security
+	// providers require (optional).
+	// Default: false
+	SignLogoutRequests bool `json:"signLogoutRequests"`
+
+	// JWKSRefetchIntervalSeconds is the minimum time between the JWKS refetches forced by
+	// tokens signed with a key ID missing from the cached JWKS (optional). Such a token
+	// triggers at most one refetch, and is rejected if the key is still missing.
+	// Default: 30
+	JWKSRefetchIntervalSeconds int `json:"jwksRefetchIntervalSeconds"`
+
+	// AllowImplicitFlow switches logins to the implicit flow for legacy providers that only
+	// support it (optional). The provider is asked for "id_token token" and the tokens it
+	// returns on the callback URL, in the query or in the fragment, are verified and used to
+	// establish the session. The access token is only used when the ID token's at_hash claim
+	// matches it. Tokens in URLs can leak through browser history and logs, so prefer the
+	// authorization code flow wherever the provider supports it.
+	// Default: false
+	AllowImplicitFlow bool `json:"allowImplicitFlow"`
+
+	// SendScopeOnRefresh repeats the scopes of the authorization request in refresh token
+	// requests, for providers that otherwise issue refreshed access tokens with default scopes
+	// or audience (optional). Resources and audience are always sent with refresh requests.
+	// Default: false
+	SendScopeOnRefresh bool `json:"sendScopeOnRefresh"`
+
+	// DisallowTokenEndpointRedirects makes token requests fail with a clear error when the
+	// token endpoint answers with a redirect, instead of following it (optional). A correctly
+	// configured token endpoint never redirects, and following e.g. an HTTP to HTTPS redirect
+	// turns the POST into a GET without the request body.
+	// Default: false
+	DisallowTokenEndpointRedirects bool `json:"disallowTokenEndpointRedirects"`
+
+	// FetchUserInfo enriches the ID token claims at login with the claims returned by the
+	// provider's UserInfo endpoint, for providers whose ID tokens carry only minimal claims
+	// (optional). UserInfo claims fill in the email or user ID missing from the ID token and
+	// are available to header templates and route rules; ID token claims take precedence.
+	// Default: false
+	FetchUserInfo bool `json:"fetchUserInfo"`
+
+	// AllowMissingNonce accepts logins whose ID token does not carry the nonce sent with the
+	// authorization request (optional). Enable it only for providers that do not echo the
+	// nonce back; a nonce that is returned is still compared with the one sent.
+	// Default: false
+	AllowMissingNonce bool `json:"allowMissingNonce"`
+
+	// TrustedProxies lists the IP addresses or CIDR ranges of proxies whose X-Forwarded-Proto
+	// and X-Forwarded-Host headers are honored when deriving the request scheme and host
+	// (optional). Requests from any other peer use the connection's TLS state and Host
+	// header instead, so clients cannot spoof them. When empty, forwarding headers are
+	// honored from any peer.
+	// Default: []
+	// Example: ["10.0.0.0/8", "192.168.1.10"]
+	TrustedProxies []string `json:"trustedProxies"`
+
+	// GoogleHostedDomain restricts Google logins to a Google Workspace domain (optional). The
+	// domain is sent as the hd parameter of the authorization request, and logins whose ID
+	// token hd claim does not match it are rejected. Unlike allowedUserDomains this cannot be
+	// satisfied by a personal account whose email address happens to use the domain.
+	// Default: ""
+	// Example: "example.com"
+	GoogleHostedDomain string `json:"googleHostedDomain"`
+
+	// MaxAuthAgeSeconds requires users to have actively authenticated with the provider within
+	// this many seconds (optional). It is sent as the max_age parameter of the authorization
+	// request, and the auth_time claim of the ID token is checked at login and on every
+	// request; once it is too old the user is sent back to the provider with prompt=login.
+	// A login whose auth_time is missing or too old is retried once with prompt=login before
+	// it fails. Set to 0 to disable.
+	// Default: 0
+	MaxAuthAgeSeconds int `json:"maxAuthAgeSeconds"`
+
+	// SessionEncryptionKeyFile is the path of a file holding the session keys, one per line,
+	// newest first, used in place of SessionEncryptionKey (optional). The file is re-read once
+	// a minute, so a rotated Kubernetes secret is picked up without a restart: put the new key
+	// on the first line and keep the previous one below it until existing sessions have expired.
+	// Default: ""
+	// Example: "/etc/traefik/oidc/session-keys"
+	SessionEncryptionKeyFile string `json:"sessionEncryptionKeyFile"`
+
+	// MaxProviderConcurrency limits how many requests to the provider (discovery,
+	// JWKS, token, UserInfo, revocation) may be in flight at once (optional). Further
+	// requests wait up to 5 seconds for a free slot, protecting the provider and the
+	// connection pool during bursts of logins. Set to 0 to disable the limit.
+	// Default: 20
+	MaxProviderConcurrency int `json:"maxProviderConcurrency"`
+
+	// RequireMFA grants access only to users whose login used multi-factor authentication,
+	// as reported by the ID token's amr claim (optional). Users who logged in without it are
+	// sent back to the provider with prompt=login (and MFAACRValues, if set) to step up; if
+	// the step-up login still shows no MFA, access is denied.
+	// Default: false
+	RequireMFA bool `json:"requireMFA"`
+
+	// MFAMethods lists the amr values accepted as multi-factor authentication (optional).
+	// Default: ["mfa", "otp", "hwk"]
+	MFAMethods []string `json:"mfaMethods"`
+
+	// MFAACRValues is sent as acr_values on authorization requests when RequireMFA is set, to
+	// ask the provider for multi-factor authentication (optional). The value is provider
+	// specific.
+	// Default: ""
+	// Example: "http://schemas.openid.net/pape/policies/2007/06/multi-factor"
+	MFAACRValues string `json:"mfaAcrValues"`
+
+	// SessionLayout selects how the session is laid out in cookies (optional). "split" keeps
+	// the main session, access token and refresh token in separate cookies, chunked as needed.
+	// "single" packs them into one cookie with a compact binary layout whenever they fit,
+	// falling back to split cookies for larger sessions.
+	// Default: "split"
+	SessionLayout string `json:"sessionLayout"`
+
+	// CallbackRateLimit is the number of callback requests a single client IP may make per
+	// minute (optional). Further callbacks are answered with 429 before any token exchange,
+	// so bogus codes cannot exhaust the provider's token endpoint quota. Behind a load
+	// balancer, set trustedProxies so clients are told apart by X-Forwarded-For. Set to 0 to
+	// disable the limit.
+	// Default: 30
+	CallbackRateLimit int `json:"callbackRateLimit"`
+
+	// CompressClaims compresses the UserInfo claims kept in the main session cookie
+	// (optional), so that users with many groups do not overflow it. Claims that still
+	// exceed a single cookie are split into chunk cookies like tokens.
+	// Default: true
+	CompressClaims bool `json:"compressClaims"`
+
+	// SingleSessionPerUser limits each user to one active session (optional): a new login
+	// terminates the user's previous session, which is sent back to the provider on its next
+	// request. Sessions are tracked in memory, so with several replicas a login only evicts
+	// sessions on the replica that handled it.
+	// Default: false
+	SingleSessionPerUser bool `json:"singleSessionPerUser"`
+
+	// IdleTimeoutSeconds ends sessions that have not been used for this many seconds
+	// (optional). Each request to a protected route restarts the timeout. Set to 0 to keep
+	// sessions until the absolute session timeout.
+	// Default: 0
+	// Example: 1800
+	IdleTimeoutSeconds int `json:"idleTimeoutSeconds"`
+
+	// SessionExpiresHeader is the name of a response header reporting, on every authorized
+	// request, the number of seconds until the session expires through the idle or absolute
+	// timeout, whichever comes first (optional). Frontends can use it to warn users before
+	// they are logged out.
+	// Default: "" (no header)
+	// Example: "X-Session-Expires-In"
+	SessionExpiresHeader string `json:"sessionExpiresHeader"`
+
+	// ClaimsRefreshIntervalSeconds refreshes a session's claims from the provider once they
+	// are this many seconds old (optional), by refreshing the token and, with fetchUserInfo,
+	// the UserInfo claims. Authorization checks then run against the updated claims, so roles
+	// revoked at the provider take effect within the interval. Requires a refresh token.
+	// Set to 0 to refresh claims only when the token is refreshed anyway.
+	// Default: 0
+	// Example: 300
+	ClaimsRefreshIntervalSeconds int `json:"claimsRefreshIntervalSeconds"`
+
+	// ProviderDialTimeoutSeconds limits how long connecting to the provider may take
+	// (optional), separately from the overall request timeout, so that an unreachable
+	// provider address fails fast. Set to 0 for the default. Ignored when HTTPClient or
+	// ProviderTransport is set.
+	// Default: 15
+	ProviderDialTimeoutSeconds int `json:"providerDialTimeoutSeconds"`
+
+	// ProviderTLSHandshakeTimeoutSeconds limits how long the TLS handshake with the provider
+	// may take (optional). Set to 0 for the default. Ignored when HTTPClient or
+	// ProviderTransport is set.
+	// Default: 5
+	ProviderTLSHandshakeTimeoutSeconds int `json:"providerTlsHandshakeTimeoutSeconds"`
+
+	// GroupClaimPaths lists dotted paths of nested claims whose values are added to the
+	// user's groups, on top of the 'groups' claim (optional). Arrays along the path are
+	// searched element by element. The groups are used by allowedRolesAndGroups, route rules
+	// and the X-User-Groups header.
+	// Default: []
+	// Example: ["memberships.groups"]
+	GroupClaimPaths []string `json:"groupClaimPaths"`
+
+	// RoleClaimPaths lists dotted paths of nested claims whose values are added to the user's
+	// roles, on top of the 'roles' claim (optional), for providers like Keycloak that nest
+	// roles inside objects. The roles are used by allowedRolesAndGroups, route rules and the
+	// X-User-Roles header.
+	// Default: []
+	// Example: ["realm_access.roles", "resource_access.my-client.roles"]
+	RoleClaimPaths []string `json:"roleClaimPaths"`
+
+	// LogCorrelationIDs tags the log lines of each auth flow with a short correlation ID
+	// (optional). The ID is generated when a login starts and stored in the session, so the
+	// redirect, the callback and later token refreshes of the session can be found with a
+	// single grep.
+	// Default: false
+	LogCorrelationIDs bool `json:"logCorrelationIds"`
+
+	// CorrelationIDHeader is the name of a response header carrying the correlation ID of the
+	// session's auth flow (optional). Setting it generates correlation IDs even when
+	// logCorrelationIds is disabled.
+	// Default: "" (no header)
+	// Example: "X-Auth-Correlation-Id"
+	CorrelationIDHeader string `json:"correlationIdHeader"`
+
+	// IntrospectionURL is the provider's token introspection endpoint (RFC 7662) (optional).
+	// If not provided, it is discovered from the provider metadata.
+	IntrospectionURL string `json:"introspectionURL"`
+
+	// IntrospectionFallback verifies tokens through the introspection endpoint while the
+	// provider's JWKS cannot be fetched (optional), instead of failing all logins. Fetching the
+	// JWKS is retried every 30 seconds and local signature verification resumes as soon as
+	// it succeeds. The session's ID token is introspected with token_type_hint=id_token, so
+	// this requires a provider whose introspection endpoint accepts ID tokens (Okta documents
+	// the id_token hint); providers that only introspect access tokens report every session
+	// as inactive.
+	// Default: false
+	IntrospectionFallback bool `json:"introspectionFallback"`
+
+	// CookiePath is the Path attribute of all session cookies (optional). Scoping the cookies
+	// to the protected application, e.g. "/app", keeps them from being sent to other
+	// applications on the same host. The callback and logout URLs must lie within the path.
+	// Default: "/"
+	// Example: "/app"
+	CookiePath string `json:"cookiePath"`
+
+	// CompressionThreshold is the token length in bytes below which tokens are stored in
+	// cookies uncompressed (optional). Compressing small tokens costs CPU and can make them
+	// slightly larger. Uncompressed tokens are not packed by the single-cookie session layout.
+	// Default: 0 (compress every token)
+	// Example: 1024
+	CompressionThreshold int `json:"compressionThreshold"`
+
+	// TokenRequestEncoding selects how token endpoint requests are encoded (optional).
+	// "form" sends application/x-www-form-urlencoded bodies as the specification requires;
+	// "json" sends the same parameters as a JSON object for providers that only accept JSON.
+	// Default: "form"
+	TokenRequestEncoding string `json:"tokenRequestEncoding"`
+
+	// PostLoginHTMLRedirect sends the user to the original page after login from a small HTML
+	// page that navigates on load, instead of a 302 redirect (optional). This makes browsers
+	// with strict cookie handling commit the session cookies set by the callback first, and
+	// resolves intermittent loops of being logged in but redirected back to the login. It is
+	// required for tokenCookieSameSite "strict".
+	// Default: false
+	PostLoginHTMLRedirect bool `json:"postLoginHtmlRedirect"`
+
+	// LoginHintTokenHeader is the name of a request header whose value is sent to the provider
+	// as login_hint_token when a login starts (optional), for providers that accept
+	// backend-channel hints identifying the user. The header must be set by a trusted proxy.
+	// Cannot be combined with loginHintTokenClaim.
+	// Example: "X-Login-Hint-Token"
+	LoginHintTokenHeader string `json:"loginHintTokenHeader"`
+
+	// LoginHintTokenClaim is the claim of the session's previous ID token sent as
+	// login_hint_token when the user logs in again (optional). Dotted paths select nested
+	// claims. Cannot be combined with loginHintTokenHeader.
+	// Example: "login_hint_token"
+	LoginHintTokenClaim string `json:"loginHintTokenClaim"`
+
+	// LogoutConfirmation serves a confirmation page for GET requests to the logout path and
+	// only logs the user out on a POST carrying the page's CSRF token (optional). This stops
+	// other sites from forcing a logout with a link or image; without it a single request to
+	// the logout path logs the user out.
+	// Default: false
+	LogoutConfirmation bool `json:"logoutConfirmation"`
 }
 
 const (
@@ -126,6 +774,72 @@ const (
 
 	// MinSessionEncryptionKeyLength defines the minimum length for session encryption key
 	MinSessionEncryptionKeyLength = 32
+
+	// DefaultRefreshBackoffSeconds defines the default initial delay after a failed token refresh
+	DefaultRefreshBackoffSeconds = 10
+
+	// DefaultMaxRefreshFailures defines the default number of consecutive refresh failures before forcing re-login
+	DefaultMaxRefreshFailures = 5
+
+	// UnauthenticatedModeRedirect redirects unauthenticated requests to the provider
+	UnauthenticatedModeRedirect = "redirect"
+
+	// UnauthenticatedModeJSON answers unauthenticated requests with a JSON 401 carrying the login URL
+	UnauthenticatedModeJSON = "json-401"
+
+	// UnauthenticatedModeCustom answers unauthenticated requests with a configured HTML page
+	UnauthenticatedModeCustom = "custom"
+
+	// TokenStorageCookie stores tokens in (chunked) session cookies
+	TokenStorageCookie = "cookie"
+
+	// TokenStorageMemory stores tokens in an in-process cache keyed by a random session ID
+	TokenStorageMemory = "memory"
+
+	// DefaultUserIDClaim defines the default claim used as the user identifier
+	DefaultUserIDClaim = "email"
+
+	// DefaultMaxRequestBodyBytes defines the default body size limit for the middleware's own endpoints
+	DefaultMaxRequestBodyBytes = 16 << 10
+
+	// CORSPreflightRespond answers CORS preflight requests in the middleware
+	CORSPreflightRespond = "respond"
+
+	// CORSPreflightPassthrough forwards CORS preflight requests to the backend unauthenticated
+	CORSPreflightPassthrough = "passthrough"
+
+	// CookieEncodingGob serializes session cookie values with encoding/gob
+	CookieEncodingGob = "gob"
+
+	// CookieEncodingJSON serializes session cookie values as a compact JSON object
+	CookieEncodingJSON = "json"
+
+	// SessionLayoutSplit stores the main session and each token in separate (chunked) cookies
+	SessionLayoutSplit = "split"
+
+	// SessionLayoutSingle packs the main session and tokens into a single cookie when they fit
+	SessionLayoutSingle = "single"
+
+	// ForwardedTokenAccess forwards the provider's access token in the access token header
+	ForwardedTokenAccess = "access"
+
+	// ForwardedTokenID forwards the verified ID token in the access token header
+	ForwardedTokenID = "id"
+
+	// TokenRequestEncodingForm sends token requests form-encoded, as the specification requires
+	TokenRequestEncodingForm = "form"
+
+	// TokenRequestEncodingJSON sends token requests as a JSON object
+	TokenRequestEncodingJSON = "json"
+
+	// DevModeEnvVar is the environment variable that must be "true" for devMode to activate
+	DevModeEnvVar = "TRAEFIKOIDC_DEV_MODE"
+
+	// DefaultStateTTLSeconds defines the default lifetime of an authorization request's state
+	DefaultStateTTLSeconds = 600
+
+	// DefaultRememberMeTimeoutSeconds defines the default absolute timeout of remembered sessions
+	DefaultRememberMeTimeoutSeconds = 30 * 24 * 60 * 60
 )
 
 // CreateConfig creates a new Config with secure default values.
@@ -153,6 +867,23 @@ func CreateConfig() *Config {
 		ForceHTTPS:                true,  // Secure by default
 		EnablePKCE:                false, // PKCE is opt-in
 		RefreshGracePeriodSeconds: 60,    // Default grace period of 60 seconds
+		CompressionCodec:          DefaultCompressionCodec,
+		UnauthenticatedMode:       UnauthenticatedModeRedirect,
+		RefreshBackoffSeconds:     DefaultRefreshBackoffSeconds,
+		MaxRefreshFailures:        DefaultMaxRefreshFailures,
+		TokenStorage:              TokenStorageCookie,
+		UserIDClaim:               DefaultUserIDClaim,
+		MaxRequestBodyBytes:       DefaultMaxRequestBodyBytes,
+		CookieEncoding:            CookieEncodingGob,
+		SessionLayout:             SessionLayoutSplit,
+		ForwardedToken:            ForwardedTokenAccess,
+		TokenRequestEncoding:      TokenRequestEncodingForm,
+		StateTTLSeconds:           DefaultStateTTLSeconds,
+		RememberMeTimeoutSeconds:  DefaultRememberMeTimeoutSeconds,
+		TokenCacheShards:          DefaultCacheShards,
+		MaxProviderConcurrency:    DefaultMaxProviderConcurrency,
+		CallbackRateLimit:         DefaultCallbackRateLimit,
+		CompressClaims:            true,
 	}
 
 	return c
@@ -191,11 +922,11 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("clientSecret is required")
 	}
 
-	// Validate session encryption key
-	if c.SessionEncryptionKey == "" {
+	// Validate session encryption key; a key file or provider supplies the keys instead
+	if c.SessionEncryptionKey == "" && c.SessionEncryptionKeyFile == "" && c.SessionKeyProvider == nil {
 		return fmt.Errorf("sessionEncryptionKey is required")
 	}
-	if len(c.SessionEncryptionKey) < MinSessionEncryptionKeyLength {
+	if c.SessionEncryptionKey != "" && len(c.SessionEncryptionKey) < MinSessionEncryptionKeyLength {
 		return fmt.Errorf("sessionEncryptionKey must be at least %d characters long", MinSessionEncryptionKeyLength)
 	}
 
@@ -244,6 +975,274 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("refreshGracePeriodSeconds cannot be negative")
 	}
 
+	// Validate refresh backoff
+	if c.RefreshBackoffSeconds < 0 {
+		return fmt.Errorf("refreshBackoffSeconds cannot be negative")
+	}
+	if c.MaxRefreshFailures < 0 {
+		return fmt.Errorf("maxRefreshFailures cannot be negative")
+	}
+
+	// Validate token cache TTL
+	if c.TokenCacheMaxTTLSeconds < 0 {
+		return fmt.Errorf("tokenCacheMaxTTLSeconds cannot be negative")
+	}
+
+	// Validate outage grace period
+	if c.OutageGracePeriodSeconds < 0 {
+		return fmt.Errorf("outageGracePeriodSeconds cannot be negative")
+	}
+
+	// Validate request body limit
+	if c.MaxRequestBodyBytes < 0 {
+		return fmt.Errorf("maxRequestBodyBytes cannot be negative")
+	}
+
+	// Validate provider client certificate
+	if (c.ProviderClientCertFile == "") != (c.ProviderClientKeyFile == "") {
+		return fmt.Errorf("providerClientCertFile and providerClientKeyFile must be set together")
+	}
+
+	// Validate resource indicators
+	for _, resource := range c.Resources {
+		u, err := url.Parse(resource)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return fmt.Errorf("resource must be an absolute URI without fragment: %s", resource)
+		}
+	}
+
+	// Validate cookie budget
+	if c.MaxCookieBytes < 0 {
+		return fmt.Errorf("maxCookieBytes cannot be negative")
+	}
+
+	// Validate public callback URL if set
+	if c.PublicCallbackURL != "" {
+		u, err := url.Parse(c.PublicCallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Fragment != "" {
+			return fmt.Errorf("publicCallbackURL must be an absolute http(s) URL without fragment")
+		}
+	}
+
+	// Validate redirect host allowlist
+	for _, host := range c.AllowedRedirectHosts {
+		if host == "" || strings.ContainsAny(host, "/?#@") {
+			return fmt.Errorf("allowedRedirectHosts entries must be host names: %q", host)
+		}
+	}
+
+	// Validate cookie SameSite modes
+	if _, _, err := c.cookieSameSite(); err != nil {
+		return err
+	}
+
+	// Validate silent renew path if set
+	if c.SilentRenewPath != "" {
+		if !strings.HasPrefix(c.SilentRenewPath, "/") {
+			return fmt.Errorf("silentRenewPath must start with /")
+		}
+		if c.SilentRenewPath == c.CallbackURL {
+			return fmt.Errorf("silentRenewPath must differ from callbackURL")
+		}
+	}
+
+	// Validate CORS preflight handling
+	switch c.CORSPreflight {
+	case "", CORSPreflightRespond, CORSPreflightPassthrough:
+	default:
+		return fmt.Errorf("corsPreflight must be one of: respond, passthrough")
+	}
+	if c.CORSPreflight == CORSPreflightRespond && len(c.CORSAllowedOrigins) == 0 {
+		return fmt.Errorf("corsPreflight respond requires corsAllowedOrigins")
+	}
+	for _, origin := range c.CORSAllowedOrigins {
+		if !validOrigin(origin) {
+			return fmt.Errorf("corsAllowedOrigins entry must be a scheme and host such as https://app.example.com: %s", origin)
+		}
+	}
+
+	if c.SessionExpiredRedirectPath != "" && !strings.HasPrefix(c.SessionExpiredRedirectPath, "/") {
+		return fmt.Errorf("sessionExpiredRedirectPath must start with /")
+	}
+
+	for _, name := range c.RestoreHeaders {
+		if canonical := http.CanonicalHeaderKey(name); canonical == "Cookie" || canonical == "Authorization" {
+			return fmt.Errorf("restoreHeaders cannot include %s", canonical)
+		}
+	}
+
+	if c.LoginHintTokenHeader != "" && c.LoginHintTokenClaim != "" {
+		return fmt.Errorf("loginHintTokenHeader and loginHintTokenClaim cannot both be set")
+	}
+	if c.LoginHintTokenClaim != "" && !validClaimPath(c.LoginHintTokenClaim) {
+		return fmt.Errorf("loginHintTokenClaim is not a valid claim path: %s", c.LoginHintTokenClaim)
+	}
+
+	switch c.TokenRequestEncoding {
+	case "", TokenRequestEncodingForm, TokenRequestEncodingJSON:
+	default:
+		return fmt.Errorf("tokenRequestEncoding must be one of: form, json")
+	}
+
+	if c.CompressionThreshold < 0 {
+		return fmt.Errorf("compressionThreshold cannot be negative")
+	}
+
+	if c.CookiePath != "" {
+		if !strings.HasPrefix(c.CookiePath, "/") || strings.ContainsAny(c.CookiePath, "; \t\r\n") {
+			return fmt.Errorf("cookiePath must start with / and cannot contain ';' or whitespace")
+		}
+		logoutURL := c.LogoutURL
+		if logoutURL == "" {
+			logoutURL = c.CallbackURL + "/logout"
+		}
+		for _, path := range []string{c.CallbackURL, logoutURL, c.SilentRenewPath} {
+			if path != "" && !cookiePathMatches(c.CookiePath, path) {
+				return fmt.Errorf("cookiePath %s must include the callback, logout and silent renew paths, %s is outside it", c.CookiePath, path)
+			}
+		}
+	}
+
+	if c.IntrospectionURL != "" && !isValidSecureURL(c.IntrospectionURL) {
+		return fmt.Errorf("introspectionURL must be a valid HTTPS URL")
+	}
+
+	for _, paths := range [][]string{c.GroupClaimPaths, c.RoleClaimPaths} {
+		for _, path := range paths {
+			if !validClaimPath(path) {
+				return fmt.Errorf("invalid claim path %q in groupClaimPaths or roleClaimPaths", path)
+			}
+		}
+	}
+
+	if c.ProviderDialTimeoutSeconds < 0 || c.ProviderTLSHandshakeTimeoutSeconds < 0 {
+		return fmt.Errorf("provider timeouts cannot be negative")
+	}
+
+	if c.ClaimsRefreshIntervalSeconds < 0 {
+		return fmt.Errorf("claimsRefreshIntervalSeconds cannot be negative")
+	}
+
+	if c.IdleTimeoutSeconds < 0 {
+		return fmt.Errorf("idleTimeoutSeconds cannot be negative")
+	}
+
+	if c.CallbackRateLimit < 0 {
+		return fmt.Errorf("callbackRateLimit cannot be negative")
+	}
+
+	switch c.SessionLayout {
+	case "", SessionLayoutSplit, SessionLayoutSingle:
+	default:
+		return fmt.Errorf("sessionLayout must be one of: split, single")
+	}
+
+	if c.MaxProviderConcurrency < 0 {
+		return fmt.Errorf("maxProviderConcurrency cannot be negative")
+	}
+
+	if c.MaxAuthAgeSeconds < 0 {
+		return fmt.Errorf("maxAuthAgeSeconds cannot be negative")
+	}
+
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		return err
+	}
+
+	if c.JWKSRefetchIntervalSeconds < 0 {
+		return fmt.Errorf("jwksRefetchIntervalSeconds cannot be negative")
+	}
+
+	if c.SignLogoutRequests && c.ClientSigningKey == "" {
+		return fmt.Errorf("signLogoutRequests requires clientSigningKey")
+	}
+
+	for _, rule := range c.RouteRules {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return fmt.Errorf("routeRules pathPrefix must start with /")
+		}
+	}
+
+	if c.TokenCacheShards < 0 {
+		return fmt.Errorf("tokenCacheShards cannot be negative")
+	}
+
+	if c.RememberMeTimeoutSeconds < 0 {
+		return fmt.Errorf("rememberMeTimeoutSeconds cannot be negative")
+	}
+
+	for _, issuer := range c.AllowedIssuers {
+		if !isValidSecureURL(issuer) {
+			return fmt.Errorf("allowedIssuers must be valid HTTPS URLs")
+		}
+	}
+
+	if c.SessionTimeoutJitterPercent < 0 || c.SessionTimeoutJitterPercent > 50 {
+		return fmt.Errorf("sessionTimeoutJitterPercent must be between 0 and 50")
+	}
+
+	if c.StateTTLSeconds < 0 {
+		return fmt.Errorf("stateTTLSeconds cannot be negative")
+	}
+
+	// Dev mode needs a user to authenticate requests as
+	if c.DevMode && len(c.DevClaims) == 0 {
+		return fmt.Errorf("devClaims must be set when devMode is enabled")
+	}
+
+	// Validate cookie encoding
+	switch c.CookieEncoding {
+	case "", CookieEncodingGob, CookieEncodingJSON:
+	default:
+		return fmt.Errorf("cookieEncoding must be one of: gob, json")
+	}
+
+	switch c.ForwardedToken {
+	case "", ForwardedTokenAccess, ForwardedTokenID:
+	default:
+		return fmt.Errorf("forwardedToken must be one of: access, id")
+	}
+
+	// Validate unauthenticated response mode
+	switch c.UnauthenticatedMode {
+	case "", UnauthenticatedModeRedirect, UnauthenticatedModeJSON:
+	case UnauthenticatedModeCustom:
+		if c.UnauthenticatedTemplate == "" {
+			return fmt.Errorf("unauthenticatedTemplate is required when unauthenticatedMode is custom")
+		}
+		if _, err := htmltemplate.New("unauthenticated").Parse(c.UnauthenticatedTemplate); err != nil {
+			return fmt.Errorf("unauthenticatedTemplate is invalid: %w", err)
+		}
+	default:
+		return fmt.Errorf("unauthenticatedMode must be one of: redirect, json-401, custom")
+	}
+
+	// Validate signing algorithm allowlist
+	for _, alg := range c.AllowedSigningAlgorithms {
+		if !supportedSigningAlgorithms[alg] {
+			return fmt.Errorf("allowedSigningAlgorithms contains unsupported algorithm: %s", alg)
+		}
+	}
+
+	// Validate extra token request parameters
+	for name := range c.TokenRequestParams {
+		if reservedTokenParams[name] {
+			return fmt.Errorf("tokenRequestParams cannot override reserved parameter: %s", name)
+		}
+	}
+
+	// Validate token storage
+	switch c.TokenStorage {
+	case "", TokenStorageCookie, TokenStorageMemory:
+	default:
+		return fmt.Errorf("tokenStorage must be one of: cookie, memory")
+	}
+
+	// Validate compression codec
+	if _, err := getCompressionCodec(c.CompressionCodec); err != nil {
+		return fmt.Errorf("compressionCodec is invalid: %w", err)
+	}
+
 	// Validate headers configuration
 	for _, header := range c.Headers {
 		if header.Name == "" {
@@ -274,6 +1273,61 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// cookieSameSite returns the SameSite modes of the main session cookie and of the token
+// cookies, checking that the login round-trip still works with them.
+//
+// Returns:
+//   - The main and token cookie SameSite modes, 0 for the default (Lax).
+//   - An error if a mode is unknown or would break logins.
+func (c *Config) cookieSameSite() (http.SameSite, http.SameSite, error) {
+	mainSameSite, err := parseSameSite(c.MainCookieSameSite)
+	if err != nil {
+		return 0, 0, fmt.Errorf("mainCookieSameSite is invalid: %w", err)
+	}
+	if mainSameSite == http.SameSiteStrictMode {
+		return 0, 0, fmt.Errorf("mainCookieSameSite cannot be strict: the session cookie must survive the redirect from the provider")
+	}
+	if mainSameSite == http.SameSiteNoneMode && !c.ForceHTTPS {
+		return 0, 0, fmt.Errorf("mainCookieSameSite none requires forceHTTPS: browsers reject SameSite=None cookies that are not Secure")
+	}
+	tokenSameSite, err := parseSameSite(c.TokenCookieSameSite)
+	if err != nil {
+		return 0, 0, fmt.Errorf("tokenCookieSameSite is invalid: %w", err)
+	}
+	// After a 302 the first page load is still part of the cross-site redirect chain from
+	// the provider and would arrive without Strict cookies
+	if tokenSameSite == http.SameSiteStrictMode && !c.PostLoginHTMLRedirect {
+		return 0, 0, fmt.Errorf("tokenCookieSameSite strict requires postLoginHtmlRedirect: the first page after login must be requested same-site")
+	}
+	if tokenSameSite == http.SameSiteNoneMode && !c.ForceHTTPS {
+		return 0, 0, fmt.Errorf("tokenCookieSameSite none requires forceHTTPS: browsers reject SameSite=None cookies that are not Secure")
+	}
+	return mainSameSite, tokenSameSite, nil
+}
+
+// parseSameSite converts a SameSite configuration value into an http.SameSite mode.
+//
+// Parameters:
+//   - value: "strict", "lax", "none" (case-insensitive), or "" for the default.
+//
+// Returns:
+//   - The SameSite mode, or 0 for the default.
+//   - An error if the value is not recognized.
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "":
+		return 0, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("must be one of: strict, lax, none")
+	}
+}
+
 // isValidSecureURL checks if a given string represents a valid, absolute HTTPS URL.
 // It uses url.Parse and checks for a nil error, an "https" scheme, and a non-empty host.
 //
@@ -287,6 +1341,36 @@ func isValidSecureURL(s string) bool {
 	return err == nil && u.Scheme == "https" && u.Host != ""
 }
 
+// validOrigin reports whether s is a serialized web origin: an http or https scheme and a
+// host with an optional port, without path, query or fragment.
+//
+// Parameters:
+//   - s: The origin string to validate.
+//
+// Returns:
+//   - true if the string is a valid origin, false otherwise.
+func validOrigin(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" &&
+		s == u.Scheme+"://"+u.Host
+}
+
+// cookiePathMatches reports whether a browser sends cookies set with the given path on
+// requests to the request path, following the path-match rules of RFC 6265.
+//
+// Parameters:
+//   - cookiePath: The Path attribute of the cookie.
+//   - requestPath: The path of the request.
+//
+// Returns:
+//   - true if the cookie is sent with the request.
+func cookiePathMatches(cookiePath, requestPath string) bool {
+	if !strings.HasPrefix(requestPath, cookiePath) {
+		return false
+	}
+	return len(requestPath) == len(cookiePath) || strings.HasSuffix(cookiePath, "/") || requestPath[len(cookiePath)] == '/'
+}
+
 // isValidLogLevel checks if the provided log level string is one of the supported values ("debug", "info", "error").
 //
 // Parameters:
@@ -408,6 +1492,26 @@ func (l *Logger) Errorf(format string, args ...interface{}) {
 	l.logError.Printf(format, args...)
 }
 
+// WithCorrelationID returns a logger writing to the same outputs at the same levels, whose
+// messages are tagged with the given correlation ID so that all log lines of one auth flow
+// can be found together.
+//
+// Parameters:
+//   - id: The correlation ID of the flow.
+//
+// Returns:
+//   - The tagged logger.
+func (l *Logger) WithCorrelationID(id string) *Logger {
+	tag := func(logger *log.Logger) *log.Logger {
+		return log.New(logger.Writer(), logger.Prefix()+"["+id+"] ", logger.Flags())
+	}
+	return &Logger{
+		logError: tag(l.logError),
+		logInfo:  tag(l.logInfo),
+		logDebug: tag(l.logDebug),
+	}
+}
+
 // handleError logs an error message using the provided logger and sends an HTTP error
 // response to the client with the specified message and status code.
 //
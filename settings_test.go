@@ -187,6 +187,698 @@ func TestConfigValidate(t *testing.T) {
 			},
 			expectedError: "oidcEndSessionURL must be a valid HTTPS URL",
 		},
+		{
+			name: "Negative RefreshBackoffSeconds",
+			config: &Config{
+				ProviderURL:           "https://provider.com",
+				CallbackURL:           "/callback",
+				ClientID:              "client-id",
+				ClientSecret:          "client-secret",
+				SessionEncryptionKey:  "this-is-a-long-enough-encryption-key",
+				RateLimit:             100,
+				RefreshBackoffSeconds: -1,
+			},
+			expectedError: "refreshBackoffSeconds cannot be negative",
+		},
+		{
+			name: "Strict TokenCookieSameSite",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				ForceHTTPS:           true,
+				TokenCookieSameSite:  "strict",
+			},
+			expectedError: "tokenCookieSameSite strict requires postLoginHtmlRedirect: the first page after login must be requested same-site",
+		},
+		{
+			name: "Strict TokenCookieSameSite With HTML Post-Login Redirect",
+			config: &Config{
+				ProviderURL:           "https://provider.com",
+				CallbackURL:           "/callback",
+				ClientID:              "client-id",
+				ClientSecret:          "client-secret",
+				SessionEncryptionKey:  "this-is-a-long-enough-encryption-key",
+				RateLimit:             100,
+				TokenCookieSameSite:   "strict",
+				PostLoginHTMLRedirect: true,
+			},
+		},
+		{
+			name: "TokenCookieSameSite None Without ForceHTTPS",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				TokenCookieSameSite:  "none",
+			},
+			expectedError: "tokenCookieSameSite none requires forceHTTPS: browsers reject SameSite=None cookies that are not Secure",
+		},
+		{
+			name: "MainCookieSameSite None Without ForceHTTPS",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				MainCookieSameSite:   "none",
+			},
+			expectedError: "mainCookieSameSite none requires forceHTTPS: browsers reject SameSite=None cookies that are not Secure",
+		},
+		{
+			name: "CORS respond without allowed origins",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CORSPreflight:        CORSPreflightRespond,
+			},
+			expectedError: "corsPreflight respond requires corsAllowedOrigins",
+		},
+		{
+			name: "Invalid CORS allowed origin",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CORSAllowedOrigins:   []string{"https://app.example.com/path"},
+			},
+			expectedError: "corsAllowedOrigins entry must be a scheme and host such as https://app.example.com: https://app.example.com/path",
+		},
+		{
+			name: "Login hint token from both a header and a claim",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				LoginHintTokenHeader: "X-Login-Hint-Token",
+				LoginHintTokenClaim:  "login_hint_token",
+			},
+			expectedError: "loginHintTokenHeader and loginHintTokenClaim cannot both be set",
+		},
+		{
+			name: "Invalid token request encoding",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				TokenRequestEncoding: "xml",
+			},
+			expectedError: "tokenRequestEncoding must be one of: form, json",
+		},
+		{
+			name: "Negative compression threshold",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CompressionThreshold: -1,
+			},
+			expectedError: "compressionThreshold cannot be negative",
+		},
+		{
+			name: "Cookie path excluding the callback",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CookiePath:           "/app",
+			},
+			expectedError: "cookiePath /app must include the callback, logout and silent renew paths, /callback is outside it",
+		},
+		{
+			name: "Cookie path is a prefix of the callback without a separator",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/application/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CookiePath:           "/app",
+			},
+			expectedError: "cookiePath /app must include the callback, logout and silent renew paths, /application/callback is outside it",
+		},
+		{
+			name: "Cookie path including the callback",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/app/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				LogLevel:             "debug",
+				RateLimit:            100,
+				CookiePath:           "/app",
+			},
+			expectedError: "",
+		},
+		{
+			name: "Insecure introspection URL",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				IntrospectionURL:     "http://provider.com/introspect",
+			},
+			expectedError: "introspectionURL must be a valid HTTPS URL",
+		},
+		{
+			name: "Invalid role claim path",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				RoleClaimPaths:       []string{"realm_access..roles"},
+			},
+			expectedError: "invalid claim path \"realm_access..roles\" in groupClaimPaths or roleClaimPaths",
+		},
+		{
+			name: "Negative provider dial timeout",
+			config: &Config{
+				ProviderURL:                "https://provider.com",
+				CallbackURL:                "/callback",
+				ClientID:                   "client-id",
+				ClientSecret:               "client-secret",
+				SessionEncryptionKey:       "this-is-a-long-enough-encryption-key",
+				RateLimit:                  100,
+				ProviderDialTimeoutSeconds: -1,
+			},
+			expectedError: "provider timeouts cannot be negative",
+		},
+		{
+			name: "Negative claims refresh interval",
+			config: &Config{
+				ProviderURL:                  "https://provider.com",
+				CallbackURL:                  "/callback",
+				ClientID:                     "client-id",
+				ClientSecret:                 "client-secret",
+				SessionEncryptionKey:         "this-is-a-long-enough-encryption-key",
+				RateLimit:                    100,
+				ClaimsRefreshIntervalSeconds: -1,
+			},
+			expectedError: "claimsRefreshIntervalSeconds cannot be negative",
+		},
+		{
+			name: "Negative idle timeout",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				IdleTimeoutSeconds:   -1,
+			},
+			expectedError: "idleTimeoutSeconds cannot be negative",
+		},
+		{
+			name: "Negative callback rate limit",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CallbackRateLimit:    -1,
+			},
+			expectedError: "callbackRateLimit cannot be negative",
+		},
+		{
+			name: "Invalid session layout",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				SessionLayout:        "triple",
+			},
+			expectedError: "sessionLayout must be one of: split, single",
+		},
+		{
+			name: "Invalid forwarded token",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				ForwardedToken:       "refresh",
+			},
+			expectedError: "forwardedToken must be one of: access, id",
+		},
+		{
+			name: "Negative MaxProviderConcurrency",
+			config: &Config{
+				ProviderURL:            "https://provider.com",
+				CallbackURL:            "/callback",
+				ClientID:               "client-id",
+				ClientSecret:           "client-secret",
+				SessionEncryptionKey:   "this-is-a-long-enough-encryption-key",
+				RateLimit:              100,
+				MaxProviderConcurrency: -1,
+			},
+			expectedError: "maxProviderConcurrency cannot be negative",
+		},
+		{
+			name: "Negative MaxAuthAgeSeconds",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				MaxAuthAgeSeconds:    -1,
+			},
+			expectedError: "maxAuthAgeSeconds cannot be negative",
+		},
+		{
+			name: "Invalid trusted proxy",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				TrustedProxies:       []string{"10.0.0.0/33"},
+			},
+			expectedError: `trustedProxies entry "10.0.0.0/33" is not an IP address or CIDR range`,
+		},
+		{
+			name: "Negative JWKSRefetchIntervalSeconds",
+			config: &Config{
+				ProviderURL:                "https://provider.com",
+				CallbackURL:                "/callback",
+				ClientID:                   "client-id",
+				ClientSecret:               "client-secret",
+				SessionEncryptionKey:       "this-is-a-long-enough-encryption-key",
+				RateLimit:                  100,
+				JWKSRefetchIntervalSeconds: -1,
+			},
+			expectedError: "jwksRefetchIntervalSeconds cannot be negative",
+		},
+		{
+			name: "SignLogoutRequests Without ClientSigningKey",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				SignLogoutRequests:   true,
+			},
+			expectedError: "signLogoutRequests requires clientSigningKey",
+		},
+		{
+			name: "Relative RouteRules PathPrefix",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				RouteRules:           []RouteRule{{PathPrefix: "admin", RequiredRoles: []string{"admin"}}},
+			},
+			expectedError: "routeRules pathPrefix must start with /",
+		},
+		{
+			name: "Negative TokenCacheShards",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				TokenCacheShards:     -1,
+			},
+			expectedError: "tokenCacheShards cannot be negative",
+		},
+		{
+			name: "Negative RememberMeTimeoutSeconds",
+			config: &Config{
+				ProviderURL:              "https://provider.com",
+				CallbackURL:              "/callback",
+				ClientID:                 "client-id",
+				ClientSecret:             "client-secret",
+				SessionEncryptionKey:     "this-is-a-long-enough-encryption-key",
+				RateLimit:                100,
+				RememberMeTimeoutSeconds: -1,
+			},
+			expectedError: "rememberMeTimeoutSeconds cannot be negative",
+		},
+		{
+			name: "Non-HTTPS AllowedIssuers",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				AllowedIssuers:       []string{"https://eu.provider.com", "http://us.provider.com"},
+			},
+			expectedError: "allowedIssuers must be valid HTTPS URLs",
+		},
+		{
+			name: "Excessive SessionTimeoutJitterPercent",
+			config: &Config{
+				ProviderURL:                 "https://provider.com",
+				CallbackURL:                 "/callback",
+				ClientID:                    "client-id",
+				ClientSecret:                "client-secret",
+				SessionEncryptionKey:        "this-is-a-long-enough-encryption-key",
+				RateLimit:                   100,
+				SessionTimeoutJitterPercent: 75,
+			},
+			expectedError: "sessionTimeoutJitterPercent must be between 0 and 50",
+		},
+		{
+			name: "Cookie In RestoreHeaders",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				RestoreHeaders:       []string{"Accept", "cookie"},
+			},
+			expectedError: "restoreHeaders cannot include Cookie",
+		},
+		{
+			name: "Relative SessionExpiredRedirectPath",
+			config: &Config{
+				ProviderURL:                "https://provider.com",
+				CallbackURL:                "/callback",
+				ClientID:                   "client-id",
+				ClientSecret:               "client-secret",
+				SessionEncryptionKey:       "this-is-a-long-enough-encryption-key",
+				RateLimit:                  100,
+				SessionExpiredRedirectPath: "dashboard",
+			},
+			expectedError: "sessionExpiredRedirectPath must start with /",
+		},
+		{
+			name: "Negative StateTTLSeconds",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				StateTTLSeconds:      -1,
+			},
+			expectedError: "stateTTLSeconds cannot be negative",
+		},
+		{
+			name: "DevMode Without DevClaims",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				DevMode:              true,
+			},
+			expectedError: "devClaims must be set when devMode is enabled",
+		},
+		{
+			name: "Invalid CookieEncoding",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CookieEncoding:       "msgpack",
+			},
+			expectedError: "cookieEncoding must be one of: gob, json",
+		},
+		{
+			name: "Invalid CORSPreflight",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CORSPreflight:        "authenticate",
+			},
+			expectedError: "corsPreflight must be one of: respond, passthrough",
+		},
+		{
+			name: "Negative TokenCacheMaxTTLSeconds",
+			config: &Config{
+				ProviderURL:             "https://provider.com",
+				CallbackURL:             "/callback",
+				ClientID:                "client-id",
+				ClientSecret:            "client-secret",
+				SessionEncryptionKey:    "this-is-a-long-enough-encryption-key",
+				RateLimit:               100,
+				TokenCacheMaxTTLSeconds: -1,
+			},
+			expectedError: "tokenCacheMaxTTLSeconds cannot be negative",
+		},
+		{
+			name: "Negative OutageGracePeriodSeconds",
+			config: &Config{
+				ProviderURL:              "https://provider.com",
+				CallbackURL:              "/callback",
+				ClientID:                 "client-id",
+				ClientSecret:             "client-secret",
+				SessionEncryptionKey:     "this-is-a-long-enough-encryption-key",
+				RateLimit:                100,
+				OutageGracePeriodSeconds: -1,
+			},
+			expectedError: "outageGracePeriodSeconds cannot be negative",
+		},
+		{
+			name: "Provider Client Cert Without Key",
+			config: &Config{
+				ProviderURL:            "https://provider.com",
+				CallbackURL:            "/callback",
+				ClientID:               "client-id",
+				ClientSecret:           "client-secret",
+				SessionEncryptionKey:   "this-is-a-long-enough-encryption-key",
+				RateLimit:              100,
+				ProviderClientCertFile: "/etc/traefik/client.pem",
+			},
+			expectedError: "providerClientCertFile and providerClientKeyFile must be set together",
+		},
+		{
+			name: "Negative MaxRequestBodyBytes",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				MaxRequestBodyBytes:  -1,
+			},
+			expectedError: "maxRequestBodyBytes cannot be negative",
+		},
+		{
+			name: "Relative Resource",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				Resources:            []string{"api/orders"},
+			},
+			expectedError: "resource must be an absolute URI without fragment: api/orders",
+		},
+		{
+			name: "Strict MainCookieSameSite",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				MainCookieSameSite:   "strict",
+			},
+			expectedError: "mainCookieSameSite cannot be strict: the session cookie must survive the redirect from the provider",
+		},
+		{
+			name: "Invalid AllowedRedirectHosts",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				AllowedRedirectHosts: []string{"https://app.example.com/"},
+			},
+			expectedError: `allowedRedirectHosts entries must be host names: "https://app.example.com/"`,
+		},
+		{
+			name: "Relative PublicCallbackURL",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				PublicCallbackURL:    "/callback",
+			},
+			expectedError: "publicCallbackURL must be an absolute http(s) URL without fragment",
+		},
+		{
+			name: "None Signing Algorithm",
+			config: &Config{
+				ProviderURL:              "https://provider.com",
+				CallbackURL:              "/callback",
+				ClientID:                 "client-id",
+				ClientSecret:             "client-secret",
+				SessionEncryptionKey:     "this-is-a-long-enough-encryption-key",
+				RateLimit:                100,
+				AllowedSigningAlgorithms: []string{"RS256", "none"},
+			},
+			expectedError: "allowedSigningAlgorithms contains unsupported algorithm: none",
+		},
+		{
+			name: "Reserved TokenRequestParams",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				TokenRequestParams:   map[string]string{"client_id": "other-client"},
+			},
+			expectedError: "tokenRequestParams cannot override reserved parameter: client_id",
+		},
+		{
+			name: "Invalid TokenStorage",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				TokenStorage:         "redis",
+			},
+			expectedError: "tokenStorage must be one of: cookie, memory",
+		},
+		{
+			name: "Negative MaxCookieBytes",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				MaxCookieBytes:       -1,
+			},
+			expectedError: "maxCookieBytes cannot be negative",
+		},
+		{
+			name: "Relative SilentRenewPath",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				SilentRenewPath:      "silent-renew",
+			},
+			expectedError: "silentRenewPath must start with /",
+		},
+		{
+			name: "Invalid UnauthenticatedMode",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				UnauthenticatedMode:  "html",
+			},
+			expectedError: "unauthenticatedMode must be one of: redirect, json-401, custom",
+		},
+		{
+			name: "Custom UnauthenticatedMode Without Template",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				UnauthenticatedMode:  "custom",
+			},
+			expectedError: "unauthenticatedTemplate is required when unauthenticatedMode is custom",
+		},
+		{
+			name: "Unknown CompressionCodec",
+			config: &Config{
+				ProviderURL:          "https://provider.com",
+				CallbackURL:          "/callback",
+				ClientID:             "client-id",
+				ClientSecret:         "client-secret",
+				SessionEncryptionKey: "this-is-a-long-enough-encryption-key",
+				RateLimit:            100,
+				CompressionCodec:     "lz4",
+			},
+			expectedError: "compressionCodec is invalid: unknown compression codec: lz4",
+		},
 		{
 			name: "Valid Config",
 			config: &Config{
@@ -0,0 +1,109 @@
+package traefikoidc
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// DefaultCacheShards is the default number of shards of the token cache.
+const DefaultCacheShards = 16
+
+// ShardedCache spreads its items over several independently locked Cache shards, chosen
+// by a hash of the key, so that concurrent operations on different keys rarely contend for
+// the same lock. Each shard keeps its own LRU order; the size limit is split evenly between
+// the shards.
+type ShardedCache struct {
+	// shards are the independently locked caches holding the items.
+	shards []*Cache
+
+	// stopCleanup channel to terminate the auto cleanup goroutine.
+	stopCleanup chan struct{}
+}
+
+// NewShardedCache creates a new empty cache with the given number of shards and the default
+// maximum size, and starts a single automatic cleanup goroutine for all shards.
+//
+// Parameters:
+//   - shards: The number of shards; values below 1 use DefaultCacheShards.
+//
+// Returns:
+//   - A pointer to the new ShardedCache.
+func NewShardedCache(shards int) *ShardedCache {
+	if shards < 1 {
+		shards = DefaultCacheShards
+	}
+	c := &ShardedCache{
+		shards:      make([]*Cache, shards),
+		stopCleanup: make(chan struct{}),
+	}
+	shardSize := shardMaxSize(DefaultMaxSize, shards)
+	for i := range c.shards {
+		c.shards[i] = newCache(shardSize)
+	}
+	go autoCleanupRoutine(c.shards[0].autoCleanupInterval, c.stopCleanup, c.Cleanup)
+	return c
+}
+
+// shardMaxSize splits a total size limit between shards, rounding up so that the shards
+// together hold at least size items.
+func shardMaxSize(size, shards int) int {
+	return (size + shards - 1) / shards
+}
+
+// shard returns the shard responsible for key.
+func (c *ShardedCache) shard(key string) *Cache {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+// SetMaxSize changes the maximum number of items held by the cache, split evenly between
+// the shards.
+func (c *ShardedCache) SetMaxSize(size int) {
+	shardSize := shardMaxSize(size, len(c.shards))
+	for _, shard := range c.shards {
+		shard.SetMaxSize(shardSize)
+	}
+}
+
+// Set adds or updates an item in the key's shard with the specified expiration duration.
+func (c *ShardedCache) Set(key string, value interface{}, expiration time.Duration) {
+	c.shard(key).Set(key, value, expiration)
+}
+
+// Get retrieves an unexpired item from the key's shard.
+func (c *ShardedCache) Get(key string) (interface{}, bool) {
+	return c.shard(key).Get(key)
+}
+
+// Delete removes an item from the key's shard.
+func (c *ShardedCache) Delete(key string) {
+	c.shard(key).Delete(key)
+}
+
+// Cleanup removes expired items from every shard, locking one shard at a time.
+func (c *ShardedCache) Cleanup() {
+	for _, shard := range c.shards {
+		shard.Cleanup()
+	}
+}
+
+// Len returns the number of items held by the cache, including expired items that have
+// not been removed yet.
+func (c *ShardedCache) Len() int {
+	n := 0
+	for _, shard := range c.shards {
+		shard.mutex.RLock()
+		n += len(shard.items)
+		shard.mutex.RUnlock()
+	}
+	return n
+}
+
+// Close stops the automatic cleanup goroutine of the cache.
+func (c *ShardedCache) Close() {
+	close(c.stopCleanup)
+}
@@ -0,0 +1,68 @@
+package traefikoidc
+
+import (
+	"net/http"
+)
+
+// canLoginSilently reports whether an expired session can be renewed with a prompt=none
+// login instead of an interactive one. This is the case for authenticated sessions that have
+// no refresh token, because the provider did not issue one despite the offline_access scope
+// or its chunk cookies were lost: while the user is still logged in at the provider, a silent
+// login obtains a new token set without showing the login page.
+//
+// Parameters:
+//   - session: The user's expired session.
+//
+// Returns:
+//   - true if a silent login should be attempted.
+func (t *TraefikOidc) canLoginSilently(session *SessionData) bool {
+	return session.GetAuthenticated() && session.GetRefreshToken() == ""
+}
+
+// fallBackToInteractiveLogin restarts a login from its callback as a regular, interactive
+// authorization request: a silent login that the provider rejected (typically with
+// login_required), or a login whose authentication is older than maxAuthAgeSeconds. The
+// session keeps the post-login destination stored by the first attempt; only the state, nonce
+// and PKCE verifier are replaced.
+//
+// Parameters:
+//   - rw: The HTTP response writer.
+//   - req: The callback request.
+//   - session: The session of the first login attempt.
+//   - forceLogin: true to send prompt=login and mark the login as a forced re-authentication,
+//     so that a callback that is still too old fails instead of looping.
+func (t *TraefikOidc) fallBackToInteractiveLogin(rw http.ResponseWriter, req *http.Request, session *SessionData, forceLogin bool) {
+	setNoStoreHeaders(rw)
+	logger := t.flowLogger(session)
+	csrfToken, nonce, codeVerifier, codeChallenge, ok := t.generateAuthRequestState(rw)
+	if !ok {
+		return
+	}
+
+	redirectURL := session.GetRedirectURI()
+	if redirectURL == "" {
+		redirectURL = t.buildRedirectURL(req)
+	}
+	session.SetSilentLogin(false)
+	session.SetAuthAgeStepUp(forceLogin)
+	session.SetCSRF(csrfToken)
+	session.SetNonce(nonce)
+	session.SetCodeVerifier("")
+	if t.enablePKCE {
+		session.SetCodeVerifier(codeVerifier)
+	}
+	t.storePendingAuth(rw, req, csrfToken, session)
+
+	if err := session.SaveMain(req, rw); err != nil {
+		logger.Errorf("Failed to save session before falling back to an interactive login: %v", err)
+		http.Error(rw, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	authURL := t.buildAuthURL(redirectURL, csrfToken, nonce, codeChallenge)
+	if forceLogin {
+		authURL = setURLQueryParam(authURL, "prompt", "login")
+	}
+	logger.Debugf("Redirecting to OIDC provider for an interactive login: %s", authURL)
+	http.Redirect(rw, req, authURL, http.StatusFound)
+}
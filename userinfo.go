@@ -0,0 +1,117 @@
+package traefikoidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// userInfoCacheTTL is how long UserInfo responses are cached per access token.
+const userInfoCacheTTL = 5 * time.Minute
+
+// maxUserInfoBytes limits the size of UserInfo responses read from the provider.
+const maxUserInfoBytes = 1 << 20
+
+// fetchUserInfo retrieves the claims the provider's UserInfo endpoint returns for an access
+// token, serving repeated requests for the same token from the UserInfo cache.
+//
+// Parameters:
+//   - ctx: The context for the outgoing HTTP request.
+//   - accessToken: The OAuth 2.0 access token, sent as a bearer token.
+//
+// Returns:
+//   - The UserInfo claims.
+//   - An error if no UserInfo endpoint or access token is available, the request fails, or
+//     the response is not a JSON object.
+func (t *TraefikOidc) fetchUserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	if t.userInfoURL == "" {
+		return nil, fmt.Errorf("provider does not advertise a userinfo_endpoint")
+	}
+	if accessToken == "" {
+		return nil, fmt.Errorf("no access token available for the UserInfo request")
+	}
+	if claims, ok := t.userInfoCache.Get(accessToken); ok {
+		return claims, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.userInfoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UserInfo request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch UserInfo: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UserInfo endpoint returned status %d", resp.StatusCode)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxUserInfoBytes)).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode UserInfo response: %w", err)
+	}
+
+	t.userInfoCache.Set(accessToken, claims, userInfoCacheTTL)
+	return claims, nil
+}
+
+// mergeUserInfoClaims adds UserInfo claims missing from the ID token claims. ID token claims
+// take precedence. As required by OpenID Connect Core, UserInfo claims whose 'sub' differs
+// from the ID token's are rejected.
+//
+// Parameters:
+//   - claims: The verified ID token claims.
+//   - userInfo: The UserInfo claims.
+//
+// Returns:
+//   - A new map with the combined claims.
+//   - An error if the UserInfo subject does not match the ID token subject.
+func mergeUserInfoClaims(claims, userInfo map[string]interface{}) (map[string]interface{}, error) {
+	if sub, ok := userInfo["sub"]; !ok || sub != claims["sub"] {
+		return nil, fmt.Errorf("UserInfo subject %v does not match ID token subject %v", userInfo["sub"], claims["sub"])
+	}
+	merged := make(map[string]interface{}, len(claims)+len(userInfo))
+	for k, v := range userInfo {
+		merged[k] = v
+	}
+	for k, v := range claims {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// sessionClaims returns the claims of the session's token, supplemented with the UserInfo
+// claims stored in the session at login.
+//
+// Parameters:
+//   - session: The authenticated session.
+//
+// Returns:
+//   - The combined claims.
+//   - An error if the token's claims cannot be extracted.
+func (t *TraefikOidc) sessionClaims(session *SessionData) (map[string]interface{}, error) {
+	claims, err := t.getTokenClaims(session.GetAccessToken())
+	if err != nil {
+		return nil, err
+	}
+	userInfo := session.GetUserInfo()
+	if len(userInfo) == 0 {
+		return claims, nil
+	}
+	merged := make(map[string]interface{}, len(claims)+len(userInfo))
+	for k, v := range userInfo {
+		merged[k] = v
+	}
+	for k, v := range claims {
+		merged[k] = v
+	}
+	return merged, nil
+}